@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/getlantern/systray"
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/instance"
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: goarctis [flags] [command]\n\n")
+	fmt.Fprintf(out, "Without a command, starts the tray application. Commands are sent to\n")
+	fmt.Fprintf(out, "the running instance:\n\n")
+	fmt.Fprintf(out, "  status                         Print the state of every device as JSON, one per line\n")
+	fmt.Fprintf(out, "  settings [device]              List device settings and their values\n")
+	fmt.Fprintf(out, "  set <device> <setting> <value> Change a device setting\n")
+	fmt.Fprintf(out, "  quit                           Stop the running instance\n\n")
+	fmt.Fprintf(out, "Other commands:\n\n")
+	fmt.Fprintf(out, "  explore [-vid id] [-pid id]    Watch and send raw HID reports (see docs/explore.md)\n\n")
+	fmt.Fprintf(out, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+// forwardCommand sends a command line to the running instance, prints its
+// output and returns the exit code
+func forwardCommand(args []string) int {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to the session bus: %v\n", err)
+		return 1
+	}
+	defer conn.Close()
+
+	output, err := instance.Forward(context.Background(), conn, args)
+	if errors.Is(err, instance.ErrNotRunning) {
+		fmt.Fprintln(os.Stderr, "goarctis is not running")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	if output != "" {
+		fmt.Println(output)
+	}
+	return 0
+}
+
+// runCommand runs a command forwarded from another invocation
+func runCommand(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command given")
+	}
+
+	switch args[0] {
+	case "status":
+		states := deviceManager.GetDeviceStates()
+		ids := make([]string, 0, len(states))
+		for id := range states {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		lines := make([]string, 0, len(ids))
+		for _, id := range ids {
+			data, err := json.Marshal(states[id])
+			if err != nil {
+				return "", fmt.Errorf("failed to encode %s: %w", id, err)
+			}
+			lines = append(lines, string(data))
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case "settings":
+		return listSettings(args[1:])
+
+	case "set":
+		if len(args) != 4 {
+			return "", fmt.Errorf("usage: set <device> <setting> <value>")
+		}
+		return setSetting(args[1], args[2], args[3])
+
+	case "quit":
+		go systray.Quit()
+		return "", nil
+
+	default:
+		return "", fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// listSettings describes the settings of the given devices, or of every
+// device that has settings
+func listSettings(deviceIDs []string) (string, error) {
+	if len(deviceIDs) == 0 {
+		for id := range deviceManager.GetAllDevices() {
+			deviceIDs = append(deviceIDs, id)
+		}
+		sort.Strings(deviceIDs)
+	}
+
+	var lines []string
+	for _, id := range deviceIDs {
+		settings, err := deviceManager.GetSettings(id)
+		if errors.Is(err, device.ErrNotConfigurable) && len(deviceIDs) > 1 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, setting := range settings {
+			lines = append(lines, fmt.Sprintf("%s %s = %s (%s)", id, setting.ID, setting.Format(setting.Value), describeSetting(setting)))
+		}
+	}
+	if len(lines) == 0 {
+		return "No device has settings", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// describeSetting lists the values a setting accepts
+func describeSetting(setting device.Setting) string {
+	switch setting.Kind {
+	case device.SettingEnum:
+		return strings.Join(setting.Options, "|")
+	case device.SettingRange:
+		step := ""
+		if setting.Step > 1 {
+			step = fmt.Sprintf(" in steps of %d", setting.Step)
+		}
+		return fmt.Sprintf("%s-%s%s", setting.Format(setting.Min), setting.Format(setting.Max), step)
+	default:
+		return "on|off"
+	}
+}
+
+// setSetting parses and applies a value given on the command line
+func setSetting(deviceID, settingID, text string) (string, error) {
+	settings, err := deviceManager.GetSettings(deviceID)
+	if err != nil {
+		return "", err
+	}
+	setting, ok := device.FindSetting(settings, settingID)
+	if !ok {
+		return "", fmt.Errorf("%s has no setting %q", deviceID, settingID)
+	}
+	value, err := setting.Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if err := deviceManager.ApplySetting(deviceID, settingID, value); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %s set to %s", deviceID, settingID, setting.Format(value)), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/jyablonski/goarctis/pkg/config"
+	"github.com/jyablonski/goarctis/pkg/explorer"
+	"github.com/jyablonski/goarctis/pkg/hidraw"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// runExplorer runs the HID protocol explorer on the products from the report
+// definitions, or on the device given with -vid and -pid, and returns the
+// exit code
+func runExplorer(args []string) int {
+	flags := flag.NewFlagSet("explore", flag.ContinueOnError)
+	vendor := flags.String("vid", "", "Vendor ID of the device to explore, e.g. 0x1038 (default: products in the report definitions)")
+	product := flags.String("pid", "", "Product ID of the device to explore; any product of the vendor if omitted")
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage: goarctis explore [flags]\n\n")
+		fmt.Fprintf(out, "Shows raw HID reports and reads commands from stdin; type help once running.\n\n")
+		fmt.Fprintf(out, "Flags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	definitions, err := protocol.LoadDefinitions(config.ReportDefinitionsDir())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load report definitions, using built-in: %v\n", err)
+		definitions = protocol.BuiltinDefinitions()
+	}
+
+	match := func(info hidraw.Info, name string) (protocol.ProductMatch, bool) {
+		return definitions.MatchProduct(info.Bus, info.VendorID, info.ProductID, name)
+	}
+	if *vendor != "" {
+		vendorID, err := strconv.ParseUint(*vendor, 0, 16)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -vid %q\n", *vendor)
+			return 2
+		}
+		var productID uint64
+		if *product != "" {
+			if productID, err = strconv.ParseUint(*product, 0, 16); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -pid %q\n", *product)
+				return 2
+			}
+		}
+		match = func(info hidraw.Info, name string) (protocol.ProductMatch, bool) {
+			if info.VendorID != uint16(vendorID) || (productID != 0 && info.ProductID != uint16(productID)) {
+				return protocol.ProductMatch{}, false
+			}
+			bus := protocol.BusUSB
+			if info.Bus == hidraw.BusBluetooth {
+				bus = protocol.BusBluetooth
+			}
+			return protocol.ProductMatch{VendorID: protocol.HexID(info.VendorID), ProductID: protocol.HexID(info.ProductID), Bus: bus}, true
+		}
+	} else if *product != "" {
+		fmt.Fprintln(os.Stderr, "-pid requires -vid")
+		return 2
+	}
+
+	paths, _ := filepath.Glob("/dev/hidraw*")
+	interfaces, err := explorer.Open(paths, hidraw.OpenDevice, match)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reports are decoded with the definitions of the first product found
+	defs := &protocol.DefinitionSet{}
+	if info, err := interfaces[0].Info(); err == nil {
+		if family, ok := definitions.Family(info.Bus, info.VendorID, info.ProductID, interfaces[0].Name); ok {
+			defs = family
+		}
+	}
+	e := explorer.New(interfaces, defs, os.Stdout)
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		e.SetColor(true)
+	}
+	if err := e.Run(ctx, os.Stdin); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	return 0
+}
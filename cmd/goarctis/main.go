@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/getlantern/systray"
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/pkg/config"
 	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/instance"
 	"github.com/jyablonski/goarctis/pkg/protocol"
 	"github.com/jyablonski/goarctis/pkg/ui"
 	"github.com/jyablonski/goarctis/pkg/version"
@@ -18,11 +24,18 @@ import (
 var (
 	deviceManager *device.DeviceManager
 	trayManager   *ui.TrayManager
+	running       *instance.Instance
+	simulated     []*device.SimulatedDevice // Replace real devices when -simulate is given
 )
 
 func main() {
 	// Parse command line flags
 	showVersion := flag.Bool("version", false, "Print version and exit")
+	simulate := flag.Bool("simulate", false, "Show simulated devices instead of real ones")
+	scenario := flag.String("simulate-scenario", device.ScenarioTour, "Scenario for -simulate: tour, random or a JSON file")
+	speed := flag.Float64("simulate-speed", 60, "Simulated seconds per real second")
+	seed := flag.Int64("simulate-seed", 0, "Seed for the random scenario (0 picks one)")
+	flag.Usage = usage
 	flag.Parse()
 
 	if *showVersion {
@@ -30,9 +43,39 @@ func main() {
 		os.Exit(0)
 	}
 
+	if *simulate {
+		var err error
+		if simulated, err = newSimulation(*scenario, *speed, *seed); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot simulate: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The explorer opens the devices itself, alongside any running instance
+	if flag.Arg(0) == "explore" {
+		os.Exit(runExplorer(flag.Args()[1:]))
+	}
+
+	// Commands are run by the instance that is already monitoring devices
+	if flag.NArg() > 0 {
+		os.Exit(forwardCommand(flag.Args()))
+	}
+
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 	log.Printf("Starting goarctis version %s...", version.Version)
 
+	// Only one instance may own the devices; a second one would poll them
+	// twice and restart the OpenRazer daemon twice
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		log.Printf("⚠️ Cannot check for another running instance: %v", err)
+	} else if running, err = instance.Acquire(conn); errors.Is(err, instance.ErrAlreadyRunning) {
+		fmt.Fprintln(os.Stderr, "goarctis is already running. Use \"goarctis status\" to query it or \"goarctis quit\" to stop it.")
+		os.Exit(1)
+	} else if err != nil {
+		log.Printf("⚠️ Cannot check for another running instance: %v", err)
+	}
+
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -53,33 +96,84 @@ func onReady() {
 	trayManager.Initialize()
 
 	// Initialize device manager
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("Failed to load config, using defaults: %v", err)
+	}
+	trayManager.SetAggregation(cfg.Aggregation)
+
 	deviceManager = device.NewDeviceManager()
 	deviceManager.SetOnStateChange(onStateChange)
+	deviceManager.SetFilterConfig(cfg.Filter)
+	deviceManager.SetStaleThreshold(time.Duration(cfg.StaleAfter))
+	deviceManager.SetRestartPolicy(cfg.Restart.Policy())
+	deviceManager.SetDaemonRestartPolicy(cfg.DaemonRestart.Policy())
+	deviceManager.SetDaemonRestartConfirm(func(ctx context.Context, unit string) bool {
+		return trayManager.Confirm(ctx, fmt.Sprintf("Restart %s?", unit))
+	})
+	deviceManager.SetOnHealthChange(onHealthChange)
+	trayManager.SetOnSettingSelected(onSettingSelected)
+	if running != nil {
+		running.SetHandler(runCommand)
+	}
 
-	// Discover and start devices
-	go func() {
-		if err := deviceManager.DiscoverDevices(); err != nil {
-			log.Printf("Failed to discover devices: %v", err)
-			trayManager.SetStatus("No devices found")
-			return
+	if simulated != nil {
+		// Simulated devices replace every real backend, and their state must
+		// not overwrite the real devices' last known state
+		if err := deviceManager.SetBackends(); err != nil {
+			log.Printf("Failed to disable device backends: %v", err)
 		}
+		deviceManager.AddBackend("Simulated devices", func(ctx context.Context) ([]device.BatteryDevice, error) {
+			devices := make([]device.BatteryDevice, 0, len(simulated))
+			for _, sim := range simulated {
+				devices = append(devices, sim)
+			}
+			return devices, nil
+		})
+	} else {
+		deviceManager.SetStateStore(device.NewStateStore(config.StatePath()))
 
-		devices := deviceManager.GetAllDevices()
-		if len(devices) == 0 {
-			trayManager.SetStatus("No devices found")
-			return
-		}
+		// Devices provided by plugin executables, supervised like built-in ones
+		deviceManager.AddBackend("Plugins", func(ctx context.Context) ([]device.BatteryDevice, error) {
+			plugins, err := device.DiscoverPlugins(ctx, config.PluginsDir())
+			devices := make([]device.BatteryDevice, 0, len(plugins))
+			for _, plugin := range plugins {
+				devices = append(devices, plugin)
+			}
+			return devices, err
+		})
+	}
 
-		// Build status message
-		deviceNames := make([]string, 0, len(devices))
-		for _, dev := range devices {
-			deviceNames = append(deviceNames, dev.GetName())
+	// Show the last known state from the previous run until devices report
+	if err := deviceManager.LoadCachedStates(); err != nil {
+		log.Printf("Failed to load cached device states: %v", err)
+	}
+
+	// Load user-supplied HID report definitions on top of the built-in ones
+	definitions, err := protocol.LoadDefinitions(config.ReportDefinitionsDir())
+	if err != nil {
+		log.Printf("Failed to load report definitions, using built-in: %v", err)
+	} else {
+		deviceManager.SetReportDefinitions(definitions)
+	}
+
+	// Pause monitoring across suspend so devices are not hammered, or the
+	// OpenRazer daemon restarted, while USB re-enumerates
+	go func() {
+		watcher := device.NewSleepWatcher(deviceManager.Suspend, deviceManager.Resume)
+		if err := watcher.Run(context.Background()); err != nil {
+			log.Printf("⚠️ Not watching for system sleep: %v", err)
 		}
-		trayManager.SetStatus(fmt.Sprintf("Connected: %d device(s)", len(devices)))
+	}()
 
-		// Start monitoring all devices
-		if err := deviceManager.StartAll(); err != nil {
-			log.Printf("Failed to start some devices: %v", err)
+	// Discover devices, starting each as soon as its backend finds it so the
+	// tray fills in while slower backends are still probing
+	deviceManager.SetOnDeviceDiscovered(onDeviceDiscovered)
+	go func() {
+		trayManager.SetStatus("Searching for devices...")
+		if err := deviceManager.DiscoverDevices(); err != nil {
+			log.Printf("Failed to discover devices: %v", err)
+			trayManager.SetStatus("No devices found")
 		}
 	}()
 
@@ -91,8 +185,65 @@ func onReady() {
 	}()
 }
 
+// newSimulation loads a scenario and creates the simulated devices playing it
+func newSimulation(name string, speed float64, seed int64) ([]*device.SimulatedDevice, error) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	scenario, err := device.LoadScenario(name, seed)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🧪 Simulating devices: scenario %s at %gx speed", scenario.Name, speed)
+	return device.NewSimulatedDevices(scenario, speed)
+}
+
+func onDeviceDiscovered(dev device.BatteryDevice) {
+	trayManager.SetDeviceName(dev.GetID(), dev.GetName())
+	trayManager.SetStatus(fmt.Sprintf("Connected: %d device(s)", len(deviceManager.GetAllDevices())))
+
+	if err := deviceManager.StartDevice(dev.GetID()); err != nil {
+		log.Printf("Failed to start %s: %v", dev.GetName(), err)
+	}
+}
+
 func onStateChange(deviceID string, state protocol.DeviceState) {
 	trayManager.UpdateDeviceState(deviceID, state)
+
+	// Settings carry their current values, which come from the state
+	settings, err := deviceManager.GetSettings(deviceID)
+	if err != nil {
+		return
+	}
+	menus := make([]ui.SettingMenu, len(settings))
+	for i, setting := range settings {
+		menus[i] = ui.SettingMenu{
+			ID:      setting.ID,
+			Name:    setting.Name,
+			Value:   setting.Format(setting.Value),
+			Choices: setting.Choices(),
+		}
+	}
+	trayManager.SetDeviceSettings(deviceID, menus)
+}
+
+func onSettingSelected(deviceID, settingID, value string) {
+	output, err := setSetting(deviceID, settingID, value)
+	if err != nil {
+		log.Printf("Failed to change %s of %s: %v", settingID, deviceID, err)
+		trayManager.SetStatus(fmt.Sprintf("⚠️ Could not change %s", settingID))
+		return
+	}
+	log.Println(output)
+}
+
+func onHealthChange(deviceID string, health device.Health) {
+	// Only problems are worth showing; a healthy device needs no label
+	label := ""
+	if health.Status == device.HealthDegraded || health.Status == device.HealthFailed {
+		label = health.String()
+	}
+	trayManager.UpdateDeviceHealth(deviceID, label)
 }
 
 func cleanup() {
@@ -103,6 +254,12 @@ func cleanup() {
 		deviceManager.CloseAll()
 	}
 
+	if running != nil {
+		if err := running.Release(); err != nil {
+			log.Printf("Failed to release instance name: %v", err)
+		}
+	}
+
 	log.Println("Cleanup complete")
 }
 
@@ -40,8 +40,8 @@ func main() {
 			fmt.Printf("  Battery: Not available\n")
 		}
 
-		if state.IsCharging != nil {
-			fmt.Printf("  Charging: %v\n", *state.IsCharging)
+		if state.Power != nil {
+			fmt.Printf("  Power: %s\n", state.Power)
 		}
 
 		fmt.Println()
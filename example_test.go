@@ -0,0 +1,125 @@
+package goarctis_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jyablonski/goarctis"
+	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// headset is a device of your own that reports a fixed battery level once
+// started. Real devices report from a goroutine whenever their state changes.
+type headset struct {
+	onChange func(protocol.DeviceState)
+}
+
+func (h *headset) GetID() string              { return "my_headset" }
+func (h *headset) GetName() string            { return "My Headset" }
+func (h *headset) GetType() device.DeviceType { return "my_headset" }
+func (h *headset) IsConnected() bool          { return true }
+func (h *headset) Stop() error                { return nil }
+func (h *headset) Close() error               { return nil }
+
+func (h *headset) GetState() protocol.DeviceState {
+	battery := 80
+	power := protocol.PowerDischarging
+	return protocol.DeviceState{
+		DeviceID:    h.GetID(),
+		DeviceType:  string(h.GetType()),
+		Battery:     &battery,
+		Power:       &power,
+		IsConnected: true,
+		LastSeen:    time.Now(),
+	}
+}
+
+func (h *headset) Start() error {
+	go h.onChange(h.GetState())
+	return nil
+}
+
+func (h *headset) SetOnStateChange(callback func(protocol.DeviceState)) {
+	h.onChange = callback
+}
+
+func discoverHeadset(ctx context.Context) ([]device.BatteryDevice, error) {
+	return []device.BatteryDevice{&headset{}}, nil
+}
+
+func Example() {
+	monitor, err := goarctis.New(
+		goarctis.WithBackends(), // No hardware in this example
+		goarctis.WithBackend("my headsets", discoverHeadset),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	events, unsubscribe := monitor.Subscribe(16)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitor.Run(ctx)
+
+	for event := range events {
+		fmt.Println(event.Type, event.DeviceID)
+		if event.Type == protocol.EventStateChanged {
+			fmt.Printf("Battery: %d%%\n", *event.State.Battery)
+			cancel()
+		}
+	}
+	// Output:
+	// device_added my_headset
+	// state_changed my_headset
+	// Battery: 80%
+}
+
+func ExampleMonitor_States() {
+	monitor, err := goarctis.New(
+		goarctis.WithBackends(),
+		goarctis.WithBackend("my headsets", discoverHeadset),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	events, unsubscribe := monitor.Subscribe(16)
+	defer unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- monitor.Run(ctx) }()
+
+	// Wait for the first reading, then look at every device at once
+	for event := range events {
+		if event.Type == protocol.EventStateChanged {
+			break
+		}
+	}
+	for id, state := range monitor.States() {
+		fmt.Printf("%s: %d%% %s\n", id, *state.Battery, state.Power)
+	}
+
+	cancel()
+	fmt.Println(<-done)
+	// Output:
+	// my_headset: 80% Discharging
+	// <nil>
+}
+
+func ExampleWithBackends() {
+	// Only look for Razer mice, leaving GameBuds and other HID devices alone
+	_, err := goarctis.New(goarctis.WithBackends(goarctis.BackendRazer))
+	fmt.Println(err)
+
+	_, err = goarctis.New(goarctis.WithBackends("bluetooth"))
+	fmt.Println(err)
+	// Output:
+	// <nil>
+	// unknown backend "bluetooth" (known: gamebuds, razer, hid_battery)
+}
@@ -0,0 +1,180 @@
+// Package goarctis embeds goarctis device monitoring in other programs. It
+// wires up the same discovery backends, filtering and restart supervision as
+// the tray application behind a small API:
+//
+//	monitor, err := goarctis.New(goarctis.WithBackends(goarctis.BackendRazer))
+//	if err != nil {
+//		return err
+//	}
+//	events, unsubscribe := monitor.Subscribe(16)
+//	defer unsubscribe()
+//	go monitor.Run(ctx)
+//	for event := range events {
+//		// event.State holds the device's latest state
+//	}
+//
+// # Compatibility
+//
+// The exported API of this package, the pkg/protocol types it returns and
+// the device.BatteryDevice interface accepted by WithBackend only change in
+// backwards-compatible ways: options, methods and DeviceState fields may be
+// added, but none are removed or change meaning without a new major version.
+// The JSON form of events and states is versioned separately by
+// protocol.SchemaVersion. Everything else under pkg/ serves the goarctis
+// commands and may change in any release.
+package goarctis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// Built-in backends, as named to WithBackends
+const (
+	BackendGameBuds   = device.BackendGameBuds   // SteelSeries GameBuds over hidraw
+	BackendRazer      = device.BackendRazer      // Razer devices via the OpenRazer daemon
+	BackendHIDBattery = device.BackendHIDBattery // Other HID devices declaring a battery
+)
+
+// ErrAlreadyRun is returned by Run when the monitor has already been run
+var ErrAlreadyRun = errors.New("monitor has already been run")
+
+// Monitor discovers devices and reports their state until its context ends
+type Monitor struct {
+	manager     *device.DeviceManager
+	sleepWatch  bool
+	mu          sync.Mutex
+	subscribers map[chan protocol.Event]struct{}
+	started     bool
+	done        bool
+}
+
+// New creates a monitor. Without options it probes every built-in backend
+// with the same defaults as the tray application.
+func New(opts ...Option) (*Monitor, error) {
+	m := &Monitor{
+		manager:     device.NewDeviceManager(),
+		subscribers: make(map[chan protocol.Event]struct{}),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	m.manager.SetOnDeviceDiscovered(m.onDeviceDiscovered)
+	m.manager.SetOnStateChange(m.onStateChange)
+	return m, nil
+}
+
+// Run discovers devices, monitors them until ctx is done and then releases
+// them. Subscriptions are closed when it returns. It fails if no backend
+// finds a device; a monitor can only be run once.
+func (m *Monitor) Run(ctx context.Context) error {
+	m.mu.Lock()
+	if m.started {
+		m.mu.Unlock()
+		return ErrAlreadyRun
+	}
+	m.started = true
+	m.mu.Unlock()
+	defer m.closeSubscribers()
+
+	if err := m.manager.LoadCachedStates(); err != nil {
+		log.Printf("Failed to load cached device states: %v", err)
+	}
+
+	if m.sleepWatch {
+		go func() {
+			watcher := device.NewSleepWatcher(m.manager.Suspend, m.manager.Resume)
+			if err := watcher.Run(ctx); err != nil {
+				log.Printf("⚠️ Not watching for system sleep: %v", err)
+			}
+		}()
+	}
+
+	// Devices are started as their backend finds them
+	err := m.manager.DiscoverDevicesContext(ctx)
+	if err == nil {
+		<-ctx.Done()
+	}
+	m.manager.CloseAll()
+	if err != nil {
+		return fmt.Errorf("failed to discover devices: %w", err)
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving an event for every device found and
+// every change in a device's state, and a function ending the subscription.
+// Events are dropped rather than block monitoring when the channel's buffer
+// is full. The channel is closed by the returned function or when Run
+// returns.
+func (m *Monitor) Subscribe(buffer int) (<-chan protocol.Event, func()) {
+	events := make(chan protocol.Event, buffer)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done {
+		close(events)
+		return events, func() {}
+	}
+	m.subscribers[events] = struct{}{}
+
+	return events, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := m.subscribers[events]; ok {
+			delete(m.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// States returns the current state of every known device, keyed by device ID
+func (m *Monitor) States() map[string]protocol.DeviceState {
+	return m.manager.GetDeviceStates()
+}
+
+// onDeviceDiscovered starts a newly found device and announces it
+func (m *Monitor) onDeviceDiscovered(dev device.BatteryDevice) {
+	state := dev.GetState()
+	m.publish(protocol.NewEvent(protocol.EventDeviceAdded, dev.GetID(), &state))
+
+	if err := m.manager.StartDevice(dev.GetID()); err != nil {
+		log.Printf("Failed to start %s: %v", dev.GetName(), err)
+	}
+}
+
+// onStateChange announces a device's filtered state
+func (m *Monitor) onStateChange(deviceID string, state protocol.DeviceState) {
+	m.publish(protocol.NewEvent(protocol.EventStateChanged, deviceID, &state))
+}
+
+// publish sends an event to every subscriber with room for it
+func (m *Monitor) publish(event protocol.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for events := range m.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// closeSubscribers ends every subscription once monitoring has stopped
+func (m *Monitor) closeSubscribers() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done = true
+	for events := range m.subscribers {
+		close(events)
+		delete(m.subscribers, events)
+	}
+}
@@ -0,0 +1,62 @@
+package goarctis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMonitor_RunWithoutDevices(t *testing.T) {
+	m, err := New(WithBackends())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	events, unsubscribe := m.Subscribe(1)
+	defer unsubscribe()
+
+	if err := m.Run(context.Background()); err == nil {
+		t.Error("Run with no devices = nil, want an error")
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Received an event, want the subscription closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Subscription was not closed when Run returned")
+	}
+
+	if err := m.Run(context.Background()); !errors.Is(err, ErrAlreadyRun) {
+		t.Errorf("Second Run = %v, want ErrAlreadyRun", err)
+	}
+	late, _ := m.Subscribe(1)
+	if _, ok := <-late; ok {
+		t.Error("Subscribing after Run should return a closed channel")
+	}
+}
+
+func TestMonitor_Unsubscribe(t *testing.T) {
+	m, err := New(WithBackends())
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	events, unsubscribe := m.Subscribe(1)
+	unsubscribe()
+	unsubscribe() // Safe to call twice
+	if _, ok := <-events; ok {
+		t.Error("Channel should be closed after unsubscribing")
+	}
+
+	// A closed subscription is not sent to, nor closed again by Run
+	m.Run(context.Background())
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	if _, err := New(WithBackends("bluetooth")); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+	if _, err := New(WithDiscoveryTimeout(0)); err == nil {
+		t.Error("Expected an error for a zero discovery timeout")
+	}
+}
@@ -0,0 +1,127 @@
+package goarctis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/config"
+	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// Option configures a Monitor created by New
+type Option func(*Monitor) error
+
+// WithBackends limits discovery to the named built-in backends. With no
+// names no built-in backend is probed, leaving only those added with
+// WithBackend.
+func WithBackends(names ...string) Option {
+	return func(m *Monitor) error {
+		return m.manager.SetBackends(names...)
+	}
+}
+
+// WithBackend adds a backend of your own, probed alongside the built-in
+// ones. discover is called once by Run and should honour ctx, which is
+// cancelled after the discovery timeout.
+func WithBackend(name string, discover func(ctx context.Context) ([]device.BatteryDevice, error)) Option {
+	return func(m *Monitor) error {
+		m.manager.AddBackend(name, discover)
+		return nil
+	}
+}
+
+// WithPlugins runs every executable in dir as a backend plugin, as the tray
+// application does with its plugins directory. See docs/plugins.md.
+func WithPlugins(dir string) Option {
+	return WithBackend("Plugins", func(ctx context.Context) ([]device.BatteryDevice, error) {
+		plugins, err := device.DiscoverPlugins(ctx, dir)
+		devices := make([]device.BatteryDevice, 0, len(plugins))
+		for _, plugin := range plugins {
+			devices = append(devices, plugin)
+		}
+		return devices, err
+	})
+}
+
+// WithUserConfig applies the tray application's config file and report
+// definitions, so the monitor behaves as configured for goarctis
+func WithUserConfig() Option {
+	return func(m *Monitor) error {
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+		m.manager.SetFilterConfig(cfg.Filter)
+		m.manager.SetStaleThreshold(time.Duration(cfg.StaleAfter))
+		m.manager.SetRestartPolicy(cfg.Restart.Policy())
+		m.manager.SetDaemonRestartPolicy(cfg.DaemonRestart.Policy())
+		return WithReportDefinitions(config.ReportDefinitionsDir())(m)
+	}
+}
+
+// WithReportDefinitions loads HID report definitions from every *.json file
+// in dir on top of the built-in ones
+func WithReportDefinitions(dir string) Option {
+	return func(m *Monitor) error {
+		definitions, err := protocol.LoadDefinitions(dir)
+		if err != nil {
+			return err
+		}
+		m.manager.SetReportDefinitions(definitions)
+		return nil
+	}
+}
+
+// WithStaleAfter sets how long a device may go without reporting before its
+// state is flagged as stale. Zero disables staleness tracking.
+func WithStaleAfter(d time.Duration) Option {
+	return func(m *Monitor) error {
+		m.manager.SetStaleThreshold(d)
+		return nil
+	}
+}
+
+// WithDiscoveryTimeout sets how long each backend may take to probe for
+// devices
+func WithDiscoveryTimeout(d time.Duration) Option {
+	return func(m *Monitor) error {
+		if d <= 0 {
+			return fmt.Errorf("discovery timeout must be positive, got %s", d)
+		}
+		m.manager.SetDiscoveryTimeout(d)
+		return nil
+	}
+}
+
+// WithStateFile keeps the last known state of every device in path, so that
+// States has values from the previous run before devices first report
+func WithStateFile(path string) Option {
+	return func(m *Monitor) error {
+		m.manager.SetStateStore(device.NewStateStore(path))
+		return nil
+	}
+}
+
+// WithSleepWatch pauses monitoring while the system is suspended, using
+// logind on the system bus. It is off by default.
+func WithSleepWatch(enabled bool) Option {
+	return func(m *Monitor) error {
+		m.sleepWatch = enabled
+		return nil
+	}
+}
+
+// WithDaemonRestarts controls whether a wedged OpenRazer daemon is restarted
+// through systemd. It is on by default, as in the tray application.
+func WithDaemonRestarts(enabled bool) Option {
+	return func(m *Monitor) error {
+		policy := device.DefaultDaemonRestartPolicy()
+		if !enabled {
+			policy.Mode = device.DaemonRestartNever
+		}
+		m.manager.SetDaemonRestartPolicy(policy)
+		return nil
+	}
+}
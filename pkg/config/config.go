@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// Config holds user settings read from config.json in the config directory
+type Config struct {
+	Filter device.FilterConfig `json:"filter"`
+
+	// StaleAfter is how long a device may go without reporting before the
+	// tray shows its values as stale. "0s" disables staleness tracking.
+	StaleAfter Duration `json:"stale_after"`
+
+	Restart RestartConfig `json:"restart"`
+
+	DaemonRestart DaemonRestartConfig `json:"daemon_restart"`
+
+	// Aggregation selects, per device, how multiple batteries are combined
+	// into the single level shown in the tray title and tooltip
+	Aggregation protocol.AggregationConfig `json:"aggregation"`
+}
+
+// RestartConfig controls how failing devices are restarted. See
+// device.RestartPolicy for the meaning of each setting.
+type RestartConfig struct {
+	InitialBackoff  Duration `json:"initial_backoff"`
+	MaxBackoff      Duration `json:"max_backoff"`
+	Multiplier      float64  `json:"multiplier"`
+	MaxFailures     int      `json:"max_failures"`
+	BreakerCooldown Duration `json:"breaker_cooldown"`
+	StableAfter     Duration `json:"stable_after"`
+}
+
+// Policy converts the settings to a device.RestartPolicy
+func (r RestartConfig) Policy() device.RestartPolicy {
+	policy := device.DefaultRestartPolicy()
+	policy.InitialBackoff = time.Duration(r.InitialBackoff)
+	policy.MaxBackoff = time.Duration(r.MaxBackoff)
+	policy.Multiplier = r.Multiplier
+	policy.MaxFailures = r.MaxFailures
+	policy.BreakerCooldown = time.Duration(r.BreakerCooldown)
+	policy.StableAfter = time.Duration(r.StableAfter)
+	return policy
+}
+
+// Validate checks that the settings are usable
+func (r RestartConfig) Validate() error {
+	if r.InitialBackoff <= 0 {
+		return fmt.Errorf("initial_backoff must be positive")
+	}
+	if r.MaxBackoff < r.InitialBackoff {
+		return fmt.Errorf("max_backoff must be at least initial_backoff")
+	}
+	if r.Multiplier < 1 {
+		return fmt.Errorf("multiplier must be at least 1, got %v", r.Multiplier)
+	}
+	if r.MaxFailures < 0 {
+		return fmt.Errorf("max_failures must not be negative, got %d", r.MaxFailures)
+	}
+	if r.MaxFailures > 0 && r.BreakerCooldown <= 0 {
+		return fmt.Errorf("breaker_cooldown must be positive while max_failures is set")
+	}
+	return nil
+}
+
+func restartConfigFrom(p device.RestartPolicy) RestartConfig {
+	return RestartConfig{
+		InitialBackoff:  Duration(p.InitialBackoff),
+		MaxBackoff:      Duration(p.MaxBackoff),
+		Multiplier:      p.Multiplier,
+		MaxFailures:     p.MaxFailures,
+		BreakerCooldown: Duration(p.BreakerCooldown),
+		StableAfter:     Duration(p.StableAfter),
+	}
+}
+
+// DaemonRestartConfig controls restarts of the OpenRazer daemon when Razer
+// devices cannot reach it. See device.DaemonRestartPolicy.
+type DaemonRestartConfig struct {
+	// Mode is "never", "ask" or "auto"
+	Mode       string   `json:"mode"`
+	MaxPerHour int      `json:"max_per_hour"`
+	Timeout    Duration `json:"timeout"`
+}
+
+// Policy converts the settings to a device.DaemonRestartPolicy
+func (d DaemonRestartConfig) Policy() device.DaemonRestartPolicy {
+	return device.DaemonRestartPolicy{
+		Mode:       device.DaemonRestartMode(d.Mode),
+		MaxPerHour: d.MaxPerHour,
+		Timeout:    time.Duration(d.Timeout),
+	}
+}
+
+// Validate checks that the settings are usable
+func (d DaemonRestartConfig) Validate() error {
+	return d.Policy().Validate()
+}
+
+func daemonRestartConfigFrom(p device.DaemonRestartPolicy) DaemonRestartConfig {
+	return DaemonRestartConfig{
+		Mode:       string(p.Mode),
+		MaxPerHour: p.MaxPerHour,
+		Timeout:    Duration(p.Timeout),
+	}
+}
+
+// Duration is a time.Duration written in JSON as a string such as "30m"
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30m\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return fmt.Errorf("duration %q must not be negative", s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Default returns the configuration used when no config file exists
+func Default() Config {
+	return Config{
+		Filter:        device.DefaultFilterConfig(),
+		StaleAfter:    Duration(device.DefaultStaleAfter),
+		Restart:       restartConfigFrom(device.DefaultRestartPolicy()),
+		DaemonRestart: daemonRestartConfigFrom(device.DefaultDaemonRestartPolicy()),
+		Aggregation:   protocol.DefaultAggregationConfig(),
+	}
+}
+
+// Dir returns the goarctis configuration directory,
+// $XDG_CONFIG_HOME/goarctis or ~/.config/goarctis
+func Dir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "goarctis")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".config", "goarctis")
+	}
+	return filepath.Join(home, ".config", "goarctis")
+}
+
+// StateDir returns the directory for persisted runtime state,
+// $XDG_STATE_HOME/goarctis or ~/.local/state/goarctis
+func StateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "goarctis")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".local", "state", "goarctis")
+	}
+	return filepath.Join(home, ".local", "state", "goarctis")
+}
+
+// StatePath returns the file holding the last known state of every device
+func StatePath() string {
+	return filepath.Join(StateDir(), "state.json")
+}
+
+// Path returns the location of the config file
+func Path() string {
+	return filepath.Join(Dir(), "config.json")
+}
+
+// ReportDefinitionsDir returns the directory holding user-supplied HID report definitions
+func ReportDefinitionsDir() string {
+	return filepath.Join(Dir(), "reports")
+}
+
+// PluginsDir returns the directory holding backend plugin executables
+func PluginsDir() string {
+	return filepath.Join(Dir(), "plugins")
+}
+
+// Load reads the config file from the default location
+func Load() (Config, error) {
+	return LoadFile(Path())
+}
+
+// LoadFile reads a config file. Settings missing from the file keep their
+// defaults, and a missing file yields the default configuration.
+func LoadFile(path string) (Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Default(), fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := cfg.Filter.Validate(); err != nil {
+		return Default(), fmt.Errorf("invalid filter settings in %s: %w", path, err)
+	}
+	if err := cfg.Restart.Validate(); err != nil {
+		return Default(), fmt.Errorf("invalid restart settings in %s: %w", path, err)
+	}
+	if err := cfg.DaemonRestart.Validate(); err != nil {
+		return Default(), fmt.Errorf("invalid daemon_restart settings in %s: %w", path, err)
+	}
+	if err := cfg.Aggregation.Validate(); err != nil {
+		return Default(), fmt.Errorf("invalid aggregation settings in %s: %w", path, err)
+	}
+	return cfg, nil
+}
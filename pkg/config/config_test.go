@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/device"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+func TestDir_XDGConfigHome(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+
+	if got := Dir(); got != filepath.Join("/tmp/xdg", "goarctis") {
+		t.Errorf("Dir() = %s, want /tmp/xdg/goarctis", got)
+	}
+	if got := ReportDefinitionsDir(); got != filepath.Join("/tmp/xdg", "goarctis", "reports") {
+		t.Errorf("ReportDefinitionsDir() = %s, want /tmp/xdg/goarctis/reports", got)
+	}
+}
+
+func TestDir_HomeFallback(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "/home/tester")
+
+	if got := Dir(); got != filepath.Join("/home/tester", ".config", "goarctis") {
+		t.Errorf("Dir() = %s, want /home/tester/.config/goarctis", got)
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", "/tmp/state")
+	if got := StatePath(); got != filepath.Join("/tmp/state", "goarctis", "state.json") {
+		t.Errorf("StatePath() = %s, want /tmp/state/goarctis/state.json", got)
+	}
+
+	t.Setenv("XDG_STATE_HOME", "")
+	t.Setenv("HOME", "/home/tester")
+	if got := StateDir(); got != filepath.Join("/home/tester", ".local", "state", "goarctis") {
+		t.Errorf("StateDir() = %s, want /home/tester/.local/state/goarctis", got)
+	}
+}
+
+func TestLoadFile_Missing(t *testing.T) {
+	cfg, err := LoadFile(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatalf("Missing config should not error, got: %v", err)
+	}
+	if cfg.Filter != device.DefaultFilterConfig() {
+		t.Errorf("Expected default filter config, got %+v", cfg.Filter)
+	}
+}
+
+func TestLoadFile_PartialOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"filter": {"smoothing": "ema", "ema_alpha": 0.3}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if cfg.Filter.Smoothing != device.SmoothingEMA || cfg.Filter.EMAAlpha != 0.3 {
+		t.Errorf("Expected ema smoothing with alpha 0.3, got %+v", cfg.Filter)
+	}
+	if cfg.Filter.SpikeThreshold != device.DefaultFilterConfig().SpikeThreshold {
+		t.Errorf("Unset settings should keep defaults, got spike threshold %d", cfg.Filter.SpikeThreshold)
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"filter": {"smoothing": "kalman"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for unknown smoothing mode")
+	}
+}
+
+func TestLoadFile_StaleAfter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"stale_after": "2h"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if time.Duration(cfg.StaleAfter) != 2*time.Hour {
+		t.Errorf("StaleAfter = %v, want 2h", time.Duration(cfg.StaleAfter))
+	}
+
+	if err := os.WriteFile(path, []byte(`{"stale_after": 60}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for numeric duration")
+	}
+}
+
+func TestLoadFile_Restart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"restart": {"max_backoff": "30s", "max_failures": 0}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	policy := cfg.Restart.Policy()
+	if policy.MaxBackoff != 30*time.Second || policy.MaxFailures != 0 {
+		t.Errorf("Policy = %+v, want max_backoff 30s and breaker disabled", policy)
+	}
+	if policy.InitialBackoff != device.DefaultRestartPolicy().InitialBackoff {
+		t.Errorf("Unset settings should keep defaults, got initial backoff %v", policy.InitialBackoff)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"restart": {"multiplier": 0.5}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for multiplier below 1")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"restart": {"max_failures": 3, "breaker_cooldown": "0s"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for a zero breaker cooldown with the breaker enabled")
+	}
+}
+
+func TestLoadFile_DaemonRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"daemon_restart": {"mode": "ask", "max_per_hour": 1}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	policy := cfg.DaemonRestart.Policy()
+	if policy.Mode != device.DaemonRestartAsk || policy.MaxPerHour != 1 {
+		t.Errorf("Policy = %+v, want ask mode with 1 restart per hour", policy)
+	}
+	if policy.Timeout != device.DefaultDaemonRestartPolicy().Timeout {
+		t.Errorf("Unset settings should keep defaults, got timeout %v", policy.Timeout)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"daemon_restart": {"mode": "sometimes"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for unknown mode")
+	}
+}
+
+func TestLoadFile_Aggregation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"aggregation": {"devices": {"steelseries_gamebuds": "average"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if cfg.Aggregation.Default != protocol.DefaultAggregationPolicy {
+		t.Errorf("Default policy = %s, want %s", cfg.Aggregation.Default, protocol.DefaultAggregationPolicy)
+	}
+	if got := cfg.Aggregation.Devices["steelseries_gamebuds"]; got != protocol.AggregateAverage {
+		t.Errorf("GameBuds policy = %s, want average", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"aggregation": {"default": "loudest"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+}
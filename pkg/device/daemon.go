@@ -0,0 +1,319 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	systemdService      = "org.freedesktop.systemd1"
+	systemdPath         = "/org/freedesktop/systemd1"
+	systemdManagerIface = "org.freedesktop.systemd1.Manager"
+
+	// OpenRazerUnit is the systemd user unit running the OpenRazer daemon
+	OpenRazerUnit = "openrazer-daemon.service"
+
+	// confirmTimeout is how long the user has to answer a restart prompt
+	confirmTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrRestartDisabled is returned when the policy does not allow restarts
+	ErrRestartDisabled = errors.New("daemon restarts are disabled")
+	// ErrRestartDeclined is returned when the user did not confirm a restart
+	ErrRestartDeclined = errors.New("daemon restart was not confirmed")
+	// ErrRestartLimit is returned when the hourly restart limit is reached
+	ErrRestartLimit = errors.New("daemon restart limit reached")
+)
+
+// DaemonRestartMode selects whether a failing daemon may be restarted
+type DaemonRestartMode string
+
+const (
+	DaemonRestartNever DaemonRestartMode = "never" // Never restart
+	DaemonRestartAsk   DaemonRestartMode = "ask"   // Restart after the user confirms
+	DaemonRestartAuto  DaemonRestartMode = "auto"  // Restart without asking
+)
+
+// DaemonRestartPolicy controls restarts of a device daemon
+type DaemonRestartPolicy struct {
+	Mode DaemonRestartMode
+	// MaxPerHour limits successful restarts within any hour; 0 means no
+	// limit. Failed attempts do not count, since the daemon is still down.
+	MaxPerHour int
+	// Timeout bounds the wait for the restart job and for the daemon to
+	// reappear on the bus
+	Timeout time.Duration
+}
+
+// DefaultDaemonRestartPolicy returns the policy used when none is configured
+func DefaultDaemonRestartPolicy() DaemonRestartPolicy {
+	return DaemonRestartPolicy{
+		Mode:       DaemonRestartAuto,
+		MaxPerHour: 3,
+		Timeout:    30 * time.Second,
+	}
+}
+
+// Validate checks that the policy is usable
+func (p DaemonRestartPolicy) Validate() error {
+	switch p.Mode {
+	case DaemonRestartNever, DaemonRestartAsk, DaemonRestartAuto:
+	default:
+		return fmt.Errorf("unknown restart mode %q", p.Mode)
+	}
+	if p.MaxPerHour < 0 {
+		return fmt.Errorf("max_per_hour must not be negative")
+	}
+	if p.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
+
+// DaemonRestarter restarts a systemd user unit through the systemd D-Bus API
+// and waits until the daemon it runs owns its bus name again
+type DaemonRestarter struct {
+	unit        string
+	busName     string
+	policy      DaemonRestartPolicy
+	connect     func() (*dbus.Conn, error) // Opens a private connection to the user bus
+	confirm     func(ctx context.Context, unit string) bool
+	now         func() time.Time
+	restarts    []time.Time // Successful restarts within the last hour
+	lastSuccess time.Time
+	prompt      *restartPrompt // Confirmation being asked, nil if none
+	mu          sync.Mutex     // Serializes restarts, but is not held while asking
+}
+
+// restartPrompt is a pending confirmation, whose answer is shared by every
+// restart requested while it is open
+type restartPrompt struct {
+	done     chan struct{} // Closed once answered
+	accepted bool
+}
+
+// NewDaemonRestarter creates a restarter for unit, whose daemon is ready once
+// it owns busName
+func NewDaemonRestarter(unit, busName string, policy DaemonRestartPolicy) *DaemonRestarter {
+	return &DaemonRestarter{
+		unit:    unit,
+		busName: busName,
+		policy:  policy,
+		connect: func() (*dbus.Conn, error) { return dbus.ConnectSessionBus() },
+		now:     time.Now,
+	}
+}
+
+// SetPolicy replaces the restart policy
+func (d *DaemonRestarter) SetPolicy(policy DaemonRestartPolicy) {
+	d.mu.Lock()
+	d.policy = policy
+	d.mu.Unlock()
+}
+
+// SetConfirm sets how the user is asked in DaemonRestartAsk mode. The
+// callback should return false if ctx ends before the user answers.
+func (d *DaemonRestarter) SetConfirm(confirm func(ctx context.Context, unit string) bool) {
+	d.mu.Lock()
+	d.confirm = confirm
+	d.mu.Unlock()
+}
+
+// Restart restarts the unit if the policy allows it and waits until the
+// daemon is back on the bus. Callers that were waiting while another restart
+// succeeded return immediately, so several devices of one daemon cause a
+// single restart.
+func (d *DaemonRestarter) Restart(ctx context.Context) error {
+	requested := d.now()
+
+	d.mu.Lock()
+	restarted, err := d.allow(requested)
+	ask := d.policy.Mode == DaemonRestartAsk
+	d.mu.Unlock()
+	if restarted || err != nil {
+		return err
+	}
+
+	if ask && !d.ask(ctx) {
+		return ErrRestartDeclined
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Another restart may have succeeded, or the policy changed, while the
+	// user was asked
+	if restarted, err := d.allow(requested); restarted || err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.policy.Timeout)
+	defer cancel()
+
+	log.Printf("🔄 Restarting %s through systemd", d.unit)
+	if err := d.restart(ctx); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", d.unit, err)
+	}
+
+	d.lastSuccess = d.now()
+	d.restarts = append(d.restarts, d.lastSuccess)
+	log.Printf("✅ %s restarted, %s is back", d.unit, d.busName)
+	return nil
+}
+
+// allow applies the policy to a restart requested at requested. restarted
+// is true if a restart has succeeded since. Must be called with d.mu held.
+func (d *DaemonRestarter) allow(requested time.Time) (restarted bool, err error) {
+	if d.lastSuccess.After(requested) {
+		return true, nil
+	}
+	if d.policy.Mode == DaemonRestartNever {
+		return false, ErrRestartDisabled
+	}
+
+	hourAgo := d.now().Add(-time.Hour)
+	recent := d.restarts[:0]
+	for _, t := range d.restarts {
+		if t.After(hourAgo) {
+			recent = append(recent, t)
+		}
+	}
+	d.restarts = recent
+	if d.policy.MaxPerHour > 0 && len(d.restarts) >= d.policy.MaxPerHour {
+		return false, fmt.Errorf("%w (%d in the last hour)", ErrRestartLimit, len(d.restarts))
+	}
+	return false, nil
+}
+
+// ask asks the user to confirm a restart, unless a prompt is already open,
+// in which case its answer is used
+func (d *DaemonRestarter) ask(ctx context.Context) bool {
+	d.mu.Lock()
+	prompt := d.prompt
+	if prompt != nil {
+		d.mu.Unlock()
+		select {
+		case <-prompt.done:
+			return prompt.accepted
+		case <-ctx.Done():
+			return false
+		}
+	}
+	prompt = &restartPrompt{done: make(chan struct{})}
+	d.prompt = prompt
+	confirm := d.confirm
+	d.mu.Unlock()
+
+	if confirm != nil {
+		askCtx, cancel := context.WithTimeout(ctx, confirmTimeout)
+		prompt.accepted = confirm(askCtx, d.unit)
+		cancel()
+	}
+
+	d.mu.Lock()
+	d.prompt = nil
+	d.mu.Unlock()
+	close(prompt.done)
+	return prompt.accepted
+}
+
+// restart asks systemd to restart the unit, waits for the job to finish
+// and then for the daemon to claim its bus name
+func (d *DaemonRestarter) restart(ctx context.Context) error {
+	conn, err := d.connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to user bus: %w", err)
+	}
+	defer conn.Close()
+
+	// Subscribe before starting the job so no signal is missed
+	signals := make(chan *dbus.Signal, 32)
+	conn.Signal(signals)
+	if err := conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(systemdPath),
+		dbus.WithMatchInterface(systemdManagerIface),
+		dbus.WithMatchMember("JobRemoved"),
+	); err != nil {
+		return fmt.Errorf("failed to watch systemd jobs: %w", err)
+	}
+	if err := conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath("/org/freedesktop/DBus"),
+		dbus.WithMatchInterface("org.freedesktop.DBus"),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, d.busName),
+	); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", d.busName, err)
+	}
+
+	manager := conn.Object(systemdService, systemdPath)
+	// systemd only emits job signals to subscribed clients
+	if err := manager.CallWithContext(ctx, systemdManagerIface+".Subscribe", 0).Err; err != nil {
+		return fmt.Errorf("failed to subscribe to systemd: %w", err)
+	}
+
+	var job dbus.ObjectPath
+	if err := manager.CallWithContext(ctx, systemdManagerIface+".RestartUnit", 0, d.unit, "replace").Store(&job); err != nil {
+		return err
+	}
+
+	if err := waitForJob(ctx, signals, job); err != nil {
+		return err
+	}
+
+	var hasOwner bool
+	err = conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.NameHasOwner", 0, d.busName).Store(&hasOwner)
+	if err == nil && hasOwner {
+		return nil
+	}
+	return waitForName(ctx, signals, d.busName)
+}
+
+// waitForJob waits for the JobRemoved signal of job and returns an error
+// unless its result is "done"
+func waitForJob(ctx context.Context, signals <-chan *dbus.Signal, job dbus.ObjectPath) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for job %s: %w", job, ctx.Err())
+		case signal := <-signals:
+			if signal.Name != systemdManagerIface+".JobRemoved" || len(signal.Body) < 4 {
+				continue
+			}
+			path, _ := signal.Body[1].(dbus.ObjectPath)
+			result, _ := signal.Body[3].(string)
+			if path != job {
+				continue
+			}
+			if result != "done" {
+				return fmt.Errorf("job %s finished with result %q", job, result)
+			}
+			return nil
+		}
+	}
+}
+
+// waitForName waits until name gains an owner
+func waitForName(ctx context.Context, signals <-chan *dbus.Signal, name string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", name, ctx.Err())
+		case signal := <-signals:
+			if signal.Name != "org.freedesktop.DBus.NameOwnerChanged" || len(signal.Body) < 3 {
+				continue
+			}
+			changed, _ := signal.Body[0].(string)
+			newOwner, _ := signal.Body[2].(string)
+			if changed == name && newOwner != "" {
+				return nil
+			}
+		}
+	}
+}
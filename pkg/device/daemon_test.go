@@ -0,0 +1,249 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/internal/dbustest"
+)
+
+// fakeSystemd implements the parts of the systemd Manager used to restart a
+// unit. A successful restart starts a fake daemon claiming razerService.
+type fakeSystemd struct {
+	t       *testing.T
+	conn    *dbus.Conn
+	address string
+	result  string // JobRemoved result
+	start   bool   // Whether the daemon claims its name after the job
+	calls   []string
+	mu      sync.Mutex
+}
+
+func startFakeSystemd(t *testing.T, address, result string, start bool) *fakeSystemd {
+	t.Helper()
+	f := &fakeSystemd{t: t, conn: dbustest.Connect(t, address), address: address, result: result, start: start}
+	if err := f.conn.Export(f, systemdPath, systemdManagerIface); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := f.conn.RequestName(systemdService, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("Failed to claim %s: %v", systemdService, err)
+	}
+	return f
+}
+
+func (f *fakeSystemd) Subscribe() *dbus.Error {
+	return nil
+}
+
+func (f *fakeSystemd) RestartUnit(name, mode string) (dbus.ObjectPath, *dbus.Error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, name+" "+mode)
+	id := uint32(len(f.calls))
+	f.mu.Unlock()
+
+	job := dbus.ObjectPath(fmt.Sprintf("%s/job/%d", systemdPath, id))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		// A job that ended earlier must not be mistaken for ours
+		f.conn.Emit(systemdPath, systemdManagerIface+".JobRemoved", id+100, dbus.ObjectPath(systemdPath+"/job/0"), name, "done")
+		f.conn.Emit(systemdPath, systemdManagerIface+".JobRemoved", id, job, name, f.result)
+		if f.result == "done" && f.start {
+			time.Sleep(20 * time.Millisecond)
+			daemon, err := dbus.Connect(f.address)
+			if err != nil {
+				return
+			}
+			f.t.Cleanup(func() { daemon.Close() })
+			daemon.RequestName(razerService, dbus.NameFlagDoNotQueue)
+		}
+	}()
+	return job, nil
+}
+
+func (f *fakeSystemd) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func newTestRestarter(address string, policy DaemonRestartPolicy) *DaemonRestarter {
+	restarter := NewDaemonRestarter(OpenRazerUnit, razerService, policy)
+	restarter.connect = func() (*dbus.Conn, error) { return dbus.Connect(address) }
+	return restarter
+}
+
+func TestDaemonRestarter_Restart(t *testing.T) {
+	address := dbustest.StartBus(t)
+	systemd := startFakeSystemd(t, address, "done", true)
+
+	restarter := newTestRestarter(address, DefaultDaemonRestartPolicy())
+	if err := restarter.Restart(context.Background()); err != nil {
+		t.Fatalf("Restart failed: %v", err)
+	}
+
+	calls := systemd.Calls()
+	if len(calls) != 1 || calls[0] != OpenRazerUnit+" replace" {
+		t.Errorf("RestartUnit calls = %v", calls)
+	}
+
+	// The daemon is already up, so only the job is awaited this time
+	if err := restarter.Restart(context.Background()); err != nil {
+		t.Fatalf("Second restart failed: %v", err)
+	}
+	if len(systemd.Calls()) != 2 {
+		t.Errorf("Expected a second RestartUnit call, got %v", systemd.Calls())
+	}
+}
+
+func TestDaemonRestarter_JobFailed(t *testing.T) {
+	address := dbustest.StartBus(t)
+	startFakeSystemd(t, address, "failed", false)
+
+	restarter := newTestRestarter(address, DefaultDaemonRestartPolicy())
+	err := restarter.Restart(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `"failed"`) {
+		t.Errorf("Restart = %v, want job failure", err)
+	}
+}
+
+func TestDaemonRestarter_DaemonNeverAppears(t *testing.T) {
+	address := dbustest.StartBus(t)
+	startFakeSystemd(t, address, "done", false)
+
+	policy := DefaultDaemonRestartPolicy()
+	policy.Timeout = 200 * time.Millisecond
+	restarter := newTestRestarter(address, policy)
+
+	err := restarter.Restart(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Restart = %v, want deadline exceeded", err)
+	}
+}
+
+func TestDaemonRestarter_Policy(t *testing.T) {
+	noBus := func() (*dbus.Conn, error) {
+		t.Error("Policy should have prevented contacting systemd")
+		return nil, errors.New("no bus")
+	}
+
+	t.Run("never", func(t *testing.T) {
+		restarter := NewDaemonRestarter(OpenRazerUnit, razerService, DaemonRestartPolicy{Mode: DaemonRestartNever, Timeout: time.Second})
+		restarter.connect = noBus
+		if err := restarter.Restart(context.Background()); !errors.Is(err, ErrRestartDisabled) {
+			t.Errorf("Restart = %v, want ErrRestartDisabled", err)
+		}
+	})
+
+	t.Run("ask declined", func(t *testing.T) {
+		restarter := NewDaemonRestarter(OpenRazerUnit, razerService, DaemonRestartPolicy{Mode: DaemonRestartAsk, Timeout: time.Second})
+		restarter.connect = noBus
+		var asked string
+		restarter.SetConfirm(func(ctx context.Context, unit string) bool {
+			asked = unit
+			return false
+		})
+		if err := restarter.Restart(context.Background()); !errors.Is(err, ErrRestartDeclined) {
+			t.Errorf("Restart = %v, want ErrRestartDeclined", err)
+		}
+		if asked != OpenRazerUnit {
+			t.Errorf("Confirm asked about %q", asked)
+		}
+	})
+
+	t.Run("ask without blocking", func(t *testing.T) {
+		restarter := NewDaemonRestarter(OpenRazerUnit, razerService, DaemonRestartPolicy{Mode: DaemonRestartAsk, Timeout: time.Second})
+		restarter.connect = noBus
+		asked := make(chan struct{}, 2)
+		answer := make(chan bool)
+		restarter.SetConfirm(func(ctx context.Context, unit string) bool {
+			asked <- struct{}{}
+			return <-answer
+		})
+
+		results := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() { results <- restarter.Restart(context.Background()) }()
+		}
+		<-asked
+
+		// The policy can change while the user is being asked
+		changed := make(chan struct{})
+		go func() {
+			restarter.SetPolicy(DaemonRestartPolicy{Mode: DaemonRestartNever, Timeout: time.Second})
+			close(changed)
+		}()
+		select {
+		case <-changed:
+		case <-time.After(time.Second):
+			t.Fatal("SetPolicy blocked while the user was asked")
+		}
+
+		// The answer is checked against the new policy
+		answer <- true
+		for i := 0; i < 2; i++ {
+			if err := <-results; !errors.Is(err, ErrRestartDisabled) {
+				t.Errorf("Restart = %v, want ErrRestartDisabled", err)
+			}
+		}
+		if len(asked) != 0 {
+			t.Error("Concurrent restarts should share one prompt")
+		}
+	})
+
+	t.Run("hourly limit", func(t *testing.T) {
+		address := dbustest.StartBus(t)
+		startFakeSystemd(t, address, "done", true)
+		now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+		restarter := newTestRestarter(address, DaemonRestartPolicy{Mode: DaemonRestartAuto, MaxPerHour: 2, Timeout: time.Second})
+		restarter.now = func() time.Time { return now }
+
+		for i := 0; i < 2; i++ {
+			if err := restarter.Restart(context.Background()); err != nil {
+				t.Fatalf("Restart %d failed: %v", i+1, err)
+			}
+			now = now.Add(10 * time.Minute)
+		}
+		if err := restarter.Restart(context.Background()); !errors.Is(err, ErrRestartLimit) {
+			t.Errorf("Third restart = %v, want ErrRestartLimit", err)
+		}
+
+		now = now.Add(45 * time.Minute)
+		if err := restarter.Restart(context.Background()); err != nil {
+			t.Errorf("Restart after the first aged out = %v, want success", err)
+		}
+	})
+
+	t.Run("failed attempts", func(t *testing.T) {
+		restarter := NewDaemonRestarter(OpenRazerUnit, razerService, DaemonRestartPolicy{Mode: DaemonRestartAuto, MaxPerHour: 1, Timeout: time.Second})
+		restarter.connect = func() (*dbus.Conn, error) { return nil, errors.New("no bus") }
+
+		// A daemon that fails to come back may be retried
+		for i := 0; i < 3; i++ {
+			if err := restarter.Restart(context.Background()); err == nil || errors.Is(err, ErrRestartLimit) {
+				t.Fatalf("Attempt %d = %v, want a connection error", i+1, err)
+			}
+		}
+	})
+}
+
+func TestDaemonRestartPolicy_Validate(t *testing.T) {
+	if err := DefaultDaemonRestartPolicy().Validate(); err != nil {
+		t.Errorf("Default policy invalid: %v", err)
+	}
+	invalid := []DaemonRestartPolicy{
+		{Mode: "sometimes", Timeout: time.Second},
+		{Mode: DaemonRestartAuto, MaxPerHour: -1, Timeout: time.Second},
+		{Mode: DaemonRestartAuto},
+	}
+	for _, policy := range invalid {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+}
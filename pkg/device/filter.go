@@ -0,0 +1,340 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// Smoothing modes for FilterConfig.Smoothing
+const (
+	SmoothingNone   = "none"
+	SmoothingMedian = "median"
+	SmoothingEMA    = "ema"
+)
+
+// FilterConfig controls how raw battery readings are cleaned up before they
+// reach the tray and other consumers
+type FilterConfig struct {
+	// SpikeThreshold rejects readings that jump by more than this many percent
+	// points from the last accepted value. 0 disables spike rejection.
+	SpikeThreshold int `json:"spike_threshold"`
+	// SpikeConfirmations is how many consecutive readings near a rejected
+	// value are needed before it is accepted as real
+	SpikeConfirmations int `json:"spike_confirmations"`
+	// SpikeConfirmSeconds accepts a rejected value once it has persisted this
+	// long without a reading contradicting it. Backends only report changes,
+	// so a lasting jump may never be read again. 0 disables the timeout.
+	SpikeConfirmSeconds int `json:"spike_confirm_seconds"`
+	// Smoothing is "none", "median" or "ema"
+	Smoothing string `json:"smoothing"`
+	// Window is the number of readings used for median smoothing
+	Window int `json:"window"`
+	// EMAAlpha is the weight given to the newest reading for EMA smoothing (0-1]
+	EMAAlpha float64 `json:"ema_alpha"`
+	// Monotonic prevents the displayed value from rising while discharging
+	Monotonic bool `json:"monotonic"`
+	// Hysteresis is the minimum change in percent points before the displayed
+	// value is updated. 0 or 1 shows every change.
+	Hysteresis int `json:"hysteresis"`
+}
+
+// DefaultFilterConfig returns the filter settings used when none are configured
+func DefaultFilterConfig() FilterConfig {
+	return FilterConfig{
+		SpikeThreshold:      30,
+		SpikeConfirmations:  2,
+		SpikeConfirmSeconds: 60,
+		Smoothing:           SmoothingMedian,
+		Window:              3,
+		EMAAlpha:            0.5,
+		Monotonic:           true,
+		Hysteresis:          1,
+	}
+}
+
+// Validate checks the filter settings for out-of-range values
+func (c FilterConfig) Validate() error {
+	switch c.Smoothing {
+	case "", SmoothingNone, SmoothingMedian, SmoothingEMA:
+	default:
+		return fmt.Errorf("unknown smoothing %q (expected none, median or ema)", c.Smoothing)
+	}
+	if c.SpikeThreshold < 0 || c.SpikeConfirmations < 0 || c.SpikeConfirmSeconds < 0 || c.Window < 0 || c.Hysteresis < 0 {
+		return fmt.Errorf("filter settings must not be negative")
+	}
+	if c.Smoothing == SmoothingEMA && (c.EMAAlpha <= 0 || c.EMAAlpha > 1) {
+		return fmt.Errorf("ema_alpha must be in (0, 1], got %v", c.EMAAlpha)
+	}
+	return nil
+}
+
+// batteryFilter filters the readings of a single battery component
+type batteryFilter struct {
+	cfg FilterConfig
+
+	history      []int   // Accepted readings, newest last
+	ema          float64 // Current EMA value
+	displayed    int     // Value last handed to consumers
+	hasValue     bool
+	pending      int       // Candidate value rejected as a spike
+	pendingSeen  int       // Consecutive readings near the candidate
+	pendingSince time.Time // When the candidate was first seen
+	charging     *bool
+}
+
+func newBatteryFilter(cfg FilterConfig) *batteryFilter {
+	return &batteryFilter{cfg: cfg}
+}
+
+// reset discards history so the next reading is accepted as-is
+func (f *batteryFilter) reset() {
+	f.history = nil
+	f.hasValue = false
+	f.pendingSeen = 0
+}
+
+// apply feeds a raw reading through the filter and returns the value to display.
+// charging is nil when the charging state of this component is unknown.
+func (f *batteryFilter) apply(raw int, charging *bool, now time.Time) int {
+	// A change between charging and discharging invalidates the history
+	if charging != nil && f.charging != nil && *charging != *f.charging {
+		f.reset()
+	}
+	f.charging = charging
+
+	if !f.hasValue {
+		f.history = []int{raw}
+		f.ema = float64(raw)
+		f.displayed = raw
+		f.hasValue = true
+		return raw
+	}
+
+	accepted, jumped := f.accept(raw, now)
+	if !accepted {
+		return f.displayed
+	}
+	if jumped {
+		return f.jump(raw)
+	}
+
+	smoothed := f.smooth(raw)
+
+	if f.cfg.Monotonic && charging != nil && !*charging && smoothed > f.displayed {
+		return f.displayed
+	}
+	// Empty and full are always shown, even when closer than the hysteresis
+	if abs(smoothed-f.displayed) < f.cfg.Hysteresis && smoothed != 0 && smoothed != 100 {
+		return f.displayed
+	}
+
+	f.displayed = smoothed
+	return f.displayed
+}
+
+// accept implements spike rejection: a large jump is only accepted once it
+// has been seen SpikeConfirmations more times in a row or has persisted for
+// SpikeConfirmSeconds, in which case jumped is true
+func (f *batteryFilter) accept(raw int, now time.Time) (accepted, jumped bool) {
+	if f.cfg.SpikeThreshold == 0 {
+		return true, false
+	}
+
+	last := f.history[len(f.history)-1]
+	if abs(raw-last) <= f.cfg.SpikeThreshold {
+		f.pendingSeen = 0
+		return true, false
+	}
+
+	if f.pendingSeen > 0 && abs(raw-f.pending) <= f.cfg.SpikeThreshold {
+		f.pendingSeen++
+	} else {
+		f.pending = raw
+		f.pendingSeen = 1
+		f.pendingSince = now
+	}
+
+	if f.pendingSeen > f.cfg.SpikeConfirmations || f.pendingHeld(now) {
+		return true, true
+	}
+	return false, false
+}
+
+// pendingHeld reports whether the rejected candidate has persisted for
+// SpikeConfirmSeconds
+func (f *batteryFilter) pendingHeld(now time.Time) bool {
+	hold := time.Duration(f.cfg.SpikeConfirmSeconds) * time.Second
+	return f.pendingSeen > 0 && hold > 0 && now.Sub(f.pendingSince) >= hold
+}
+
+// jump treats a persisting spike as a real change and restarts smoothing. It
+// also moves the floor kept while discharging, e.g. after the battery was
+// swapped or charged while unobserved.
+func (f *batteryFilter) jump(raw int) int {
+	f.pendingSeen = 0
+	f.history = nil
+	f.ema = float64(raw)
+	f.displayed = f.smooth(raw)
+	return f.displayed
+}
+
+// recheck accepts the rejected candidate once it has persisted for
+// SpikeConfirmSeconds without further readings, and returns the value to display
+func (f *batteryFilter) recheck(now time.Time) int {
+	if f.pendingHeld(now) {
+		return f.jump(f.pending)
+	}
+	return f.displayed
+}
+
+// smooth records the accepted reading and returns the smoothed value
+func (f *batteryFilter) smooth(raw int) int {
+	window := f.cfg.Window
+	if window < 1 {
+		window = 1
+	}
+	f.history = append(f.history, raw)
+	if len(f.history) > window {
+		f.history = f.history[len(f.history)-window:]
+	}
+
+	switch f.cfg.Smoothing {
+	case SmoothingMedian:
+		sorted := append([]int(nil), f.history...)
+		sort.Ints(sorted)
+		return sorted[len(sorted)/2]
+	case SmoothingEMA:
+		f.ema = f.cfg.EMAAlpha*float64(raw) + (1-f.cfg.EMAAlpha)*f.ema
+		return int(f.ema + 0.5)
+	default:
+		return raw
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// StateFilter applies battery filtering to every battery field of one device
+// while keeping the most recent unfiltered state for diagnostics
+type StateFilter struct {
+	cfg      FilterConfig
+	battery  *batteryFilter
+	left     *batteryFilter
+	right    *batteryFilter
+	dock     *batteryFilter
+	raw      protocol.DeviceState
+	filtered protocol.DeviceState
+	hasState bool
+	mu       sync.Mutex
+}
+
+// NewStateFilter creates a filter for a single device
+func NewStateFilter(cfg FilterConfig) *StateFilter {
+	return &StateFilter{
+		cfg:     cfg,
+		battery: newBatteryFilter(cfg),
+		left:    newBatteryFilter(cfg),
+		right:   newBatteryFilter(cfg),
+		dock:    newBatteryFilter(cfg),
+	}
+}
+
+// Apply filters a raw state. It returns the filtered state and whether it
+// differs from the previously returned one.
+func (f *StateFilter) Apply(state protocol.DeviceState) (protocol.DeviceState, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.raw = state
+
+	now := time.Now()
+	filtered := state
+	filtered.Battery = filterValue(f.battery, state.Battery, pluggedIn(state.Power, state.IsCharging), now)
+	filtered.LeftBattery = filterValue(f.left, state.LeftBattery, pluggedIn(state.LeftPower, earbudCharging(state.LeftStatus)), now)
+	filtered.RightBattery = filterValue(f.right, state.RightBattery, pluggedIn(state.RightPower, earbudCharging(state.RightStatus)), now)
+	filtered.DockBattery = filterValue(f.dock, state.DockBattery, pluggedIn(state.DockPower, nil), now)
+
+	changed := !f.hasState || !f.filtered.Equal(filtered)
+	f.filtered = filtered
+	f.hasState = true
+	return filtered, changed
+}
+
+// Recheck accepts rejected spikes that have persisted for SpikeConfirmSeconds
+// since the last call to Apply. It returns the filtered state and whether it changed.
+func (f *StateFilter) Recheck(now time.Time) (protocol.DeviceState, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.hasState {
+		return f.filtered, false
+	}
+
+	filtered := f.filtered
+	filtered.Battery = recheckValue(f.battery, filtered.Battery, now)
+	filtered.LeftBattery = recheckValue(f.left, filtered.LeftBattery, now)
+	filtered.RightBattery = recheckValue(f.right, filtered.RightBattery, now)
+	filtered.DockBattery = recheckValue(f.dock, filtered.DockBattery, now)
+
+	changed := !f.filtered.Equal(filtered)
+	f.filtered = filtered
+	return filtered, changed
+}
+
+// Raw returns the last unfiltered state passed to Apply
+func (f *StateFilter) Raw() protocol.DeviceState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.raw
+}
+
+// Filtered returns the last filtered state and whether Apply has been called
+func (f *StateFilter) Filtered() (protocol.DeviceState, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.filtered, f.hasState
+}
+
+func filterValue(f *batteryFilter, raw *int, charging *bool, now time.Time) *int {
+	if raw == nil {
+		return nil
+	}
+	value := f.apply(*raw, charging, now)
+	return &value
+}
+
+func recheckValue(f *batteryFilter, value *int, now time.Time) *int {
+	if value == nil {
+		return nil
+	}
+	rechecked := f.recheck(now)
+	return &rechecked
+}
+
+// pluggedIn reports whether a battery is on external power according to its
+// power state, falling back to the legacy charging flag when the state is unknown
+func pluggedIn(power *protocol.PowerState, fallback *bool) *bool {
+	if power == nil || *power == protocol.PowerUnknown {
+		return fallback
+	}
+	plugged := power.IsPluggedIn()
+	return &plugged
+}
+
+// earbudCharging treats an earbud in the case as charging and one outside it
+// as discharging
+func earbudCharging(status *protocol.EarbudStatus) *bool {
+	if status == nil {
+		return nil
+	}
+	charging := *status == protocol.StatusInCase
+	return &charging
+}
@@ -0,0 +1,266 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+// filterStart is the time of the first reading in batteryFilter tests
+var filterStart = time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestBatteryFilter_SpikeRejection(t *testing.T) {
+	cfg := FilterConfig{SpikeThreshold: 20, SpikeConfirmations: 2, Smoothing: SmoothingNone}
+	f := newBatteryFilter(cfg)
+
+	readings := []struct {
+		raw  int
+		want int
+	}{
+		{80, 80},
+		{0, 80},  // glitch rejected
+		{79, 79}, // back to normal
+		{10, 79}, // first sighting of a large drop
+		{10, 79}, // second sighting
+		{10, 10}, // confirmed
+	}
+
+	for i, r := range readings {
+		if got := f.apply(r.raw, nil, filterStart); got != r.want {
+			t.Errorf("reading %d: apply(%d) = %d, want %d", i, r.raw, got, r.want)
+		}
+	}
+}
+
+func TestBatteryFilter_MedianSmoothing(t *testing.T) {
+	cfg := FilterConfig{Smoothing: SmoothingMedian, Window: 3}
+	f := newBatteryFilter(cfg)
+
+	f.apply(50, nil, filterStart)
+	f.apply(60, nil, filterStart)
+	if got := f.apply(52, nil, filterStart); got != 52 {
+		t.Errorf("median of [50 60 52] = %d, want 52", got)
+	}
+	if got := f.apply(51, nil, filterStart); got != 52 {
+		t.Errorf("median of [60 52 51] = %d, want 52", got)
+	}
+}
+
+func TestBatteryFilter_EMASmoothing(t *testing.T) {
+	cfg := FilterConfig{Smoothing: SmoothingEMA, EMAAlpha: 0.5}
+	f := newBatteryFilter(cfg)
+
+	f.apply(60, nil, filterStart)
+	if got := f.apply(50, nil, filterStart); got != 55 {
+		t.Errorf("EMA after 60, 50 = %d, want 55", got)
+	}
+}
+
+func TestBatteryFilter_MonotonicWhileDischarging(t *testing.T) {
+	cfg := FilterConfig{Smoothing: SmoothingNone, Monotonic: true}
+	f := newBatteryFilter(cfg)
+
+	f.apply(55, boolPtr(false), filterStart)
+	if got := f.apply(56, boolPtr(false), filterStart); got != 55 {
+		t.Errorf("Value rose while discharging: got %d, want 55", got)
+	}
+	if got := f.apply(54, boolPtr(false), filterStart); got != 54 {
+		t.Errorf("Expected drop to 54, got %d", got)
+	}
+
+	// Plugging in resets the filter so the value may rise again
+	if got := f.apply(60, boolPtr(true), filterStart); got != 60 {
+		t.Errorf("Expected 60 after charging started, got %d", got)
+	}
+	if got := f.apply(61, boolPtr(true), filterStart); got != 61 {
+		t.Errorf("Expected 61 while charging, got %d", got)
+	}
+}
+
+func TestBatteryFilter_Hysteresis(t *testing.T) {
+	cfg := FilterConfig{Smoothing: SmoothingNone, Hysteresis: 2}
+	f := newBatteryFilter(cfg)
+
+	f.apply(55, nil, filterStart)
+	if got := f.apply(54, nil, filterStart); got != 55 {
+		t.Errorf("1 point change should be suppressed, got %d", got)
+	}
+	if got := f.apply(55, nil, filterStart); got != 55 {
+		t.Errorf("Expected 55, got %d", got)
+	}
+	if got := f.apply(53, nil, filterStart); got != 53 {
+		t.Errorf("2 point change should be shown, got %d", got)
+	}
+}
+
+func TestBatteryFilter_MonotonicConfirmedJump(t *testing.T) {
+	cfg := FilterConfig{SpikeThreshold: 20, SpikeConfirmations: 2, Smoothing: SmoothingNone, Monotonic: true}
+	f := newBatteryFilter(cfg)
+
+	// A fresh battery while the device keeps reporting discharging
+	readings := []struct {
+		raw  int
+		want int
+	}{
+		{30, 30},
+		{90, 30}, // first sighting of a large rise
+		{90, 30}, // second sighting
+		{90, 90}, // confirmed, so the floor moves up
+		{89, 89},
+		{90, 89}, // still no rise while discharging
+	}
+
+	for i, r := range readings {
+		if got := f.apply(r.raw, boolPtr(false), filterStart); got != r.want {
+			t.Errorf("reading %d: apply(%d) = %d, want %d", i, r.raw, got, r.want)
+		}
+	}
+}
+
+func TestBatteryFilter_HysteresisPassesFullAndEmpty(t *testing.T) {
+	cfg := FilterConfig{Smoothing: SmoothingNone, Hysteresis: 3}
+
+	f := newBatteryFilter(cfg)
+	f.apply(98, boolPtr(true), filterStart)
+	if got := f.apply(100, boolPtr(true), filterStart); got != 100 {
+		t.Errorf("Full battery should be shown, got %d", got)
+	}
+
+	f = newBatteryFilter(cfg)
+	f.apply(2, boolPtr(false), filterStart)
+	if got := f.apply(1, boolPtr(false), filterStart); got != 2 {
+		t.Errorf("1 point change should be suppressed, got %d", got)
+	}
+	if got := f.apply(0, boolPtr(false), filterStart); got != 0 {
+		t.Errorf("Empty battery should be shown, got %d", got)
+	}
+}
+
+func TestStateFilter_KeepsRaw(t *testing.T) {
+	f := NewStateFilter(FilterConfig{SpikeThreshold: 20, SpikeConfirmations: 2, Smoothing: SmoothingNone})
+
+	battery := 80
+	f.Apply(protocol.DeviceState{Battery: &battery})
+
+	glitch := 0
+	filtered, changed := f.Apply(protocol.DeviceState{Battery: &glitch})
+	if changed {
+		t.Error("Rejected spike should not report a change")
+	}
+	if *filtered.Battery != 80 {
+		t.Errorf("Filtered battery = %d, want 80", *filtered.Battery)
+	}
+	if raw := f.Raw(); raw.Battery == nil || *raw.Battery != 0 {
+		t.Errorf("Raw battery = %v, want 0", raw.Battery)
+	}
+}
+
+func TestStateFilter_EarbudStatusDrivesMonotonic(t *testing.T) {
+	f := NewStateFilter(FilterConfig{Smoothing: SmoothingNone, Monotonic: true})
+
+	worn := protocol.StatusWorn
+	left := 70
+	f.Apply(protocol.DeviceState{LeftBattery: &left, LeftStatus: &worn})
+
+	higher := 71
+	filtered, _ := f.Apply(protocol.DeviceState{LeftBattery: &higher, LeftStatus: &worn})
+	if *filtered.LeftBattery != 70 {
+		t.Errorf("Worn earbud battery rose to %d, want 70", *filtered.LeftBattery)
+	}
+}
+
+func TestFilterConfig_Validate(t *testing.T) {
+	if err := DefaultFilterConfig().Validate(); err != nil {
+		t.Errorf("Default config should be valid: %v", err)
+	}
+	if err := (FilterConfig{Smoothing: "kalman"}).Validate(); err == nil {
+		t.Error("Expected error for unknown smoothing")
+	}
+	if err := (FilterConfig{Smoothing: SmoothingEMA, EMAAlpha: 0}).Validate(); err == nil {
+		t.Error("Expected error for zero EMA alpha")
+	}
+	if err := (FilterConfig{Hysteresis: -1}).Validate(); err == nil {
+		t.Error("Expected error for negative hysteresis")
+	}
+}
+
+func TestDeviceManager_FiltersStateChanges(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.SetFilterConfig(FilterConfig{SpikeThreshold: 20, SpikeConfirmations: 2, Smoothing: SmoothingNone})
+
+	var received []int
+	dm.SetOnStateChange(func(deviceID string, state protocol.DeviceState) {
+		received = append(received, *state.Battery)
+	})
+
+	handler := dm.makeStateChangeHandler("mouse")
+	for _, b := range []int{80, 80, 0, 79} {
+		battery := b
+		handler(protocol.DeviceState{DeviceID: "mouse", Battery: &battery})
+	}
+
+	if len(received) != 2 || received[0] != 80 || received[1] != 79 {
+		t.Errorf("Expected callbacks [80 79], got %v", received)
+	}
+}
+
+func TestBatteryFilter_SpikeConfirmedByTime(t *testing.T) {
+	cfg := FilterConfig{SpikeThreshold: 20, SpikeConfirmations: 2, SpikeConfirmSeconds: 60, Smoothing: SmoothingNone, Monotonic: true}
+	f := newBatteryFilter(cfg)
+
+	f.apply(20, boolPtr(false), filterStart)
+	if got := f.apply(90, boolPtr(false), filterStart.Add(time.Second)); got != 20 {
+		t.Errorf("First sighting of a jump = %d, want 20", got)
+	}
+	if got := f.recheck(filterStart.Add(30 * time.Second)); got != 20 {
+		t.Errorf("Jump accepted after 29s = %d, want 20", got)
+	}
+	if got := f.recheck(filterStart.Add(61 * time.Second)); got != 90 {
+		t.Errorf("Jump held for 60s = %d, want 90", got)
+	}
+
+	// A glitch contradicted by the next reading is never confirmed
+	f.apply(10, boolPtr(false), filterStart.Add(2*time.Minute))
+	f.apply(89, boolPtr(false), filterStart.Add(2*time.Minute+time.Second))
+	if got := f.recheck(filterStart.Add(time.Hour)); got != 89 {
+		t.Errorf("Rejected glitch was confirmed: got %d, want 89", got)
+	}
+}
+
+func TestDeviceManager_ConfirmsLastingJumpWithoutRepeats(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.SetFilterConfig(FilterConfig{SpikeThreshold: 20, SpikeConfirmations: 2, SpikeConfirmSeconds: 60, Smoothing: SmoothingNone})
+	dm.SetStaleThreshold(0)
+
+	device := &mockHIDDevice{id: "mouse"}
+	dm.mu.Lock()
+	dm.devices["mouse"] = device
+	dm.mu.Unlock()
+
+	var received []int
+	dm.SetOnStateChange(func(deviceID string, state protocol.DeviceState) {
+		received = append(received, *state.Battery)
+	})
+
+	// Backends only report changes, so the jump to 90 arrives once
+	handler := dm.makeStateChangeHandler("mouse")
+	for _, b := range []int{20, 90} {
+		battery := b
+		device.state = protocol.DeviceState{DeviceID: "mouse", Battery: &battery, IsConnected: true}
+		handler(device.state)
+	}
+	if len(received) != 1 || received[0] != 20 {
+		t.Fatalf("Expected callbacks [20] before the jump is confirmed, got %v", received)
+	}
+
+	dm.checkStaleness(time.Now().Add(2 * time.Minute))
+	if len(received) != 2 || received[1] != 90 {
+		t.Errorf("Expected the lasting jump to be shown, got %v", received)
+	}
+	if state := dm.GetDeviceStates()["mouse"]; state.Battery == nil || *state.Battery != 90 {
+		t.Errorf("GetDeviceStates battery = %v, want 90", state.Battery)
+	}
+}
@@ -0,0 +1,528 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/hidraw"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// hidFeaturePollInterval is how often battery values found in feature
+// reports are requested; input reports are pushed by the device
+const hidFeaturePollInterval = time.Minute
+
+// hidLevelUsages report a battery level, most preferred first
+var hidLevelUsages = []hidraw.Usage{
+	hidraw.UsageBatteryStrength,
+	hidraw.UsageRelativeStateOfCharge,
+	hidraw.UsageAbsoluteStateOfCharge,
+	hidraw.UsageRemainingCapacity,
+}
+
+// hidValue locates one value in a device's reports
+type hidValue struct {
+	field hidraw.Field
+	index int
+}
+
+// hidBatteryLayout locates the battery values declared in a device's report
+// descriptor. Only level is required.
+type hidBatteryLayout struct {
+	level       *hidValue
+	charging    *hidValue
+	discharging *hidValue
+	acPresent   *hidValue
+
+	featureLengths map[byte]int // Full length of each feature report, by report ID
+}
+
+// findHIDBattery looks for battery usages in parsed descriptor fields,
+// preferring values the device pushes in input reports over feature reports
+func findHIDBattery(fields []hidraw.Field) (hidBatteryLayout, bool) {
+	find := func(usage hidraw.Usage) *hidValue {
+		for _, reportType := range []hidraw.ReportType{hidraw.InputReport, hidraw.FeatureReport} {
+			for _, field := range fields {
+				if field.Type != reportType {
+					continue
+				}
+				if index := field.UsageIndex(usage); index >= 0 {
+					return &hidValue{field: field, index: index}
+				}
+			}
+		}
+		return nil
+	}
+
+	var layout hidBatteryLayout
+	for _, usage := range hidLevelUsages {
+		if layout.level = find(usage); layout.level != nil {
+			break
+		}
+	}
+	if layout.level == nil {
+		return hidBatteryLayout{}, false
+	}
+	layout.charging = find(hidraw.UsageCharging)
+	layout.discharging = find(hidraw.UsageDischarging)
+	layout.acPresent = find(hidraw.UsageACPresent)
+
+	layout.featureLengths = make(map[byte]int)
+	for _, id := range layout.featureReports() {
+		layout.featureLengths[id] = hidraw.ReportLength(fields, hidraw.FeatureReport, id)
+	}
+	return layout, true
+}
+
+func (l hidBatteryLayout) values() []*hidValue {
+	return []*hidValue{l.level, l.charging, l.discharging, l.acPresent}
+}
+
+// featureReports returns the IDs of the feature reports holding battery values
+func (l hidBatteryLayout) featureReports() []byte {
+	var ids []byte
+	seen := make(map[byte]bool)
+	for _, v := range l.values() {
+		if v != nil && v.field.Type == hidraw.FeatureReport && !seen[v.field.ReportID] {
+			seen[v.field.ReportID] = true
+			ids = append(ids, v.field.ReportID)
+		}
+	}
+	return ids
+}
+
+// hidBatteryReading is the latest value of each battery usage, nil until
+// a report containing it arrives
+type hidBatteryReading struct {
+	level       *int
+	charging    *bool
+	discharging *bool
+	acPresent   *bool
+}
+
+// apply updates the reading from a report of the given type and reports
+// whether any battery value was found in it
+func (l hidBatteryLayout) apply(reading *hidBatteryReading, report []byte, reportType hidraw.ReportType) bool {
+	read := func(v *hidValue) (int32, bool) {
+		if v == nil || v.field.Type != reportType {
+			return 0, false
+		}
+		return v.field.Value(report, v.index)
+	}
+	flag := func(v *hidValue, target **bool) bool {
+		value, ok := read(v)
+		if ok {
+			set := value != 0
+			*target = &set
+		}
+		return ok
+	}
+
+	found := false
+	if value, ok := read(l.level); ok {
+		level := scaleHIDLevel(value, l.level.field)
+		reading.level = &level
+		found = true
+	}
+	found = flag(l.charging, &reading.charging) || found
+	found = flag(l.discharging, &reading.discharging) || found
+	found = flag(l.acPresent, &reading.acPresent) || found
+	return found
+}
+
+// scaleHIDLevel converts a value within the field's logical range to a
+// percentage. Battery Strength is commonly 0-255, Battery System values 0-100.
+func scaleHIDLevel(value int32, field hidraw.Field) int {
+	lo, hi := int64(field.LogicalMin), int64(field.LogicalMax)
+	if hi <= lo {
+		lo, hi = 0, 100
+	}
+	level := int((int64(value) - lo) * 100 / (hi - lo))
+	if level < 0 {
+		return 0
+	}
+	if level > 100 {
+		return 100
+	}
+	return level
+}
+
+// power derives the power state from the charging usages, or nil when the
+// device reports none of them
+func (r hidBatteryReading) power() *protocol.PowerState {
+	if r.charging == nil && r.discharging == nil && r.acPresent == nil {
+		return nil
+	}
+	charging := r.charging != nil && *r.charging
+	plugged := false
+	if r.acPresent != nil {
+		plugged = *r.acPresent
+	} else if r.discharging != nil {
+		plugged = !*r.discharging
+	}
+	level := 0
+	if r.level != nil {
+		level = *r.level
+	}
+	power := protocol.ChargerPowerState(charging, plugged, level)
+	return &power
+}
+
+// HIDBatteryDevice monitors any hidraw device whose report descriptor
+// declares a battery, following the HID usage tables rather than a
+// product-specific protocol
+type HIDBatteryDevice struct {
+	open     hidraw.Opener
+	path     string
+	info     hidraw.Info
+	dev      hidraw.Device
+	deviceID string
+	name     string
+	layout   hidBatteryLayout
+	reading  hidBatteryReading
+	state    protocol.DeviceState
+	stopChan chan struct{}
+	onChange func(protocol.DeviceState)
+	mu       sync.RWMutex
+}
+
+// newHIDBatteryDevice creates a monitor for an open hidraw node
+func newHIDBatteryDevice(open hidraw.Opener, path string, dev hidraw.Device, info hidraw.Info,
+	deviceID, name string, layout hidBatteryLayout) *HIDBatteryDevice {
+	return &HIDBatteryDevice{
+		open:     open,
+		path:     path,
+		info:     info,
+		dev:      dev,
+		deviceID: deviceID,
+		name:     name,
+		layout:   layout,
+		state: protocol.DeviceState{
+			DeviceID:   deviceID,
+			DeviceType: string(DeviceTypeHIDBattery),
+		},
+		stopChan: make(chan struct{}),
+	}
+}
+
+// GetID returns the device identifier, derived from its vendor and product IDs
+func (h *HIDBatteryDevice) GetID() string {
+	return h.deviceID
+}
+
+// GetName returns the name reported by the device
+func (h *HIDBatteryDevice) GetName() string {
+	return h.name
+}
+
+// GetType returns the device type
+func (h *HIDBatteryDevice) GetType() DeviceType {
+	return DeviceTypeHIDBattery
+}
+
+// GetState returns the current device state
+func (h *HIDBatteryDevice) GetState() protocol.DeviceState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.state
+}
+
+// IsConnected returns whether the device has reported since it was opened
+func (h *HIDBatteryDevice) IsConnected() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.state.IsConnected
+}
+
+// SetOnStateChange sets the callback for state changes
+func (h *HIDBatteryDevice) SetOnStateChange(callback func(protocol.DeviceState)) {
+	h.mu.Lock()
+	h.onChange = callback
+	h.mu.Unlock()
+}
+
+// Start begins monitoring the device
+func (h *HIDBatteryDevice) Start() error {
+	go func() {
+		if err := h.run(nil); err != nil {
+			log.Printf("HID battery monitoring for %s stopped: %v", h.name, err)
+		}
+	}()
+	return nil
+}
+
+// Run monitors the device until ctx is cancelled or the device goes away,
+// reopening its node first if a previous run closed it
+func (h *HIDBatteryDevice) Run(ctx context.Context) error {
+	return h.run(ctx.Done())
+}
+
+// Stop stops monitoring the device
+func (h *HIDBatteryDevice) Stop() error {
+	select {
+	case <-h.stopChan:
+		// Already closed
+	default:
+		close(h.stopChan)
+	}
+	return nil
+}
+
+// Close stops monitoring and closes the device node
+func (h *HIDBatteryDevice) Close() error {
+	h.Stop()
+	h.closeDevice()
+	return nil
+}
+
+// run reads input reports and polls feature reports until stopped, done is
+// closed or a read fails
+func (h *HIDBatteryDevice) run(done <-chan struct{}) error {
+	dev, err := h.device()
+	if err != nil {
+		h.markDisconnected()
+		return err
+	}
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- h.readLoop(dev)
+	}()
+
+	var poll <-chan time.Time
+	if len(h.layout.featureReports()) > 0 {
+		h.pollFeatures(dev)
+		ticker := time.NewTicker(hidFeaturePollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-h.stopChan:
+			h.closeDevice()
+			return nil
+		case <-done:
+			h.closeDevice()
+			return nil
+		case <-poll:
+			h.pollFeatures(dev)
+		case err := <-readErr:
+			h.closeDevice()
+			h.markDisconnected()
+			if err == nil {
+				err = fmt.Errorf("%s closed", h.path)
+			}
+			return err
+		}
+	}
+}
+
+// device returns the open node, reopening it if needed. The node must still
+// identify as the same product, since hidraw numbers are reused.
+func (h *HIDBatteryDevice) device() (hidraw.Device, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.dev != nil {
+		return h.dev, nil
+	}
+
+	dev, err := h.open(h.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reopen %s: %w", h.path, err)
+	}
+	if info, err := dev.Info(); err != nil || info != h.info {
+		dev.Close()
+		return nil, fmt.Errorf("%s is no longer %s", h.path, h.name)
+	}
+	h.dev = dev
+	return dev, nil
+}
+
+func (h *HIDBatteryDevice) closeDevice() {
+	h.mu.Lock()
+	dev := h.dev
+	h.dev = nil
+	h.mu.Unlock()
+
+	if dev != nil {
+		dev.Close()
+	}
+}
+
+// readLoop applies input reports until a read fails. EOF is not an error.
+func (h *HIDBatteryDevice) readLoop(dev hidraw.Device) error {
+	buf := make([]byte, 64)
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if n > 0 {
+			h.applyReport(buf[:n], hidraw.InputReport)
+		}
+	}
+}
+
+// pollFeatures requests each feature report holding battery values
+func (h *HIDBatteryDevice) pollFeatures(dev hidraw.Device) {
+	for _, id := range h.layout.featureReports() {
+		length := h.layout.featureLengths[id]
+		if id == 0 {
+			// Unnumbered reports are still requested with a leading 0
+			length++
+		}
+		report, err := dev.GetFeatureReport(id, length)
+		if err != nil {
+			log.Printf("Failed to read feature report 0x%02X from %s: %v", id, h.name, err)
+			continue
+		}
+		if id == 0 && len(report) > 0 {
+			report = report[1:]
+		}
+		h.applyReport(report, hidraw.FeatureReport)
+	}
+}
+
+// applyReport updates the state from a report and notifies listeners if it changed
+func (h *HIDBatteryDevice) applyReport(report []byte, reportType hidraw.ReportType) {
+	h.mu.Lock()
+	if !h.layout.apply(&h.reading, report, reportType) {
+		h.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	oldState := h.state
+	if h.reading.level != nil {
+		level := *h.reading.level
+		h.state.Battery = &level
+		h.state.MarkUpdated(protocol.TargetBattery, now)
+	}
+	h.state.Power = h.reading.power()
+	h.state.IsConnected = true
+	h.state.LastSeen = now
+	state := h.state
+	onChange := h.onChange
+	h.mu.Unlock()
+
+	if onChange != nil && !oldState.Equal(state) {
+		log.Printf("🔋 %s: %s", h.name, state)
+		onChange(state)
+	}
+}
+
+// markDisconnected flags the device as disconnected and notifies listeners
+func (h *HIDBatteryDevice) markDisconnected() {
+	h.mu.Lock()
+	oldState := h.state
+	h.state.IsConnected = false
+	state := h.state
+	onChange := h.onChange
+	h.mu.Unlock()
+
+	if onChange != nil && !oldState.Equal(state) {
+		onChange(state)
+	}
+}
+
+// DiscoverHIDBatteryDevices finds hidraw devices whose report descriptor
+// declares a battery. Products listed in defs are skipped, since the GameBuds
+// backend handles them with their own protocol.
+func DiscoverHIDBatteryDevices(defs *protocol.DefinitionSet) ([]*HIDBatteryDevice, error) {
+	return discoverHIDBatteryDevices(RealFileSystem{}, hidraw.OpenDevice, defs)
+}
+
+func discoverHIDBatteryDevices(fs FileSystem, open hidraw.Opener, defs *protocol.DefinitionSet) ([]*HIDBatteryDevice, error) {
+	files, err := fs.ReadDir("/sys/class/hidraw")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hidraw devices: %w", err)
+	}
+
+	var devices []*HIDBatteryDevice
+	seen := make(map[string]bool)
+	for _, f := range files {
+		if !mayBeHIDBattery(fs, f.Name(), defs) {
+			continue
+		}
+		path := fmt.Sprintf("/dev/%s", f.Name())
+		dev, err := open(path)
+		if err != nil {
+			continue
+		}
+
+		info, name, layout, ok := identifyHIDBattery(dev, defs)
+		if !ok {
+			dev.Close()
+			continue
+		}
+
+		deviceID := fmt.Sprintf("hid_%04x_%04x", info.VendorID, info.ProductID)
+		if seen[deviceID] {
+			// A second unit of the same model
+			deviceID += "_" + f.Name()
+		}
+		seen[deviceID] = true
+
+		log.Printf("Found HID battery device %s at %s", name, path)
+		devices = append(devices, newHIDBatteryDevice(open, path, dev, info, deviceID, name, layout))
+	}
+
+	return devices, nil
+}
+
+// mayBeHIDBattery checks a node's sysfs uevent and report descriptor, so
+// that only nodes that may declare a battery are opened. A node whose sysfs
+// attributes cannot be read is checked once opened instead.
+func mayBeHIDBattery(fs FileSystem, node string, defs *protocol.DefinitionSet) bool {
+	if info, name, ok := sysfsIdentity(fs, node); ok {
+		if _, listed := defs.MatchProduct(info.Bus, info.VendorID, info.ProductID, name); listed {
+			return false
+		}
+	}
+	desc, err := fs.ReadFile(sysfsDescriptorPath(node))
+	if err != nil {
+		return true
+	}
+	fields, err := hidraw.ParseDescriptor(desc)
+	if err != nil {
+		return true
+	}
+	_, ok := findHIDBattery(fields)
+	return ok
+}
+
+// identifyHIDBattery reads a node's identity and report descriptor and
+// locates its battery values
+func identifyHIDBattery(dev hidraw.Device, defs *protocol.DefinitionSet) (hidraw.Info, string, hidBatteryLayout, bool) {
+	info, err := dev.Info()
+	if err != nil {
+		return hidraw.Info{}, "", hidBatteryLayout{}, false
+	}
+	name, _ := dev.Name()
+	if _, ok := defs.MatchProduct(info.Bus, info.VendorID, info.ProductID, name); ok {
+		return hidraw.Info{}, "", hidBatteryLayout{}, false
+	}
+	if name == "" {
+		name = fmt.Sprintf("HID Device %04x:%04x", info.VendorID, info.ProductID)
+	}
+
+	desc, err := dev.ReportDescriptor()
+	if err != nil {
+		return hidraw.Info{}, "", hidBatteryLayout{}, false
+	}
+	fields, err := hidraw.ParseDescriptor(desc)
+	if err != nil {
+		log.Printf("Failed to parse report descriptor of %s: %v", name, err)
+		return hidraw.Info{}, "", hidBatteryLayout{}, false
+	}
+	layout, ok := findHIDBattery(fields)
+	return info, name, layout, ok
+}
@@ -0,0 +1,265 @@
+package device
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/hidraw"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// batteryMouseDescriptor declares a Battery Strength byte in input report 1
+var batteryMouseDescriptor = []byte{
+	0x05, 0x01, 0x09, 0x02, 0xA1, 0x01, // Generic Desktop, Mouse, Collection
+	0x85, 0x01, // Report ID (1)
+	0x05, 0x09, 0x19, 0x01, 0x29, 0x03, // Buttons 1-3
+	0x15, 0x00, 0x25, 0x01, 0x95, 0x03, 0x75, 0x01, 0x81, 0x02, // Input 3 x 1 bit
+	0x95, 0x01, 0x75, 0x05, 0x81, 0x01, // Padding
+	0x05, 0x06, 0x09, 0x20, // Generic Device Controls, Battery Strength
+	0x26, 0xFF, 0x00, 0x75, 0x08, 0x81, 0x02, // Input 8 bits, 0-255
+	0xC0,
+}
+
+// batterySystemDescriptor declares charge, charging and AC present in feature report 12
+var batterySystemDescriptor = []byte{
+	0x05, 0x84, 0x09, 0x04, 0xA1, 0x01, // Power Device, UPS, Collection
+	0x85, 0x0C, // Report ID (12)
+	0x05, 0x85, 0x09, 0x64, // Battery System, Relative State of Charge
+	0x15, 0x00, 0x25, 0x64, 0x75, 0x08, 0x95, 0x01, 0xB1, 0x02, // Feature 8 bits, 0-100
+	0x09, 0x44, 0x09, 0xD0, // Charging, AC Present
+	0x25, 0x01, 0x75, 0x01, 0x95, 0x02, 0xB1, 0x02, // Feature 2 x 1 bit
+	0x75, 0x06, 0x95, 0x01, 0xB1, 0x01, // Padding
+	0xC0,
+}
+
+// keyboardDescriptor has no battery
+var keyboardDescriptor = []byte{
+	0x05, 0x01, 0x09, 0x06, 0xA1, 0x01, // Generic Desktop, Keyboard, Collection
+	0x05, 0x07, 0x19, 0xE0, 0x29, 0xE7, // Modifier keys
+	0x15, 0x00, 0x25, 0x01, 0x75, 0x01, 0x95, 0x08, 0x81, 0x02,
+	0xC0,
+}
+
+func fakeOpener(fakes map[string]*hidraw.Fake) hidraw.Opener {
+	return func(path string) (hidraw.Device, error) {
+		if fake, ok := fakes[path]; ok {
+			return fake, nil
+		}
+		return nil, os.ErrNotExist
+	}
+}
+
+func hidrawNodes(names ...string) *MockFileSystem {
+	var infos []os.FileInfo
+	for _, name := range names {
+		infos = append(infos, MockFileInfo{name: name})
+	}
+	return &MockFileSystem{dirContents: map[string][]os.FileInfo{"/sys/class/hidraw": infos}}
+}
+
+func waitForState(t *testing.T, states <-chan protocol.DeviceState, match func(protocol.DeviceState) bool) protocol.DeviceState {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case state := <-states:
+			if match(state) {
+				return state
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for state")
+			return protocol.DeviceState{}
+		}
+	}
+}
+
+func TestDiscoverHIDBatteryDevices(t *testing.T) {
+	buds := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x230A}, "SteelSeries Arctis GameBuds")
+	buds.Descriptor = batteryMouseDescriptor
+	keyboard := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x046D, ProductID: 0xC31C}, "Logitech Keyboard")
+	keyboard.Descriptor = keyboardDescriptor
+	mouse := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusBluetooth, VendorID: 0x046D, ProductID: 0xB023}, "MX Master 3")
+	mouse.Descriptor = batteryMouseDescriptor
+
+	open := fakeOpener(map[string]*hidraw.Fake{
+		"/dev/hidraw0": buds,
+		"/dev/hidraw1": keyboard,
+		"/dev/hidraw2": mouse,
+	})
+	devices, err := discoverHIDBatteryDevices(hidrawNodes("hidraw0", "hidraw1", "hidraw2", "hidraw3"), open, protocol.BuiltinDefinitions())
+	if err != nil {
+		t.Fatalf("discoverHIDBatteryDevices failed: %v", err)
+	}
+
+	if len(devices) != 1 {
+		t.Fatalf("Expected only the mouse, got %d devices", len(devices))
+	}
+	if devices[0].GetID() != "hid_046d_b023" || devices[0].GetName() != "MX Master 3" {
+		t.Errorf("Device = %s (%s)", devices[0].GetID(), devices[0].GetName())
+	}
+	if devices[0].GetType() != DeviceTypeHIDBattery {
+		t.Errorf("GetType() = %s, want %s", devices[0].GetType(), DeviceTypeHIDBattery)
+	}
+	if _, err := keyboard.Write([]byte{0}); err == nil {
+		t.Error("Device without a battery should have been closed")
+	}
+}
+
+func TestDiscoverHIDBatteryDevices_SysfsFilter(t *testing.T) {
+	mouse := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusBluetooth, VendorID: 0x046D, ProductID: 0xB023}, "MX Master 3")
+	mouse.Descriptor = batteryMouseDescriptor
+	fs := hidrawNodes("hidraw0", "hidraw1", "hidraw2")
+	fs.files = map[string][]byte{
+		// Listed in the definitions
+		"/sys/class/hidraw/hidraw0/device/uevent":            []byte("HID_ID=0003:00001038:0000230A\nHID_NAME=SteelSeries Arctis GameBuds\n"),
+		"/sys/class/hidraw/hidraw0/device/report_descriptor": batteryMouseDescriptor,
+		// No battery
+		"/sys/class/hidraw/hidraw1/device/uevent":            []byte("HID_ID=0003:0000046D:0000C31C\nHID_NAME=Logitech Keyboard\n"),
+		"/sys/class/hidraw/hidraw1/device/report_descriptor": keyboardDescriptor,
+		"/sys/class/hidraw/hidraw2/device/uevent":            []byte("HID_ID=0005:0000046D:0000B023\nHID_NAME=MX Master 3\n"),
+		"/sys/class/hidraw/hidraw2/device/report_descriptor": batteryMouseDescriptor,
+	}
+
+	var opened []string
+	open := func(path string) (hidraw.Device, error) {
+		opened = append(opened, path)
+		if path == "/dev/hidraw2" {
+			return mouse, nil
+		}
+		return nil, os.ErrPermission
+	}
+	devices, err := discoverHIDBatteryDevices(fs, open, protocol.BuiltinDefinitions())
+	if err != nil {
+		t.Fatalf("discoverHIDBatteryDevices failed: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Expected only the mouse, got %d devices", len(devices))
+	}
+	if len(opened) != 1 || opened[0] != "/dev/hidraw2" {
+		t.Errorf("Opened %v, want only the mouse", opened)
+	}
+}
+
+func TestHIDBatteryDevice_InputReports(t *testing.T) {
+	mouse := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x046D, ProductID: 0xB023}, "MX Master 3")
+	mouse.Descriptor = batteryMouseDescriptor
+	devices, err := discoverHIDBatteryDevices(hidrawNodes("hidraw0"), fakeOpener(map[string]*hidraw.Fake{"/dev/hidraw0": mouse}), protocol.BuiltinDefinitions())
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	dev := devices[0]
+
+	states := make(chan protocol.DeviceState, 10)
+	dev.SetOnStateChange(func(state protocol.DeviceState) { states <- state })
+
+	result := make(chan error)
+	go func() { result <- dev.Run(context.Background()) }()
+
+	mouse.Inject([]byte{0x01, 0x00, 0x80})
+	state := waitForState(t, states, func(s protocol.DeviceState) bool { return s.Battery != nil })
+	if *state.Battery != 50 || !state.IsConnected {
+		t.Errorf("Battery = %d (connected %v), want 50 scaled from 128/255", *state.Battery, state.IsConnected)
+	}
+	if state.Power != nil {
+		t.Errorf("Power = %v, want nil without charging usages", *state.Power)
+	}
+
+	// Unplugging ends the run so the supervisor can retry
+	mouse.Close()
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Run should return an error when the device goes away")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after the device closed")
+	}
+	if dev.IsConnected() {
+		t.Error("Device should be disconnected")
+	}
+}
+
+func TestHIDBatteryDevice_FeatureReports(t *testing.T) {
+	ups := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x0764, ProductID: 0x0501}, "UPS")
+	ups.Descriptor = batterySystemDescriptor
+	ups.Features[0x0C] = []byte{0x0C, 87, 0x03}
+
+	devices, err := discoverHIDBatteryDevices(hidrawNodes("hidraw0"), fakeOpener(map[string]*hidraw.Fake{"/dev/hidraw0": ups}), protocol.BuiltinDefinitions())
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+	dev := devices[0]
+
+	states := make(chan protocol.DeviceState, 10)
+	dev.SetOnStateChange(func(state protocol.DeviceState) { states <- state })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- dev.Run(ctx) }()
+
+	state := waitForState(t, states, func(s protocol.DeviceState) bool { return s.Battery != nil })
+	if *state.Battery != 87 {
+		t.Errorf("Battery = %d, want 87", *state.Battery)
+	}
+	if state.Power == nil || *state.Power != protocol.PowerCharging {
+		t.Errorf("Power = %v, want Charging", state.Power)
+	}
+
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Run after cancel = %v, want nil", err)
+	}
+}
+
+func TestHIDBatteryReading_Power(t *testing.T) {
+	yes, no := true, false
+	full := 100
+
+	tests := []struct {
+		name    string
+		reading hidBatteryReading
+		want    protocol.PowerState
+		known   bool
+	}{
+		{"no charging usages", hidBatteryReading{}, protocol.PowerUnknown, false},
+		{"charging", hidBatteryReading{charging: &yes}, protocol.PowerCharging, true},
+		{"on battery", hidBatteryReading{charging: &no, acPresent: &no}, protocol.PowerDischarging, true},
+		{"plugged in full", hidBatteryReading{level: &full, charging: &no, acPresent: &yes}, protocol.PowerFull, true},
+		{"not discharging means plugged", hidBatteryReading{discharging: &no}, protocol.PowerNotCharging, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			power := tt.reading.power()
+			if (power != nil) != tt.known {
+				t.Fatalf("power() = %v, want known %v", power, tt.known)
+			}
+			if power != nil && *power != tt.want {
+				t.Errorf("power() = %v, want %v", *power, tt.want)
+			}
+		})
+	}
+}
+
+func TestScaleHIDLevel(t *testing.T) {
+	tests := []struct {
+		value    int32
+		min, max int32
+		want     int
+	}{
+		{255, 0, 255, 100},
+		{64, 0, 255, 25},
+		{87, 0, 100, 87},
+		{120, 0, 100, 100},
+		{42, 0, 0, 42},
+	}
+
+	for _, tt := range tests {
+		field := hidraw.Field{LogicalMin: tt.min, LogicalMax: tt.max}
+		if got := scaleHIDLevel(tt.value, field); got != tt.want {
+			t.Errorf("scaleHIDLevel(%d, %d-%d) = %d, want %d", tt.value, tt.min, tt.max, got, tt.want)
+		}
+	}
+}
@@ -1,19 +1,28 @@
 package device
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/jyablonski/goarctis/pkg/hidraw"
 	"github.com/jyablonski/goarctis/pkg/protocol"
 )
 
 const (
 	VendorID  = 0x1038
 	ProductID = 0x230a
+
+	// linkCheckInterval is how often report silence is checked to detect
+	// buds that were switched off while the dongle stays plugged in
+	linkCheckInterval = 10 * time.Second
 )
 
 // FileSystem interface for testability
@@ -38,151 +47,369 @@ func (fs RealFileSystem) OpenFile(name string, flag int, perm os.FileMode) (io.R
 	return os.OpenFile(name, flag, perm)
 }
 
+// sysfsDevice is a hidraw node opened through a FileSystem. It is identified
+// from the node's sysfs uevent instead of ioctls and only supports reading.
+type sysfsDevice struct {
+	io.ReadCloser
+	fs   FileSystem
+	node string
+	info hidraw.Info
+	name string
+	phys string
+}
+
+// sysfsOpener returns an Opener that opens hidraw nodes through fs
+func sysfsOpener(fs FileSystem) hidraw.Opener {
+	return func(path string) (hidraw.Device, error) {
+		node := strings.TrimPrefix(path, "/dev/")
+		uevent, err := fs.ReadFile(sysfsUeventPath(node))
+		if err != nil {
+			return nil, err
+		}
+		busType, vendorID, productID, name, ok := parseHIDUevent(string(uevent))
+		if !ok {
+			return nil, fmt.Errorf("%s: no HID_ID in uevent", node)
+		}
+
+		f, err := fs.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			return nil, err
+		}
+		return &sysfsDevice{
+			ReadCloser: f,
+			fs:         fs,
+			node:       node,
+			info:       hidraw.Info{Bus: busType, VendorID: vendorID, ProductID: productID},
+			name:       name,
+			phys:       ueventValue(string(uevent), "HID_PHYS"),
+		}, nil
+	}
+}
+
+func (d *sysfsDevice) Info() (hidraw.Info, error) { return d.info, nil }
+func (d *sysfsDevice) Name() (string, error)      { return d.name, nil }
+func (d *sysfsDevice) Phys() (string, error)      { return d.phys, nil }
+
+func (d *sysfsDevice) ReportDescriptor() ([]byte, error) {
+	return d.fs.ReadFile(sysfsDescriptorPath(d.node))
+}
+
+// sysfsUeventPath returns the uevent of a hidraw node such as "hidraw3"
+func sysfsUeventPath(node string) string {
+	return fmt.Sprintf("/sys/class/hidraw/%s/device/uevent", node)
+}
+
+// sysfsDescriptorPath returns the report descriptor of a hidraw node
+func sysfsDescriptorPath(node string) string {
+	return fmt.Sprintf("/sys/class/hidraw/%s/device/report_descriptor", node)
+}
+
+// sysfsIdentity reads a hidraw node's bus, IDs and name from its uevent,
+// which unlike the node itself is readable without permissions. ok is false
+// if the uevent cannot be read or parsed.
+func sysfsIdentity(fs FileSystem, node string) (info hidraw.Info, name string, ok bool) {
+	uevent, err := fs.ReadFile(sysfsUeventPath(node))
+	if err != nil {
+		return hidraw.Info{}, "", false
+	}
+	busType, vendorID, productID, name, ok := parseHIDUevent(string(uevent))
+	return hidraw.Info{Bus: busType, VendorID: vendorID, ProductID: productID}, name, ok
+}
+
+func (d *sysfsDevice) Write([]byte) (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func (d *sysfsDevice) GetFeatureReport(byte, int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (d *sysfsDevice) SendFeatureReport([]byte) error {
+	return errors.ErrUnsupported
+}
+
+// hidInterface is an open hidraw node and the product definition it matched
+type hidInterface struct {
+	hidraw.Device
+	product protocol.ProductMatch
+}
+
 type HIDRawManager struct {
-	devices    []io.ReadCloser
-	protocol   *protocol.Handler
-	stopChan   chan struct{}
-	fs         FileSystem
-	deviceID   string
-	deviceName string
-	onChange   func(protocol.DeviceState)
+	devices     []hidInterface
+	transport   string // Bus of the opened interfaces, see Transport
+	protocol    *protocol.Handler
+	definitions *protocol.DefinitionSet
+	stopChan    chan struct{}
+	fs          FileSystem
+	open        hidraw.Opener
+	deviceID    string
+	deviceName  string
+	deviceType  DeviceType
+	identified  bool // Whether the identity was taken from a found product
+	onChange    func(protocol.DeviceState)
+	mu          sync.Mutex // Guards devices, the identity and onChange
 }
 
 func NewHIDRawManager() *HIDRawManager {
-	return &HIDRawManager{
-		protocol:   protocol.NewHandler(),
-		stopChan:   make(chan struct{}),
-		fs:         RealFileSystem{},
-		deviceID:   "steelseries_gamebuds",
-		deviceName: "SteelSeries Arctis GameBuds",
-	}
+	return newHIDRawManager(RealFileSystem{}, hidraw.OpenDevice, protocol.BuiltinDefinitions())
 }
 
-// NewHIDRawManagerWithFS creates a manager with a custom filesystem (for testing)
+// NewHIDRawManagerWithFS creates a manager with a custom filesystem (for
+// testing). Devices are identified from their sysfs uevent.
 func NewHIDRawManagerWithFS(fs FileSystem) *HIDRawManager {
+	return newHIDRawManager(fs, sysfsOpener(fs), protocol.BuiltinDefinitions())
+}
+
+// NewHIDRawManagerWithDefinitions creates a manager that matches products and
+// parses reports according to the given definitions, which should be a
+// single product family (see protocol.DefinitionSet.Families)
+func NewHIDRawManagerWithDefinitions(defs *protocol.DefinitionSet) *HIDRawManager {
+	return newHIDRawManager(RealFileSystem{}, hidraw.OpenDevice, defs)
+}
+
+func newHIDRawManager(fs FileSystem, open hidraw.Opener, defs *protocol.DefinitionSet) *HIDRawManager {
 	return &HIDRawManager{
-		protocol:   protocol.NewHandler(),
-		stopChan:   make(chan struct{}),
-		fs:         fs,
-		deviceID:   "steelseries_gamebuds",
-		deviceName: "SteelSeries Arctis GameBuds",
+		protocol:    protocol.NewHandlerWithDefinitions(defs),
+		definitions: defs,
+		stopChan:    make(chan struct{}),
+		fs:          fs,
+		open:        open,
+		deviceID:    "steelseries_gamebuds",
+		deviceName:  "SteelSeries Arctis GameBuds",
+		deviceType:  DeviceTypeSteelSeriesGameBuds,
 	}
 }
 
-// FindDevices finds all hidraw devices for the GameBuds
+// FindDevices finds all hidraw devices for the products in the definitions,
+// e.g. the GameBuds attached through the USB dongle or paired directly over
+// Bluetooth. Each node is identified by its bus, vendor/product IDs and name
+// as reported by the hidraw driver.
 func (m *HIDRawManager) FindDevices() error {
 	files, err := m.fs.ReadDir("/sys/class/hidraw")
 	if err != nil {
 		return fmt.Errorf("failed to read hidraw devices: %w", err)
 	}
 
-	var hidrawPaths []string
+	var found []hidInterface
+	var openErr error
+	opened := 0
 	for _, f := range files {
-		devicePath := fmt.Sprintf("/sys/class/hidraw/%s/device/uevent", f.Name())
-		data, err := m.fs.ReadFile(devicePath)
+		// Nodes of other products are skipped without opening them
+		if info, name, ok := sysfsIdentity(m.fs, f.Name()); ok {
+			if _, listed := m.definitions.MatchProduct(info.Bus, info.VendorID, info.ProductID, name); !listed {
+				continue
+			}
+		}
+
+		hidrawPath := fmt.Sprintf("/dev/%s", f.Name())
+		dev, err := m.open(hidrawPath)
 		if err != nil {
+			openErr = err
 			continue
 		}
+		opened++
 
-		content := string(data)
-		// Look for our VID:PID (1038:230a)
-		if strings.Contains(content, "HID_ID=0003:00001038:0000230A") {
-			hidrawPath := fmt.Sprintf("/dev/%s", f.Name())
-			hidrawPaths = append(hidrawPaths, hidrawPath)
+		// Look for any product listed in the report definitions
+		// (1038:230a over USB and GameBuds over Bluetooth built in)
+		product, ok := m.matchProduct(dev)
+		if !ok {
+			dev.Close()
+			continue
+		}
+		found = append(found, hidInterface{Device: dev, product: product})
+		m.identify(dev)
 
-			// Get interface number
-			interfacePath := fmt.Sprintf("/sys/class/hidraw/%s/device/../bInterfaceNumber", f.Name())
-			if ifNum, err := m.fs.ReadFile(interfacePath); err == nil {
-				ifNumStr := strings.TrimSpace(string(ifNum))
-				log.Printf("Found GameBuds HID interface: %s (interface %s)", hidrawPath, ifNumStr)
-			}
+		if phys, err := dev.Phys(); err == nil && phys != "" {
+			log.Printf("Found %s HID interface: %s (%s)", m.GetName(), hidrawPath, phys)
+		} else {
+			log.Printf("Found %s HID interface: %s (%s)", m.GetName(), hidrawPath, product.BusName())
 		}
 	}
 
-	if len(hidrawPaths) == 0 {
-		return fmt.Errorf("no GameBuds hidraw devices found")
+	if len(found) == 0 {
+		if openErr != nil {
+			// Nodes without read permission cannot be identified, and
+			// the GameBuds may be among them
+			log.Printf("Warning: Could not open some hidraw devices: %v", openErr)
+		}
+		if opened == 0 && openErr != nil {
+			return fmt.Errorf("could not open any hidraw devices")
+		}
+		return fmt.Errorf("no %s hidraw devices found", m.familyName())
 	}
 
-	// Open all devices
-	for _, path := range hidrawPaths {
-		f, err := m.fs.OpenFile(path, os.O_RDONLY, 0)
-		if err != nil {
-			log.Printf("Warning: Could not open %s: %v", path, err)
+	m.mu.Lock()
+	m.devices = append(m.devices, found...)
+	m.transport = found[len(found)-1].product.BusName()
+	m.mu.Unlock()
+
+	log.Printf("Successfully opened %d HID interfaces", len(found))
+	return nil
+}
+
+// matchProduct returns the defined product an open hidraw node belongs to
+func (m *HIDRawManager) matchProduct(dev hidraw.Device) (protocol.ProductMatch, bool) {
+	info, err := dev.Info()
+	if err != nil {
+		return protocol.ProductMatch{}, false
+	}
+	name, _ := dev.Name()
+	return m.definitions.MatchProduct(info.Bus, info.VendorID, info.ProductID, name)
+}
+
+// identify takes the device identity from the first product found. Products
+// in the GameBuds family keep the GameBuds ID; others are named like HID
+// battery devices, after their vendor/product IDs.
+func (m *HIDRawManager) identify(dev hidraw.Device) {
+	info, err := dev.Info()
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.identified {
+		return
+	}
+	m.identified = true
+	if m.isGameBuds() {
+		return
+	}
+
+	m.deviceID = fmt.Sprintf("hid_%04x_%04x", info.VendorID, info.ProductID)
+	m.deviceType = DeviceTypeHIDReports
+	m.deviceName = m.definitions.Name
+	if m.deviceName == "" {
+		m.deviceName, _ = dev.Name()
+	}
+	if m.deviceName == "" {
+		m.deviceName = fmt.Sprintf("HID Device %04x:%04x", info.VendorID, info.ProductID)
+	}
+}
+
+// isGameBuds reports whether the definitions are the GameBuds family
+func (m *HIDRawManager) isGameBuds() bool {
+	return m.definitions.HasProduct(VendorID, ProductID)
+}
+
+// familyName names the products looked for in errors
+func (m *HIDRawManager) familyName() string {
+	switch {
+	case m.isGameBuds():
+		return "GameBuds"
+	case m.definitions.Name == "":
+		return "matching"
+	}
+	return m.definitions.Name
+}
+
+// parseHIDUevent extracts the bus type, IDs and name from a hidraw device's
+// uevent, e.g. "HID_ID=0005:00001038:000012AB" and "HID_NAME=Arctis GameBuds"
+func parseHIDUevent(uevent string) (busType, vendorID, productID uint16, name string, ok bool) {
+	for _, line := range strings.Split(uevent, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
 			continue
 		}
-		m.devices = append(m.devices, f)
+		switch key {
+		case "HID_ID":
+			var bus, vendor, product uint32
+			if _, err := fmt.Sscanf(value, "%x:%x:%x", &bus, &vendor, &product); err != nil {
+				return 0, 0, 0, "", false
+			}
+			busType, vendorID, productID = uint16(bus), uint16(vendor), uint16(product)
+			ok = true
+		case "HID_NAME":
+			name = value
+		}
 	}
+	return busType, vendorID, productID, name, ok
+}
 
-	if len(m.devices) == 0 {
-		return fmt.Errorf("could not open any hidraw devices")
+// ueventValue returns the value of key in a uevent, or "" if absent
+func ueventValue(uevent, key string) string {
+	for _, line := range strings.Split(uevent, "\n") {
+		if k, value, found := strings.Cut(strings.TrimSpace(line), "="); found && k == key {
+			return value
+		}
 	}
+	return ""
+}
 
-	log.Printf("Successfully opened %d HID interfaces", len(m.devices))
-	return nil
+// Transport returns how the GameBuds are attached: "usb" for the dongle or
+// "bluetooth" when paired directly, or "" before discovery
+func (m *HIDRawManager) Transport() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.transport
+}
+
+// lostConnection is the connection state after every interface closed. A
+// Bluetooth node disappears when the buds switch off or go out of range,
+// while a dongle node only disappears when the dongle is unplugged.
+func (m *HIDRawManager) lostConnection() protocol.ConnectionState {
+	if m.Transport() == protocol.BusBluetooth {
+		return protocol.ConnectionOff
+	}
+	return protocol.ConnectionDisconnected
 }
 
 // GetID returns the device identifier
 func (m *HIDRawManager) GetID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.deviceID
 }
 
 // GetName returns the device name
 func (m *HIDRawManager) GetName() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.deviceName
 }
 
 // GetType returns the device type
 func (m *HIDRawManager) GetType() DeviceType {
-	return DeviceTypeSteelSeriesGameBuds
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deviceType
 }
 
 // SetOnStateChange sets a callback for when device state changes
 func (m *HIDRawManager) SetOnStateChange(callback func(protocol.DeviceState)) {
+	m.mu.Lock()
 	m.onChange = callback
+	m.mu.Unlock()
 	// Wrap callback to set device ID and type
 	m.protocol.SetOnChange(func(state protocol.DeviceState) {
+		m.mu.Lock()
 		state.DeviceID = m.deviceID
-		state.DeviceType = string(DeviceTypeSteelSeriesGameBuds)
-		if m.onChange != nil {
-			m.onChange(state)
+		state.DeviceType = string(m.deviceType)
+		onChange := m.onChange
+		m.mu.Unlock()
+		if onChange != nil {
+			onChange(state)
 		}
 	})
 }
 
 // Start begins monitoring all HID interfaces
 func (m *HIDRawManager) Start() error {
-	if len(m.devices) == 0 {
+	devices := m.openDevices()
+	if len(devices) == 0 {
 		return fmt.Errorf("no devices to monitor")
 	}
 
-	log.Printf("Monitoring %d HID interfaces...", len(m.devices))
+	log.Printf("Monitoring %d HID interfaces...", len(devices))
+	go m.watchLink(nil)
 
 	// Start a goroutine for each device
-	for i, device := range m.devices {
+	for i, device := range devices {
 		deviceNum := i
 		dev := device
 
 		go func() {
-			buf := make([]byte, 64)
-			for {
-				select {
-				case <-m.stopChan:
-					return
-				default:
-					n, err := dev.Read(buf)
-					if err != nil {
-						if err != io.EOF {
-							log.Printf("Read error on device %d: %v", deviceNum, err)
-						}
-						return
-					}
-
-					if n > 0 {
-						data := make([]byte, n)
-						copy(data, buf[:n])
-						m.protocol.ParseReport(data)
-					}
-				}
+			if err := m.readLoop(dev, nil); err != nil {
+				log.Printf("Read error on device %d: %v", deviceNum, err)
 			}
 		}()
 	}
@@ -190,17 +417,188 @@ func (m *HIDRawManager) Start() error {
 	return nil
 }
 
+// Run monitors all HID interfaces until ctx is cancelled, reopening them if
+// needed. It returns an error once every interface has stopped delivering
+// reports, e.g. because the dongle was unplugged.
+func (m *HIDRawManager) Run(ctx context.Context) error {
+	if len(m.openDevices()) == 0 {
+		if err := m.FindDevices(); err != nil {
+			m.protocol.SetConnection(m.lostConnection())
+			return err
+		}
+	}
+	devices := m.openDevices()
+	if state := m.protocol.GetState(); state.Connection != nil && *state.Connection == protocol.ConnectionDisconnected {
+		// The dongle is back; the buds still have to report before they count
+		// as linked. Bluetooth buds keep their "off" state until they report.
+		m.protocol.SetConnection(protocol.ConnectionDongle)
+	}
+
+	log.Printf("Monitoring %d HID interfaces...", len(devices))
+
+	watchDone := make(chan struct{})
+	defer close(watchDone)
+	go m.watchLink(watchDone)
+
+	errs := make(chan error, len(devices))
+	for _, device := range devices {
+		dev := device
+		go func() {
+			errs <- recoverAsError(func() error {
+				return m.readLoop(dev, ctx.Done())
+			})
+		}()
+	}
+
+	var lastErr error
+	for remaining := len(devices); remaining > 0; remaining-- {
+		select {
+		case err := <-errs:
+			if err != nil {
+				lastErr = err
+			}
+		case <-ctx.Done():
+			// Closing the devices unblocks the pending reads
+			m.closeDevices()
+			return nil
+		}
+	}
+
+	m.closeDevices()
+	m.protocol.SetConnection(m.lostConnection())
+	if lastErr == nil {
+		lastErr = fmt.Errorf("all HID interfaces closed")
+	}
+	return lastErr
+}
+
+// watchLink periodically checks whether the buds have gone silent until the
+// manager is stopped or done is closed
+func (m *HIDRawManager) watchLink(done <-chan struct{}) {
+	ticker := time.NewTicker(linkCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		case <-done:
+			return
+		case now := <-ticker.C:
+			m.protocol.CheckLink(now)
+		}
+	}
+}
+
+// readLoop forwards reports from one interface to the protocol handler until
+// the device is stopped, done is closed or a read fails. EOF is not an error.
+func (m *HIDRawManager) readLoop(dev hidInterface, done <-chan struct{}) error {
+	buf := make([]byte, 64)
+	for {
+		select {
+		case <-m.stopChan:
+			return nil
+		case <-done:
+			return nil
+		default:
+			n, err := dev.Read(buf)
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+
+			if n > 0 {
+				data := make([]byte, n)
+				copy(data, buf[:n])
+				m.protocol.ParseFramedReport(data, dev.product.HeaderLength)
+			}
+		}
+	}
+}
+
+// openDevices returns a snapshot of the currently open interfaces
+func (m *HIDRawManager) openDevices() []hidInterface {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]hidInterface(nil), m.devices...)
+}
+
+// closeDevices closes and forgets all open interfaces
+func (m *HIDRawManager) closeDevices() {
+	m.mu.Lock()
+	devices := m.devices
+	m.devices = nil
+	m.mu.Unlock()
+
+	for _, dev := range devices {
+		dev.Close()
+	}
+}
+
+// Settings returns the ANC mode, if the report definitions include a
+// command to change it
+func (m *HIDRawManager) Settings() []Setting {
+	command, ok := m.definitions.Command(protocol.TargetANCMode)
+	if !ok {
+		return nil
+	}
+
+	setting := Setting{
+		ID:      SettingANCMode,
+		Name:    "Noise Cancelling",
+		Kind:    SettingEnum,
+		Options: command.Values(),
+	}
+	if mode := m.protocol.GetState().ANCMode; mode != nil {
+		setting.Value = mode.ID()
+	}
+	return []Setting{setting}
+}
+
+// ApplySetting sends the command for a setting as an output report. The
+// state is updated once the buds confirm the change with their own report.
+func (m *HIDRawManager) ApplySetting(id string, value any) error {
+	command, ok := m.definitions.Command(protocol.TargetANCMode)
+	if id != SettingANCMode || !ok {
+		return fmt.Errorf("unknown setting %q", id)
+	}
+	name, _ := value.(string)
+	report, err := command.Encode(name)
+	if err != nil {
+		return err
+	}
+
+	devices := m.openDevices()
+	if len(devices) == 0 {
+		return fmt.Errorf("%s is not connected", m.GetName())
+	}
+
+	// Only one of the interfaces accepts output reports
+	var lastErr error
+	for _, dev := range devices {
+		if _, err := dev.Write(report); err != nil {
+			lastErr = err
+			continue
+		}
+		log.Printf("Sent %s (% X)", command.Name, report)
+		return nil
+	}
+	return fmt.Errorf("failed to send %s: %w", command.Name, lastErr)
+}
+
 // GetState returns the current device state
 func (m *HIDRawManager) GetState() protocol.DeviceState {
 	state := m.protocol.GetState()
-	state.DeviceID = m.deviceID
-	state.DeviceType = string(DeviceTypeSteelSeriesGameBuds)
+	state.DeviceID = m.GetID()
+	state.DeviceType = string(m.GetType())
 	return state
 }
 
-// IsConnected returns whether the device is connected
+// IsConnected returns whether the dongle is open and the buds are linked
 func (m *HIDRawManager) IsConnected() bool {
-	return len(m.devices) > 0
+	return len(m.openDevices()) > 0 && m.protocol.GetState().IsConnected
 }
 
 // Stop stops monitoring
@@ -217,8 +615,6 @@ func (m *HIDRawManager) Stop() error {
 // Close closes all devices
 func (m *HIDRawManager) Close() error {
 	m.Stop()
-	for _, dev := range m.devices {
-		dev.Close()
-	}
+	m.closeDevices()
 	return nil
 }
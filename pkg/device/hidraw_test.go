@@ -2,12 +2,15 @@ package device
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jyablonski/goarctis/pkg/hidraw"
 	"github.com/jyablonski/goarctis/pkg/protocol"
 )
 
@@ -258,3 +261,282 @@ func TestStart_NoDevices(t *testing.T) {
 		t.Error("Start should return error when no devices")
 	}
 }
+
+func TestFindDevices_DefinedProduct(t *testing.T) {
+	mockFS := &MockFileSystem{
+		dirContents: map[string][]os.FileInfo{
+			"/sys/class/hidraw": {
+				MockFileInfo{name: "hidraw0"},
+			},
+		},
+		files: map[string][]byte{
+			"/sys/class/hidraw/hidraw0/device/uevent": []byte("HID_ID=0003:00001038:0000230B\n"),
+			"/dev/hidraw0": []byte{},
+		},
+	}
+
+	defs := protocol.BuiltinDefinitions()
+	defs.Merge(&protocol.DefinitionSet{
+		Products: []protocol.ProductMatch{{VendorID: 0x1038, ProductID: 0x230B}},
+	})
+
+	manager := newHIDRawManager(mockFS, sysfsOpener(mockFS), defs)
+	if err := manager.FindDevices(); err != nil {
+		t.Fatalf("FindDevices failed for product from definitions: %v", err)
+	}
+	if len(manager.devices) != 1 {
+		t.Errorf("Expected 1 device, got %d", len(manager.devices))
+	}
+}
+
+func TestFindDevices_SkipsOtherProducts(t *testing.T) {
+	buds := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x230A}, "SteelSeries Arctis GameBuds")
+	fs := hidrawNodes("hidraw0", "hidraw1")
+	fs.files = map[string][]byte{
+		"/sys/class/hidraw/hidraw0/device/uevent": []byte("HID_ID=0003:0000046D:0000C31C\nHID_NAME=Logitech Keyboard\n"),
+		"/sys/class/hidraw/hidraw1/device/uevent": []byte("HID_ID=0003:00001038:0000230A\nHID_NAME=SteelSeries Arctis GameBuds\n"),
+	}
+
+	var opened []string
+	open := func(path string) (hidraw.Device, error) {
+		opened = append(opened, path)
+		if path == "/dev/hidraw1" {
+			return buds, nil
+		}
+		return nil, os.ErrPermission
+	}
+	manager := newHIDRawManager(fs, open, protocol.BuiltinDefinitions())
+	if err := manager.FindDevices(); err != nil {
+		t.Fatalf("FindDevices failed: %v", err)
+	}
+	if len(opened) != 1 || opened[0] != "/dev/hidraw1" {
+		t.Errorf("Opened %v, want only the GameBuds", opened)
+	}
+}
+
+func TestFindDevices_ProductFamilies(t *testing.T) {
+	defs := protocol.BuiltinDefinitions()
+	defs.Scoped = append(defs.Scoped, &protocol.DefinitionSet{
+		Name:     "Other Headset",
+		Products: []protocol.ProductMatch{{VendorID: 0x1038, ProductID: 0x12AB}},
+		Reports: []protocol.ReportDefinition{{Name: "Battery", ReportID: protocol.ReportBattery, MinLength: 2, Fields: []protocol.FieldDefinition{
+			{Name: "level", Target: protocol.TargetBattery, Offset: 1},
+		}}},
+	})
+	fakes := map[string]*hidraw.Fake{
+		"/dev/hidraw0": hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x230A}, "SteelSeries Arctis GameBuds"),
+		"/dev/hidraw1": hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x12AB}, "SteelSeries Headset"),
+	}
+	fs := hidrawNodes("hidraw0", "hidraw1")
+
+	families := defs.Families()
+	if len(families) != 2 {
+		t.Fatalf("Families = %d, want 2", len(families))
+	}
+	buds := newHIDRawManager(fs, fakeOpener(fakes), families[0])
+	headset := newHIDRawManager(fs, fakeOpener(fakes), families[1])
+	for _, manager := range []*HIDRawManager{buds, headset} {
+		if err := manager.FindDevices(); err != nil {
+			t.Fatalf("FindDevices failed: %v", err)
+		}
+		if len(manager.devices) != 1 {
+			t.Errorf("%s opened %d interfaces, want 1", manager.GetName(), len(manager.devices))
+		}
+	}
+
+	if buds.GetID() != "steelseries_gamebuds" || buds.GetType() != DeviceTypeSteelSeriesGameBuds {
+		t.Errorf("GameBuds = %s (%s)", buds.GetID(), buds.GetType())
+	}
+	if headset.GetID() != "hid_1038_12ab" || headset.GetName() != "Other Headset" || headset.GetType() != DeviceTypeHIDReports {
+		t.Errorf("Headset = %s %q (%s)", headset.GetID(), headset.GetName(), headset.GetType())
+	}
+
+	// The headset's battery report is not parsed as the GameBuds' one
+	headset.protocol.ParseReport([]byte{protocol.ReportBattery, 64})
+	if state := headset.GetState(); state.Battery == nil || *state.Battery != 64 || state.LeftBattery != nil {
+		t.Errorf("Headset state = %+v, want battery 64", state)
+	}
+	if state := headset.GetState(); state.DeviceID != "hid_1038_12ab" {
+		t.Errorf("DeviceID = %q", state.DeviceID)
+	}
+}
+
+func TestRun_ReportsThenDongleRemoved(t *testing.T) {
+	mockFS := &MockFileSystem{
+		dirContents: map[string][]os.FileInfo{
+			"/sys/class/hidraw": {MockFileInfo{name: "hidraw0"}},
+		},
+		files: map[string][]byte{
+			"/sys/class/hidraw/hidraw0/device/uevent": []byte("HID_ID=0003:00001038:0000230A\n"),
+			// One battery report, then EOF as if the dongle was unplugged
+			"/dev/hidraw0": {protocol.ReportBattery, 60, 70},
+		},
+	}
+
+	manager := NewHIDRawManagerWithFS(mockFS)
+	var states []protocol.DeviceState
+	manager.SetOnStateChange(func(state protocol.DeviceState) {
+		states = append(states, state)
+	})
+
+	err := manager.Run(context.Background())
+	if err == nil {
+		t.Fatal("Run should fail once every interface has closed")
+	}
+
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 state changes (linked, disconnected), got %d", len(states))
+	}
+	if !states[0].IsConnected || *states[0].Connection != protocol.ConnectionLinked {
+		t.Errorf("First state = %v, want linked", states[0].Connection)
+	}
+	last := states[1]
+	if last.IsConnected || *last.Connection != protocol.ConnectionDisconnected {
+		t.Errorf("Last state = %v, want disconnected", last.Connection)
+	}
+	if manager.IsConnected() {
+		t.Error("IsConnected should be false after the dongle is removed")
+	}
+}
+
+func TestParseHIDUevent(t *testing.T) {
+	uevent := "DRIVER=hid-generic\nHID_ID=0005:00001038:000012AB\nHID_NAME=Arctis GameBuds\nHID_PHYS=aa:bb:cc:dd:ee:ff\n"
+	bus, vendor, product, name, ok := parseHIDUevent(uevent)
+	if !ok {
+		t.Fatal("parseHIDUevent failed")
+	}
+	if bus != 0x0005 || vendor != 0x1038 || product != 0x12AB || name != "Arctis GameBuds" {
+		t.Errorf("parseHIDUevent = %04X:%04X:%04X %q", bus, vendor, product, name)
+	}
+
+	if _, _, _, _, ok := parseHIDUevent("DRIVER=hid-generic\n"); ok {
+		t.Error("Expected failure without HID_ID")
+	}
+}
+
+func TestFindDevices_Bluetooth(t *testing.T) {
+	mockFS := &MockFileSystem{
+		dirContents: map[string][]os.FileInfo{
+			"/sys/class/hidraw": {
+				MockFileInfo{name: "hidraw0"},
+				MockFileInfo{name: "hidraw1"},
+			},
+		},
+		files: map[string][]byte{
+			"/sys/class/hidraw/hidraw0/device/uevent": []byte("HID_ID=0005:00001038:000012AB\nHID_NAME=Arctis GameBuds\n"),
+			// Another SteelSeries Bluetooth device must not match
+			"/sys/class/hidraw/hidraw1/device/uevent": []byte("HID_ID=0005:00001038:00001234\nHID_NAME=Arctis Nova 7\n"),
+			"/dev/hidraw0": {protocol.ReportBattery, 55, 65},
+			"/dev/hidraw1": {},
+		},
+	}
+
+	manager := NewHIDRawManagerWithFS(mockFS)
+	if err := manager.FindDevices(); err != nil {
+		t.Fatalf("FindDevices failed: %v", err)
+	}
+	if len(manager.devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(manager.devices))
+	}
+	if got := manager.Transport(); got != protocol.BusBluetooth {
+		t.Errorf("Transport() = %q, want bluetooth", got)
+	}
+	if manager.GetType() != DeviceTypeSteelSeriesGameBuds || manager.GetID() != "steelseries_gamebuds" {
+		t.Error("Bluetooth GameBuds should be presented as the same device model")
+	}
+
+	// When the buds disappear over Bluetooth they are off, not unplugged
+	manager.Run(context.Background())
+	state := manager.GetState()
+	if state.LeftBattery == nil || *state.LeftBattery != 55 {
+		t.Errorf("Expected left battery 55 from Bluetooth report, got %v", state.LeftBattery)
+	}
+	if state.Connection == nil || *state.Connection != protocol.ConnectionOff {
+		t.Errorf("Connection = %v, want buds_off", state.Connection)
+	}
+}
+
+func TestFindDevices_IdentifiesWithHidraw(t *testing.T) {
+	// No uevents: identification comes from the hidraw driver
+	mockFS := &MockFileSystem{
+		dirContents: map[string][]os.FileInfo{
+			"/sys/class/hidraw": {
+				MockFileInfo{name: "hidraw0"},
+				MockFileInfo{name: "hidraw1"},
+				MockFileInfo{name: "hidraw2"},
+			},
+		},
+	}
+	keyboard := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x046D, ProductID: 0xC31C}, "Logitech Keyboard")
+	buds := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x230A}, "SteelSeries Arctis GameBuds")
+	buds.DevicePhys = "usb-0000:00:14.0-2/input3"
+	fakes := map[string]*hidraw.Fake{"/dev/hidraw0": keyboard, "/dev/hidraw1": buds}
+
+	open := func(path string) (hidraw.Device, error) {
+		if fake, ok := fakes[path]; ok {
+			return fake, nil
+		}
+		return nil, os.ErrPermission
+	}
+
+	manager := newHIDRawManager(mockFS, open, protocol.BuiltinDefinitions())
+	if err := manager.FindDevices(); err != nil {
+		t.Fatalf("FindDevices failed: %v", err)
+	}
+	if len(manager.devices) != 1 || manager.devices[0].Device != buds {
+		t.Fatalf("Expected only the GameBuds interface to be kept, got %d", len(manager.devices))
+	}
+	if _, err := keyboard.Write([]byte{0x00}); err == nil {
+		t.Error("Non-matching device should have been closed")
+	}
+
+	buds.Inject([]byte{protocol.ReportBattery, 30, 35})
+	buds.Close()
+	manager.Run(context.Background())
+	if state := manager.GetState(); state.LeftBattery == nil || *state.LeftBattery != 30 {
+		t.Errorf("Expected left battery 30, got %v", state.LeftBattery)
+	}
+}
+
+func TestHIDRawManager_ANCSetting(t *testing.T) {
+	defs := protocol.BuiltinDefinitions()
+	buds := hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x230A}, "SteelSeries Arctis GameBuds")
+	manager := newHIDRawManager(hidrawNodes("hidraw0"), fakeOpener(map[string]*hidraw.Fake{"/dev/hidraw0": buds}), defs)
+
+	// Without a command in the definitions there is nothing to change
+	if settings := manager.Settings(); len(settings) != 0 {
+		t.Fatalf("Settings() = %+v, want none without a command", settings)
+	}
+
+	user, err := protocol.ParseDefinitions(strings.NewReader(`{"name": "user", "reports": [], "commands": [
+		{"name": "Set ANC", "target": "anc_mode", "report": ["0x06", "0xBD", "0x00"], "value_offset": 2,
+		 "enum": {"0": "off", "1": "transparency", "2": "active"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs.Merge(user)
+
+	if err := manager.ApplySetting(SettingANCMode, "active"); err == nil {
+		t.Error("ApplySetting should fail while the buds are not open")
+	}
+	if err := manager.FindDevices(); err != nil {
+		t.Fatalf("FindDevices failed: %v", err)
+	}
+	manager.protocol.ParseReport([]byte{protocol.ReportANCMode, 0x01})
+
+	settings := manager.Settings()
+	if len(settings) != 1 || settings[0].Kind != SettingEnum || settings[0].Value != "transparency" {
+		t.Fatalf("Settings() = %+v, want ANC mode transparency", settings)
+	}
+	if got := strings.Join(settings[0].Options, ","); got != "off,transparency,active" {
+		t.Errorf("Options = %s", got)
+	}
+
+	if err := manager.ApplySetting(SettingANCMode, "active"); err != nil {
+		t.Fatalf("ApplySetting failed: %v", err)
+	}
+	outputs := buds.Outputs()
+	if len(outputs) != 1 || !bytes.Equal(outputs[0], []byte{0x06, 0xBD, 0x02}) {
+		t.Errorf("Output reports = % X, want 06 BD 02", outputs)
+	}
+}
@@ -8,6 +8,8 @@ type DeviceType string
 const (
 	DeviceTypeSteelSeriesGameBuds DeviceType = "steelseries_gamebuds"
 	DeviceTypeRazerDeathAdder     DeviceType = "razer_deathadder"
+	DeviceTypeHIDBattery          DeviceType = "hid_battery" // Any HID device declaring a battery in its report descriptor
+	DeviceTypeHIDReports          DeviceType = "hid_reports" // Other products described by user report definitions
 )
 
 // BatteryDevice is the interface that all battery-monitoring devices must implement
@@ -1,25 +1,339 @@
 package device
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jyablonski/goarctis/pkg/protocol"
 )
 
+const (
+	// DefaultStaleAfter is how long a device may go without reporting before
+	// its state is flagged as stale
+	DefaultStaleAfter  = 30 * time.Minute
+	staleCheckInterval = time.Minute
+
+	// stateSaveDelay is how long state changes are collected before the
+	// state file is written, so a burst of reports causes a single write
+	stateSaveDelay = 10 * time.Second
+
+	// DefaultDiscoveryTimeout bounds how long each backend may take to probe
+	// for devices
+	DefaultDiscoveryTimeout = 10 * time.Second
+
+	// resumeSettleDelay gives USB devices time to re-enumerate after the
+	// system wakes up before they are reopened
+	resumeSettleDelay = 3 * time.Second
+)
+
+// Built-in backends, as named to SetBackends
+const (
+	BackendGameBuds   = "gamebuds"    // SteelSeries GameBuds over hidraw
+	BackendRazer      = "razer"       // Razer devices via the OpenRazer daemon
+	BackendHIDBattery = "hid_battery" // Other HID devices declaring a battery
+)
+
+// BuiltinBackends lists the built-in backends in the order they are probed
+var BuiltinBackends = []string{BackendGameBuds, BackendRazer, BackendHIDBattery}
+
+// discoveryBackend probes for one kind of device
+type discoveryBackend struct {
+	name     string
+	discover func(ctx context.Context) ([]BatteryDevice, error)
+}
+
 // DeviceManager manages multiple battery devices
 type DeviceManager struct {
-	devices  map[string]BatteryDevice
-	mu       sync.RWMutex
-	onChange func(string, protocol.DeviceState)
+	devices          map[string]BatteryDevice
+	filters          map[string]*StateFilter
+	filterConfig     FilterConfig
+	definitions      *protocol.DefinitionSet
+	staleAfter       time.Duration
+	stale            map[string]bool
+	staleStop        chan struct{}
+	store            *StateStore
+	cached           map[string]CachedDevice
+	saveDelay        time.Duration
+	saveTimer        *time.Timer // Pending write of the state file, nil if none
+	supervisors      map[string]*Supervisor
+	restart          RestartPolicy
+	razerDaemon      *DaemonRestarter
+	backends         []discoveryBackend // Overrides the built-in backends in tests
+	enabled          []string           // Built-in backends to probe, nil for all
+	extra            []discoveryBackend // Added with AddBackend
+	discoveryTimeout time.Duration
+	suspended        bool
+	resuming         chan struct{} // Closed by Suspend to abort a pending Resume, nil if none
+	resumeDelay      time.Duration
+	mu               sync.RWMutex
+	onChange         func(string, protocol.DeviceState)
+	onHealth         func(string, Health)
+	onDiscovered     func(BatteryDevice)
 }
 
 // NewDeviceManager creates a new device manager
 func NewDeviceManager() *DeviceManager {
 	return &DeviceManager{
-		devices: make(map[string]BatteryDevice),
+		devices:          make(map[string]BatteryDevice),
+		filters:          make(map[string]*StateFilter),
+		filterConfig:     DefaultFilterConfig(),
+		definitions:      protocol.BuiltinDefinitions(),
+		staleAfter:       DefaultStaleAfter,
+		stale:            make(map[string]bool),
+		cached:           make(map[string]CachedDevice),
+		saveDelay:        stateSaveDelay,
+		supervisors:      make(map[string]*Supervisor),
+		restart:          DefaultRestartPolicy(),
+		razerDaemon:      NewDaemonRestarter(OpenRazerUnit, razerService, DefaultDaemonRestartPolicy()),
+		discoveryTimeout: DefaultDiscoveryTimeout,
+		resumeDelay:      resumeSettleDelay,
+	}
+}
+
+// SetBackends limits discovery to the named built-in backends. Calling it
+// with no names disables every built-in backend, leaving only those added
+// with AddBackend.
+func (dm *DeviceManager) SetBackends(names ...string) error {
+	for _, name := range names {
+		if !slices.Contains(BuiltinBackends, name) {
+			return fmt.Errorf("unknown backend %q (known: %s)", name, strings.Join(BuiltinBackends, ", "))
+		}
+	}
+	dm.mu.Lock()
+	dm.enabled = append([]string{}, names...)
+	dm.mu.Unlock()
+	return nil
+}
+
+// AddBackend adds a backend probed alongside the built-in ones. discover is
+// bounded by the discovery timeout like any other backend.
+func (dm *DeviceManager) AddBackend(name string, discover func(ctx context.Context) ([]BatteryDevice, error)) {
+	dm.mu.Lock()
+	dm.extra = append(dm.extra, discoveryBackend{name, discover})
+	dm.mu.Unlock()
+}
+
+// SetDiscoveryTimeout sets how long each backend may take to probe for
+// devices before its results are abandoned
+func (dm *DeviceManager) SetDiscoveryTimeout(d time.Duration) {
+	dm.mu.Lock()
+	dm.discoveryTimeout = d
+	dm.mu.Unlock()
+}
+
+// SetOnDeviceDiscovered sets a callback for each device found by
+// DiscoverDevices, called as soon as its backend finishes
+func (dm *DeviceManager) SetOnDeviceDiscovered(callback func(BatteryDevice)) {
+	dm.mu.Lock()
+	dm.onDiscovered = callback
+	dm.mu.Unlock()
+}
+
+// SetDaemonRestartPolicy sets when the OpenRazer daemon may be restarted after
+// Razer devices repeatedly fail to reach it
+func (dm *DeviceManager) SetDaemonRestartPolicy(policy DaemonRestartPolicy) {
+	dm.razerDaemon.SetPolicy(policy)
+}
+
+// SetDaemonRestartConfirm sets how the user is asked before a restart when
+// the policy mode is DaemonRestartAsk
+func (dm *DeviceManager) SetDaemonRestartConfirm(confirm func(ctx context.Context, unit string) bool) {
+	dm.razerDaemon.SetConfirm(confirm)
+}
+
+// SetRestartPolicy sets how supervised devices are restarted after a failure.
+// It applies to devices started by subsequent calls to StartAll.
+func (dm *DeviceManager) SetRestartPolicy(policy RestartPolicy) {
+	dm.mu.Lock()
+	dm.restart = policy
+	dm.mu.Unlock()
+}
+
+// SetOnHealthChange sets a callback for when a supervised device's health changes
+// The callback receives (deviceID, health)
+func (dm *DeviceManager) SetOnHealthChange(callback func(string, Health)) {
+	dm.mu.Lock()
+	dm.onHealth = callback
+	dm.mu.Unlock()
+}
+
+// GetDeviceHealth returns the supervision status of all supervised devices
+func (dm *DeviceManager) GetDeviceHealth() map[string]Health {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	health := make(map[string]Health)
+	for deviceID, supervisor := range dm.supervisors {
+		health[deviceID] = supervisor.Health()
 	}
+	return health
+}
+
+// SetStaleThreshold sets how long a device may go without reporting before
+// its state is flagged as stale. 0 disables staleness tracking.
+func (dm *DeviceManager) SetStaleThreshold(d time.Duration) {
+	dm.mu.Lock()
+	dm.staleAfter = d
+	dm.mu.Unlock()
+}
+
+// SetStateStore sets where device states are persisted between runs
+func (dm *DeviceManager) SetStateStore(store *StateStore) {
+	dm.mu.Lock()
+	dm.store = store
+	dm.mu.Unlock()
+}
+
+// LoadCachedStates loads the states persisted by a previous run and reports
+// each one, marked as cached, through the state change callback. Call this
+// before DiscoverDevices so the last known values are shown immediately.
+func (dm *DeviceManager) LoadCachedStates() error {
+	dm.mu.Lock()
+	store := dm.store
+	dm.mu.Unlock()
+	if store == nil {
+		return nil
+	}
+
+	cached, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	for deviceID, entry := range cached {
+		entry.State.Cached = true
+		entry.State.Stale = false
+		cached[deviceID] = entry
+	}
+	dm.cached = cached
+	onChange := dm.onChange
+	dm.mu.Unlock()
+
+	log.Printf("Loaded cached state for %d device(s) from %s", len(cached), store.Path())
+	if onChange != nil {
+		for deviceID, entry := range cached {
+			onChange(deviceID, entry.State)
+		}
+	}
+	return nil
+}
+
+// GetCachedStates returns the persisted states of devices that have not been
+// discovered in this run, such as a mouse that is currently switched off
+func (dm *DeviceManager) GetCachedStates() map[string]protocol.DeviceState {
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+
+	states := make(map[string]protocol.DeviceState)
+	for deviceID, entry := range dm.cached {
+		if _, present := dm.devices[deviceID]; !present {
+			states[deviceID] = entry.State
+		}
+	}
+	return states
+}
+
+// remember records a device's latest state for persistence.
+// Must be called with dm.mu held.
+func (dm *DeviceManager) remember(deviceID string, state protocol.DeviceState) {
+	name := dm.cached[deviceID].Name
+	if device, ok := dm.devices[deviceID]; ok {
+		name = device.GetName()
+	}
+	state.Stale = false
+	state.Cached = false
+	dm.cached[deviceID] = CachedDevice{Name: name, State: state}
+}
+
+// cachedSnapshot copies the remembered states so they can be saved without
+// holding the lock. Must be called with dm.mu held.
+func (dm *DeviceManager) cachedSnapshot() map[string]CachedDevice {
+	snapshot := make(map[string]CachedDevice, len(dm.cached))
+	for k, v := range dm.cached {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// scheduleSave writes the remembered states once saveDelay has passed,
+// unless a write is already pending. Must be called with dm.mu held.
+func (dm *DeviceManager) scheduleSave() {
+	if dm.store == nil || dm.saveTimer != nil {
+		return
+	}
+	dm.saveTimer = time.AfterFunc(dm.saveDelay, dm.flushStates)
+}
+
+// flushStates writes the remembered states for a pending save
+func (dm *DeviceManager) flushStates() {
+	dm.mu.Lock()
+	if dm.saveTimer == nil {
+		// Cancelled by SaveStates, which wrote the states itself
+		dm.mu.Unlock()
+		return
+	}
+	dm.saveTimer = nil
+	snapshot := dm.cachedSnapshot()
+	store := dm.store
+	dm.mu.Unlock()
+
+	if err := store.Save(snapshot); err != nil {
+		log.Printf("Failed to persist device states: %v", err)
+	}
+}
+
+// cancelSave drops a pending save. Must be called with dm.mu held.
+func (dm *DeviceManager) cancelSave() {
+	if dm.saveTimer != nil {
+		dm.saveTimer.Stop()
+		dm.saveTimer = nil
+	}
+}
+
+// SaveStates persists the current state of every known device
+func (dm *DeviceManager) SaveStates() error {
+	now := time.Now()
+	states := make(map[string]protocol.DeviceState)
+	for deviceID, device := range dm.GetAllDevices() {
+		states[deviceID] = dm.currentState(deviceID, device, now)
+	}
+
+	dm.mu.Lock()
+	for deviceID, state := range states {
+		dm.remember(deviceID, state)
+	}
+	dm.cancelSave()
+	snapshot := dm.cachedSnapshot()
+	store := dm.store
+	dm.mu.Unlock()
+
+	if store == nil {
+		return nil
+	}
+	return store.Save(snapshot)
+}
+
+// SetFilterConfig sets the battery filter settings. Filters that already
+// exist are replaced, discarding their history.
+func (dm *DeviceManager) SetFilterConfig(cfg FilterConfig) {
+	dm.mu.Lock()
+	dm.filterConfig = cfg
+	dm.filters = make(map[string]*StateFilter)
+	dm.mu.Unlock()
+}
+
+// SetReportDefinitions sets the HID report definitions used for hidraw devices
+// found by subsequent calls to DiscoverDevices
+func (dm *DeviceManager) SetReportDefinitions(defs *protocol.DefinitionSet) {
+	dm.mu.Lock()
+	dm.definitions = defs
+	dm.mu.Unlock()
 }
 
 // SetOnStateChange sets a callback for when any device state changes
@@ -32,91 +346,567 @@ func (dm *DeviceManager) SetOnStateChange(callback func(string, protocol.DeviceS
 
 // DiscoverDevices discovers all supported devices
 func (dm *DeviceManager) DiscoverDevices() error {
-	dm.mu.Lock()
-	defer dm.mu.Unlock()
+	return dm.DiscoverDevicesContext(context.Background())
+}
 
-	// Discover SteelSeries GameBuds
-	steelSeriesDevice := NewHIDRawManager()
-	if err := steelSeriesDevice.FindDevices(); err != nil {
-		log.Printf("SteelSeries GameBuds not found: %v", err)
-	} else {
-		steelSeriesDevice.SetOnStateChange(dm.makeStateChangeHandler(steelSeriesDevice.GetID()))
-		dm.devices[steelSeriesDevice.GetID()] = steelSeriesDevice
-		log.Printf("Found %s", steelSeriesDevice.GetName())
+// DiscoverDevicesContext probes every backend concurrently, each bounded by
+// the discovery timeout, and adds devices as each backend finishes so that a
+// slow backend does not hold up the others. It returns once all backends
+// have finished or timed out.
+func (dm *DeviceManager) DiscoverDevicesContext(ctx context.Context) error {
+	dm.mu.RLock()
+	backends := dm.backends
+	if backends == nil {
+		backends = append(builtinBackends(dm.definitions, dm.enabled), dm.extra...)
 	}
+	timeout := dm.discoveryTimeout
+	dm.mu.RUnlock()
 
-	// Discover Razer devices
-	razerDevices, err := DiscoverRazerDevices()
-	if err != nil {
-		log.Printf("Razer devices not found or OpenRazer not available: %v", err)
-	} else {
-		for _, razerDevice := range razerDevices {
-			razerDevice.SetOnStateChange(dm.makeStateChangeHandler(razerDevice.GetID()))
-			dm.devices[razerDevice.GetID()] = razerDevice
-			log.Printf("Found %s", razerDevice.GetName())
-		}
+	var wg sync.WaitGroup
+	for _, backend := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dm.discover(ctx, backend, timeout)
+		}()
 	}
+	wg.Wait()
 
-	if len(dm.devices) == 0 {
+	dm.mu.RLock()
+	found := len(dm.devices)
+	dm.mu.RUnlock()
+	if found == 0 {
 		return fmt.Errorf("no supported devices found")
 	}
-
 	return nil
 }
 
-// makeStateChangeHandler creates a state change handler for a specific device
+// builtinBackends returns the enabled built-in backends, or all of them
+// when enabled is nil
+func builtinBackends(defs *protocol.DefinitionSet, enabled []string) []discoveryBackend {
+	if enabled == nil {
+		enabled = BuiltinBackends
+	}
+	var backends []discoveryBackend
+	for _, name := range BuiltinBackends {
+		if !slices.Contains(enabled, name) {
+			continue
+		}
+		switch name {
+		case BackendGameBuds:
+			backends = append(backends, discoveryBackend{"SteelSeries GameBuds", func(ctx context.Context) ([]BatteryDevice, error) {
+				// One device per product family, so reports defined for one
+				// product are not applied to another
+				var devices []BatteryDevice
+				firstErr := fmt.Errorf("no products in the report definitions")
+				for i, family := range defs.Families() {
+					manager := NewHIDRawManagerWithDefinitions(family)
+					if err := manager.FindDevices(); err != nil {
+						if i == 0 {
+							firstErr = err
+						}
+						continue
+					}
+					devices = append(devices, manager)
+				}
+				if len(devices) == 0 {
+					return nil, firstErr
+				}
+				return devices, nil
+			}})
+		case BackendRazer:
+			backends = append(backends, discoveryBackend{"Razer devices", func(ctx context.Context) ([]BatteryDevice, error) {
+				razerDevices, err := DiscoverRazerDevicesContext(ctx)
+				devices := make([]BatteryDevice, 0, len(razerDevices))
+				for _, razerDevice := range razerDevices {
+					devices = append(devices, razerDevice)
+				}
+				return devices, err
+			}})
+		case BackendHIDBattery:
+			backends = append(backends, discoveryBackend{"HID battery devices", func(ctx context.Context) ([]BatteryDevice, error) {
+				hidDevices, err := DiscoverHIDBatteryDevices(defs)
+				devices := make([]BatteryDevice, 0, len(hidDevices))
+				for _, hidDevice := range hidDevices {
+					devices = append(devices, hidDevice)
+				}
+				return devices, err
+			}})
+		}
+	}
+	return backends
+}
+
+// discover runs one backend and adds what it finds. Devices a backend
+// returns after its timeout are closed rather than added.
+func (dm *DeviceManager) discover(ctx context.Context, backend discoveryBackend, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type result struct {
+		devices []BatteryDevice
+		err     error
+	}
+	results := make(chan result, 1)
+	go func() {
+		devices, err := backend.discover(ctx)
+		results <- result{devices, err}
+	}()
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			log.Printf("%s not found: %v", backend.name, r.err)
+			return
+		}
+		for _, device := range r.devices {
+			dm.addDevice(device)
+		}
+	case <-ctx.Done():
+		log.Printf("⏱️ Gave up discovering %s: %v", backend.name, ctx.Err())
+		go func() {
+			for _, device := range (<-results).devices {
+				device.Close()
+			}
+		}()
+	}
+}
+
+// addDevice registers a discovered device and reports it. A device whose ID
+// is already registered is closed, keeping the one that may be running.
+func (dm *DeviceManager) addDevice(device BatteryDevice) {
+	deviceID := device.GetID()
+
+	dm.mu.Lock()
+	if existing, ok := dm.devices[deviceID]; ok {
+		dm.mu.Unlock()
+		log.Printf("Ignoring %s, %s already has ID %s", device.GetName(), existing.GetName(), deviceID)
+		device.Close()
+		return
+	}
+	device.SetOnStateChange(dm.makeStateChangeHandler(deviceID))
+	if razerDevice, ok := device.(*RazerDevice); ok {
+		razerDevice.SetDaemonRestarter(dm.razerDaemon)
+	}
+	dm.devices[deviceID] = device
+	onDiscovered := dm.onDiscovered
+	dm.mu.Unlock()
+
+	log.Printf("Found %s", device.GetName())
+	if onDiscovered != nil {
+		onDiscovered(device)
+	}
+}
+
+// makeStateChangeHandler creates a state change handler for a specific device.
+// States are passed through the device's battery filter, and the callback only
+// fires when the filtered state actually changes.
 func (dm *DeviceManager) makeStateChangeHandler(deviceID string) func(protocol.DeviceState) {
 	return func(state protocol.DeviceState) {
+		// Any report proves the device's monitoring loop is working
 		dm.mu.RLock()
-		onChange := dm.onChange
+		supervisor := dm.supervisors[deviceID]
 		dm.mu.RUnlock()
+		if supervisor != nil && state.IsConnected {
+			supervisor.MarkAlive()
+		}
+
+		filtered, changed := dm.filterFor(deviceID).Apply(state)
+		if !changed {
+			return
+		}
+
+		dm.mu.Lock()
+		filtered.Stale = filtered.IsStale(dm.staleAfter, time.Now())
+		dm.stale[deviceID] = filtered.Stale
+		dm.remember(deviceID, filtered)
+		dm.scheduleSave()
+		onChange := dm.onChange
+		dm.mu.Unlock()
+
 		if onChange != nil {
-			onChange(deviceID, state)
+			onChange(deviceID, filtered)
 		}
 	}
 }
 
-// StartAll starts monitoring all discovered devices
-func (dm *DeviceManager) StartAll() error {
+// filterFor returns the battery filter for a device, creating it if needed
+func (dm *DeviceManager) filterFor(deviceID string) *StateFilter {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	filter, ok := dm.filters[deviceID]
+	if !ok {
+		filter = NewStateFilter(dm.filterConfig)
+		dm.filters[deviceID] = filter
+	}
+	return filter
+}
+
+// currentState returns the filtered state of a device with the backend's
+// latest timestamps and the staleness flag applied. It queries the device, so
+// it must be called without dm.mu held.
+func (dm *DeviceManager) currentState(deviceID string, device BatteryDevice, now time.Time) protocol.DeviceState {
+	raw := device.GetState()
+
 	dm.mu.RLock()
-	defer dm.mu.RUnlock()
+	filter := dm.filters[deviceID]
+	staleAfter := dm.staleAfter
+	dm.mu.RUnlock()
 
-	var errors []error
+	state := raw
+	if filter != nil {
+		if filtered, ok := filter.Filtered(); ok {
+			state = filtered
+			state.LastSeen = raw.LastSeen
+			state.Updated = raw.Updated
+		}
+	}
+	state.Stale = state.IsStale(staleAfter, now)
+	return state
+}
+
+// checkStaleness notifies listeners about devices that are stale or have just
+// recovered. Stale devices are re-sent on every check so displayed ages stay current.
+// It also accepts battery spikes that have persisted, since backends do not
+// report an unchanged reading again.
+func (dm *DeviceManager) checkStaleness(now time.Time) {
+	type update struct {
+		deviceID string
+		state    protocol.DeviceState
+	}
+
+	var updates []update
+	for deviceID, device := range dm.GetAllDevices() {
+		dm.mu.RLock()
+		filter := dm.filters[deviceID]
+		dm.mu.RUnlock()
+
+		confirmed := false
+		if filter != nil {
+			_, confirmed = filter.Recheck(now)
+		}
+		state := dm.currentState(deviceID, device, now)
+
+		dm.mu.Lock()
+		if confirmed {
+			dm.remember(deviceID, state)
+			dm.scheduleSave()
+		}
+		wasStale := dm.stale[deviceID]
+		dm.stale[deviceID] = state.Stale
+		dm.mu.Unlock()
+
+		if confirmed || state.Stale || wasStale {
+			updates = append(updates, update{deviceID, state})
+		}
+	}
+
+	dm.mu.RLock()
+	onChange := dm.onChange
+	dm.mu.RUnlock()
+
+	if onChange == nil {
+		return
+	}
+	for _, u := range updates {
+		onChange(u.deviceID, u.state)
+	}
+}
+
+// staleLoop periodically checks for stale devices until stop is closed
+func (dm *DeviceManager) staleLoop(stop chan struct{}) {
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			dm.checkStaleness(now)
+		}
+	}
+}
+
+// stopStaleLoop stops the staleness checker if it is running. Must be called with dm.mu held.
+func (dm *DeviceManager) stopStaleLoop() {
+	if dm.staleStop != nil {
+		close(dm.staleStop)
+		dm.staleStop = nil
+	}
+}
+
+// StartAll starts monitoring all discovered devices. Devices that implement
+// Runner are run under a Supervisor, which restarts them when they fail.
+func (dm *DeviceManager) StartAll() error {
+	dm.mu.Lock()
+	dm.startStaleLoop()
+	starts := make(map[string]func() error, len(dm.devices))
 	for deviceID, device := range dm.devices {
-		if err := device.Start(); err != nil {
-			log.Printf("Failed to start device %s: %v", deviceID, err)
-			errors = append(errors, fmt.Errorf("device %s: %w", deviceID, err))
+		starts[deviceID] = dm.starter(deviceID, device)
+	}
+	dm.mu.Unlock()
+
+	var errors []error
+	for _, start := range starts {
+		if err := start(); err != nil {
+			errors = append(errors, err)
 		}
 	}
 
-	if len(errors) > 0 && len(errors) == len(dm.devices) {
+	if len(errors) > 0 && len(errors) == len(starts) {
 		return fmt.Errorf("failed to start any devices: %v", errors)
 	}
 
 	return nil
 }
 
+// StartDevice starts monitoring one discovered device, for callers that
+// start devices as they are discovered instead of calling StartAll
+func (dm *DeviceManager) StartDevice(deviceID string) error {
+	dm.mu.Lock()
+	device, ok := dm.devices[deviceID]
+	if !ok {
+		dm.mu.Unlock()
+		return fmt.Errorf("unknown device %s", deviceID)
+	}
+	dm.startStaleLoop()
+	start := dm.starter(deviceID, device)
+	dm.mu.Unlock()
+
+	return start()
+}
+
+// startStaleLoop starts the staleness checker if it is not running. Must be
+// called with dm.mu held.
+func (dm *DeviceManager) startStaleLoop() {
+	if dm.staleStop == nil {
+		dm.staleStop = make(chan struct{})
+		go dm.staleLoop(dm.staleStop)
+	}
+}
+
+// starter returns the function that starts a device: its supervisor's Start
+// for a Runner, or the device's own Start. It must be called with dm.mu
+// held, and the function it returns without it, since a device may report
+// its state while starting.
+func (dm *DeviceManager) starter(deviceID string, device BatteryDevice) func() error {
+	if runner, ok := device.(Runner); ok {
+		supervisor := dm.supervisorFor(deviceID, device.GetName(), runner)
+		return func() error {
+			supervisor.Start()
+			return nil
+		}
+	}
+	return func() error {
+		if err := device.Start(); err != nil {
+			log.Printf("Failed to start device %s: %v", deviceID, err)
+			return fmt.Errorf("device %s: %w", deviceID, err)
+		}
+		return nil
+	}
+}
+
+// supervisorFor returns the supervisor of a device, creating it if needed.
+// Must be called with dm.mu held.
+func (dm *DeviceManager) supervisorFor(deviceID, name string, runner Runner) *Supervisor {
+	supervisor, ok := dm.supervisors[deviceID]
+	if !ok {
+		supervisor = NewSupervisor(name, runner, dm.restart)
+		supervisor.SetOnHealthChange(func(h Health) {
+			dm.mu.RLock()
+			onHealth := dm.onHealth
+			dm.mu.RUnlock()
+			if onHealth != nil {
+				onHealth(deviceID, h)
+			}
+		})
+		dm.supervisors[deviceID] = supervisor
+	}
+	return supervisor
+}
+
+// stopSupervisors stops every supervisor and waits for the devices' runs to
+// return. It must be called without dm.mu held, since running devices may be
+// reporting state concurrently.
+func (dm *DeviceManager) stopSupervisors() {
+	dm.mu.RLock()
+	supervisors := make([]*Supervisor, 0, len(dm.supervisors))
+	for _, supervisor := range dm.supervisors {
+		supervisors = append(supervisors, supervisor)
+	}
+	dm.mu.RUnlock()
+
+	for _, supervisor := range supervisors {
+		supervisor.Stop()
+	}
+}
+
 // StopAll stops monitoring all devices
 func (dm *DeviceManager) StopAll() {
-	dm.mu.RLock()
-	defer dm.mu.RUnlock()
+	dm.stopSupervisors()
 
-	for _, device := range dm.devices {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	dm.stopStaleLoop()
+
+	for deviceID, device := range dm.devices {
+		if _, supervised := dm.supervisors[deviceID]; supervised {
+			continue
+		}
 		device.Stop()
 	}
 }
 
-// CloseAll closes all devices and releases resources
+// CloseAll persists the last known states, then closes all devices and releases resources
 func (dm *DeviceManager) CloseAll() {
+	dm.stopSupervisors()
+
+	if err := dm.SaveStates(); err != nil {
+		log.Printf("Failed to persist device states: %v", err)
+	}
+
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
+	dm.stopStaleLoop()
+
 	for _, device := range dm.devices {
 		if err := device.Close(); err != nil {
 			log.Printf("Error closing device: %v", err)
 		}
 	}
 	dm.devices = make(map[string]BatteryDevice)
+	dm.filters = make(map[string]*StateFilter)
+	dm.stale = make(map[string]bool)
+	dm.supervisors = make(map[string]*Supervisor)
+}
+
+// Suspend pauses monitoring of all supervised devices, e.g. before the system
+// sleeps, so that their loops do not burn through reconnect attempts while
+// the hardware is gone. Devices not run under a supervisor keep running.
+func (dm *DeviceManager) Suspend() {
+	dm.mu.Lock()
+	if dm.suspended {
+		// Going back to sleep before a resume has settled cancels it
+		if dm.resuming != nil {
+			close(dm.resuming)
+			dm.resuming = nil
+		}
+		dm.mu.Unlock()
+		return
+	}
+	dm.suspended = true
+	dm.mu.Unlock()
+
+	dm.stopSupervisors()
+
+	dm.mu.Lock()
+	dm.stopStaleLoop()
+	dm.mu.Unlock()
+	log.Printf("💤 Device monitoring paused")
+}
+
+// Resume waits for devices to settle after the system wakes up, resyncs
+// the devices that need it and restarts monitoring of everything paused by
+// Suspend. Restarted loops reopen their hidraw nodes. A Suspend arriving
+// while it waits aborts the resume.
+func (dm *DeviceManager) Resume() {
+	dm.mu.Lock()
+	if !dm.suspended || dm.resuming != nil {
+		dm.mu.Unlock()
+		return
+	}
+	aborted := make(chan struct{})
+	dm.resuming = aborted
+	delay := dm.resumeDelay
+	resyncers := make(map[string]Resyncer)
+	for deviceID := range dm.supervisors {
+		if resyncer, ok := dm.devices[deviceID].(Resyncer); ok {
+			resyncers[deviceID] = resyncer
+		}
+	}
+	dm.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-aborted:
+		log.Printf("💤 Resume aborted, the system is suspending again")
+		return
+	}
+
+	// A failed resync is left to the device's own retries once it runs again
+	for deviceID, resyncer := range resyncers {
+		if err := resyncer.Resync(); err != nil {
+			log.Printf("Resync of %s after resume failed: %v", deviceID, err)
+		}
+	}
+
+	dm.mu.Lock()
+	select {
+	case <-aborted:
+		dm.mu.Unlock()
+		log.Printf("💤 Resume aborted, the system is suspending again")
+		return
+	default:
+	}
+	dm.resuming = nil
+	dm.suspended = false
+	if len(dm.supervisors) > 0 {
+		dm.startStaleLoop()
+	}
+	supervisors := make([]*Supervisor, 0, len(dm.supervisors))
+	for _, supervisor := range dm.supervisors {
+		supervisors = append(supervisors, supervisor)
+	}
+	dm.mu.Unlock()
+
+	for _, supervisor := range supervisors {
+		supervisor.Start()
+	}
+	log.Printf("⏰ Device monitoring resumed")
+}
+
+// GetSettings returns a device's settings with their current values, or
+// ErrNotConfigurable if it has none
+func (dm *DeviceManager) GetSettings(deviceID string) ([]Setting, error) {
+	configurable, err := dm.configurable(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return configurable.Settings(), nil
+}
+
+// ApplySetting validates a value and applies it to a device's setting
+func (dm *DeviceManager) ApplySetting(deviceID, settingID string, value any) error {
+	configurable, err := dm.configurable(deviceID)
+	if err != nil {
+		return err
+	}
+	setting, ok := FindSetting(configurable.Settings(), settingID)
+	if !ok {
+		return fmt.Errorf("%s has no setting %q", deviceID, settingID)
+	}
+	if err := setting.Validate(value); err != nil {
+		return err
+	}
+	return configurable.ApplySetting(settingID, value)
+}
+
+func (dm *DeviceManager) configurable(deviceID string) (Configurable, error) {
+	device := dm.GetDevice(deviceID)
+	if device == nil {
+		return nil, fmt.Errorf("unknown device %s", deviceID)
+	}
+	configurable, ok := device.(Configurable)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", deviceID, ErrNotConfigurable)
+	}
+	return configurable, nil
 }
 
 // GetDevice returns a device by ID
@@ -138,13 +928,20 @@ func (dm *DeviceManager) GetAllDevices() map[string]BatteryDevice {
 	return result
 }
 
-// GetDeviceStates returns the current state of all devices
+// GetDeviceStates returns the current filtered state of all devices
 func (dm *DeviceManager) GetDeviceStates() map[string]protocol.DeviceState {
-	dm.mu.RLock()
-	defer dm.mu.RUnlock()
+	now := time.Now()
+	states := make(map[string]protocol.DeviceState)
+	for deviceID, device := range dm.GetAllDevices() {
+		states[deviceID] = dm.currentState(deviceID, device, now)
+	}
+	return states
+}
 
+// GetRawDeviceStates returns the unfiltered state of all devices, for diagnostics
+func (dm *DeviceManager) GetRawDeviceStates() map[string]protocol.DeviceState {
 	states := make(map[string]protocol.DeviceState)
-	for deviceID, device := range dm.devices {
+	for deviceID, device := range dm.GetAllDevices() {
 		states[deviceID] = device.GetState()
 	}
 	return states
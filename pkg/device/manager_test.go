@@ -1,7 +1,11 @@
 package device
 
 import (
+	"context"
+	"errors"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/jyablonski/goarctis/pkg/protocol"
 )
@@ -110,6 +114,52 @@ func TestStartAll_NoDevices(t *testing.T) {
 	}
 }
 
+// reportingDevice reports its state from Start, as a device reading its
+// initial state synchronously would
+type reportingDevice struct {
+	mockHIDDevice
+	onChange func(protocol.DeviceState)
+}
+
+func (d *reportingDevice) SetOnStateChange(callback func(protocol.DeviceState)) {
+	d.onChange = callback
+}
+
+func (d *reportingDevice) Start() error {
+	d.onChange(d.state)
+	return nil
+}
+
+func TestStartDevice_ReportsWhileStarting(t *testing.T) {
+	dm := NewDeviceManager()
+	battery := 80
+	dm.addDevice(&reportingDevice{mockHIDDevice: mockHIDDevice{
+		id:    "keyboard",
+		state: protocol.DeviceState{DeviceID: "keyboard", IsConnected: true, Battery: &battery},
+	}})
+
+	done := make(chan error, 1)
+	go func() {
+		if err := dm.StartDevice("keyboard"); err != nil {
+			done <- err
+			return
+		}
+		done <- dm.StartAll()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Starting a device that reports its state deadlocked the manager")
+	}
+	if state := dm.GetDeviceStates()["keyboard"]; state.Battery == nil || *state.Battery != 80 {
+		t.Errorf("State = %+v, want the state reported while starting", state)
+	}
+	dm.CloseAll()
+}
+
 func TestDeviceManager_GetDeviceStates_WithDevices(t *testing.T) {
 	dm := NewDeviceManager()
 
@@ -180,3 +230,339 @@ func (m *mockHIDDevice) Close() error {
 func (m *mockHIDDevice) SetOnStateChange(callback func(protocol.DeviceState)) {
 	// Mock implementation
 }
+
+func TestDeviceManager_CheckStaleness(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.SetStaleThreshold(time.Hour)
+
+	lastSeen := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	battery := 60
+	mockDevice := &mockHIDDevice{
+		id: "mouse",
+		state: protocol.DeviceState{
+			DeviceID: "mouse",
+			Battery:  &battery,
+			LastSeen: lastSeen,
+		},
+	}
+	dm.mu.Lock()
+	dm.devices["mouse"] = mockDevice
+	dm.mu.Unlock()
+
+	var received []protocol.DeviceState
+	dm.SetOnStateChange(func(deviceID string, state protocol.DeviceState) {
+		received = append(received, state)
+	})
+
+	// Fresh device: nothing to report
+	dm.checkStaleness(lastSeen.Add(time.Minute))
+	if len(received) != 0 {
+		t.Fatalf("Expected no updates for fresh device, got %d", len(received))
+	}
+
+	// Stale device is reported on every check
+	dm.checkStaleness(lastSeen.Add(2 * time.Hour))
+	dm.checkStaleness(lastSeen.Add(3 * time.Hour))
+	if len(received) != 2 || !received[0].Stale || !received[1].Stale {
+		t.Fatalf("Expected 2 stale updates, got %+v", received)
+	}
+
+	// Recovery is reported once
+	mockDevice.state.LastSeen = lastSeen.Add(3 * time.Hour)
+	dm.checkStaleness(lastSeen.Add(3*time.Hour + time.Minute))
+	dm.checkStaleness(lastSeen.Add(3*time.Hour + 2*time.Minute))
+	if len(received) != 3 || received[2].Stale {
+		t.Errorf("Expected a single recovery update, got %d updates", len(received))
+	}
+}
+
+// closeTrackingDevice records when it is closed
+type closeTrackingDevice struct {
+	*mockHIDDevice
+	closed chan struct{}
+}
+
+func (c *closeTrackingDevice) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestDiscoverDevices_ConcurrentWithTimeout(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.SetDiscoveryTimeout(100 * time.Millisecond)
+
+	release := make(chan struct{})
+	late := &closeTrackingDevice{&mockHIDDevice{id: "late", name: "Late"}, make(chan struct{})}
+	dm.backends = []discoveryBackend{
+		{"fast", func(ctx context.Context) ([]BatteryDevice, error) {
+			return []BatteryDevice{&mockHIDDevice{id: "fast", name: "Fast"}}, nil
+		}},
+		// A wedged backend that ignores its context
+		{"wedged", func(ctx context.Context) ([]BatteryDevice, error) {
+			<-release
+			return []BatteryDevice{late}, nil
+		}},
+		{"missing", func(ctx context.Context) ([]BatteryDevice, error) {
+			return nil, errors.New("not found")
+		}},
+	}
+
+	discovered := make(chan string, 3)
+	dm.SetOnDeviceDiscovered(func(device BatteryDevice) { discovered <- device.GetID() })
+
+	result := make(chan error)
+	go func() { result <- dm.DiscoverDevices() }()
+
+	// The fast backend is reported while the wedged one is still running
+	select {
+	case id := <-discovered:
+		if id != "fast" {
+			t.Errorf("Discovered %s, want fast", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Fast backend was not reported")
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("DiscoverDevices = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("DiscoverDevices did not give up on the wedged backend")
+	}
+	if len(dm.GetAllDevices()) != 1 {
+		t.Errorf("Expected only the fast device, got %v", dm.GetAllDevices())
+	}
+
+	// Results arriving after the timeout are released, not added
+	close(release)
+	select {
+	case <-late.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Late device was not closed")
+	}
+	if dm.GetDevice("late") != nil {
+		t.Error("Late device should not be added")
+	}
+}
+
+func TestDiscoverDevices_NoneFound(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.backends = []discoveryBackend{
+		{"missing", func(ctx context.Context) ([]BatteryDevice, error) {
+			return nil, errors.New("not found")
+		}},
+	}
+	if err := dm.DiscoverDevices(); err == nil {
+		t.Error("Expected error when no backend finds a device")
+	}
+}
+
+func TestDeviceManager_SetBackends(t *testing.T) {
+	dm := NewDeviceManager()
+	if err := dm.SetBackends("bluetooth"); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+	if err := dm.SetBackends(BackendHIDBattery, BackendRazer); err != nil {
+		t.Fatalf("SetBackends failed: %v", err)
+	}
+
+	// Built-in backends keep their order whatever order they are named in
+	var names []string
+	for _, backend := range builtinBackends(dm.definitions, dm.enabled) {
+		names = append(names, backend.name)
+	}
+	if want := []string{"Razer devices", "HID battery devices"}; !slices.Equal(names, want) {
+		t.Errorf("Backends = %v, want %v", names, want)
+	}
+
+	// With every built-in backend disabled only added ones are probed
+	if err := dm.SetBackends(); err != nil {
+		t.Fatalf("SetBackends failed: %v", err)
+	}
+	dm.AddBackend("custom", func(ctx context.Context) ([]BatteryDevice, error) {
+		return []BatteryDevice{&mockHIDDevice{id: "custom", name: "Custom"}}, nil
+	})
+	if err := dm.DiscoverDevices(); err != nil {
+		t.Fatalf("DiscoverDevices failed: %v", err)
+	}
+	if len(dm.GetAllDevices()) != 1 || dm.GetDevice("custom") == nil {
+		t.Errorf("Devices = %v, want only the custom device", dm.GetAllDevices())
+	}
+}
+
+// runnerDevice counts its runs and resyncs
+type runnerDevice struct {
+	*mockHIDDevice
+	runs    chan struct{}
+	resyncs chan struct{}
+}
+
+func (r *runnerDevice) Run(ctx context.Context) error {
+	r.runs <- struct{}{}
+	<-ctx.Done()
+	return nil
+}
+
+func (r *runnerDevice) Resync() error {
+	r.resyncs <- struct{}{}
+	return nil
+}
+
+func TestDeviceManager_SuspendResume(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.resumeDelay = 0
+	dev := &runnerDevice{&mockHIDDevice{id: "mouse", name: "Mouse"}, make(chan struct{}, 4), make(chan struct{}, 4)}
+	dm.mu.Lock()
+	dm.devices["mouse"] = dev
+	dm.mu.Unlock()
+
+	if err := dm.StartAll(); err != nil {
+		t.Fatalf("StartAll failed: %v", err)
+	}
+	defer dm.StopAll()
+	receive(t, dev.runs, "first run")
+
+	dm.Suspend()
+	if health := dm.GetDeviceHealth()["mouse"]; health.Status != HealthStopped {
+		t.Errorf("Health while suspended = %s, want stopped", health.Status)
+	}
+	dm.Suspend() // A repeated signal is harmless
+
+	dm.Resume()
+	receive(t, dev.resyncs, "resync")
+	receive(t, dev.runs, "run after resume")
+
+	dm.Resume() // Resuming twice does not resync again
+	select {
+	case <-dev.resyncs:
+		t.Error("Unexpected second resync")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestDeviceManager_SuspendDuringResume(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.resumeDelay = time.Hour
+	dev := &runnerDevice{&mockHIDDevice{id: "mouse", name: "Mouse"}, make(chan struct{}, 4), make(chan struct{}, 4)}
+	dm.mu.Lock()
+	dm.devices["mouse"] = dev
+	dm.mu.Unlock()
+
+	if err := dm.StartAll(); err != nil {
+		t.Fatalf("StartAll failed: %v", err)
+	}
+	defer dm.StopAll()
+	receive(t, dev.runs, "first run")
+	dm.Suspend()
+
+	resumed := make(chan struct{})
+	go func() {
+		dm.Resume()
+		close(resumed)
+	}()
+	// Wait for the resume to start settling
+	for {
+		dm.mu.RLock()
+		resuming := dm.resuming != nil
+		dm.mu.RUnlock()
+		if resuming {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	dm.Suspend()
+	receive(t, resumed, "aborted resume")
+	select {
+	case <-dev.resyncs:
+		t.Error("An aborted resume should not resync")
+	case <-dev.runs:
+		t.Error("An aborted resume should not restart the device")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if health := dm.GetDeviceHealth()["mouse"]; health.Status != HealthStopped {
+		t.Errorf("Health after aborted resume = %s, want stopped", health.Status)
+	}
+
+	// The next wake-up resumes normally
+	dm.resumeDelay = 0
+	dm.Resume()
+	receive(t, dev.resyncs, "resync")
+	receive(t, dev.runs, "run after resume")
+}
+
+func TestAddDevice_DuplicateIDClosed(t *testing.T) {
+	dm := NewDeviceManager()
+	first := &closeTrackingDevice{&mockHIDDevice{id: "mouse", name: "Wired"}, make(chan struct{})}
+	second := &closeTrackingDevice{&mockHIDDevice{id: "mouse", name: "Wireless"}, make(chan struct{})}
+
+	dm.addDevice(first)
+	dm.addDevice(second)
+
+	select {
+	case <-second.closed:
+	default:
+		t.Error("A device with an already registered ID should be closed")
+	}
+	select {
+	case <-first.closed:
+		t.Error("The registered device should be kept open")
+	default:
+	}
+	if device := dm.GetDevice("mouse"); device != first {
+		t.Errorf("GetDevice = %v, want the first device", device)
+	}
+}
+
+// blockingDevice blocks in GetState until release is closed
+type blockingDevice struct {
+	*mockHIDDevice
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingDevice) GetState() protocol.DeviceState {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.mockHIDDevice.GetState()
+}
+
+func TestDeviceManager_StateQueriesDoNotHoldLock(t *testing.T) {
+	dm := NewDeviceManager()
+	dev := &blockingDevice{&mockHIDDevice{id: "mouse", name: "Mouse"}, make(chan struct{}, 4), make(chan struct{})}
+	dm.mu.Lock()
+	dm.devices["mouse"] = dev
+	dm.mu.Unlock()
+
+	for name, query := range map[string]func(){
+		"checkStaleness":     func() { dm.checkStaleness(time.Now()) },
+		"GetDeviceStates":    func() { dm.GetDeviceStates() },
+		"GetRawDeviceStates": func() { dm.GetRawDeviceStates() },
+		"SaveStates":         func() { dm.SaveStates() },
+	} {
+		done := make(chan struct{})
+		go func() {
+			query()
+			close(done)
+		}()
+		receive(t, dev.entered, name+" to query the device")
+
+		// The manager stays usable while a device is slow to answer
+		locked := make(chan struct{})
+		go func() {
+			dm.SetStaleThreshold(time.Hour)
+			dm.addDevice(&mockHIDDevice{id: "keyboard-" + name, name: "Keyboard"})
+			close(locked)
+		}()
+		select {
+		case <-locked:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s held the manager lock while querying a device", name)
+		}
+
+		dev.release <- struct{}{}
+		receive(t, done, name)
+	}
+}
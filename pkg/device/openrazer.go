@@ -1,9 +1,12 @@
 package device
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os/exec"
+	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -19,54 +22,125 @@ const (
 	razerDeviceIface  = "razer.device"
 	razerPowerIface   = "razer.device.power"
 	pollInterval      = 5 * time.Second
+
+	// maxAsleepPollInterval caps how far polling backs off while the mouse
+	// is asleep, which bounds how long it takes to notice it woke up
+	maxAsleepPollInterval = 2 * time.Minute
+
+	// razerAsleepFloor is the lowest last reading from which a drop to 0%
+	// means the mouse fell asleep; below it the battery may really be empty
+	razerAsleepFloor = 5
+
+	// dbusCallTimeout bounds each poll call, so a hung daemon is noticed
+	// instead of blocking the poll loop
+	dbusCallTimeout = 2 * time.Second
+
+	// razerSysfsDriverDir is where the OpenRazer kernel driver exposes the
+	// charge_level and charge_status attributes of each bound mouse
+	razerSysfsDriverDir = "/sys/bus/hid/drivers/razermouse"
 )
 
-// RazerDevice represents a Razer device monitored via OpenRazer D-Bus
+// Limits the OpenRazer driver accepts for the power settings
+const (
+	razerMinIdleTime     = 60  // Seconds
+	razerMaxIdleTime     = 900 // Seconds
+	razerMinLowThreshold = 5   // Percent
+	razerMaxLowThreshold = 25  // Percent
+)
+
+// RazerDevice represents a Razer device monitored via OpenRazer D-Bus. A
+// dual-mode mouse is a single RazerDevice, reading from whichever of its
+// wired and wireless identities is present.
 type RazerDevice struct {
 	conn         *dbus.Conn
-	devicePath   dbus.ObjectPath
+	identity     razerIdentity        // The daemon device being read
+	identities   []razerIdentity      // Every daemon device known to be this mouse
+	lastScan     time.Time            // When the daemon was last checked for identities
+	appeared     map[string]time.Time // When each daemon serial appeared, zero if present at the first scan
+	lostAt       time.Time            // When an identity of this mouse last disappeared from the daemon
 	deviceSerial string
 	deviceName   string
 	state        protocol.DeviceState
 	stopChan     chan struct{}
 	onChange     func(protocol.DeviceState)
+	connect      func() (*dbus.Conn, error) // Opens the bus the daemon is on
+	fs           FileSystem
+	sysfsDir     string // Kernel driver attribute directory, found on first use
+	fallback     bool   // Reading sysfs because the daemon is unavailable
+	powerRead    bool   // Power settings were read on the current connection
+	asleep       bool   // The mouse stopped answering while idle
+	asleepDelay  time.Duration
+	restarter    *DaemonRestarter
 	mu           sync.RWMutex
 }
 
 // NewRazerDevice creates a new Razer device monitor
 func NewRazerDevice(devicePath dbus.ObjectPath, deviceSerial string) (*RazerDevice, error) {
-	conn, err := dbus.SessionBus()
+	return NewRazerDeviceContext(context.Background(), devicePath, deviceSerial)
+}
+
+// NewRazerDeviceContext creates a new Razer device monitor, giving up on the
+// daemon's replies when ctx ends
+func NewRazerDeviceContext(ctx context.Context, devicePath dbus.ObjectPath, deviceSerial string) (*RazerDevice, error) {
+	conn, err := dbus.ConnectSessionBus()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to session D-Bus: %w", err)
 	}
 
 	// Get device name (try getDeviceName, fallback to serial)
-	deviceName := fmt.Sprintf("Razer Device (%s)", deviceSerial)
-	var name string
-	err = conn.Object(razerService, devicePath).Call(razerDeviceIface+".getDeviceName", 0).Store(&name)
-	if err == nil && name != "" {
-		deviceName = name
+	identity := lookupRazerIdentity(ctx, conn, deviceSerial)
+	identity.path = devicePath
+	return newRazerDeviceContext(ctx, conn, []razerIdentity{identity}), nil
+}
+
+// newRazerDeviceContext creates a device for the identities of one mouse and
+// fetches its initial state. The device owns conn and closes it.
+func newRazerDeviceContext(ctx context.Context, conn *dbus.Conn, identities []razerIdentity) *RazerDevice {
+	rd := newRazerDeviceGroup(conn, RealFileSystem{}, identities)
+
+	// Initial state fetch
+	if err := rd.updateState(); err != nil {
+		log.Printf("Warning: Failed to fetch initial state for %s: %v", rd.deviceName, err)
 	}
+	return rd
+}
 
-	rd := &RazerDevice{
+func newRazerDevice(conn *dbus.Conn, fs FileSystem, devicePath dbus.ObjectPath, deviceSerial, deviceName string) *RazerDevice {
+	return newRazerDeviceGroup(conn, fs, []razerIdentity{newRazerIdentity(devicePath, deviceSerial, deviceName)})
+}
+
+// newRazerDeviceGroup creates a device for the identities of one mouse,
+// reading from the preferred one. The device is named after the model and
+// keeps the serial of the preferred identity as its ID.
+func newRazerDeviceGroup(conn *dbus.Conn, fs FileSystem, identities []razerIdentity) *RazerDevice {
+	identities = append([]razerIdentity(nil), identities...)
+	sortByPreference(identities)
+	identity := identities[0]
+
+	return &RazerDevice{
 		conn:         conn,
-		devicePath:   devicePath,
-		deviceSerial: deviceSerial,
-		deviceName:   deviceName,
+		identity:     identity,
+		identities:   identities,
+		lastScan:     time.Now(),
+		deviceSerial: identity.serial,
+		deviceName:   identity.model,
 		state: protocol.DeviceState{
-			DeviceID:    deviceSerial,
+			DeviceID:    identity.serial,
 			DeviceType:  string(DeviceTypeRazerDeathAdder),
 			IsConnected: true,
 		},
 		stopChan: make(chan struct{}),
+		connect:  func() (*dbus.Conn, error) { return dbus.ConnectSessionBus() },
+		fs:       fs,
 	}
+}
 
-	// Initial state fetch
-	if err := rd.updateState(); err != nil {
-		log.Printf("Warning: Failed to fetch initial state for %s: %v", deviceName, err)
-	}
-
-	return rd, nil
+// SetDaemonRestarter sets how the OpenRazer daemon is restarted after
+// repeated failures. Without one the daemon is never restarted.
+func (r *RazerDevice) SetDaemonRestarter(restarter *DaemonRestarter) {
+	r.mu.Lock()
+	r.restarter = restarter
+	r.mu.Unlock()
 }
 
 // GetID returns the device serial number
@@ -108,10 +182,21 @@ func (r *RazerDevice) SetOnStateChange(callback func(protocol.DeviceState)) {
 // Start begins monitoring the device
 func (r *RazerDevice) Start() error {
 	log.Printf("Starting Razer device monitoring for %s", r.deviceName)
-	go r.pollLoop()
+	go func() {
+		if err := r.pollLoop(nil); err != nil {
+			log.Printf("Razer device monitoring stopped: %v", err)
+		}
+	}()
 	return nil
 }
 
+// Run monitors the device until ctx is cancelled or the OpenRazer daemon
+// cannot be recovered, so that a Supervisor can restart it
+func (r *RazerDevice) Run(ctx context.Context) error {
+	log.Printf("Starting supervised Razer device monitoring for %s", r.deviceName)
+	return r.pollLoop(ctx.Done())
+}
+
 // Stop stops monitoring the device
 func (r *RazerDevice) Stop() error {
 	select {
@@ -135,10 +220,11 @@ func (r *RazerDevice) Close() error {
 	return nil
 }
 
-// pollLoop periodically polls the device for battery status
-func (r *RazerDevice) pollLoop() {
-	ticker := time.NewTicker(pollInterval)
-	defer ticker.Stop()
+// pollLoop periodically polls the device for battery status. It returns nil
+// when stopped via Stop or done, or an error once the daemon restart fails.
+func (r *RazerDevice) pollLoop(done <-chan struct{}) error {
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
 
 	consecutiveErrors := 0
 	maxConsecutiveErrors := 3
@@ -146,8 +232,10 @@ func (r *RazerDevice) pollLoop() {
 	for {
 		select {
 		case <-r.stopChan:
-			return
-		case <-ticker.C:
+			return nil
+		case <-done:
+			return nil
+		case <-timer.C:
 			if err := r.updateState(); err != nil {
 				consecutiveErrors++
 
@@ -169,23 +257,15 @@ func (r *RazerDevice) pollLoop() {
 						// If multiple failures, try restarting OpenRazer daemon
 						if consecutiveErrors >= maxConsecutiveErrors {
 							log.Printf("Multiple reconnection failures, attempting to restart OpenRazer daemon...")
-							if restartErr := r.restartOpenRazerDaemon(); restartErr != nil {
+							if restartErr := r.restartOpenRazerDaemon(done); restartErr != nil {
 								log.Printf("Failed to restart OpenRazer daemon: %v", restartErr)
-							} else {
-								log.Printf("OpenRazer daemon restarted, waiting before retry...")
-								time.Sleep(2 * time.Second)
-								consecutiveErrors = 0 // Reset counter after restart
+								r.markDisconnected()
+								return fmt.Errorf("OpenRazer daemon unavailable: %w", restartErr)
 							}
+							consecutiveErrors = 0 // Reset counter after restart
 						}
 
-						// Mark as disconnected
-						r.mu.Lock()
-						oldState := r.state
-						r.state.IsConnected = false
-						if r.onChange != nil && oldState != r.state {
-							r.onChange(r.state)
-						}
-						r.mu.Unlock()
+						r.markDisconnected()
 					} else {
 						log.Printf("Successfully reconnected to Razer device")
 
@@ -211,22 +291,54 @@ func (r *RazerDevice) pollLoop() {
 				} else {
 					log.Printf("Error updating Razer device state: %v", err)
 					// Mark as disconnected if we can't communicate
-					r.mu.Lock()
-					oldState := r.state
-					r.state.IsConnected = false
-					if r.onChange != nil && oldState != r.state {
-						r.onChange(r.state)
-					}
-					r.mu.Unlock()
+					r.markDisconnected()
 				}
 			} else {
 				// Success - reset error counter
 				consecutiveErrors = 0
 			}
+			timer.Reset(r.nextPollDelay())
 		}
 	}
 }
 
+// nextPollDelay returns how long to wait before the next poll. While the
+// mouse is asleep the delay doubles up to maxAsleepPollInterval, so polling
+// does not keep waking the receiver.
+func (r *RazerDevice) nextPollDelay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.asleep {
+		r.asleepDelay = 0
+		return pollInterval
+	}
+	if r.asleepDelay == 0 {
+		r.asleepDelay = pollInterval
+	}
+	r.asleepDelay *= 2
+	if r.asleepDelay > maxAsleepPollInterval {
+		r.asleepDelay = maxAsleepPollInterval
+	}
+	return r.asleepDelay
+}
+
+// markDisconnected flags the device as disconnected and notifies listeners
+func (r *RazerDevice) markDisconnected() {
+	r.mu.Lock()
+	oldState := r.state
+	r.state.IsConnected = false
+	r.state.Connection = nil
+	r.asleep = false
+	state := r.state
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if onChange != nil && !oldState.Equal(state) {
+		onChange(state)
+	}
+}
+
 // isConnectionClosed checks if the error indicates a closed connection
 func isConnectionClosed(err error) bool {
 	if err == nil {
@@ -295,12 +407,15 @@ func (r *RazerDevice) reconnect() error {
 	}
 
 	// Create new connection
-	conn, err := dbus.SessionBus()
+	conn, err := r.connect()
 	if err != nil {
 		return fmt.Errorf("failed to reconnect to session D-Bus: %w", err)
 	}
 
+	// The settings may have been changed elsewhere, e.g. by Synapse, while
+	// the device was away
 	r.conn = conn
+	r.powerRead = false
 	return nil
 }
 
@@ -308,7 +423,7 @@ func (r *RazerDevice) reconnect() error {
 func (r *RazerDevice) verifyDevice() error {
 	r.mu.RLock()
 	conn := r.conn
-	devicePath := r.devicePath
+	devicePath := r.identity.path
 	r.mu.RUnlock()
 
 	if conn == nil {
@@ -318,140 +433,606 @@ func (r *RazerDevice) verifyDevice() error {
 	obj := conn.Object(razerService, devicePath)
 	// Try a simple call to verify device exists
 	var battery float64
-	err := obj.Call(razerPowerIface+".getBattery", 0).Store(&battery)
+	err := callDBus(context.Background(), obj, razerPowerIface+".getBattery").Store(&battery)
 	if err != nil {
+		// After a mode switch the mouse is there under its other identity
+		if r.findIdentity(context.Background()) {
+			return nil
+		}
 		return fmt.Errorf("device not accessible: %w", err)
 	}
 	return nil
 }
 
-// restartOpenRazerDaemon attempts to restart the OpenRazer daemon
-func (r *RazerDevice) restartOpenRazerDaemon() error {
-	// Try to stop the daemon via D-Bus first
+// Resync opens a fresh connection to the daemon and re-queries the device,
+// e.g. after the system resumes from sleep. The query verifies the device is
+// still there, and falls back to sysfs if the daemon is not back yet.
+func (r *RazerDevice) Resync() error {
+	if err := r.reconnect(); err != nil {
+		log.Printf("Failed to reconnect %s after resume: %v", r.deviceName, err)
+	}
+	return r.updateState()
+}
+
+// restartOpenRazerDaemon restarts the OpenRazer daemon through systemd,
+// subject to the restarter's policy, and reconnects once it is back on the bus
+func (r *RazerDevice) restartOpenRazerDaemon(done <-chan struct{}) error {
 	r.mu.RLock()
-	conn := r.conn
+	restarter := r.restarter
 	r.mu.RUnlock()
 
-	if conn != nil {
-		obj := conn.Object(razerService, razerManagerPath)
-		err := obj.Call("razer.daemon.stop", 0).Store()
-		if err != nil {
-			log.Printf("Could not stop daemon via D-Bus: %v", err)
+	if restarter == nil {
+		return ErrRestartDisabled
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+		case <-r.stopChan:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	if err := restarter.Restart(ctx); err != nil {
+		return err
+	}
+	return r.reconnect()
+}
+
+// updateState fetches the current battery and charging status from D-Bus.
+// While the daemon is unavailable the kernel driver's sysfs attributes are
+// read instead, and the D-Bus error is only returned if that fails too. A
+// mouse that has fallen asleep keeps its last reading.
+func (r *RazerDevice) updateState() error {
+	// Pick up a cable plugged in while the receiver still answers; the
+	// wired identity is recognised by reporting the same reading
+	if r.identityScanDue() {
+		r.findIdentity(context.Background())
+	}
+
+	battery, isCharging, chargingErr, err := r.readDBus()
+	if err != nil && isIdentityGone(err) && r.findIdentity(context.Background()) {
+		battery, isCharging, chargingErr, err = r.readDBus()
+	}
+	if err == nil {
+		r.mu.Lock()
+		recovered := r.fallback
+		r.fallback = false
+		r.mu.Unlock()
+		if recovered {
+			log.Printf("OpenRazer daemon is back, reading %s over D-Bus again", r.deviceName)
 		}
-		time.Sleep(1 * time.Second)
+		if r.isAsleepReading(battery, isCharging && chargingErr == nil) {
+			r.markAsleep()
+			return nil
+		}
+		r.applyReading(battery, isCharging, chargingErr)
+		r.updatePowerSettings()
+		return nil
+	}
+
+	// The daemon answered, so the connection is fine and the mouse is just
+	// not responding
+	if isAsleepError(err) && r.hasReading() {
+		r.markAsleep()
+		return nil
+	}
+
+	battery, isCharging, sysfsErr := r.readSysfs()
+	if sysfsErr != nil {
+		return err
 	}
 
-	// Try to restart via systemd user service
-	cmd := exec.Command("systemctl", "--user", "restart", "openrazer-daemon.service")
-	if err := cmd.Run(); err != nil {
-		// Fallback: try without .service suffix
-		cmd = exec.Command("systemctl", "--user", "restart", "openrazer-daemon")
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to restart OpenRazer daemon: %w", err)
+	r.mu.Lock()
+	started := !r.fallback
+	r.fallback = true
+	conn := r.conn
+	r.mu.Unlock()
+	if started {
+		log.Printf("⚠️ OpenRazer daemon unavailable (%v), reading %s from sysfs", err, r.deviceName)
+	}
+
+	// Keep trying to get back on D-Bus; the daemon may return at any time
+	if conn == nil || isConnectionClosed(err) {
+		if reconnectErr := r.reconnect(); reconnectErr != nil {
+			log.Printf("D-Bus still unavailable: %v", reconnectErr)
 		}
 	}
 
-	// Wait for daemon to be ready
-	time.Sleep(2 * time.Second)
+	if r.isAsleepReading(battery, isCharging) {
+		r.markAsleep()
+		return nil
+	}
+	r.applyReading(battery, isCharging, nil)
+	return nil
+}
 
-	// Reconnect after daemon restart
-	return r.reconnect()
+// isAsleepReading reports whether a reading of 0% is the mouse asleep rather
+// than an empty battery. OpenRazer reports 0% when the receiver cannot reach
+// the mouse; a real battery does not drop from the last good reading to
+// zero between polls.
+func (r *RazerDevice) isAsleepReading(battery int, charging bool) bool {
+	if battery != 0 || charging {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.Battery != nil && *r.state.Battery >= razerAsleepFloor
 }
 
-// updateState fetches the current battery and charging status from D-Bus
-func (r *RazerDevice) updateState() error {
+// hasReading reports whether the device has reported a battery level
+func (r *RazerDevice) hasReading() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state.Battery != nil
+}
+
+// isAsleepError reports whether err is the timeout the OpenRazer daemon
+// raises when the receiver gets no answer from the mouse. Other daemon
+// exceptions are real driver or daemon failures, and errors from the bus,
+// such as an unknown object or no reply, mean the device or the daemon is
+// gone.
+func isAsleepError(err error) bool {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) || dbusErr.Name != "org.freedesktop.DBus.Python.OSError" {
+		return false
+	}
+	message := strings.ToLower(dbusErr.Error())
+	return strings.Contains(message, "timed out") || strings.Contains(message, "errno 110")
+}
+
+// markAsleep flags the mouse as asleep, keeping its last reading, and
+// notifies listeners
+func (r *RazerDevice) markAsleep() {
+	r.mu.Lock()
+	oldState := r.state
+	wasAsleep := r.asleep
+	r.asleep = true
+	asleep := protocol.ConnectionAsleep
+	r.state.Connection = &asleep
+	r.state.IsConnected = false
+	state := r.state
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if !wasAsleep {
+		log.Printf("💤 Razer %s is asleep, keeping the last reading", r.deviceName)
+	}
+	if onChange != nil && !oldState.Equal(state) {
+		onChange(state)
+	}
+}
+
+// readDBus reads the battery level and charging status from the daemon. An
+// error from isCharging is returned separately, since it only means the
+// status is unknown, not that the device is running wirelessly.
+func (r *RazerDevice) readDBus() (battery int, isCharging bool, chargingErr, err error) {
 	r.mu.RLock()
 	conn := r.conn
+	devicePath := r.identity.path
 	r.mu.RUnlock()
 
 	if conn == nil {
-		return fmt.Errorf("D-Bus connection not available")
+		return 0, false, nil, fmt.Errorf("D-Bus connection not available")
 	}
 
-	obj := conn.Object(razerService, r.devicePath)
+	obj := conn.Object(razerService, devicePath)
 
 	// Get battery level
-	var battery float64
-	err := obj.Call(razerPowerIface+".getBattery", 0).Store(&battery)
-	if err != nil {
-		return fmt.Errorf("failed to get battery: %w", err)
+	var level float64
+	if err := callDBus(context.Background(), obj, razerPowerIface+".getBattery").Store(&level); err != nil {
+		return 0, false, nil, fmt.Errorf("failed to get battery: %w", err)
 	}
 
 	// Get charging status
-	var isCharging bool
-	err = obj.Call(razerPowerIface+".isCharging", 0).Store(&isCharging)
+	chargingErr = callDBus(context.Background(), obj, razerPowerIface+".isCharging").Store(&isCharging)
+	if chargingErr != nil {
+		log.Printf("Failed to get charging status for %s: %v", r.deviceName, chargingErr)
+	}
+
+	return int(level), isCharging, chargingErr, nil
+}
+
+// updatePowerSettings reads the idle time and low battery threshold once per
+// connection, since they only change when set. Devices without them leave
+// the fields unknown.
+func (r *RazerDevice) updatePowerSettings() {
+	r.mu.Lock()
+	conn := r.conn
+	devicePath := r.identity.path
+	done := r.powerRead
+	r.powerRead = true
+	r.mu.Unlock()
+	if done || conn == nil {
+		return
+	}
+
+	obj := conn.Object(razerService, devicePath)
+	var idleTime *int
+	var seconds uint16
+	if err := callDBus(context.Background(), obj, razerPowerIface+".getIdleTime").Store(&seconds); err != nil {
+		log.Printf("Failed to get idle time for %s: %v", r.deviceName, err)
+	} else {
+		value := int(seconds)
+		idleTime = &value
+	}
+
+	var threshold *int
+	var percent uint8
+	if err := callDBus(context.Background(), obj, razerPowerIface+".getLowBatteryThreshold").Store(&percent); err != nil {
+		log.Printf("Failed to get low battery threshold for %s: %v", r.deviceName, err)
+	} else {
+		value := int(percent)
+		threshold = &value
+	}
+
+	r.applyPowerSetting(protocol.TargetIdleTime, idleTime)
+	r.applyPowerSetting(protocol.TargetLowBatteryThreshold, threshold)
+}
+
+// applyPowerSetting stores a power setting read from or written to the
+// device and notifies listeners if it changed. A nil value is ignored.
+func (r *RazerDevice) applyPowerSetting(target string, value *int) {
+	if value == nil {
+		return
+	}
+
+	r.mu.Lock()
+	oldState := r.state
+	switch target {
+	case protocol.TargetIdleTime:
+		r.state.IdleTime = value
+	case protocol.TargetLowBatteryThreshold:
+		r.state.LowBatteryThreshold = value
+	}
+	r.state.MarkUpdated(target, time.Now())
+	state := r.state
+	onChange := r.onChange
+	r.mu.Unlock()
+
+	if onChange != nil && !oldState.Equal(state) {
+		onChange(state)
+	}
+}
+
+// Settings returns the power settings the device reported
+func (r *RazerDevice) Settings() []Setting {
+	return razerSettings(r.GetState())
+}
+
+// razerSettings describes the power settings whose values a state holds
+func razerSettings(state protocol.DeviceState) []Setting {
+	var settings []Setting
+	if state.IdleTime != nil {
+		settings = append(settings, Setting{
+			ID:      SettingIdleTime,
+			Name:    "Sleep After",
+			Kind:    SettingRange,
+			Min:     razerMinIdleTime,
+			Max:     razerMaxIdleTime,
+			Unit:    "s",
+			Presets: []int{60, 120, 300, 600, 900},
+			Value:   *state.IdleTime,
+		})
+	}
+	if state.LowBatteryThreshold != nil {
+		settings = append(settings, Setting{
+			ID:      SettingLowBatteryThreshold,
+			Name:    "Low Battery Warning",
+			Kind:    SettingRange,
+			Min:     razerMinLowThreshold,
+			Max:     razerMaxLowThreshold,
+			Unit:    "%",
+			Presets: []int{5, 10, 15, 20, 25},
+			Value:   *state.LowBatteryThreshold,
+		})
+	}
+	return settings
+}
+
+// ApplySetting sets the idle time or low battery threshold through the
+// OpenRazer daemon
+func (r *RazerDevice) ApplySetting(id string, value any) error {
+	r.mu.RLock()
+	conn := r.conn
+	devicePath := r.identity.path
+	fallback := r.fallback
+	r.mu.RUnlock()
+
+	if conn == nil || fallback {
+		return fmt.Errorf("OpenRazer daemon not available")
+	}
+	n, ok := value.(int)
+	if !ok {
+		return fmt.Errorf("%s takes a number", id)
+	}
+
+	obj := conn.Object(razerService, devicePath)
+	var target string
+	var err error
+	switch id {
+	case SettingIdleTime:
+		target = protocol.TargetIdleTime
+		err = callDBus(context.Background(), obj, razerPowerIface+".setIdleTime", uint16(n)).Err
+	case SettingLowBatteryThreshold:
+		target = protocol.TargetLowBatteryThreshold
+		err = callDBus(context.Background(), obj, razerPowerIface+".setLowBatteryThreshold", uint8(n)).Err
+	default:
+		return fmt.Errorf("unknown setting %q", id)
+	}
 	if err != nil {
-		// When not charging, isCharging might return false or error
-		// In wireless mode, isCharging should be false
-		// We'll treat errors as "not charging" (wireless mode)
-		isCharging = false
+		return fmt.Errorf("failed to set %s: %w", id, err)
 	}
 
-	batteryInt := int(battery)
+	log.Printf("🖱️ Razer %s: %s set to %d", r.deviceName, id, n)
+	r.applyPowerSetting(target, &n)
+	return nil
+}
+
+// readSysfs reads the battery from the OpenRazer kernel driver. charge_level
+// is 0-255 and charge_status is 1 while charging.
+func (r *RazerDevice) readSysfs() (battery int, isCharging bool, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.fs == nil {
+		return 0, false, fmt.Errorf("sysfs not available")
+	}
+	if r.sysfsDir == "" {
+		dir, err := findRazerSysfsDevice(r.fs, r.identity.serial)
+		if err != nil {
+			return 0, false, err
+		}
+		r.sysfsDir = dir
+	}
+
+	level, err := readSysfsInt(r.fs, r.sysfsDir+"/charge_level")
+	if err == nil {
+		var status int
+		status, err = readSysfsInt(r.fs, r.sysfsDir+"/charge_status")
+		isCharging = status == 1
+	}
+	if err != nil {
+		// The device may have been re-bound under another HID instance
+		r.sysfsDir = ""
+		return 0, false, err
+	}
+
+	return int(math.Round(float64(level) * 100 / 255)), isCharging, nil
+}
+
+// applyReading stores a battery reading and notifies listeners if it changed
+func (r *RazerDevice) applyReading(batteryInt int, isCharging bool, chargingErr error) {
+	r.mu.RLock()
+	mode := r.identity.mode
+	r.mu.RUnlock()
+	power := razerPowerState(isCharging, chargingErr, mode, batteryInt)
+
+	now := time.Now()
 
 	r.mu.Lock()
 	oldState := r.state
+	r.state.Mode = nil
+	if mode != protocol.ModeUnknown {
+		r.state.Mode = &mode
+	}
+	woke := r.asleep
+	r.asleep = false
+	r.state.Connection = nil
 	r.state.Battery = &batteryInt
-	r.state.IsCharging = &isCharging
+	r.state.Power = &power
+	r.state.IsCharging = nil
+	if chargingErr == nil {
+		r.state.IsCharging = &isCharging
+		r.state.MarkUpdated(protocol.TargetIsCharging, now)
+	}
 	r.state.IsConnected = true
+	r.state.MarkUpdated(protocol.TargetBattery, now)
+	source := ""
+	if r.fallback {
+		source = " via sysfs"
+	}
+	state := r.state
+	onChange := r.onChange
 	r.mu.Unlock()
 
+	if woke {
+		log.Printf("⏰ Razer %s woke up", r.deviceName)
+	}
+
 	// Trigger callback if state changed
-	if r.onChange != nil && oldState != r.state {
-		r.mu.RLock()
-		currentState := r.state
-		r.mu.RUnlock()
-		r.onChange(currentState)
+	if onChange != nil && !oldState.Equal(state) {
+		onChange(state)
 	}
 
-	log.Printf("🖱️ Razer %s: Battery %d%% (Charging: %v)", r.deviceName, batteryInt, isCharging)
-	return nil
+	log.Printf("🖱️ Razer %s: Battery %d%% (%s)%s", r.deviceName, batteryInt, power, source)
 }
 
-// DiscoverRazerDevices discovers all Razer devices with battery support via OpenRazer
-func DiscoverRazerDevices() ([]*RazerDevice, error) {
-	conn, err := dbus.SessionBus()
+// findRazerSysfsDevice returns the driver attribute directory of the device
+// with the given serial. A device is bound once per HID interface, but only
+// one of them has the charge attributes.
+func findRazerSysfsDevice(fs FileSystem, serial string) (string, error) {
+	entries, err := fs.ReadDir(razerSysfsDriverDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to session D-Bus: %w", err)
+		return "", fmt.Errorf("OpenRazer kernel driver not loaded: %w", err)
 	}
-	defer conn.Close()
 
-	// Check if OpenRazer daemon is running
-	obj := conn.Object(razerService, razerManagerPath)
+	for _, entry := range entries {
+		dir := razerSysfsDriverDir + "/" + entry.Name()
+		data, err := fs.ReadFile(dir + "/device_serial")
+		if err != nil || strings.TrimSpace(string(data)) != serial {
+			continue
+		}
+		if _, err := fs.ReadFile(dir + "/charge_level"); err != nil {
+			continue
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("no device with serial %s in %s", serial, razerSysfsDriverDir)
+}
+
+// readSysfsInt reads a sysfs attribute holding a decimal integer
+func readSysfsInt(fs FileSystem, path string) (int, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	return value, nil
+}
+
+// razerPowerState derives the power state from OpenRazer's charging flag. A
+// device read through its wired or dock identity that is not charging is
+// plugged in rather than on battery.
+func razerPowerState(charging bool, chargingErr error, mode protocol.ConnectionMode, battery int) protocol.PowerState {
+	if chargingErr != nil {
+		return protocol.PowerUnknown
+	}
+	return protocol.ChargerPowerState(charging, mode.IsPluggedIn(), battery)
+}
+
+// DiscoverRazerDevices discovers all Razer devices with battery support via
+// OpenRazer. If the daemon is not available, devices bound to the OpenRazer
+// kernel driver are monitored through sysfs until it is.
+func DiscoverRazerDevices() ([]*RazerDevice, error) {
+	return DiscoverRazerDevicesContext(context.Background())
+}
+
+// DiscoverRazerDevicesContext is DiscoverRazerDevices, giving up on the
+// daemon when ctx ends
+func DiscoverRazerDevicesContext(ctx context.Context) ([]*RazerDevice, error) {
 	var devices []string
-	err = obj.Call(razerManagerIface+".getDevices", 0).Store(&devices)
+	conn, err := dbus.ConnectSessionBus()
 	if err != nil {
-		return nil, fmt.Errorf("OpenRazer daemon not available or error: %w", err)
+		err = fmt.Errorf("failed to connect to session D-Bus: %w", err)
+	} else {
+		defer conn.Close()
+		devices, err = listRazerDevices(ctx, conn)
+	}
+	if err != nil {
+		sysfsDevices, sysfsErr := discoverRazerSysfsDevices(RealFileSystem{})
+		if sysfsErr != nil || len(sysfsDevices) == 0 {
+			return nil, err
+		}
+		log.Printf("⚠️ %v; monitoring %d Razer device(s) through sysfs", err, len(sysfsDevices))
+		return sysfsDevices, nil
 	}
 
 	if len(devices) == 0 {
 		return []*RazerDevice{}, nil
 	}
 
-	var razerDevices []*RazerDevice
+	var identities []razerIdentity
+	readings := make(map[dbus.ObjectPath]razerReading)
 	for _, deviceSerial := range devices {
 		// Device path format: /org/razer/device/{serial}
-		devicePath := dbus.ObjectPath(fmt.Sprintf("/org/razer/device/%s", deviceSerial))
+		devicePath := razerDevicePath(deviceSerial)
 		deviceObj := conn.Object(razerService, devicePath)
 
 		// Try to get battery to check if device supports it
 		// If this fails, the device doesn't support battery monitoring
 		var battery float64
-		err := deviceObj.Call(razerPowerIface+".getBattery", 0).Store(&battery)
+		err := callDBus(ctx, deviceObj, razerPowerIface+".getBattery").Store(&battery)
 		if err != nil {
 			// Device doesn't support battery, skip it
 			log.Printf("Device %s doesn't support battery monitoring: %v", deviceSerial, err)
 			continue
 		}
+		identities = append(identities, lookupRazerIdentity(ctx, conn, deviceSerial))
+		if reading, err := readRazerIdentity(ctx, conn, devicePath); err == nil {
+			readings[devicePath] = reading
+		}
+	}
 
-		device, err := NewRazerDevice(devicePath, deviceSerial)
+	// The wired and wireless identities of a dual-mode mouse are one device
+	var razerDevices []*RazerDevice
+	for _, group := range groupRazerIdentities(identities, readings) {
+		if len(group) > 1 {
+			log.Printf("Merged %d identities of %s", len(group), group[0].model)
+		}
+		// Each device has a connection of its own, since it closes it on
+		// reconnect and Close
+		deviceConn, err := dbus.ConnectSessionBus()
 		if err != nil {
-			log.Printf("Warning: Failed to create Razer device %s: %v", deviceSerial, err)
+			log.Printf("Failed to connect to session D-Bus for %s: %v", group[0].model, err)
 			continue
 		}
-
-		razerDevices = append(razerDevices, device)
+		razerDevices = append(razerDevices, newRazerDeviceContext(ctx, deviceConn, group))
 	}
 
 	return razerDevices, nil
 }
+
+// listRazerDevices returns the serials of the devices the daemon manages
+func listRazerDevices(ctx context.Context, conn *dbus.Conn) ([]string, error) {
+	// Check if OpenRazer daemon is running
+	var devices []string
+	err := callDBus(ctx, conn.Object(razerService, razerManagerPath), razerManagerIface+".getDevices").Store(&devices)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRazer daemon not available or error: %w", err)
+	}
+	return devices, nil
+}
+
+// callDBus calls a method on obj, bounded by dbusCallTimeout and ctx, so a
+// wedged daemon cannot block the caller
+func callDBus(ctx context.Context, obj dbus.BusObject, method string, args ...interface{}) *dbus.Call {
+	ctx, cancel := context.WithTimeout(ctx, dbusCallTimeout)
+	defer cancel()
+	return obj.CallWithContext(ctx, method, 0, args...)
+}
+
+// discoverRazerSysfsDevices finds devices bound to the OpenRazer kernel
+// driver that report a charge level, without a D-Bus connection. The
+// serial matches the daemon's device path, so they switch to D-Bus once the
+// daemon is back.
+func discoverRazerSysfsDevices(fs FileSystem) ([]*RazerDevice, error) {
+	entries, err := fs.ReadDir(razerSysfsDriverDir)
+	if err != nil {
+		return nil, fmt.Errorf("OpenRazer kernel driver not loaded: %w", err)
+	}
+
+	var identities []razerIdentity
+	dirs := make(map[string]string) // Attribute directory per serial
+	for _, entry := range entries {
+		dir := razerSysfsDriverDir + "/" + entry.Name()
+		data, err := fs.ReadFile(dir + "/device_serial")
+		if err != nil {
+			continue
+		}
+		serial := strings.TrimSpace(string(data))
+		if serial == "" || dirs[serial] != "" {
+			continue
+		}
+		if _, err := fs.ReadFile(dir + "/charge_level"); err != nil {
+			continue
+		}
+		dirs[serial] = dir
+
+		name := fmt.Sprintf("Razer Device (%s)", serial)
+		if deviceType, err := fs.ReadFile(dir + "/device_type"); err == nil && strings.TrimSpace(string(deviceType)) != "" {
+			name = strings.TrimSpace(string(deviceType))
+		}
+		identities = append(identities, newRazerIdentity(razerDevicePath(serial), serial, name))
+	}
+
+	var devices []*RazerDevice
+	for _, group := range groupRazerIdentities(identities, nil) {
+		device := newRazerDeviceGroup(nil, fs, group)
+		device.fallback = true
+		device.sysfsDir = dirs[device.identity.serial]
+		if battery, isCharging, err := device.readSysfs(); err != nil {
+			log.Printf("Warning: Failed to fetch initial state for %s: %v", device.deviceName, err)
+		} else {
+			device.applyReading(battery, isCharging, nil)
+		}
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
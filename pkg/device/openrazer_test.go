@@ -2,8 +2,13 @@ package device
 
 import (
 	"errors"
+	"os"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/internal/dbustest"
 	"github.com/jyablonski/goarctis/pkg/protocol"
 )
 
@@ -161,3 +166,433 @@ func TestDeviceType_String(t *testing.T) {
 		t.Errorf("DeviceTypeRazerDeathAdder = %q, want 'razer_deathadder'", DeviceTypeRazerDeathAdder)
 	}
 }
+
+func TestRazerPowerState(t *testing.T) {
+	tests := []struct {
+		name        string
+		charging    bool
+		chargingErr error
+		mode        protocol.ConnectionMode
+		battery     int
+		want        protocol.PowerState
+	}{
+		{"wireless", false, nil, protocol.ModeWireless, 60, protocol.PowerDischarging},
+		{"charging", true, nil, protocol.ModeWired, 60, protocol.PowerCharging},
+		{"charged", true, nil, protocol.ModeWired, 100, protocol.PowerFull},
+		{"wired not charging", false, nil, protocol.ModeWired, 80, protocol.PowerNotCharging},
+		{"on dock", false, nil, protocol.ModeDock, 80, protocol.PowerNotCharging},
+		{"charging over receiver", true, nil, protocol.ModeWireless, 60, protocol.PowerCharging},
+		{"query failed", false, errors.New("method not found"), protocol.ModeUnknown, 80, protocol.PowerUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := razerPowerState(tt.charging, tt.chargingErr, tt.mode, tt.battery)
+			if got != tt.want {
+				t.Errorf("razerPowerState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// razerSysfs builds a kernel driver directory with a mouse bound on two HID
+// interfaces, only the second of which has the charge attributes
+func razerSysfs(level, status string) *MockFileSystem {
+	dir := razerSysfsDriverDir
+	return &MockFileSystem{
+		dirContents: map[string][]os.FileInfo{
+			dir: {
+				MockFileInfo{name: "0003:1532:007C.0004"},
+				MockFileInfo{name: "0003:1532:007C.0005"},
+				MockFileInfo{name: "bind"},
+			},
+		},
+		files: map[string][]byte{
+			dir + "/0003:1532:007C.0004/device_serial": []byte("PM2143H12345678\n"),
+			dir + "/0003:1532:007C.0005/device_serial": []byte("PM2143H12345678\n"),
+			dir + "/0003:1532:007C.0005/device_type":   []byte("Razer DeathAdder V2 Pro (Wireless)\n"),
+			dir + "/0003:1532:007C.0005/charge_level":  []byte(level + "\n"),
+			dir + "/0003:1532:007C.0005/charge_status": []byte(status + "\n"),
+		},
+	}
+}
+
+func noBus() (*dbus.Conn, error) {
+	return nil, errors.New("no session bus")
+}
+
+func TestRazerDevice_SysfsFallback(t *testing.T) {
+	fs := razerSysfs("191", "0")
+	r := newRazerDevice(nil, fs, "/org/razer/device/PM2143H12345678", "PM2143H12345678", "Razer DeathAdder V2 Pro (Wireless)")
+	r.connect = noBus
+
+	var changes []protocol.DeviceState
+	r.SetOnStateChange(func(state protocol.DeviceState) { changes = append(changes, state) })
+
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState should fall back to sysfs, got: %v", err)
+	}
+	state := r.GetState()
+	if state.Battery == nil || *state.Battery != 75 {
+		t.Errorf("Battery = %v, want 75 scaled from 191/255", state.Battery)
+	}
+	if state.Power == nil || *state.Power != protocol.PowerDischarging {
+		t.Errorf("Power = %v, want Discharging", state.Power)
+	}
+	if !state.IsConnected || len(changes) != 1 {
+		t.Errorf("Expected one connected state change, got %d", len(changes))
+	}
+	if !r.fallback || r.sysfsDir != razerSysfsDriverDir+"/0003:1532:007C.0005" {
+		t.Errorf("fallback = %v, sysfsDir = %q", r.fallback, r.sysfsDir)
+	}
+
+	fs.files[r.sysfsDir+"/charge_status"] = []byte("1\n")
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+	if state := r.GetState(); state.Power == nil || *state.Power != protocol.PowerCharging {
+		t.Errorf("Power = %v, want Charging", state.Power)
+	}
+}
+
+func TestRazerDevice_NoFallback(t *testing.T) {
+	r := newRazerDevice(nil, &MockFileSystem{}, "/org/razer/device/XYZ", "XYZ", "Razer Mouse")
+	r.connect = noBus
+
+	err := r.updateState()
+	if err == nil || err.Error() != "D-Bus connection not available" {
+		t.Errorf("Expected the D-Bus error when sysfs is unavailable, got %v", err)
+	}
+}
+
+func TestDiscoverRazerSysfsDevices(t *testing.T) {
+	devices, err := discoverRazerSysfsDevices(razerSysfs("255", "1"))
+	if err != nil {
+		t.Fatalf("discoverRazerSysfsDevices failed: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("Expected one device for both interfaces, got %d", len(devices))
+	}
+
+	dev := devices[0]
+	if dev.GetID() != "PM2143H12345678" || dev.GetName() != "Razer DeathAdder V2 Pro" {
+		t.Errorf("Device = %s (%s)", dev.GetID(), dev.GetName())
+	}
+	if dev.identity.path != "/org/razer/device/PM2143H12345678" {
+		t.Errorf("path = %s, want the daemon's path for the serial", dev.identity.path)
+	}
+	if state := dev.GetState(); state.Mode == nil || *state.Mode != protocol.ModeWireless {
+		t.Errorf("Mode = %v, want Wireless from the device type", state.Mode)
+	}
+	if state := dev.GetState(); state.Battery == nil || *state.Battery != 100 {
+		t.Errorf("Battery = %v, want 100", state.Battery)
+	}
+
+	if _, err := discoverRazerSysfsDevices(&MockFileSystem{}); err == nil {
+		t.Error("Expected error without the kernel driver")
+	}
+}
+
+// fakeRazerDaemon serves the daemon's device list and the methods of each
+// device it has
+type fakeRazerDaemon struct {
+	conn    *dbus.Conn
+	serials []string
+	mu      sync.Mutex
+}
+
+func startFakeRazerDaemon(t *testing.T, address string) *fakeRazerDaemon {
+	t.Helper()
+	d := &fakeRazerDaemon{conn: dbustest.Connect(t, address)}
+	methods := map[string]interface{}{
+		"getDevices": func() ([]string, *dbus.Error) {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			return append([]string{}, d.serials...), nil
+		},
+	}
+	if err := d.conn.ExportMethodTable(methods, razerManagerPath, razerManagerIface); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := d.conn.RequestName(razerService, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("Failed to claim %s: %v", razerService, err)
+	}
+	return d
+}
+
+// fakeRazerMouse is one device of the fake daemon. Setters are recorded
+// under mu, since the bus calls them from its own goroutine.
+type fakeRazerMouse struct {
+	battery    float64
+	batteryErr *dbus.Error // Returned by getBattery when set
+	charging   bool
+	idleTime   uint16
+	threshold  uint8
+	mu         sync.Mutex
+}
+
+// addMouse exports a device at the daemon's path for serial
+func (d *fakeRazerDaemon) addMouse(t *testing.T, serial, name string) *fakeRazerMouse {
+	t.Helper()
+	f := &fakeRazerMouse{battery: 80, idleTime: 300, threshold: 10}
+	path := razerDevicePath(serial)
+	power := map[string]interface{}{
+		"getBattery": func() (float64, *dbus.Error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.battery, f.batteryErr
+		},
+		"isCharging": func() (bool, *dbus.Error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.charging, nil
+		},
+		"getIdleTime": func() (uint16, *dbus.Error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.idleTime, nil
+		},
+		"setIdleTime": func(seconds uint16) *dbus.Error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.idleTime = seconds
+			return nil
+		},
+		"getLowBatteryThreshold": func() (uint8, *dbus.Error) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			return f.threshold, nil
+		},
+		"setLowBatteryThreshold": func(percent uint8) *dbus.Error {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			f.threshold = percent
+			return nil
+		},
+	}
+	device := map[string]interface{}{
+		"getDeviceName": func() (string, *dbus.Error) { return name, nil },
+	}
+	if err := d.conn.ExportMethodTable(power, path, razerPowerIface); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.conn.ExportMethodTable(device, path, razerDeviceIface); err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.Lock()
+	d.serials = append(d.serials, serial)
+	d.mu.Unlock()
+	return f
+}
+
+// removeMouse takes a device away, as when its cable is unplugged
+func (d *fakeRazerDaemon) removeMouse(serial string) {
+	path := razerDevicePath(serial)
+	d.conn.ExportMethodTable(nil, path, razerPowerIface)
+	d.conn.ExportMethodTable(nil, path, razerDeviceIface)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, s := range d.serials {
+		if s == serial {
+			d.serials = append(d.serials[:i], d.serials[i+1:]...)
+			break
+		}
+	}
+}
+
+func TestRazerDevice_PowerSettings(t *testing.T) {
+	address := dbustest.StartBus(t)
+	path := razerDevicePath("PM2143H12345678")
+	mouse := startFakeRazerDaemon(t, address).addMouse(t, "PM2143H12345678", "Razer DeathAdder V2 Pro")
+
+	r := newRazerDevice(dbustest.Connect(t, address), nil, path, "PM2143H12345678", "Razer DeathAdder V2 Pro")
+	changes := make(chan protocol.DeviceState, 8)
+	r.SetOnStateChange(func(state protocol.DeviceState) { changes <- state })
+
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+	state := r.GetState()
+	if state.IdleTime == nil || *state.IdleTime != 300 {
+		t.Errorf("IdleTime = %v, want 300", state.IdleTime)
+	}
+	if state.LowBatteryThreshold == nil || *state.LowBatteryThreshold != 10 {
+		t.Errorf("LowBatteryThreshold = %v, want 10", state.LowBatteryThreshold)
+	}
+
+	settings := r.Settings()
+	idle, ok := FindSetting(settings, SettingIdleTime)
+	if !ok || idle.Value != 300 || idle.Validate(30) == nil {
+		t.Errorf("Idle time setting = %+v, want value 300 and 30s rejected", idle)
+	}
+	if _, ok := FindSetting(settings, SettingLowBatteryThreshold); !ok {
+		t.Errorf("Settings = %+v, want a low battery threshold", settings)
+	}
+
+	for len(changes) > 0 {
+		<-changes
+	}
+	if err := r.ApplySetting(SettingIdleTime, 600); err != nil {
+		t.Fatalf("ApplySetting(idle_time) failed: %v", err)
+	}
+	if err := r.ApplySetting(SettingLowBatteryThreshold, 15); err != nil {
+		t.Fatalf("ApplySetting(low_battery_threshold) failed: %v", err)
+	}
+	mouse.mu.Lock()
+	if mouse.idleTime != 600 || mouse.threshold != 15 {
+		t.Errorf("Daemon got idle time %d and threshold %d, want 600 and 15", mouse.idleTime, mouse.threshold)
+	}
+	mouse.mu.Unlock()
+	if state := receive(t, changes, "idle time change"); *state.IdleTime != 600 {
+		t.Errorf("IdleTime after ApplySetting = %d, want 600", *state.IdleTime)
+	}
+	if state := receive(t, changes, "threshold change"); *state.LowBatteryThreshold != 15 {
+		t.Errorf("LowBatteryThreshold after ApplySetting = %d, want 15", *state.LowBatteryThreshold)
+	}
+
+	// Without the daemon the settings cannot be changed
+	r.mu.Lock()
+	r.fallback = true
+	r.mu.Unlock()
+	if err := r.ApplySetting(SettingIdleTime, 300); err == nil {
+		t.Error("ApplySetting while reading sysfs should fail")
+	}
+}
+
+func (f *fakeRazerMouse) setBattery(battery float64, err *dbus.Error) {
+	f.mu.Lock()
+	f.battery, f.batteryErr = battery, err
+	f.mu.Unlock()
+}
+
+func TestRazerDevice_Asleep(t *testing.T) {
+	address := dbustest.StartBus(t)
+	path := razerDevicePath("PM2143H12345678")
+	mouse := startFakeRazerDaemon(t, address).addMouse(t, "PM2143H12345678", "Razer DeathAdder V2 Pro")
+	r := newRazerDevice(dbustest.Connect(t, address), nil, path, "PM2143H12345678", "Razer DeathAdder V2 Pro")
+
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+	if delay := r.nextPollDelay(); delay != pollInterval {
+		t.Errorf("Poll delay while awake = %v, want %v", delay, pollInterval)
+	}
+
+	// A sleeping mouse reads as 0%, or the daemon raises an error
+	for _, reading := range []struct {
+		battery float64
+		err     *dbus.Error
+	}{
+		{0, nil},
+		{0, dbus.NewError("org.freedesktop.DBus.Python.OSError", []interface{}{"timed out"})},
+	} {
+		mouse.setBattery(reading.battery, reading.err)
+		if err := r.updateState(); err != nil {
+			t.Fatalf("updateState while asleep = %v, want nil so no reconnect is attempted", err)
+		}
+		state := r.GetState()
+		if state.Connection == nil || *state.Connection != protocol.ConnectionAsleep || state.IsConnected {
+			t.Errorf("Connection = %v, IsConnected = %v, want asleep and not connected", state.Connection, state.IsConnected)
+		}
+		if state.Battery == nil || *state.Battery != 80 {
+			t.Errorf("Battery while asleep = %v, want the last reading of 80", state.Battery)
+		}
+	}
+
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, maxAsleepPollInterval, maxAsleepPollInterval}
+	for i, w := range want {
+		if delay := r.nextPollDelay(); delay != w {
+			t.Errorf("Poll delay %d while asleep = %v, want %v", i, delay, w)
+		}
+	}
+
+	mouse.setBattery(78, nil)
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState after waking failed: %v", err)
+	}
+	state := r.GetState()
+	if state.Connection != nil || !state.IsConnected || *state.Battery != 78 {
+		t.Errorf("State after waking = %s (connection %v), want connected at 78%%", state, state.Connection)
+	}
+	if delay := r.nextPollDelay(); delay != pollInterval {
+		t.Errorf("Poll delay after waking = %v, want %v", delay, pollInterval)
+	}
+
+	// The device disappearing from the daemon is not sleep
+	mouse.setBattery(0, dbus.NewError("org.freedesktop.DBus.Error.UnknownObject", []interface{}{"no such device"}))
+	r.updateState()
+	if state := r.GetState(); state.Connection != nil && *state.Connection == protocol.ConnectionAsleep {
+		t.Error("An unknown object error should not be treated as sleep")
+	}
+}
+
+func TestIsAsleepError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error // Errors from the bus are dbus.Error values
+		want bool
+	}{
+		{"receiver timed out", *dbus.NewError("org.freedesktop.DBus.Python.OSError", []interface{}{"timed out"}), true},
+		{"timeout errno", *dbus.NewError("org.freedesktop.DBus.Python.OSError", []interface{}{"OSError: [Errno 110] Connection timed out"}), true},
+		{"driver I/O error", *dbus.NewError("org.freedesktop.DBus.Python.OSError", []interface{}{"OSError: [Errno 5] Input/output error"}), false},
+		{"daemon exception", *dbus.NewError("org.freedesktop.DBus.Python.KeyError", []interface{}{"KeyError: 'charge_level'"}), false},
+		{"unknown object", *dbus.NewError("org.freedesktop.DBus.Error.UnknownObject", []interface{}{"no such device"}), false},
+		{"not a D-Bus error", errors.New("timed out"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAsleepError(tt.err); got != tt.want {
+				t.Errorf("isAsleepError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRazerDevice_EmptyBattery(t *testing.T) {
+	r := newRazerDevice(nil, nil, "/org/razer/device/XYZ", "XYZ", "Razer Mouse")
+
+	// Dropping from a few percent to zero is a battery running out
+	r.applyReading(3, false, nil)
+	if r.isAsleepReading(0, false) {
+		t.Error("0% after 3% should be an empty battery, not sleep")
+	}
+	r.applyReading(60, false, nil)
+	if !r.isAsleepReading(0, false) {
+		t.Error("0% after 60% should be sleep")
+	}
+	if r.isAsleepReading(0, true) {
+		t.Error("A charging mouse is not asleep")
+	}
+}
+
+func TestRazerDevice_CallbackReadsSettings(t *testing.T) {
+	r := newRazerDevice(nil, nil, "/org/razer/device/XYZ", "XYZ", "Razer Mouse")
+
+	// The tray reads the settings from the state change callback
+	var calls int
+	r.SetOnStateChange(func(protocol.DeviceState) {
+		r.Settings()
+		calls++
+	})
+
+	done := make(chan struct{})
+	go func() {
+		r.applyReading(60, false, nil)
+		r.markAsleep()
+		r.applyReading(58, false, nil)
+		r.markDisconnected()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Calling Settings from the state change callback deadlocked")
+	}
+	if calls != 4 {
+		t.Errorf("Callback ran %d times, want 4", calls)
+	}
+}
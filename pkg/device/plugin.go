@@ -0,0 +1,636 @@
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// PluginProtocolVersion is the version of the JSON-lines protocol spoken
+// with plugins, passed to them in the GOARCTIS_PLUGIN_PROTOCOL environment
+// variable. See docs/plugins.md.
+const PluginProtocolVersion = 1
+
+// DeviceTypePlugin is the type of plugin devices that do not declare one
+const DeviceTypePlugin DeviceType = "plugin"
+
+const (
+	// pluginReplyTimeout bounds how long a plugin may take to answer a
+	// setting change
+	pluginReplyTimeout = 5 * time.Second
+
+	// pluginStopTimeout is how long a plugin has to exit after its stdin is
+	// closed before it is killed
+	pluginStopTimeout = 2 * time.Second
+
+	// maxPluginLine bounds a single message from a plugin
+	maxPluginLine = 1 << 20
+)
+
+// Message types of the plugin protocol
+const (
+	pluginMsgDevice   = "device"   // Plugin announces its device; must come first
+	pluginMsgState    = "state"    // Plugin reports the device's state
+	pluginMsgSettings = "settings" // Plugin reports the device's settings
+	pluginMsgResult   = "result"   // Plugin answers a set request
+	pluginMsgSet      = "set"      // goarctis asks the plugin to change a setting
+)
+
+// pluginMessage is a line written by a plugin
+type pluginMessage struct {
+	Type       string                `json:"type"`
+	ID         string                `json:"id"`
+	Name       string                `json:"name"`
+	DeviceType string                `json:"device_type"`
+	State      *protocol.DeviceState `json:"state"`
+	Settings   []Setting             `json:"settings"`
+	Request    int                   `json:"request"`
+	Error      string                `json:"error"`
+}
+
+// pluginRequest is a line written to a plugin
+type pluginRequest struct {
+	Type    string `json:"type"`
+	Request int    `json:"request"`
+	Setting string `json:"setting"`
+	Value   any    `json:"value"`
+}
+
+// pluginProcess is one run of a plugin executable
+type pluginProcess struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	messages chan pluginMessage // Closed once the plugin has exited
+	err      error              // Exit error, set before messages is closed
+
+	mu          sync.Mutex
+	nextRequest int
+	pending     map[int]chan error
+	queue       []pluginMessage // Read but not yet passed to messages
+	exited      bool            // The plugin exited and pending requests were answered
+	wake        chan struct{}   // Tells forward that queue or exited changed
+}
+
+// startPluginProcess runs a plugin and starts reading its messages. Lines
+// the plugin writes to stderr are logged.
+func startPluginProcess(path string) (*pluginProcess, error) {
+	cmd := exec.Command(path)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GOARCTIS_PLUGIN_PROTOCOL=%d", PluginProtocolVersion))
+	cmd.Stderr = &lineLogger{prefix: filepath.Base(path)}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start plugin %s: %w", path, err)
+	}
+
+	p := &pluginProcess{
+		cmd:      cmd,
+		stdin:    stdin,
+		messages: make(chan pluginMessage, 16),
+		pending:  make(map[int]chan error),
+		wake:     make(chan struct{}, 1),
+	}
+	go p.read(stdout)
+	go p.forward()
+	return p, nil
+}
+
+// read decodes the plugin's output until it exits. Replies to set requests
+// are handed to the waiting caller right away; every other message is queued
+// for messages, so a reply is never stuck behind messages nobody reads yet.
+func (p *pluginProcess) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 4096), maxPluginLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var msg pluginMessage
+		if err := json.Unmarshal(line, &msg); err != nil {
+			log.Printf("⚠️ Ignoring invalid message from plugin %s: %v", filepath.Base(p.cmd.Path), err)
+			continue
+		}
+		if msg.Type == pluginMsgResult {
+			p.resolve(msg.Request, msg.Error)
+			continue
+		}
+		p.mu.Lock()
+		p.queue = append(p.queue, msg)
+		p.mu.Unlock()
+		p.signal()
+	}
+	// Stop reading so a plugin writing too much cannot block on a full pipe
+	io.Copy(io.Discard, stdout)
+
+	p.err = p.cmd.Wait()
+	if p.err == nil {
+		p.err = errors.New("plugin exited")
+	}
+	p.mu.Lock()
+	p.exited = true
+	for id, reply := range p.pending {
+		reply <- p.err
+		delete(p.pending, id)
+	}
+	p.mu.Unlock()
+	p.signal()
+}
+
+// forward passes queued messages on to messages, and closes it once the
+// plugin has exited and every message was passed on
+func (p *pluginProcess) forward() {
+	for {
+		p.mu.Lock()
+		queue := p.queue
+		p.queue = nil
+		exited := p.exited
+		p.mu.Unlock()
+
+		for _, msg := range queue {
+			p.messages <- msg
+		}
+		if len(queue) > 0 {
+			continue
+		}
+		if exited {
+			close(p.messages)
+			return
+		}
+		<-p.wake
+	}
+}
+
+// signal wakes forward without blocking
+func (p *pluginProcess) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// resolve passes the answer to a set request to its caller
+func (p *pluginProcess) resolve(request int, errText string) {
+	p.mu.Lock()
+	reply, ok := p.pending[request]
+	delete(p.pending, request)
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	if errText != "" {
+		reply <- errors.New(errText)
+	} else {
+		reply <- nil
+	}
+}
+
+// set asks the plugin to change a setting and waits for its answer
+func (p *pluginProcess) set(settingID string, value any) error {
+	reply := make(chan error, 1)
+	p.mu.Lock()
+	if p.exited {
+		// Nothing would answer the request
+		p.mu.Unlock()
+		return fmt.Errorf("plugin is not running: %w", p.err)
+	}
+	p.nextRequest++
+	request := p.nextRequest
+	p.pending[request] = reply
+	line, err := json.Marshal(pluginRequest{Type: pluginMsgSet, Request: request, Setting: settingID, Value: value})
+	if err == nil {
+		_, err = p.stdin.Write(append(line, '\n'))
+	}
+	if err != nil {
+		delete(p.pending, request)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send request to plugin: %w", err)
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-time.After(pluginReplyTimeout):
+		p.mu.Lock()
+		delete(p.pending, request)
+		p.mu.Unlock()
+		return fmt.Errorf("plugin did not answer within %s", pluginReplyTimeout)
+	}
+}
+
+// stop closes the plugin's stdin, which asks it to exit, and kills it if it
+// does not
+func (p *pluginProcess) stop() {
+	p.stdin.Close()
+	timer := time.NewTimer(pluginStopTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case _, ok := <-p.messages:
+			if !ok {
+				return
+			}
+		case <-timer.C:
+			p.cmd.Process.Kill()
+			for range p.messages {
+			}
+			return
+		}
+	}
+}
+
+// announcement waits for the plugin to announce its device
+func (p *pluginProcess) announcement(ctx context.Context) (pluginMessage, error) {
+	select {
+	case msg, ok := <-p.messages:
+		if !ok {
+			return pluginMessage{}, fmt.Errorf("plugin exited before announcing a device: %w", p.err)
+		}
+		if msg.Type != pluginMsgDevice || msg.ID == "" {
+			return pluginMessage{}, fmt.Errorf("plugin sent %q before announcing a device with an id", msg.Type)
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return pluginMessage{}, fmt.Errorf("plugin did not announce a device: %w", ctx.Err())
+	}
+}
+
+// PluginDevice is a device provided by an out-of-process plugin: an
+// executable that speaks JSON lines over stdin and stdout. Each run of Run
+// starts the plugin, so a Supervisor restarts it when it crashes.
+type PluginDevice struct {
+	path       string
+	id         string
+	name       string
+	deviceType DeviceType
+	state      protocol.DeviceState
+	settings   []Setting
+	proc       *pluginProcess // Running plugin, nil between runs
+	stopChan   chan struct{}
+	mu         sync.RWMutex
+	onChange   func(protocol.DeviceState)
+}
+
+// DiscoverPlugins starts every executable in dir and waits for each to
+// announce its device. Plugins that fail to start or announce are skipped.
+func DiscoverPlugins(ctx context.Context, dir string) ([]*PluginDevice, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plugins: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(paths)
+
+	var (
+		mu      sync.Mutex
+		devices []*PluginDevice
+		wg      sync.WaitGroup
+	)
+	for _, path := range paths {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			device, err := launchPlugin(ctx, path)
+			if err != nil {
+				log.Printf("⚠️ Skipping plugin %s: %v", filepath.Base(path), err)
+				return
+			}
+			mu.Lock()
+			devices = append(devices, device)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no plugins in %s", dir)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].path < devices[j].path })
+	return devices, nil
+}
+
+// launchPlugin starts a plugin and builds its device from the announcement.
+// The plugin keeps running for the first Run.
+func launchPlugin(ctx context.Context, path string) (*PluginDevice, error) {
+	proc, err := startPluginProcess(path)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := proc.announcement(ctx)
+	if err != nil {
+		proc.stop()
+		return nil, err
+	}
+
+	deviceType := DeviceType(msg.DeviceType)
+	if deviceType == "" {
+		deviceType = DeviceTypePlugin
+	}
+	name := msg.Name
+	if name == "" {
+		name = msg.ID
+	}
+	p := &PluginDevice{
+		path:       path,
+		id:         msg.ID,
+		name:       name,
+		deviceType: deviceType,
+		state: protocol.DeviceState{
+			DeviceID:   msg.ID,
+			DeviceType: string(deviceType),
+		},
+		settings: normalizeSettings(msg.Settings),
+		proc:     proc,
+		stopChan: make(chan struct{}),
+	}
+	log.Printf("🔌 Plugin %s provides %s", filepath.Base(path), name)
+	return p, nil
+}
+
+// GetID returns the ID the plugin announced
+func (p *PluginDevice) GetID() string {
+	return p.id
+}
+
+// GetName returns the name the plugin announced
+func (p *PluginDevice) GetName() string {
+	return p.name
+}
+
+// GetType returns the type the plugin announced, or DeviceTypePlugin
+func (p *PluginDevice) GetType() DeviceType {
+	return p.deviceType
+}
+
+// GetState returns the state last reported by the plugin
+func (p *PluginDevice) GetState() protocol.DeviceState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.state
+}
+
+// IsConnected returns whether the plugin reports the device as connected
+func (p *PluginDevice) IsConnected() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.state.IsConnected
+}
+
+// SetOnStateChange sets the callback for state changes
+func (p *PluginDevice) SetOnStateChange(callback func(protocol.DeviceState)) {
+	p.mu.Lock()
+	p.onChange = callback
+	p.mu.Unlock()
+}
+
+// Start runs the plugin without supervision
+func (p *PluginDevice) Start() error {
+	log.Printf("Starting plugin %s", p.name)
+	go func() {
+		if err := p.runLoop(nil); err != nil {
+			log.Printf("Plugin %s stopped: %v", p.name, err)
+		}
+	}()
+	return nil
+}
+
+// Run runs the plugin until ctx is cancelled or the plugin exits, so that a
+// Supervisor can restart it
+func (p *PluginDevice) Run(ctx context.Context) error {
+	log.Printf("Starting supervised plugin %s", p.name)
+	return p.runLoop(ctx.Done())
+}
+
+// Stop stops the plugin
+func (p *PluginDevice) Stop() error {
+	select {
+	case <-p.stopChan:
+		// Already closed
+	default:
+		close(p.stopChan)
+	}
+	return nil
+}
+
+// Close stops the plugin, including one left running by discovery
+func (p *PluginDevice) Close() error {
+	p.Stop()
+	p.mu.Lock()
+	proc := p.proc
+	p.proc = nil
+	p.mu.Unlock()
+	if proc != nil {
+		proc.stop()
+	}
+	return nil
+}
+
+// runLoop handles the plugin's messages until done, Stop or the plugin
+// exits. The plugin is started again if its previous run has ended.
+func (p *PluginDevice) runLoop(done <-chan struct{}) error {
+	proc, err := p.process(done)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		p.mu.Lock()
+		if p.proc == proc {
+			p.proc = nil
+		}
+		p.mu.Unlock()
+		proc.stop()
+	}()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return nil
+		case <-done:
+			return nil
+		case msg, ok := <-proc.messages:
+			if !ok {
+				p.markDisconnected()
+				return fmt.Errorf("plugin %s exited: %w", filepath.Base(p.path), proc.err)
+			}
+			p.handle(msg)
+		}
+	}
+}
+
+// process returns the running plugin, starting it again if needed. A
+// restarted plugin must announce the same device.
+func (p *PluginDevice) process(done <-chan struct{}) (*pluginProcess, error) {
+	p.mu.RLock()
+	proc := p.proc
+	p.mu.RUnlock()
+	if proc != nil {
+		return proc, nil
+	}
+
+	proc, err := startPluginProcess(p.path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultDiscoveryTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-done:
+		case <-p.stopChan:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	msg, err := proc.announcement(ctx)
+	if err == nil && msg.ID != p.id {
+		err = fmt.Errorf("plugin now announces %q instead of %q", msg.ID, p.id)
+	}
+	if err != nil {
+		proc.stop()
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.proc = proc
+	if msg.Settings != nil {
+		p.settings = normalizeSettings(msg.Settings)
+	}
+	p.mu.Unlock()
+	return proc, nil
+}
+
+// handle applies one message from the plugin
+func (p *PluginDevice) handle(msg pluginMessage) {
+	switch msg.Type {
+	case pluginMsgState:
+		if msg.State == nil {
+			log.Printf("⚠️ Plugin %s sent a state message without a state", p.name)
+			return
+		}
+		state := *msg.State
+		state.DeviceID = p.id
+		state.DeviceType = string(p.deviceType)
+		if state.LastSeen.IsZero() {
+			state.LastSeen = time.Now()
+		}
+		p.update(state)
+
+	case pluginMsgSettings:
+		p.mu.Lock()
+		p.settings = normalizeSettings(msg.Settings)
+		p.mu.Unlock()
+
+	case pluginMsgDevice:
+		log.Printf("⚠️ Plugin %s announced another device; each plugin provides one", p.name)
+
+	default:
+		// Newer plugins may send messages this version does not know
+		log.Printf("Ignoring %q message from plugin %s", msg.Type, p.name)
+	}
+}
+
+// update stores a state and reports it
+func (p *PluginDevice) update(state protocol.DeviceState) {
+	p.mu.Lock()
+	p.state = state
+	callback := p.onChange
+	p.mu.Unlock()
+
+	if callback != nil {
+		callback(state)
+	}
+}
+
+// markDisconnected reports the device as disconnected once its plugin exits
+func (p *PluginDevice) markDisconnected() {
+	p.mu.RLock()
+	state := p.state
+	p.mu.RUnlock()
+	if !state.IsConnected {
+		return
+	}
+	state.IsConnected = false
+	p.update(state)
+}
+
+// Settings returns the settings last reported by the plugin
+func (p *PluginDevice) Settings() []Setting {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]Setting(nil), p.settings...)
+}
+
+// ApplySetting asks the plugin to change a setting. The plugin reports the
+// new value with its next settings or state message.
+func (p *PluginDevice) ApplySetting(id string, value any) error {
+	p.mu.RLock()
+	proc := p.proc
+	p.mu.RUnlock()
+	if proc == nil {
+		return fmt.Errorf("plugin %s is not running", p.name)
+	}
+	return proc.set(id, value)
+}
+
+// normalizeSettings converts values decoded from JSON to the types Validate
+// expects: JSON numbers arrive as float64, range settings take an int
+func normalizeSettings(settings []Setting) []Setting {
+	for i, setting := range settings {
+		if v, ok := setting.Value.(float64); ok && setting.Kind == SettingRange && v == math.Trunc(v) {
+			settings[i].Value = int(v)
+		}
+	}
+	return settings
+}
+
+// lineLogger logs each line written to it, e.g. a plugin's stderr
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(data []byte) (int, error) {
+	l.buf = append(l.buf, data...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimSpace(l.buf[:i]); len(line) > 0 {
+			log.Printf("🔌 %s: %s", l.prefix, line)
+		}
+		l.buf = l.buf[i+1:]
+	}
+	return len(data), nil
+}
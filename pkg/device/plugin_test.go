@@ -0,0 +1,199 @@
+package device
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// testPlugin announces a UPS with one setting, reports its battery and
+// answers set requests, rejecting the value "bad". It exits when asked to
+// "crash" the mode.
+const testPlugin = `#!/bin/sh
+echo '{"type":"device","id":"test_ups","name":"Test UPS","device_type":"ups","settings":[{"id":"mode","name":"Mode","kind":"enum","options":["eco","full","bad","crash"],"value":"eco"}]}'
+echo '{"type":"state","state":{"schema_version":1,"is_connected":true,"battery":42}}'
+echo "protocol $GOARCTIS_PLUGIN_PROTOCOL" >&2
+while read -r line; do
+	req=$(echo "$line" | sed 's/.*"request":\([0-9]*\).*/\1/')
+	case "$line" in
+	*'"value":"bad"'*) echo "{\"type\":\"result\",\"request\":$req,\"error\":\"mode not supported\"}" ;;
+	*'"value":"crash"'*) exit 3 ;;
+	*) echo "{\"type\":\"result\",\"request\":$req}"
+	   echo '{"type":"state","state":{"schema_version":1,"is_connected":true,"battery":43}}' ;;
+	esac
+done
+`
+
+func writePlugin(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverPlugins(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "ups", testPlugin)
+	writePlugin(t, dir, "silent", "#!/bin/sh\nexit 0\n")
+	writePlugin(t, dir, "chatty", "#!/bin/sh\necho '{\"type\":\"state\"}'\n")
+	if err := os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	plugins, err := DiscoverPlugins(ctx, dir)
+	if err != nil {
+		t.Fatalf("DiscoverPlugins failed: %v", err)
+	}
+	if len(plugins) != 1 {
+		t.Fatalf("Found %d plugins, want only the one announcing a device", len(plugins))
+	}
+	p := plugins[0]
+	defer p.Close()
+	if p.GetID() != "test_ups" || p.GetName() != "Test UPS" || p.GetType() != "ups" {
+		t.Errorf("Plugin device = %s %q (%s), want test_ups \"Test UPS\" (ups)", p.GetID(), p.GetName(), p.GetType())
+	}
+	if setting, ok := FindSetting(p.Settings(), "mode"); !ok || setting.Value != "eco" {
+		t.Errorf("Settings = %v, want mode = eco", p.Settings())
+	}
+
+	if _, err := DiscoverPlugins(ctx, filepath.Join(dir, "missing")); err == nil {
+		t.Error("Expected an error for a missing plugin directory")
+	}
+}
+
+func TestPluginDevice_Run(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "ups", testPlugin)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	plugins, err := DiscoverPlugins(ctx, dir)
+	if err != nil {
+		t.Fatalf("DiscoverPlugins failed: %v", err)
+	}
+	p := plugins[0]
+	defer p.Close()
+
+	states := make(chan protocol.DeviceState, 10)
+	p.SetOnStateChange(func(state protocol.DeviceState) { states <- state })
+	result := make(chan error, 1)
+	go func() { result <- p.Run(ctx) }()
+
+	state := receive(t, states, "first state")
+	if state.Battery == nil || *state.Battery != 42 || state.DeviceID != "test_ups" || state.DeviceType != "ups" {
+		t.Errorf("State = %v, want test_ups at 42%%", state)
+	}
+	if state.LastSeen.IsZero() {
+		t.Error("LastSeen should be set when the plugin does not report it")
+	}
+
+	// Settings are passed to the plugin, which answers each request
+	if err := p.ApplySetting("mode", "full"); err != nil {
+		t.Errorf("ApplySetting(full) = %v, want nil", err)
+	}
+	if state := receive(t, states, "state after the change"); *state.Battery != 43 {
+		t.Errorf("Battery = %d, want 43", *state.Battery)
+	}
+	if err := p.ApplySetting("mode", "bad"); err == nil || !strings.Contains(err.Error(), "mode not supported") {
+		t.Errorf("ApplySetting(bad) = %v, want the plugin's error", err)
+	}
+
+	// A crash ends the run with an error and disconnects the device
+	if err := p.ApplySetting("mode", "crash"); err == nil {
+		t.Error("ApplySetting should fail when the plugin exits")
+	}
+	if err := receive(t, result, "end of run"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Run = %v, want the plugin's exit status", err)
+	}
+	if state := receive(t, states, "disconnected state"); state.IsConnected {
+		t.Error("Device should be disconnected after the plugin exits")
+	}
+
+	// The next run starts the plugin again
+	runCtx, stop := context.WithCancel(ctx)
+	go func() { result <- p.Run(runCtx) }()
+	if state := receive(t, states, "state after restart"); !state.IsConnected || *state.Battery != 42 {
+		t.Errorf("State after restart = %v, want connected at 42%%", state)
+	}
+	stop()
+	if err := receive(t, result, "end of second run"); err != nil {
+		t.Errorf("Run after cancel = %v, want nil", err)
+	}
+}
+
+func TestPluginProcess_ResultBehindMessages(t *testing.T) {
+	// Answers each request after more state messages than messages buffers
+	dir := t.TempDir()
+	writePlugin(t, dir, "busy", `#!/bin/sh
+while read -r line; do
+	i=0
+	while [ $i -lt 40 ]; do
+		echo '{"type":"state","state":{"schema_version":1,"battery":50}}'
+		i=$((i+1))
+	done
+	echo '{"type":"result","request":1}'
+done
+`)
+	proc, err := startPluginProcess(filepath.Join(dir, "busy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.stop()
+
+	// Nothing reads messages yet, as before Run
+	start := time.Now()
+	if err := proc.set("mode", "eco"); err != nil {
+		t.Fatalf("set = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed > pluginReplyTimeout/2 {
+		t.Errorf("set took %s, the reply was stuck behind unread messages", elapsed)
+	}
+
+	for i := 0; i < 40; i++ {
+		receive(t, proc.messages, "queued state")
+	}
+}
+
+func TestPluginProcess_SetAfterExit(t *testing.T) {
+	dir := t.TempDir()
+	writePlugin(t, dir, "gone", "#!/bin/sh\nexit 2\n")
+	proc, err := startPluginProcess(filepath.Join(dir, "gone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range proc.messages {
+	}
+
+	start := time.Now()
+	if err := proc.set("mode", "eco"); err == nil || !strings.Contains(err.Error(), "exit status 2") {
+		t.Errorf("set = %v, want the exit status", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("set took %s after the plugin exited", elapsed)
+	}
+}
+
+func TestNormalizeSettings(t *testing.T) {
+	settings := normalizeSettings([]Setting{
+		{ID: "level", Kind: SettingRange, Min: 0, Max: 10, Value: float64(4)},
+		{ID: "mode", Kind: SettingEnum, Options: []string{"a"}, Value: "a"},
+		{ID: "unknown", Kind: SettingRange, Min: 0, Max: 10},
+	})
+	for _, setting := range settings {
+		if setting.Value != nil {
+			if err := setting.Validate(setting.Value); err != nil {
+				t.Errorf("%s: %v", setting.ID, err)
+			}
+		}
+	}
+	if settings[0].Value != 4 {
+		t.Errorf("Range value = %#v, want int 4", settings[0].Value)
+	}
+}
@@ -0,0 +1,333 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// razerIdentityScanInterval is how often the daemon's device list is checked
+// for another identity of a mouse, e.g. the wired device once a cable is
+// plugged in
+const razerIdentityScanInterval = 30 * time.Second
+
+// razerIdentityWindow is how close together one identity of a mouse must
+// disappear and another appear for them to count as a mode switch
+const razerIdentityWindow = 2 * razerIdentityScanInterval
+
+// razerModeSuffixes maps the suffixes OpenRazer appends to the names of
+// dual-mode devices to the connection mode they stand for
+var razerModeSuffixes = map[string]protocol.ConnectionMode{
+	"(Wired)":    protocol.ModeWired,
+	"(Wireless)": protocol.ModeWireless,
+	"(Receiver)": protocol.ModeWireless,
+	"(Dock)":     protocol.ModeDock,
+}
+
+// razerIdentity is one device the OpenRazer daemon enumerates. A dual-mode
+// mouse has one identity per connection mode, each with its own product ID
+// and sometimes its own serial.
+type razerIdentity struct {
+	path   dbus.ObjectPath
+	serial string
+	name   string                  // As reported, e.g. "Razer DeathAdder V2 Pro (Wired)"
+	model  string                  // name without the mode suffix
+	mode   protocol.ConnectionMode // ModeUnknown when the name has no suffix
+}
+
+// newRazerIdentity derives the model and connection mode from the name
+func newRazerIdentity(path dbus.ObjectPath, serial, name string) razerIdentity {
+	identity := razerIdentity{path: path, serial: serial, name: name, model: name}
+	for suffix, mode := range razerModeSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			identity.model = strings.TrimSpace(strings.TrimSuffix(name, suffix))
+			identity.mode = mode
+			break
+		}
+	}
+	return identity
+}
+
+// razerDevicePath returns the daemon's object path for a serial
+func razerDevicePath(serial string) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("/org/razer/device/%s", serial))
+}
+
+// lookupRazerIdentity asks the daemon for a device's name, falling back to
+// the serial
+func lookupRazerIdentity(ctx context.Context, conn *dbus.Conn, serial string) razerIdentity {
+	path := razerDevicePath(serial)
+	name := fmt.Sprintf("Razer Device (%s)", serial)
+	var reported string
+	err := callDBus(ctx, conn.Object(razerService, path), razerDeviceIface+".getDeviceName").Store(&reported)
+	if err == nil && reported != "" {
+		name = reported
+	}
+	return newRazerIdentity(path, serial, name)
+}
+
+// razerReading is the battery level and charging status read from one identity
+type razerReading struct {
+	battery  int
+	charging bool
+}
+
+// readRazerIdentity reads the battery level and charging status of an identity
+func readRazerIdentity(ctx context.Context, conn *dbus.Conn, path dbus.ObjectPath) (razerReading, error) {
+	obj := conn.Object(razerService, path)
+	var level float64
+	if err := callDBus(ctx, obj, razerPowerIface+".getBattery").Store(&level); err != nil {
+		return razerReading{}, err
+	}
+	var charging bool
+	if err := callDBus(ctx, obj, razerPowerIface+".isCharging").Store(&charging); err != nil {
+		return razerReading{}, err
+	}
+	return razerReading{battery: int(level), charging: charging}, nil
+}
+
+// matches reports whether two readings could come from one mouse. A
+// sleeping mouse reads 0% over its receiver, so that never matches.
+func (r razerReading) matches(other razerReading) bool {
+	return r.battery > 0 && r == other
+}
+
+// otherMode reports whether candidate has another serial than identities
+// but is the same model in a connection mode none of them has
+func otherMode(identities []razerIdentity, candidate razerIdentity) bool {
+	if candidate.mode == protocol.ModeUnknown {
+		return false
+	}
+	modelMatch := false
+	for _, known := range identities {
+		if known.serial == candidate.serial || known.mode == candidate.mode {
+			return false
+		}
+		if known.model == candidate.model && known.mode != protocol.ModeUnknown {
+			modelMatch = true
+		}
+	}
+	return modelMatch
+}
+
+// sameMouse reports whether candidate is another identity of the mouse
+// known by identities. An identity with the same serial always is. One with
+// another serial must be the same model in a connection mode none of them
+// has, and either report the same reading as this mouse, as a mouse on its
+// cable does while the receiver stays plugged in, or have appeared within
+// razerIdentityWindow of an identity of this mouse disappearing; appeared
+// and lost are zero when unknown. Otherwise two identical mice could be
+// mistaken for one.
+func sameMouse(identities []razerIdentity, candidate razerIdentity, appeared, lost time.Time, sameReading bool) bool {
+	for _, known := range identities {
+		if known.serial == candidate.serial {
+			return true
+		}
+	}
+	if !otherMode(identities, candidate) {
+		return false
+	}
+	if sameReading {
+		return true
+	}
+
+	if appeared.IsZero() || lost.IsZero() {
+		return false
+	}
+	gap := appeared.Sub(lost)
+	if gap < 0 {
+		gap = -gap
+	}
+	return gap <= razerIdentityWindow
+}
+
+// groupRazerIdentities merges the identities of each mouse, keeping the
+// order in which each mouse was first seen. Without knowing when they
+// appeared, identities with different serials are only merged when
+// readings, keyed by path, holds matching readings for them.
+func groupRazerIdentities(identities []razerIdentity, readings map[dbus.ObjectPath]razerReading) [][]razerIdentity {
+	var groups [][]razerIdentity
+	for _, identity := range identities {
+		merged := false
+		for i, group := range groups {
+			if sameMouse(group, identity, time.Time{}, time.Time{}, sameReading(readings, group, identity)) {
+				groups[i] = append(group, identity)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			groups = append(groups, []razerIdentity{identity})
+		}
+	}
+	for _, group := range groups {
+		sortByPreference(group)
+	}
+	return groups
+}
+
+// sameReading reports whether candidate reads the same as any identity of group
+func sameReading(readings map[dbus.ObjectPath]razerReading, group []razerIdentity, candidate razerIdentity) bool {
+	reading, ok := readings[candidate.path]
+	if !ok {
+		return false
+	}
+	for _, known := range group {
+		if other, ok := readings[known.path]; ok && reading.matches(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortByPreference orders identities by the mode to read from: a cable or
+// dock reports charging reliably and never sleeps, so it beats the receiver
+func sortByPreference(identities []razerIdentity) {
+	rank := map[protocol.ConnectionMode]int{
+		protocol.ModeWired:    0,
+		protocol.ModeDock:     1,
+		protocol.ModeWireless: 2,
+		protocol.ModeUnknown:  3,
+	}
+	sort.SliceStable(identities, func(i, j int) bool {
+		return rank[identities[i].mode] < rank[identities[j].mode]
+	})
+}
+
+// isIdentityGone reports whether err means the daemon no longer has the
+// device being read, e.g. because the cable was unplugged
+func isIdentityGone(err error) bool {
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) {
+		return false
+	}
+	switch dbusErr.Name {
+	case "org.freedesktop.DBus.Error.UnknownObject",
+		"org.freedesktop.DBus.Error.UnknownInterface",
+		"org.freedesktop.DBus.Error.UnknownMethod":
+		return true
+	default:
+		return false
+	}
+}
+
+// findIdentity looks through the daemon's devices for the best identity of
+// this mouse that answers and reads from it from now on. Names are looked up
+// again each time, since the daemon may reuse a serial's path for the other
+// mode. It returns whether the identity changed.
+func (r *RazerDevice) findIdentity(ctx context.Context) bool {
+	r.mu.Lock()
+	conn := r.conn
+	current := r.identity
+	known := append([]razerIdentity(nil), r.identities...)
+	r.lastScan = time.Now()
+	r.mu.Unlock()
+
+	if conn == nil {
+		return false
+	}
+	serials, err := listRazerDevices(ctx, conn)
+	if err != nil {
+		return false
+	}
+	appeared, lost := r.trackSerials(serials, time.Now())
+
+	currentReading := sync.OnceValue(func() razerReading {
+		reading, _ := readRazerIdentity(ctx, conn, current.path)
+		return reading
+	})
+	var candidates []razerIdentity
+	for _, serial := range serials {
+		identity := lookupRazerIdentity(ctx, conn, serial)
+
+		// A cable plugged in while the receiver keeps answering shows up as
+		// a second identity reporting the same reading
+		matching := false
+		if otherMode(known, identity) {
+			reading, err := readRazerIdentity(ctx, conn, identity.path)
+			matching = err == nil && reading.matches(currentReading())
+		}
+		if sameMouse(known, identity, appeared[serial], lost, matching) {
+			candidates = append(candidates, identity)
+		}
+	}
+	sortByPreference(candidates)
+
+	for _, candidate := range candidates {
+		if candidate == current {
+			return false
+		}
+		var battery float64
+		if err := callDBus(ctx, conn.Object(razerService, candidate.path), razerPowerIface+".getBattery").Store(&battery); err != nil {
+			continue
+		}
+		r.useIdentity(candidate)
+		return true
+	}
+	return false
+}
+
+// trackSerials records when each of the daemon's serials appeared and when
+// an identity of this mouse last disappeared, as of a scan at now. It returns
+// the appearance times and the last disappearance.
+func (r *RazerDevice) trackSerials(serials []string, now time.Time) (map[string]time.Time, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	appeared := make(map[string]time.Time, len(serials))
+	for _, serial := range serials {
+		at, ok := r.appeared[serial]
+		if !ok && r.appeared != nil {
+			at = now
+		}
+		appeared[serial] = at
+	}
+
+	for _, identity := range r.identities {
+		// Before the first scan, the identities found at discovery were present
+		_, wasPresent := r.appeared[identity.serial]
+		_, isPresent := appeared[identity.serial]
+		if (wasPresent || r.appeared == nil) && !isPresent {
+			r.lostAt = now
+		}
+	}
+	r.appeared = appeared
+	return appeared, r.lostAt
+}
+
+// useIdentity switches reading to identity and records its connection mode
+func (r *RazerDevice) useIdentity(identity razerIdentity) {
+	r.mu.Lock()
+	r.identity = identity
+	replaced := false
+	for i, known := range r.identities {
+		if known.path == identity.path {
+			r.identities[i] = identity
+			replaced = true
+		}
+	}
+	if !replaced {
+		r.identities = append(r.identities, identity)
+	}
+	r.sysfsDir = ""
+	r.powerRead = false
+	r.mu.Unlock()
+
+	log.Printf("🔌 Razer %s is now %s (%s)", r.deviceName, strings.ToLower(identity.mode.String()), identity.name)
+}
+
+// identityScanDue reports whether the daemon's devices should be checked
+// for a better identity
+func (r *RazerDevice) identityScanDue() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.conn != nil && !r.fallback && time.Since(r.lastScan) >= razerIdentityScanInterval
+}
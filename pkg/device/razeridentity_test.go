@@ -0,0 +1,277 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/internal/dbustest"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+func TestNewRazerIdentity(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		mode  protocol.ConnectionMode
+	}{
+		{"Razer DeathAdder V2 Pro (Wired)", "Razer DeathAdder V2 Pro", protocol.ModeWired},
+		{"Razer DeathAdder V2 Pro (Wireless)", "Razer DeathAdder V2 Pro", protocol.ModeWireless},
+		{"Razer Basilisk Ultimate (Receiver)", "Razer Basilisk Ultimate", protocol.ModeWireless},
+		{"Razer Naga Pro (Dock)", "Razer Naga Pro", protocol.ModeDock},
+		{"Razer Viper Mini", "Razer Viper Mini", protocol.ModeUnknown},
+	}
+
+	for _, tt := range tests {
+		identity := newRazerIdentity(razerDevicePath("X"), "X", tt.name)
+		if identity.model != tt.model || identity.mode != tt.mode {
+			t.Errorf("newRazerIdentity(%q) = model %q, mode %v; want %q, %v", tt.name, identity.model, identity.mode, tt.model, tt.mode)
+		}
+	}
+}
+
+func TestGroupRazerIdentities(t *testing.T) {
+	identity := func(serial, name string) razerIdentity {
+		return newRazerIdentity(razerDevicePath(serial), serial, name)
+	}
+
+	tests := []struct {
+		name       string
+		identities []razerIdentity
+		want       [][]string // Serials of each group, preferred first
+	}{
+		{
+			"wired and wireless with one serial",
+			[]razerIdentity{identity("PM1", "Razer DeathAdder V2 Pro (Wireless)"), newRazerIdentity(razerDevicePath("PM1-wired"), "PM1", "Razer DeathAdder V2 Pro (Wired)")},
+			[][]string{{"PM1", "PM1"}},
+		},
+		{
+			// Without a mode switch to go by, these may be two mice
+			"wired and wireless with different serials",
+			[]razerIdentity{identity("RX1", "Razer DeathAdder V2 Pro (Wireless)"), identity("PM1", "Razer DeathAdder V2 Pro (Wired)")},
+			[][]string{{"RX1"}, {"PM1"}},
+		},
+		{
+			"two identical wireless mice",
+			[]razerIdentity{identity("A", "Razer DeathAdder V2 Pro (Wireless)"), identity("B", "Razer DeathAdder V2 Pro (Wireless)")},
+			[][]string{{"A"}, {"B"}},
+		},
+		{
+			"same model without a mode",
+			[]razerIdentity{identity("A", "Razer Viper Mini"), identity("B", "Razer Viper Mini")},
+			[][]string{{"A"}, {"B"}},
+		},
+		{
+			"different models",
+			[]razerIdentity{identity("A", "Razer Naga Pro (Wireless)"), identity("B", "Razer DeathAdder V2 Pro (Wired)")},
+			[][]string{{"A"}, {"B"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := groupRazerIdentities(tt.identities, nil)
+			if len(groups) != len(tt.want) {
+				t.Fatalf("Got %d groups, want %d", len(groups), len(tt.want))
+			}
+			for i, group := range groups {
+				if len(group) != len(tt.want[i]) {
+					t.Fatalf("Group %d has %d identities, want %v", i, len(group), tt.want[i])
+				}
+				for j, identity := range group {
+					if identity.serial != tt.want[i][j] {
+						t.Errorf("Group %d identity %d = %s, want %s", i, j, identity.serial, tt.want[i][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGroupRazerIdentities_SameReading(t *testing.T) {
+	wireless := newRazerIdentity(razerDevicePath("RX1"), "RX1", "Razer DeathAdder V2 Pro (Wireless)")
+	wired := newRazerIdentity(razerDevicePath("PM1"), "PM1", "Razer DeathAdder V2 Pro (Wired)")
+	identities := []razerIdentity{wireless, wired}
+
+	// A mouse on its cable with the receiver still plugged in
+	groups := groupRazerIdentities(identities, map[dbus.ObjectPath]razerReading{
+		wireless.path: {battery: 80, charging: true},
+		wired.path:    {battery: 80, charging: true},
+	})
+	if len(groups) != 1 || groups[0][0].serial != "PM1" {
+		t.Errorf("Groups = %v, want one mouse read over the cable", groups)
+	}
+
+	// Two mice of one model, one of them charging
+	groups = groupRazerIdentities(identities, map[dbus.ObjectPath]razerReading{
+		wireless.path: {battery: 80},
+		wired.path:    {battery: 20, charging: true},
+	})
+	if len(groups) != 2 {
+		t.Errorf("Groups = %v, want two mice", groups)
+	}
+
+	// A sleeping mouse reads 0% and proves nothing
+	groups = groupRazerIdentities(identities, map[dbus.ObjectPath]razerReading{
+		wireless.path: {},
+		wired.path:    {},
+	})
+	if len(groups) != 2 {
+		t.Errorf("Groups = %v, want 0%% readings to keep them apart", groups)
+	}
+}
+
+func TestSameMouse_Timing(t *testing.T) {
+	wireless := newRazerIdentity(razerDevicePath("RX1"), "RX1", "Razer DeathAdder V2 Pro (Wireless)")
+	wired := newRazerIdentity(razerDevicePath("PM1"), "PM1", "Razer DeathAdder V2 Pro (Wired)")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		appeared time.Time
+		lost     time.Time
+		want     bool
+	}{
+		{"appeared as the other disappeared", now, now, true},
+		{"appeared shortly before the other disappeared", now, now.Add(razerIdentityWindow), true},
+		{"appeared long after", now.Add(razerIdentityWindow + time.Second), now, false},
+		{"nothing disappeared", now, time.Time{}, false},
+		{"present from the start", time.Time{}, now, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameMouse([]razerIdentity{wireless}, wired, tt.appeared, tt.lost, false); got != tt.want {
+				t.Errorf("sameMouse = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// scanIdentities makes the next updateState look through the daemon's devices
+func scanIdentities(r *RazerDevice) {
+	r.mu.Lock()
+	r.lastScan = time.Time{}
+	r.mu.Unlock()
+}
+
+func TestRazerDevice_ModeSwitch(t *testing.T) {
+	address := dbustest.StartBus(t)
+	daemon := startFakeRazerDaemon(t, address)
+	receiver := daemon.addMouse(t, "RX1", "Razer DeathAdder V2 Pro (Wireless)")
+	daemon.addMouse(t, "KB1", "Razer BlackWidow V3")
+
+	r := newRazerDeviceGroup(dbustest.Connect(t, address), nil, []razerIdentity{
+		newRazerIdentity(razerDevicePath("RX1"), "RX1", "Razer DeathAdder V2 Pro (Wireless)"),
+	})
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+	if state := r.GetState(); state.Mode == nil || *state.Mode != protocol.ModeWireless {
+		t.Fatalf("Mode = %v, want Wireless", state.Mode)
+	}
+
+	// Plugging in the cable adds a wired identity while the receiver stays
+	// plugged in and reports the same mouse, found on the next scan
+	receiver.mu.Lock()
+	receiver.charging = true
+	receiver.mu.Unlock()
+	cable := daemon.addMouse(t, "PM1", "Razer DeathAdder V2 Pro (Wired)")
+	cable.mu.Lock()
+	cable.charging = true
+	cable.mu.Unlock()
+	scanIdentities(r)
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState after plugging in failed: %v", err)
+	}
+	state := r.GetState()
+	if state.Mode == nil || *state.Mode != protocol.ModeWired || *state.Power != protocol.PowerCharging {
+		t.Errorf("Mode = %v, Power = %v, want Wired and Charging", state.Mode, state.Power)
+	}
+	if state.DeviceID != "RX1" || r.GetName() != "Razer DeathAdder V2 Pro" {
+		t.Errorf("Device became %s (%s), want the same logical device", state.DeviceID, r.GetName())
+	}
+
+	// Unplugging falls back to the receiver without a reconnect
+	daemon.removeMouse("PM1")
+	receiver.mu.Lock()
+	receiver.charging = false
+	receiver.mu.Unlock()
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState after unplugging = %v, want the wireless identity to be used", err)
+	}
+	state = r.GetState()
+	if state.Mode == nil || *state.Mode != protocol.ModeWireless || *state.Power != protocol.PowerDischarging {
+		t.Errorf("Mode = %v, Power = %v, want Wireless and Discharging", state.Mode, state.Power)
+	}
+	if len(r.identities) != 2 {
+		t.Errorf("Known identities = %d, want wired and wireless", len(r.identities))
+	}
+}
+
+func TestRazerDevice_ModeSwitchReplacesIdentity(t *testing.T) {
+	address := dbustest.StartBus(t)
+	daemon := startFakeRazerDaemon(t, address)
+	daemon.addMouse(t, "RX1", "Razer DeathAdder V2 Pro (Wireless)")
+
+	r := newRazerDeviceGroup(dbustest.Connect(t, address), nil, []razerIdentity{
+		newRazerIdentity(razerDevicePath("RX1"), "RX1", "Razer DeathAdder V2 Pro (Wireless)"),
+	})
+	scanIdentities(r)
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+
+	// Some mice drop the wireless identity when the cable goes in, so the
+	// readings cannot be compared and the timing decides
+	daemon.removeMouse("RX1")
+	cable := daemon.addMouse(t, "PM1", "Razer DeathAdder V2 Pro (Wired)")
+	cable.mu.Lock()
+	cable.battery = 60
+	cable.charging = true
+	cable.mu.Unlock()
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState after plugging in failed: %v", err)
+	}
+	state := r.GetState()
+	if state.Mode == nil || *state.Mode != protocol.ModeWired || *state.Battery != 60 {
+		t.Errorf("Mode = %v, Battery = %v, want the wired reading", state.Mode, state.Battery)
+	}
+	if state.DeviceID != "RX1" {
+		t.Errorf("Device became %s, want the same logical device", state.DeviceID)
+	}
+}
+
+func TestRazerDevice_TwoMiceOfOneModel(t *testing.T) {
+	address := dbustest.StartBus(t)
+	daemon := startFakeRazerDaemon(t, address)
+	daemon.addMouse(t, "RX1", "Razer DeathAdder V2 Pro (Wireless)")
+
+	r := newRazerDeviceGroup(dbustest.Connect(t, address), nil, []razerIdentity{
+		newRazerIdentity(razerDevicePath("RX1"), "RX1", "Razer DeathAdder V2 Pro (Wireless)"),
+	})
+	scanIdentities(r)
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+
+	// A second mouse of the same model is plugged in while the first keeps
+	// answering over its receiver
+	second := daemon.addMouse(t, "PM2", "Razer DeathAdder V2 Pro (Wired)")
+	second.mu.Lock()
+	second.battery = 20
+	second.charging = true
+	second.mu.Unlock()
+	scanIdentities(r)
+	if err := r.updateState(); err != nil {
+		t.Fatalf("updateState failed: %v", err)
+	}
+
+	state := r.GetState()
+	if state.Mode == nil || *state.Mode != protocol.ModeWireless || *state.Battery != 80 {
+		t.Errorf("Mode = %v, Battery = %v, want the first mouse's wireless reading", state.Mode, state.Battery)
+	}
+	if len(r.identities) != 1 {
+		t.Errorf("Known identities = %d, want only the first mouse", len(r.identities))
+	}
+}
@@ -0,0 +1,169 @@
+package device
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+//go:embed scenarios/*.json
+var builtinScenarioFiles embed.FS
+
+// Scenario names accepted by LoadScenario besides a file path
+const (
+	ScenarioTour   = "tour"   // Scripted walk through every tray state
+	ScenarioRandom = "random" // Randomized activity, see RandomScenario
+)
+
+// Scenario scripts what simulated devices do over simulated time
+type Scenario struct {
+	Name  string
+	Steps []ScenarioStep // Sorted by At
+	// LoopAfter is the simulated time after which the steps play again.
+	// 0 plays them once.
+	LoopAfter time.Duration
+}
+
+// ScenarioStep is one action taken by a simulated device
+type ScenarioStep struct {
+	At     time.Duration // Simulated time since the scenario (or loop) started
+	Device string        // SimEarbuds, SimMouse or SimKeyboard
+	Action string        // See docs/simulation.md
+	Value  string        // Argument of the action, if it takes one
+}
+
+type scenarioJSON struct {
+	Name      string     `json:"name"`
+	LoopAfter string     `json:"loop_after"`
+	Steps     []stepJSON `json:"steps"`
+}
+
+type stepJSON struct {
+	At     string `json:"at"`
+	Device string `json:"device"`
+	Action string `json:"action"`
+	Value  string `json:"value"`
+}
+
+// LoadScenario returns a built-in scenario by name or reads one from a file.
+// seed drives ScenarioRandom.
+func LoadScenario(name string, seed int64) (Scenario, error) {
+	switch name {
+	case ScenarioRandom:
+		return RandomScenario(seed), nil
+	case ScenarioTour:
+		f, err := builtinScenarioFiles.Open("scenarios/tour.json")
+		if err != nil {
+			return Scenario{}, err
+		}
+		defer f.Close()
+		return ParseScenario(f)
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("failed to open scenario: %w", err)
+	}
+	defer f.Close()
+	scenario, err := ParseScenario(f)
+	if err != nil {
+		return Scenario{}, fmt.Errorf("%s: %w", name, err)
+	}
+	return scenario, nil
+}
+
+// ParseScenario reads a scenario and checks that every step is an action
+// its device can take
+func ParseScenario(r io.Reader) (Scenario, error) {
+	var raw scenarioJSON
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return Scenario{}, fmt.Errorf("invalid scenario: %w", err)
+	}
+
+	scenario := Scenario{Name: raw.Name}
+	if raw.LoopAfter != "" {
+		d, err := time.ParseDuration(raw.LoopAfter)
+		if err != nil || d < 0 {
+			return Scenario{}, fmt.Errorf("invalid loop_after %q", raw.LoopAfter)
+		}
+		scenario.LoopAfter = d
+	}
+
+	devices := make(map[string]*SimulatedDevice)
+	for i, s := range raw.Steps {
+		at, err := time.ParseDuration(s.At)
+		if err != nil || at < 0 {
+			return Scenario{}, fmt.Errorf("step %d: invalid time %q", i+1, s.At)
+		}
+		step := ScenarioStep{At: at, Device: s.Device, Action: s.Action, Value: s.Value}
+
+		// Rehearse the step on a scratch device to validate it
+		device, ok := devices[step.Device]
+		if !ok {
+			device, err = newSimulatedDevice(step.Device)
+			if err != nil {
+				return Scenario{}, fmt.Errorf("step %d: %w", i+1, err)
+			}
+			devices[step.Device] = device
+		}
+		if err := device.apply(step); err != nil {
+			return Scenario{}, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		scenario.Steps = append(scenario.Steps, step)
+	}
+
+	sort.SliceStable(scenario.Steps, func(i, j int) bool {
+		return scenario.Steps[i].At < scenario.Steps[j].At
+	})
+	return scenario, nil
+}
+
+// randomActions are the steps RandomScenario picks from for each device
+var randomActions = map[string][]ScenarioStep{
+	SimEarbuds: {
+		{Action: "wear"}, {Action: "wear"}, {Action: "remove", Value: "left"},
+		{Action: "remove", Value: "right"}, {Action: "case"}, {Action: "case", Value: "left"},
+		{Action: "anc", Value: "off"}, {Action: "anc", Value: "transparency"}, {Action: "anc", Value: "active"},
+		{Action: "plug"}, {Action: "unplug"},
+		{Action: "connection", Value: "buds_off"}, {Action: "connection", Value: "buds_linked"},
+		{Action: "connection", Value: "buds_linked"},
+	},
+	SimMouse: {
+		{Action: "plug"}, {Action: "unplug"}, {Action: "unplug"},
+		{Action: "sleep"}, {Action: "wake"}, {Action: "wake"},
+	},
+	SimKeyboard: {
+		{Action: "plug"}, {Action: "unplug"}, {Action: "unplug"},
+		{Action: "disconnect"}, {Action: "connect"}, {Action: "connect"},
+	},
+}
+
+// RandomScenario generates twelve simulated hours of random but plausible
+// activity, repeated. The same seed always gives the same scenario.
+func RandomScenario(seed int64) Scenario {
+	const length = 12 * time.Hour
+	rng := rand.New(rand.NewSource(seed))
+
+	scenario := Scenario{Name: fmt.Sprintf("random (seed %d)", seed), LoopAfter: length}
+	for _, kind := range simulatedKinds {
+		scenario.Steps = append(scenario.Steps, ScenarioStep{Device: kind, Action: "battery", Value: fmt.Sprint(20 + rng.Intn(81))})
+	}
+	for at := time.Duration(0); ; {
+		at += time.Duration(5+rng.Intn(36)) * time.Minute
+		if at >= length {
+			break
+		}
+		kind := simulatedKinds[rng.Intn(len(simulatedKinds))]
+		actions := randomActions[kind]
+		step := actions[rng.Intn(len(actions))]
+		step.At = at
+		step.Device = kind
+		scenario.Steps = append(scenario.Steps, step)
+	}
+	return scenario
+}
@@ -0,0 +1,175 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Setting IDs shared across backends
+const (
+	SettingANCMode             = "anc_mode"
+	SettingIdleTime            = "idle_time"
+	SettingLowBatteryThreshold = "low_battery_threshold"
+)
+
+// SettingKind is the type of value a setting takes
+type SettingKind string
+
+const (
+	SettingEnum  SettingKind = "enum"  // One of Options
+	SettingRange SettingKind = "range" // An integer from Min to Max in multiples of Step
+	SettingBool  SettingKind = "bool"
+)
+
+// ErrNotConfigurable is returned for devices without settings
+var ErrNotConfigurable = errors.New("device has no settings")
+
+// Setting describes an adjustable device setting and its current value
+type Setting struct {
+	ID   string      `json:"id"`   // Stable identifier, e.g. "anc_mode"
+	Name string      `json:"name"` // Label for menus
+	Kind SettingKind `json:"kind"`
+
+	Options []string `json:"options,omitempty"` // Values of an enum setting
+
+	// Bounds and step of a range setting; a Step of 0 or 1 allows every integer
+	Min  int    `json:"min,omitempty"`
+	Max  int    `json:"max,omitempty"`
+	Step int    `json:"step,omitempty"`
+	Unit string `json:"unit,omitempty"` // Shown after range values, e.g. "s" or "%"
+
+	// Presets are the range values offered by menus; any value in range can
+	// still be set from the command line
+	Presets []int `json:"presets,omitempty"`
+
+	// Value is the current value: a string for enum settings, an int for
+	// range settings and a bool for bool settings. nil when unknown.
+	Value any `json:"value"`
+}
+
+// Configurable is implemented by devices whose settings can be read and
+// changed. Callers render settings from their descriptions, so they need no
+// knowledge of the device.
+type Configurable interface {
+	// Settings returns the device's settings with their current values
+	Settings() []Setting
+
+	// ApplySetting changes a setting on the device. The value has already
+	// been checked with Setting.Validate.
+	ApplySetting(id string, value any) error
+}
+
+// FindSetting returns the setting with the given ID
+func FindSetting(settings []Setting, id string) (Setting, bool) {
+	for _, s := range settings {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Choices returns the values a menu offers for the setting, formatted for
+// display. Parse accepts each of them.
+func (s Setting) Choices() []string {
+	switch s.Kind {
+	case SettingEnum:
+		return s.Options
+	case SettingRange:
+		choices := make([]string, len(s.Presets))
+		for i, preset := range s.Presets {
+			choices[i] = s.Format(preset)
+		}
+		return choices
+	case SettingBool:
+		return []string{"on", "off"}
+	default:
+		return nil
+	}
+}
+
+// Validate checks that value has the right type and is allowed
+func (s Setting) Validate(value any) error {
+	switch s.Kind {
+	case SettingEnum:
+		v, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s takes one of %s", s.ID, strings.Join(s.Options, ", "))
+		}
+		for _, option := range s.Options {
+			if v == option {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s must be one of %s, got %q", s.ID, strings.Join(s.Options, ", "), v)
+
+	case SettingRange:
+		v, ok := value.(int)
+		if !ok {
+			return fmt.Errorf("%s takes a number", s.ID)
+		}
+		if v < s.Min || v > s.Max {
+			return fmt.Errorf("%s must be between %d and %d, got %d", s.ID, s.Min, s.Max, v)
+		}
+		if s.Step > 1 && (v-s.Min)%s.Step != 0 {
+			return fmt.Errorf("%s must be a multiple of %d from %d, got %d", s.ID, s.Step, s.Min, v)
+		}
+		return nil
+
+	case SettingBool:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s takes on or off", s.ID)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("%s has unknown kind %q", s.ID, s.Kind)
+	}
+}
+
+// Parse converts text, e.g. from the command line, to a valid value
+func (s Setting) Parse(text string) (any, error) {
+	var value any
+	switch s.Kind {
+	case SettingEnum:
+		value = text
+	case SettingRange:
+		n, err := strconv.Atoi(strings.TrimSuffix(text, s.Unit))
+		if err != nil {
+			return nil, fmt.Errorf("%s takes a number, got %q", s.ID, text)
+		}
+		value = n
+	case SettingBool:
+		switch strings.ToLower(text) {
+		case "on", "true", "yes", "1":
+			value = true
+		case "off", "false", "no", "0":
+			value = false
+		default:
+			return nil, fmt.Errorf("%s takes on or off, got %q", s.ID, text)
+		}
+	}
+	if err := s.Validate(value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Format renders a value for display, e.g. "300s" or "on"
+func (s Setting) Format(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "unknown"
+	case bool:
+		if v {
+			return "on"
+		}
+		return "off"
+	case int:
+		return strconv.Itoa(v) + s.Unit
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,122 @@
+package device
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSetting_Parse(t *testing.T) {
+	anc := Setting{ID: "anc_mode", Kind: SettingEnum, Options: []string{"off", "active"}}
+	idle := Setting{ID: "idle_time", Kind: SettingRange, Min: 60, Max: 900, Step: 60, Unit: "s"}
+	lights := Setting{ID: "lights", Kind: SettingBool}
+
+	tests := []struct {
+		setting Setting
+		text    string
+		want    any
+		wantErr bool
+	}{
+		{anc, "active", "active", false},
+		{anc, "loud", nil, true},
+		{idle, "300", 300, false},
+		{idle, "300s", 300, false},
+		{idle, "30", nil, true},
+		{idle, "330", nil, true},
+		{idle, "soon", nil, true},
+		{lights, "on", true, false},
+		{lights, "false", false, false},
+		{lights, "maybe", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.setting.Parse(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s.Parse(%q) error = %v, want error %v", tt.setting.ID, tt.text, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s.Parse(%q) = %v, want %v", tt.setting.ID, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSetting_ValidateType(t *testing.T) {
+	idle := Setting{ID: "idle_time", Kind: SettingRange, Min: 60, Max: 900}
+	if err := idle.Validate("300"); err == nil {
+		t.Error("A range setting should reject a string")
+	}
+	if got := idle.Format(300); got != "300" {
+		t.Errorf("Format(300) = %q", got)
+	}
+	if got := idle.Format(nil); got != "unknown" {
+		t.Errorf("Format(nil) = %q", got)
+	}
+}
+
+func TestSetting_Choices(t *testing.T) {
+	settings := []Setting{
+		{ID: "anc_mode", Kind: SettingEnum, Options: []string{"off", "active"}},
+		{ID: "idle_time", Kind: SettingRange, Min: 60, Max: 900, Unit: "s", Presets: []int{60, 300}},
+		{ID: "lights", Kind: SettingBool},
+	}
+	want := [][]string{{"off", "active"}, {"60s", "300s"}, {"on", "off"}}
+
+	// Menus hand the chosen text back to Parse
+	for i, setting := range settings {
+		choices := setting.Choices()
+		if !slices.Equal(choices, want[i]) {
+			t.Errorf("%s.Choices() = %v, want %v", setting.ID, choices, want[i])
+		}
+		for _, choice := range choices {
+			if _, err := setting.Parse(choice); err != nil {
+				t.Errorf("%s.Parse(%q) failed: %v", setting.ID, choice, err)
+			}
+		}
+	}
+}
+
+// configurableDevice is a mock device with one range setting
+type configurableDevice struct {
+	*mockHIDDevice
+	threshold int
+}
+
+func (c *configurableDevice) Settings() []Setting {
+	return []Setting{{ID: "threshold", Kind: SettingRange, Min: 5, Max: 25, Value: c.threshold}}
+}
+
+func (c *configurableDevice) ApplySetting(id string, value any) error {
+	c.threshold = value.(int)
+	return nil
+}
+
+func TestDeviceManager_ApplySetting(t *testing.T) {
+	dm := NewDeviceManager()
+	dev := &configurableDevice{mockHIDDevice: &mockHIDDevice{id: "mouse"}, threshold: 10}
+	dm.mu.Lock()
+	dm.devices["mouse"] = dev
+	dm.devices["plain"] = &mockHIDDevice{id: "plain"}
+	dm.mu.Unlock()
+
+	if err := dm.ApplySetting("mouse", "threshold", 15); err != nil {
+		t.Fatalf("ApplySetting failed: %v", err)
+	}
+	settings, err := dm.GetSettings("mouse")
+	if err != nil || settings[0].Value != 15 {
+		t.Errorf("GetSettings = %+v, %v, want threshold 15", settings, err)
+	}
+
+	if err := dm.ApplySetting("mouse", "threshold", 50); err == nil {
+		t.Error("Out of range value should be rejected before reaching the device")
+	}
+	if err := dm.ApplySetting("mouse", "volume", 1); err == nil {
+		t.Error("Unknown setting should be rejected")
+	}
+	if _, err := dm.GetSettings("plain"); !errors.Is(err, ErrNotConfigurable) {
+		t.Errorf("GetSettings on a plain device = %v, want ErrNotConfigurable", err)
+	}
+	if _, err := dm.GetSettings("missing"); err == nil {
+		t.Error("Unknown device should be an error")
+	}
+}
@@ -0,0 +1,497 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// Kinds of simulated device, as named in scenarios
+const (
+	SimEarbuds  = "earbuds"  // GameBuds-style earbuds with a charging case
+	SimMouse    = "mouse"    // Razer-style wireless mouse with a cable
+	SimKeyboard = "keyboard" // Generic HID keyboard with one battery
+)
+
+var simulatedKinds = []string{SimEarbuds, SimMouse, SimKeyboard}
+
+// simTickInterval is how often, in real time, simulated devices advance and
+// report. Like a polled device they report on every tick.
+const simTickInterval = time.Second
+
+// Battery rates of simulated devices, in percent per simulated hour
+const (
+	simBudWornDrain    = 12
+	simBudOutDrain     = 4
+	simBudCaseCharge   = 60
+	simCaseDrain       = 10 // Per bud being charged
+	simCaseCharge      = 40
+	simMouseDrain      = 3
+	simMouseSleepDrain = 0.5
+	simMouseCharge     = 50
+	simKeyboardDrain   = 2
+	simKeyboardCharge  = 40
+)
+
+// SimulatedDevice is a synthetic device that follows a scenario instead of
+// talking to hardware, for demos and UI development. Its state takes the
+// same shape as the real device it imitates, so it is displayed the same way.
+type SimulatedDevice struct {
+	kind       string
+	id         string
+	name       string
+	deviceType DeviceType
+
+	steps     []ScenarioStep // This device's steps of the scenario
+	loopAfter time.Duration
+	speed     float64       // Simulated time per real time
+	start     time.Time     // Real time the simulation started
+	clock     time.Duration // Simulated time reached
+	next      int           // Index of the next step
+	loopBase  time.Duration // Simulated time the current loop started
+
+	// Simulated hardware. Mice and keyboards use battery; earbuds use
+	// left, right and dock.
+	battery                 float64
+	left, right, dock       float64
+	leftStatus, rightStatus protocol.EarbudStatus
+	plugged                 bool
+	anc                     protocol.ANCMode
+	connection              protocol.ConnectionState
+	connected               bool
+	asleep                  bool
+	idleTime                int
+	lowThreshold            int
+
+	stopChan chan struct{}
+	mu       sync.RWMutex
+	onChange func(protocol.DeviceState)
+}
+
+// NewSimulatedDevices creates one device of each kind, all following the
+// scenario on a shared clock. speed is how many simulated seconds pass per
+// real second.
+func NewSimulatedDevices(scenario Scenario, speed float64) ([]*SimulatedDevice, error) {
+	if speed <= 0 {
+		return nil, fmt.Errorf("simulation speed must be positive, got %v", speed)
+	}
+	start := time.Now()
+	devices := make([]*SimulatedDevice, 0, len(simulatedKinds))
+	for _, kind := range simulatedKinds {
+		device, err := newSimulatedDevice(kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, step := range scenario.Steps {
+			if step.Device == kind {
+				device.steps = append(device.steps, step)
+			}
+		}
+		device.loopAfter = scenario.LoopAfter
+		device.speed = speed
+		device.start = start
+		devices = append(devices, device)
+	}
+	return devices, nil
+}
+
+// newSimulatedDevice creates a device of a kind in its initial condition:
+// connected, charged and not plugged in
+func newSimulatedDevice(kind string) (*SimulatedDevice, error) {
+	s := &SimulatedDevice{
+		kind:      kind,
+		id:        "sim_" + kind,
+		connected: true,
+		speed:     1,
+		start:     time.Now(),
+		stopChan:  make(chan struct{}),
+	}
+	switch kind {
+	case SimEarbuds:
+		s.name = "Simulated GameBuds"
+		s.deviceType = DeviceTypeSteelSeriesGameBuds
+		s.left, s.right, s.dock = 100, 100, 100
+		s.leftStatus, s.rightStatus = protocol.StatusWorn, protocol.StatusWorn
+		s.anc = protocol.ANCOff
+		s.connection = protocol.ConnectionLinked
+	case SimMouse:
+		s.name = "Simulated Razer Mouse"
+		s.deviceType = DeviceTypeRazerDeathAdder
+		s.battery = 80
+		s.idleTime = 300
+		s.lowThreshold = 10
+	case SimKeyboard:
+		s.name = "Simulated Keyboard"
+		s.deviceType = DeviceTypeHIDBattery
+		s.battery = 60
+	default:
+		return nil, fmt.Errorf("unknown simulated device %q (known: earbuds, mouse, keyboard)", kind)
+	}
+	return s, nil
+}
+
+// GetID returns the device ID, e.g. "sim_earbuds"
+func (s *SimulatedDevice) GetID() string {
+	return s.id
+}
+
+// GetName returns the device name
+func (s *SimulatedDevice) GetName() string {
+	return s.name
+}
+
+// GetType returns the type of the device being imitated
+func (s *SimulatedDevice) GetType() DeviceType {
+	return s.deviceType
+}
+
+// GetState returns the simulated state
+func (s *SimulatedDevice) GetState() protocol.DeviceState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stateLocked(time.Now())
+}
+
+// IsConnected returns whether the simulated device is connected
+func (s *SimulatedDevice) IsConnected() bool {
+	return s.GetState().IsConnected
+}
+
+// SetOnStateChange sets the callback for state reports
+func (s *SimulatedDevice) SetOnStateChange(callback func(protocol.DeviceState)) {
+	s.mu.Lock()
+	s.onChange = callback
+	s.mu.Unlock()
+}
+
+// Start runs the simulation without supervision
+func (s *SimulatedDevice) Start() error {
+	go s.tickLoop(nil)
+	return nil
+}
+
+// Run runs the simulation until ctx is cancelled
+func (s *SimulatedDevice) Run(ctx context.Context) error {
+	s.tickLoop(ctx.Done())
+	return nil
+}
+
+// Stop stops the simulation
+func (s *SimulatedDevice) Stop() error {
+	select {
+	case <-s.stopChan:
+		// Already closed
+	default:
+		close(s.stopChan)
+	}
+	return nil
+}
+
+// Close stops the simulation
+func (s *SimulatedDevice) Close() error {
+	return s.Stop()
+}
+
+// tickLoop advances and reports the device until done or Stop
+func (s *SimulatedDevice) tickLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(simTickInterval)
+	defer ticker.Stop()
+
+	s.tick(time.Now())
+	for {
+		select {
+		case <-s.stopChan:
+			return
+		case <-done:
+			return
+		case now := <-ticker.C:
+			s.tick(now)
+		}
+	}
+}
+
+// tick advances the simulation to a real time and reports the state
+func (s *SimulatedDevice) tick(now time.Time) {
+	s.mu.Lock()
+	s.advance(now)
+	state := s.stateLocked(now)
+	callback := s.onChange
+	s.mu.Unlock()
+
+	if callback != nil {
+		callback(state)
+	}
+}
+
+// advance plays the steps due by a real time, draining and charging the
+// batteries in between. Must be called with s.mu held.
+func (s *SimulatedDevice) advance(now time.Time) {
+	target := time.Duration(float64(now.Sub(s.start)) * s.speed)
+	for s.next < len(s.steps) {
+		step := s.steps[s.next]
+		at := s.loopBase + step.At
+		if at > target {
+			break
+		}
+		if at > s.clock {
+			s.drift(at - s.clock)
+			s.clock = at
+		}
+		if err := s.apply(step); err != nil {
+			log.Printf("⚠️ %s: %v", s.name, err)
+		}
+		s.next++
+		if s.next == len(s.steps) && s.loopAfter > 0 {
+			s.next = 0
+			s.loopBase += s.loopAfter
+		}
+	}
+	if target > s.clock {
+		s.drift(target - s.clock)
+		s.clock = target
+	}
+}
+
+// drift changes the batteries over a span of simulated time
+func (s *SimulatedDevice) drift(d time.Duration) {
+	hours := d.Hours()
+	switch s.kind {
+	case SimEarbuds:
+		for _, bud := range []struct {
+			level  *float64
+			status protocol.EarbudStatus
+		}{{&s.left, s.leftStatus}, {&s.right, s.rightStatus}} {
+			switch bud.status {
+			case protocol.StatusWorn:
+				*bud.level -= simBudWornDrain * hours
+			case protocol.StatusOut:
+				*bud.level -= simBudOutDrain * hours
+			case protocol.StatusInCase:
+				if s.dock > 0 && *bud.level < 100 {
+					*bud.level += simBudCaseCharge * hours
+					s.dock -= simCaseDrain * hours
+				}
+			}
+		}
+		if s.plugged {
+			s.dock += simCaseCharge * hours
+		}
+		s.left, s.right, s.dock = clampLevel(s.left), clampLevel(s.right), clampLevel(s.dock)
+
+	case SimMouse:
+		switch {
+		case s.plugged:
+			s.battery += simMouseCharge * hours
+		case s.asleep:
+			s.battery -= simMouseSleepDrain * hours
+		case s.connected:
+			s.battery -= simMouseDrain * hours
+		}
+		s.battery = clampLevel(s.battery)
+
+	case SimKeyboard:
+		switch {
+		case s.plugged:
+			s.battery += simKeyboardCharge * hours
+		case s.connected:
+			s.battery -= simKeyboardDrain * hours
+		}
+		s.battery = clampLevel(s.battery)
+	}
+}
+
+// apply takes one scenario step
+func (s *SimulatedDevice) apply(step ScenarioStep) error {
+	earbuds := s.kind == SimEarbuds
+	switch step.Action {
+	case "battery", "case_battery":
+		level, err := strconv.Atoi(step.Value)
+		if err != nil || level < 0 || level > 100 {
+			return fmt.Errorf("%s takes a level from 0 to 100, got %q", step.Action, step.Value)
+		}
+		switch {
+		case step.Action == "case_battery" && earbuds:
+			s.dock = float64(level)
+		case step.Action == "case_battery":
+			return s.unsupported(step)
+		case earbuds:
+			s.left, s.right = float64(level), float64(level)
+		default:
+			s.battery = float64(level)
+		}
+
+	case "plug", "unplug":
+		s.plugged = step.Action == "plug"
+
+	case "wear", "remove", "case":
+		if !earbuds {
+			return s.unsupported(step)
+		}
+		status := map[string]protocol.EarbudStatus{
+			"wear":   protocol.StatusWorn,
+			"remove": protocol.StatusOut,
+			"case":   protocol.StatusInCase,
+		}[step.Action]
+		switch step.Value {
+		case "left":
+			s.leftStatus = status
+		case "right":
+			s.rightStatus = status
+		case "", "both":
+			s.leftStatus, s.rightStatus = status, status
+		default:
+			return fmt.Errorf("%s takes left, right or both, got %q", step.Action, step.Value)
+		}
+
+	case "anc":
+		if !earbuds {
+			return s.unsupported(step)
+		}
+		return s.anc.UnmarshalText([]byte(step.Value))
+
+	case "connection":
+		if !earbuds {
+			return s.unsupported(step)
+		}
+		return s.connection.UnmarshalText([]byte(step.Value))
+
+	case "sleep", "wake":
+		if s.kind != SimMouse {
+			return s.unsupported(step)
+		}
+		s.asleep = step.Action == "sleep"
+
+	case "connect", "disconnect":
+		if earbuds {
+			return s.unsupported(step)
+		}
+		s.connected = step.Action == "connect"
+
+	default:
+		return fmt.Errorf("unknown action %q", step.Action)
+	}
+	return nil
+}
+
+func (s *SimulatedDevice) unsupported(step ScenarioStep) error {
+	return fmt.Errorf("%s cannot %s", s.kind, step.Action)
+}
+
+// stateLocked builds the state the imitated device would report. Must be
+// called with s.mu held.
+func (s *SimulatedDevice) stateLocked(now time.Time) protocol.DeviceState {
+	state := protocol.DeviceState{
+		DeviceID:   s.id,
+		DeviceType: string(s.deviceType),
+	}
+
+	switch s.kind {
+	case SimEarbuds:
+		left, right, dock := roundLevel(s.left), roundLevel(s.right), roundLevel(s.dock)
+		leftStatus, rightStatus := s.leftStatus, s.rightStatus
+		leftPower := protocol.EarbudPowerState(leftStatus, &left)
+		rightPower := protocol.EarbudPowerState(rightStatus, &right)
+		dockPower := protocol.ChargerPowerState(s.plugged && dock < 100, s.plugged, dock)
+		anc := s.anc
+		connection := s.connection
+
+		state.Connection = &connection
+		state.IsConnected = connection == protocol.ConnectionLinked
+		if connection == protocol.ConnectionDisconnected {
+			break
+		}
+		state.LeftBattery, state.RightBattery, state.DockBattery = &left, &right, &dock
+		state.LeftStatus, state.RightStatus = &leftStatus, &rightStatus
+		state.LeftPower, state.RightPower, state.DockPower = &leftPower, &rightPower, &dockPower
+		state.ANCMode = &anc
+		for _, target := range []string{protocol.TargetLeftBattery, protocol.TargetRightBattery, protocol.TargetDockBattery} {
+			state.MarkUpdated(target, now)
+		}
+
+	case SimMouse:
+		battery := roundLevel(s.battery)
+		power := protocol.ChargerPowerState(s.plugged && battery < 100, s.plugged, battery)
+		charging := power == protocol.PowerCharging
+		mode := protocol.ModeWireless
+		if s.plugged {
+			mode = protocol.ModeWired
+		}
+		idleTime, lowThreshold := s.idleTime, s.lowThreshold
+
+		state.Battery, state.Power, state.IsCharging, state.Mode = &battery, &power, &charging, &mode
+		state.IdleTime, state.LowBatteryThreshold = &idleTime, &lowThreshold
+		state.IsConnected = s.connected && (!s.asleep || s.plugged)
+		if s.asleep && !s.plugged {
+			asleep := protocol.ConnectionAsleep
+			state.Connection = &asleep
+		}
+		state.MarkUpdated(protocol.TargetBattery, now)
+
+	case SimKeyboard:
+		battery := roundLevel(s.battery)
+		power := protocol.ChargerPowerState(s.plugged && battery < 100, s.plugged, battery)
+		state.Battery, state.Power = &battery, &power
+		state.IsConnected = s.connected
+		state.MarkUpdated(protocol.TargetBattery, now)
+	}
+
+	return state
+}
+
+// Settings returns the settings of the imitated device: noise cancelling for
+// earbuds and the power settings for the mouse
+func (s *SimulatedDevice) Settings() []Setting {
+	switch s.kind {
+	case SimEarbuds:
+		state := s.GetState()
+		setting := Setting{
+			ID:      SettingANCMode,
+			Name:    "Noise Cancelling",
+			Kind:    SettingEnum,
+			Options: []string{protocol.ANCOff.ID(), protocol.ANCTransparency.ID(), protocol.ANCActive.ID()},
+		}
+		if state.ANCMode != nil {
+			setting.Value = state.ANCMode.ID()
+		}
+		return []Setting{setting}
+	case SimMouse:
+		return razerSettings(s.GetState())
+	default:
+		return nil
+	}
+}
+
+// ApplySetting changes a simulated setting, which is reported on the next tick
+func (s *SimulatedDevice) ApplySetting(id string, value any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case id == SettingANCMode && s.kind == SimEarbuds:
+		name, _ := value.(string)
+		return s.anc.UnmarshalText([]byte(name))
+	case id == SettingIdleTime && s.kind == SimMouse:
+		s.idleTime, _ = value.(int)
+	case id == SettingLowBatteryThreshold && s.kind == SimMouse:
+		s.lowThreshold, _ = value.(int)
+	default:
+		return fmt.Errorf("unknown setting %q", id)
+	}
+	return nil
+}
+
+// clampLevel keeps a simulated battery level between 0 and 100
+func clampLevel(level float64) float64 {
+	return math.Max(0, math.Min(100, level))
+}
+
+// roundLevel converts a simulated battery level to a reported percentage
+func roundLevel(level float64) int {
+	return int(math.Round(level))
+}
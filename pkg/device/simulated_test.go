@@ -0,0 +1,151 @@
+package device
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+func TestParseScenario(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{"valid", `{"loop_after": "1h", "steps": [{"at": "10m", "device": "mouse", "action": "sleep"}]}`, ""},
+		{"unknown device", `{"steps": [{"at": "0s", "device": "toaster", "action": "plug"}]}`, "unknown simulated device"},
+		{"unknown action", `{"steps": [{"at": "0s", "device": "mouse", "action": "explode"}]}`, "unknown action"},
+		{"action of another device", `{"steps": [{"at": "0s", "device": "keyboard", "action": "anc", "value": "active"}]}`, "keyboard cannot anc"},
+		{"bad value", `{"steps": [{"at": "0s", "device": "earbuds", "action": "battery", "value": "120"}]}`, "level from 0 to 100"},
+		{"bad enum", `{"steps": [{"at": "0s", "device": "earbuds", "action": "connection", "value": "sideways"}]}`, "unknown connection state"},
+		{"bad time", `{"steps": [{"at": "soon", "device": "mouse", "action": "wake"}]}`, "invalid time"},
+		{"bad loop", `{"loop_after": "-1h", "steps": []}`, "invalid loop_after"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseScenario(strings.NewReader(tt.json))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ParseScenario() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseScenario() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadScenario_BuiltIn(t *testing.T) {
+	tour, err := LoadScenario(ScenarioTour, 0)
+	if err != nil {
+		t.Fatalf("Tour scenario is invalid: %v", err)
+	}
+	if len(tour.Steps) == 0 || tour.LoopAfter == 0 {
+		t.Errorf("Tour = %d steps looping after %s, want a looping script", len(tour.Steps), tour.LoopAfter)
+	}
+
+	// Random scenarios are reproducible and only take valid steps
+	random, err := LoadScenario(ScenarioRandom, 42)
+	if err != nil {
+		t.Fatalf("LoadScenario(random) failed: %v", err)
+	}
+	if !reflect.DeepEqual(random, RandomScenario(42)) {
+		t.Error("The same seed should give the same scenario")
+	}
+	devices := make(map[string]*SimulatedDevice)
+	for _, step := range random.Steps {
+		if devices[step.Device] == nil {
+			devices[step.Device], _ = newSimulatedDevice(step.Device)
+		}
+		if err := devices[step.Device].apply(step); err != nil {
+			t.Errorf("Random step %+v is invalid: %v", step, err)
+		}
+	}
+}
+
+func TestSimulatedDevice_Scenario(t *testing.T) {
+	scenario := Scenario{
+		LoopAfter: 10 * time.Hour,
+		Steps: []ScenarioStep{
+			{At: 0, Device: SimMouse, Action: "battery", Value: "50"},
+			{At: 0, Device: SimMouse, Action: "wake"},
+			{At: 2 * time.Hour, Device: SimMouse, Action: "plug"},
+			{At: 3 * time.Hour, Device: SimMouse, Action: "unplug"},
+			{At: 3 * time.Hour, Device: SimMouse, Action: "sleep"},
+			{At: 2 * time.Hour, Device: SimEarbuds, Action: "case", Value: "left"},
+		},
+	}
+	// One real second is one simulated hour
+	devices, err := NewSimulatedDevices(scenario, 3600)
+	if err != nil {
+		t.Fatalf("NewSimulatedDevices failed: %v", err)
+	}
+	earbuds, mouse := devices[0], devices[1]
+	start := mouse.start
+	at := func(hours float64) time.Time {
+		return start.Add(time.Duration(hours * float64(time.Second)))
+	}
+
+	var reported protocol.DeviceState
+	mouse.SetOnStateChange(func(state protocol.DeviceState) { reported = state })
+
+	// Discharging at 3% per hour
+	mouse.tick(at(2))
+	if *reported.Battery != 44 || *reported.Power != protocol.PowerCharging || *reported.Mode != protocol.ModeWired {
+		t.Errorf("After 2h: battery %d, %s, %s; want 44%% charging wired", *reported.Battery, reported.Power, reported.Mode)
+	}
+
+	// Charging at 50% per hour, then asleep on the receiver
+	mouse.tick(at(3))
+	if *reported.Battery != 94 || reported.IsConnected || reported.Connection == nil || *reported.Connection != protocol.ConnectionAsleep {
+		t.Errorf("After 3h: battery %d, connected %v, connection %v; want 94%% asleep", *reported.Battery, reported.IsConnected, reported.Connection)
+	}
+
+	// The scenario starts over after ten hours
+	mouse.tick(at(10))
+	if *reported.Battery != 50 || !reported.IsConnected {
+		t.Errorf("After looping: battery %d, connected %v; want 50%% and connected", *reported.Battery, reported.IsConnected)
+	}
+
+	// Earbuds charge in the case while the case drains
+	earbuds.tick(at(2.2))
+	state := earbuds.GetState()
+	if *state.LeftBattery != 88 || *state.RightBattery != 74 || *state.DockBattery != 98 {
+		t.Errorf("Earbuds = %d/%d case %d, want 88/74 case 98", *state.LeftBattery, *state.RightBattery, *state.DockBattery)
+	}
+	if *state.LeftPower != protocol.PowerCharging || *state.RightPower != protocol.PowerDischarging {
+		t.Errorf("Earbud power = %s/%s, want charging/discharging", state.LeftPower, state.RightPower)
+	}
+}
+
+func TestSimulatedDevice_Settings(t *testing.T) {
+	devices, err := NewSimulatedDevices(Scenario{}, 1)
+	if err != nil {
+		t.Fatalf("NewSimulatedDevices failed: %v", err)
+	}
+	earbuds, mouse, keyboard := devices[0], devices[1], devices[2]
+
+	if err := earbuds.ApplySetting(SettingANCMode, "transparency"); err != nil {
+		t.Fatalf("ApplySetting(anc_mode) failed: %v", err)
+	}
+	if setting, _ := FindSetting(earbuds.Settings(), SettingANCMode); setting.Value != "transparency" {
+		t.Errorf("ANC mode = %v, want transparency", setting.Value)
+	}
+
+	if err := mouse.ApplySetting(SettingIdleTime, 600); err != nil {
+		t.Fatalf("ApplySetting(idle_time) failed: %v", err)
+	}
+	if setting, _ := FindSetting(mouse.Settings(), SettingIdleTime); setting.Value != 600 {
+		t.Errorf("Idle time = %v, want 600", setting.Value)
+	}
+
+	if keyboard.Settings() != nil || keyboard.ApplySetting(SettingANCMode, "off") == nil {
+		t.Error("The keyboard should have no settings")
+	}
+}
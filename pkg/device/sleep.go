@@ -0,0 +1,121 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	logindService      = "org.freedesktop.login1"
+	logindPath         = "/org/freedesktop/login1"
+	logindManagerIface = "org.freedesktop.login1.Manager"
+)
+
+// Resyncer is implemented by devices that need more than a restart of their
+// monitoring loop to recover after the system resumes from sleep
+type Resyncer interface {
+	// Resync re-establishes communication with the device and re-queries
+	// its state
+	Resync() error
+}
+
+// SleepWatcher follows logind's PrepareForSleep signal on the system bus. It
+// holds a delay inhibitor lock so that monitoring is paused before the
+// system actually sleeps.
+type SleepWatcher struct {
+	connect  func() (*dbus.Conn, error)
+	onSleep  func()
+	onResume func()
+}
+
+// NewSleepWatcher creates a watcher that calls onSleep before the system
+// sleeps and onResume after it wakes up
+func NewSleepWatcher(onSleep, onResume func()) *SleepWatcher {
+	return &SleepWatcher{
+		connect:  func() (*dbus.Conn, error) { return dbus.ConnectSystemBus() },
+		onSleep:  onSleep,
+		onResume: onResume,
+	}
+}
+
+// Run watches for sleep until ctx is cancelled. It returns an error if
+// logind cannot be reached or the bus connection is lost.
+func (w *SleepWatcher) Run(ctx context.Context) error {
+	conn, err := w.connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	signals := make(chan *dbus.Signal, 8)
+	conn.Signal(signals)
+	if err := conn.AddMatchSignalContext(ctx,
+		dbus.WithMatchObjectPath(logindPath),
+		dbus.WithMatchInterface(logindManagerIface),
+		dbus.WithMatchMember("PrepareForSleep"),
+	); err != nil {
+		return fmt.Errorf("failed to watch for sleep: %w", err)
+	}
+
+	// Without the lock the pause may race with the system going to sleep,
+	// which is still better than not pausing at all
+	lock, err := inhibitSleep(ctx, conn)
+	if err != nil {
+		log.Printf("⚠️ Could not take sleep inhibitor lock: %v", err)
+	}
+	defer func() {
+		if lock != nil {
+			lock.Close()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case signal, ok := <-signals:
+			if !ok {
+				return fmt.Errorf("system bus connection closed")
+			}
+			if signal.Name != logindManagerIface+".PrepareForSleep" || len(signal.Body) < 1 {
+				continue
+			}
+			sleeping, _ := signal.Body[0].(bool)
+
+			if sleeping {
+				log.Printf("💤 System is going to sleep")
+				w.onSleep()
+				// Releasing the lock lets the sleep proceed
+				if lock != nil {
+					lock.Close()
+					lock = nil
+				}
+				continue
+			}
+
+			log.Printf("⏰ System resumed from sleep")
+			if lock == nil {
+				if lock, err = inhibitSleep(ctx, conn); err != nil {
+					log.Printf("⚠️ Could not take sleep inhibitor lock: %v", err)
+				}
+			}
+			w.onResume()
+		}
+	}
+}
+
+// inhibitSleep takes a logind delay lock, which holds off sleep until the
+// returned file is closed or logind's InhibitDelayMaxSec passes
+func inhibitSleep(ctx context.Context, conn *dbus.Conn) (*os.File, error) {
+	var fd dbus.UnixFD
+	err := callDBus(ctx, conn.Object(logindService, logindPath), logindManagerIface+".Inhibit",
+		"sleep", "goarctis", "Pause device monitoring before sleep", "delay").Store(&fd)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "sleep-inhibitor"), nil
+}
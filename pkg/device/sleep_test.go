@@ -0,0 +1,112 @@
+package device
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/jyablonski/goarctis/internal/dbustest"
+)
+
+// fakeLogind hands out a pipe as the inhibitor lock, so the test can tell
+// when the watcher releases it
+type fakeLogind struct {
+	conn     *dbus.Conn
+	locks    chan *os.File // Read ends of handed out locks
+	inhibits chan string   // Mode of each Inhibit call
+}
+
+func startFakeLogind(t *testing.T, address string) *fakeLogind {
+	t.Helper()
+	f := &fakeLogind{
+		conn:     dbustest.Connect(t, address),
+		locks:    make(chan *os.File, 4),
+		inhibits: make(chan string, 4),
+	}
+	if err := f.conn.Export(f, logindPath, logindManagerIface); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := f.conn.RequestName(logindService, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("Failed to claim %s: %v", logindService, err)
+	}
+	return f
+}
+
+func (f *fakeLogind) Inhibit(what, who, why, mode string) (dbus.UnixFD, *dbus.Error) {
+	var fds [2]int
+	if err := syscall.Pipe(fds[:]); err != nil {
+		return 0, dbus.MakeFailedError(err)
+	}
+	f.locks <- os.NewFile(uintptr(fds[0]), "lock")
+	f.inhibits <- what + " " + mode
+	// Only the watcher's copy keeps the lock held once the reply is sent
+	time.AfterFunc(200*time.Millisecond, func() { syscall.Close(fds[1]) })
+	return dbus.UnixFD(fds[1]), nil
+}
+
+func (f *fakeLogind) prepareForSleep(t *testing.T, start bool) {
+	t.Helper()
+	if err := f.conn.Emit(logindPath, logindManagerIface+".PrepareForSleep", start); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func receive[T any](t *testing.T, ch <-chan T, what string) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for %s", what)
+		var zero T
+		return zero
+	}
+}
+
+func TestSleepWatcher(t *testing.T) {
+	address := dbustest.StartBus(t)
+	logind := startFakeLogind(t, address)
+
+	events := make(chan string, 4)
+	watcher := NewSleepWatcher(func() { events <- "sleep" }, func() { events <- "resume" })
+	watcher.connect = func() (*dbus.Conn, error) { return dbus.Connect(address) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- watcher.Run(ctx) }()
+
+	if mode := receive(t, logind.inhibits, "inhibitor lock"); mode != "sleep delay" {
+		t.Errorf("Inhibit(%s), want a sleep delay lock", mode)
+	}
+	lock := receive(t, logind.locks, "lock")
+
+	logind.prepareForSleep(t, true)
+	if event := receive(t, events, "sleep callback"); event != "sleep" {
+		t.Fatalf("Got %s, want sleep", event)
+	}
+
+	// The lock is released once monitoring is paused
+	released := make(chan error, 1)
+	go func() {
+		_, err := lock.Read(make([]byte, 1))
+		released <- err
+	}()
+	if err := receive(t, released, "lock release"); err != io.EOF {
+		t.Errorf("Reading the lock = %v, want EOF once released", err)
+	}
+
+	logind.prepareForSleep(t, false)
+	if event := receive(t, events, "resume callback"); event != "resume" {
+		t.Fatalf("Got %s, want resume", event)
+	}
+	receive(t, logind.inhibits, "lock taken again after resume")
+
+	cancel()
+	if err := receive(t, result, "Run to return"); err != nil {
+		t.Errorf("Run after cancel = %v, want nil", err)
+	}
+}
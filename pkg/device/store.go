@@ -0,0 +1,92 @@
+package device
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// CachedDevice is the last known state of a device, persisted between runs
+type CachedDevice struct {
+	Name  string               `json:"name"`
+	State protocol.DeviceState `json:"state"`
+}
+
+type stateFile struct {
+	SchemaVersion int                     `json:"schema_version"`
+	Devices       map[string]CachedDevice `json:"devices"`
+}
+
+// StateStore persists device states to a JSON file
+type StateStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewStateStore creates a store backed by the file at path
+func NewStateStore(path string) *StateStore {
+	return &StateStore{path: path}
+}
+
+// Path returns the location of the state file
+func (s *StateStore) Path() string {
+	return s.path
+}
+
+// Load reads all persisted devices. A missing file yields an empty map.
+func (s *StateStore) Load() (map[string]CachedDevice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]CachedDevice{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", s.path, err)
+	}
+	if file.Devices == nil {
+		file.Devices = map[string]CachedDevice{}
+	}
+	return file.Devices, nil
+}
+
+// Save replaces the persisted devices. The file is written atomically so a
+// crash mid-write never leaves a truncated state file behind.
+func (s *StateStore) Save(devices map[string]CachedDevice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.MarshalIndent(stateFile{
+		SchemaVersion: protocol.SchemaVersion,
+		Devices:       devices,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package device
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+func TestStateStore_RoundTrip(t *testing.T) {
+	store := NewStateStore(filepath.Join(t.TempDir(), "nested", "state.json"))
+
+	battery := 42
+	charging := false
+	state := protocol.DeviceState{
+		DeviceID:    "mouse",
+		DeviceType:  string(DeviceTypeRazerDeathAdder),
+		Battery:     &battery,
+		IsCharging:  &charging,
+		IsConnected: true,
+	}
+	state.MarkUpdated(protocol.TargetBattery, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	if err := store.Save(map[string]CachedDevice{"mouse": {Name: "DeathAdder", State: state}}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	entry, ok := loaded["mouse"]
+	if !ok {
+		t.Fatal("Saved device missing after load")
+	}
+	if entry.Name != "DeathAdder" {
+		t.Errorf("Name = %q, want DeathAdder", entry.Name)
+	}
+	if !entry.State.Equal(state) || !entry.State.LastSeen.Equal(state.LastSeen) {
+		t.Errorf("Loaded state = %v, want %v", entry.State, state)
+	}
+}
+
+func TestStateStore_MissingFile(t *testing.T) {
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatalf("Missing file should not error, got: %v", err)
+	}
+	if len(loaded) != 0 {
+		t.Errorf("Expected no devices, got %d", len(loaded))
+	}
+}
+
+func TestStateStore_Corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStateStore(path).Load(); err == nil {
+		t.Error("Expected error for corrupt state file")
+	}
+}
+
+func TestDeviceManager_LoadCachedStates(t *testing.T) {
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+	battery := 55
+	store.Save(map[string]CachedDevice{
+		"mouse": {Name: "DeathAdder", State: protocol.DeviceState{DeviceID: "mouse", Battery: &battery}},
+	})
+
+	dm := NewDeviceManager()
+	dm.SetStateStore(store)
+
+	received := make(map[string]protocol.DeviceState)
+	dm.SetOnStateChange(func(deviceID string, state protocol.DeviceState) {
+		received[deviceID] = state
+	})
+
+	if err := dm.LoadCachedStates(); err != nil {
+		t.Fatalf("LoadCachedStates failed: %v", err)
+	}
+
+	state, ok := received["mouse"]
+	if !ok {
+		t.Fatal("Cached state was not reported")
+	}
+	if !state.Cached {
+		t.Error("Loaded state should be marked as cached")
+	}
+
+	// The device is absent in this run, so it is still remembered
+	if _, ok := dm.GetCachedStates()["mouse"]; !ok {
+		t.Error("Absent device should be returned by GetCachedStates")
+	}
+}
+
+func TestDeviceManager_PersistsOnChange(t *testing.T) {
+	store := NewStateStore(filepath.Join(t.TempDir(), "state.json"))
+	dm := NewDeviceManager()
+	dm.SetStateStore(store)
+	dm.saveDelay = 50 * time.Millisecond
+
+	// Changes in quick succession are written together, after the delay
+	mouse, keyboard := 70, 50
+	dm.makeStateChangeHandler("mouse")(protocol.DeviceState{DeviceID: "mouse", Battery: &mouse})
+	dm.makeStateChangeHandler("keyboard")(protocol.DeviceState{DeviceID: "keyboard", Battery: &keyboard})
+	if _, err := os.Stat(store.Path()); err == nil {
+		t.Error("State should not be written from the state change callback")
+	}
+
+	var loaded map[string]CachedDevice
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var err error
+		if loaded, err = store.Load(); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if len(loaded) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("State was never persisted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	entry, ok := loaded["mouse"]
+	if !ok || entry.State.Battery == nil || *entry.State.Battery != 70 {
+		t.Errorf("Expected persisted battery 70, got %+v", loaded)
+	}
+	if _, ok := loaded["keyboard"]; !ok {
+		t.Errorf("Both changes should be written together, got %+v", loaded)
+	}
+	if entry.State.Cached {
+		t.Error("Persisted state should not be marked cached")
+	}
+}
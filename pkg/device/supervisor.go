@@ -0,0 +1,271 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Runner is implemented by devices whose monitoring loop can be supervised.
+// Run blocks until ctx is cancelled (returning nil) or the device fails.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// HealthStatus describes where a supervised device is in its lifecycle
+type HealthStatus string
+
+const (
+	HealthStarting HealthStatus = "starting"
+	HealthRunning  HealthStatus = "running"
+	HealthDegraded HealthStatus = "degraded" // Failed and waiting to restart
+	HealthFailed   HealthStatus = "failed"   // Circuit breaker open, restarts paused
+	HealthStopped  HealthStatus = "stopped"
+)
+
+// Health is the supervision status of a single device
+type Health struct {
+	Status    HealthStatus
+	Attempt   int       // Consecutive failed runs
+	LastError string    // Error from the most recent failure
+	Since     time.Time // When Status last changed
+}
+
+// String returns a short description such as "reconnecting (attempt 3)"
+func (h Health) String() string {
+	switch h.Status {
+	case HealthDegraded:
+		return fmt.Sprintf("reconnecting (attempt %d)", h.Attempt)
+	case HealthFailed:
+		if h.LastError != "" {
+			return fmt.Sprintf("failed: %s", h.LastError)
+		}
+		return "failed"
+	default:
+		return string(h.Status)
+	}
+}
+
+// RestartPolicy controls how a Supervisor restarts a failing device
+type RestartPolicy struct {
+	InitialBackoff time.Duration // Delay before the first restart
+	MaxBackoff     time.Duration // Upper bound for the exponential backoff
+	Multiplier     float64       // Backoff growth factor per consecutive failure
+	// MaxFailures opens the circuit breaker after this many consecutive
+	// failures. 0 retries forever.
+	MaxFailures int
+	// BreakerCooldown is how long the breaker stays open before a single
+	// trial restart is attempted
+	BreakerCooldown time.Duration
+	// StableAfter is how long a run must last to reset the failure count
+	StableAfter time.Duration
+	// StartupGrace is how long a run must last before it counts as running
+	StartupGrace time.Duration
+}
+
+// DefaultRestartPolicy returns the policy used when none is configured
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		InitialBackoff:  time.Second,
+		MaxBackoff:      2 * time.Minute,
+		Multiplier:      2,
+		MaxFailures:     8,
+		BreakerCooldown: 10 * time.Minute,
+		StableAfter:     5 * time.Minute,
+		StartupGrace:    2 * time.Second,
+	}
+}
+
+// backoff returns the delay before restart attempt n (1-based)
+func (p RestartPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		delay *= p.Multiplier
+		if p.MaxBackoff > 0 && delay >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(delay)
+}
+
+// Supervisor runs a device's monitoring loop, recovering panics and
+// restarting it with exponential backoff and a circuit breaker
+type Supervisor struct {
+	runner   Runner
+	name     string
+	policy   RestartPolicy
+	health   Health
+	onHealth func(Health)
+	cancel   context.CancelFunc
+	done     chan struct{}
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// NewSupervisor creates a supervisor for a device; name is used in logs
+func NewSupervisor(name string, runner Runner, policy RestartPolicy) *Supervisor {
+	return &Supervisor{
+		runner: runner,
+		name:   name,
+		policy: policy,
+		health: Health{Status: HealthStopped, Since: time.Now()},
+		now:    time.Now,
+	}
+}
+
+// SetOnHealthChange sets a callback invoked whenever the health changes
+func (s *Supervisor) SetOnHealthChange(callback func(Health)) {
+	s.mu.Lock()
+	s.onHealth = callback
+	s.mu.Unlock()
+}
+
+// Health returns the current supervision status
+func (s *Supervisor) Health() Health {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.health
+}
+
+// Start begins supervising in the background. Calling Start on a running
+// supervisor has no effect.
+func (s *Supervisor) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.loop(ctx, s.done)
+}
+
+// Stop cancels the device's run and waits for it to return
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel = nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+	s.setHealth(HealthStopped, 0, "")
+}
+
+// MarkAlive promotes a starting, reconnecting or trial-run device to running, e.g. when
+// it reports state or survives the startup grace period
+func (s *Supervisor) MarkAlive() {
+	s.mu.Lock()
+	status := s.health.Status
+	attempt, lastErr := s.health.Attempt, s.health.LastError
+	s.mu.Unlock()
+
+	if status == HealthStarting || status == HealthDegraded || status == HealthFailed {
+		s.setHealth(HealthRunning, attempt, lastErr)
+	}
+}
+
+func (s *Supervisor) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	failures := 0
+	for {
+		// Restarts keep reporting "reconnecting" until the run proves healthy
+		if failures == 0 {
+			s.setHealth(HealthStarting, 0, "")
+		}
+		started := s.now()
+
+		grace := time.AfterFunc(s.policy.StartupGrace, s.MarkAlive)
+		err := s.runOnce(ctx)
+		grace.Stop()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("monitoring loop exited unexpectedly")
+		}
+
+		if s.policy.StableAfter > 0 && s.now().Sub(started) >= s.policy.StableAfter {
+			failures = 0
+		}
+		failures++
+		log.Printf("%s failed (attempt %d): %v", s.name, failures, err)
+
+		if s.policy.MaxFailures > 0 && failures >= s.policy.MaxFailures {
+			log.Printf("%s failed %d times in a row, pausing restarts for %v", s.name, failures, s.policy.BreakerCooldown)
+			s.setHealth(HealthFailed, failures, err.Error())
+			if !sleepContext(ctx, s.policy.BreakerCooldown) {
+				return
+			}
+			// Half-open: allow one trial run; another failure reopens the breaker
+			failures = s.policy.MaxFailures - 1
+			continue
+		}
+
+		s.setHealth(HealthDegraded, failures, err.Error())
+		if !sleepContext(ctx, s.policy.backoff(failures)) {
+			return
+		}
+	}
+}
+
+// runOnce runs the device once, converting a panic into an error
+func (s *Supervisor) runOnce(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s panicked: %v\n%s", s.name, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return s.runner.Run(ctx)
+}
+
+func (s *Supervisor) setHealth(status HealthStatus, attempt int, lastErr string) {
+	s.mu.Lock()
+	if s.health.Status == status && s.health.Attempt == attempt && s.health.LastError == lastErr {
+		s.mu.Unlock()
+		return
+	}
+	s.health = Health{Status: status, Attempt: attempt, LastError: lastErr, Since: s.now()}
+	health := s.health
+	onHealth := s.onHealth
+	s.mu.Unlock()
+
+	if onHealth != nil {
+		onHealth(health)
+	}
+}
+
+// sleepContext waits for d, returning false if ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// recoverAsError runs fn, converting a panic into an error. It is used for
+// goroutines that a Supervisor cannot recover directly.
+func recoverAsError(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Recovered panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,286 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunner runs a scripted sequence of behaviours, one per call to Run
+type fakeRunner struct {
+	mu    sync.Mutex
+	calls int
+	runs  []func(ctx context.Context) error
+}
+
+func (f *fakeRunner) Run(ctx context.Context) error {
+	f.mu.Lock()
+	i := f.calls
+	f.calls++
+	f.mu.Unlock()
+
+	if i < len(f.runs) {
+		return f.runs[i](ctx)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (f *fakeRunner) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func failWith(msg string) func(context.Context) error {
+	return func(context.Context) error { return errors.New(msg) }
+}
+
+func testPolicy() RestartPolicy {
+	return RestartPolicy{
+		InitialBackoff:  time.Millisecond,
+		MaxBackoff:      4 * time.Millisecond,
+		Multiplier:      2,
+		MaxFailures:     3,
+		BreakerCooldown: time.Hour,
+		StartupGrace:    time.Hour,
+	}
+}
+
+// healthRecorder collects health transitions from a supervisor
+type healthRecorder struct {
+	mu      sync.Mutex
+	history []Health
+}
+
+func (h *healthRecorder) record(health Health) {
+	h.mu.Lock()
+	h.history = append(h.history, health)
+	h.mu.Unlock()
+}
+
+func (h *healthRecorder) waitFor(t *testing.T, status HealthStatus) Health {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		for _, health := range h.history {
+			if health.Status == status {
+				h.mu.Unlock()
+				return health
+			}
+		}
+		h.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for health %q", status)
+	return Health{}
+}
+
+func TestRestartPolicy_Backoff(t *testing.T) {
+	policy := RestartPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Multiplier: 2}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := policy.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestSupervisor_RestartsAfterFailure(t *testing.T) {
+	runner := &fakeRunner{runs: []func(context.Context) error{failWith("device unplugged")}}
+	recorder := &healthRecorder{}
+
+	s := NewSupervisor("test", runner, testPolicy())
+	s.SetOnHealthChange(recorder.record)
+	s.Start()
+	defer s.Stop()
+
+	degraded := recorder.waitFor(t, HealthDegraded)
+	if degraded.Attempt != 1 || degraded.LastError != "device unplugged" {
+		t.Errorf("Degraded health = %+v, want attempt 1 with error", degraded)
+	}
+	if got := degraded.String(); got != "reconnecting (attempt 1)" {
+		t.Errorf("String() = %q, want reconnecting (attempt 1)", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runner.Calls() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if runner.Calls() < 2 {
+		t.Fatal("Runner was not restarted")
+	}
+
+	s.MarkAlive()
+	if got := s.Health().Status; got != HealthRunning {
+		t.Errorf("Health after MarkAlive = %q, want running", got)
+	}
+}
+
+func TestSupervisor_RecoversPanic(t *testing.T) {
+	runner := &fakeRunner{runs: []func(context.Context) error{
+		func(context.Context) error { panic("nil map write") },
+	}}
+	recorder := &healthRecorder{}
+
+	s := NewSupervisor("test", runner, testPolicy())
+	s.SetOnHealthChange(recorder.record)
+	s.Start()
+	defer s.Stop()
+
+	degraded := recorder.waitFor(t, HealthDegraded)
+	if degraded.LastError != "panic: nil map write" {
+		t.Errorf("LastError = %q, want panic message", degraded.LastError)
+	}
+}
+
+func TestSupervisor_CircuitBreaker(t *testing.T) {
+	runner := &fakeRunner{runs: []func(context.Context) error{
+		failWith("one"), failWith("two"), failWith("three"), failWith("four"),
+	}}
+	recorder := &healthRecorder{}
+
+	s := NewSupervisor("test", runner, testPolicy())
+	s.SetOnHealthChange(recorder.record)
+	s.Start()
+	defer s.Stop()
+
+	failed := recorder.waitFor(t, HealthFailed)
+	if failed.Attempt != 3 {
+		t.Errorf("Breaker opened after %d failures, want 3", failed.Attempt)
+	}
+	if got := failed.String(); got != "failed: three" {
+		t.Errorf("String() = %q, want failed: three", got)
+	}
+
+	// The breaker stays open for the cooldown, so no further runs happen
+	time.Sleep(20 * time.Millisecond)
+	if calls := runner.Calls(); calls != 3 {
+		t.Errorf("Runner called %d times while breaker open, want 3", calls)
+	}
+}
+
+func TestSupervisor_StartupGrace(t *testing.T) {
+	policy := testPolicy()
+	policy.StartupGrace = time.Millisecond
+
+	recorder := &healthRecorder{}
+	s := NewSupervisor("test", &fakeRunner{}, policy)
+	s.SetOnHealthChange(recorder.record)
+	s.Start()
+	defer s.Stop()
+
+	recorder.waitFor(t, HealthStarting)
+	recorder.waitFor(t, HealthRunning)
+}
+
+func TestSupervisor_Stop(t *testing.T) {
+	runner := &fakeRunner{}
+	s := NewSupervisor("test", runner, testPolicy())
+	s.Start()
+	s.Start() // Second start is a no-op
+
+	s.Stop()
+	if got := s.Health().Status; got != HealthStopped {
+		t.Errorf("Health after Stop = %q, want stopped", got)
+	}
+	if calls := runner.Calls(); calls != 1 {
+		t.Errorf("Runner called %d times, want 1", calls)
+	}
+
+	// Stopping twice is safe
+	s.Stop()
+}
+
+func TestRecoverAsError(t *testing.T) {
+	err := recoverAsError(func() error { panic("boom") })
+	if err == nil || err.Error() != "panic: boom" {
+		t.Errorf("recoverAsError = %v, want panic: boom", err)
+	}
+
+	want := errors.New("read failed")
+	if err := recoverAsError(func() error { return want }); err != want {
+		t.Errorf("recoverAsError = %v, want %v", err, want)
+	}
+}
+
+func TestDeviceManager_SupervisesRunners(t *testing.T) {
+	dm := NewDeviceManager()
+	dm.SetRestartPolicy(testPolicy())
+
+	var mu sync.Mutex
+	var received []Health
+	dm.SetOnHealthChange(func(deviceID string, h Health) {
+		mu.Lock()
+		defer mu.Unlock()
+		if deviceID == "runner" {
+			received = append(received, h)
+		}
+	})
+
+	runner := &runnableDevice{mockHIDDevice: &mockHIDDevice{id: "runner", name: "Runner"}, runner: &fakeRunner{}}
+	dm.mu.Lock()
+	dm.devices["runner"] = runner
+	dm.mu.Unlock()
+
+	if err := dm.StartAll(); err != nil {
+		t.Fatalf("StartAll failed: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runner.runner.Calls() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if runner.runner.Calls() != 1 {
+		t.Fatalf("Runner called %d times, want 1", runner.runner.Calls())
+	}
+	if runner.started {
+		t.Error("Supervised device should not be started directly")
+	}
+
+	health := dm.GetDeviceHealth()
+	if got := health["runner"].Status; got != HealthStarting {
+		t.Errorf("Health = %q, want starting", got)
+	}
+
+	dm.StopAll()
+	if got := dm.GetDeviceHealth()["runner"].Status; got != HealthStopped {
+		t.Errorf("Health after StopAll = %q, want stopped", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) == 0 {
+		t.Error("Expected health change callbacks")
+	}
+}
+
+// runnableDevice is a mock device that can be supervised
+type runnableDevice struct {
+	*mockHIDDevice
+	runner  *fakeRunner
+	started bool
+}
+
+func (d *runnableDevice) Start() error {
+	d.started = true
+	return nil
+}
+
+func (d *runnableDevice) Run(ctx context.Context) error {
+	return d.runner.Run(ctx)
+}
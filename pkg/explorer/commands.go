@@ -0,0 +1,363 @@
+package explorer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+const helpText = `Report IDs are hex (b7 or 0xB7); offsets count bytes from the report ID at 0.
+
+  reports                                    Summarize the reports seen so far
+  annotate <report> <offset>[-<end>] <name> [big]
+                                             Name a field of a report
+  unannotate <report> <name>                 Remove a field
+  mute <report>, unmute <report>             Hide or show a report ID
+  interfaces                                 List the open interfaces
+  use <interface>                            Choose the interface reports are sent to
+  output <bytes>                             Send an output report, starting with the report ID
+  feature <bytes>                            Send a feature report
+  get <report> <length>                      Read a feature report of length bytes
+  save <file>                                Write annotated reports as a definition file
+  quit                                       Stop exploring
+`
+
+// execute runs one command line and reports whether exploring should stop
+func (e *Explorer) execute(line string) (bool, error) {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return false, nil
+	}
+
+	switch args[0] {
+	case "help":
+		e.printf("%s", helpText)
+	case "reports":
+		e.listReports()
+	case "interfaces":
+		e.listInterfaces()
+	case "annotate":
+		return false, e.annotate(args[1:])
+	case "unannotate":
+		return false, e.unannotate(args[1:])
+	case "mute", "unmute":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: %s <report>", args[0])
+		}
+		id, err := parseReportID(args[1])
+		if err != nil {
+			return false, err
+		}
+		e.muted[id] = args[0] == "mute"
+	case "use":
+		return false, e.use(args[1:])
+	case "output", "feature":
+		return false, e.send(args[0] == "feature", args[1:])
+	case "get":
+		return false, e.getFeature(args[1:])
+	case "save":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: save <file>")
+		}
+		return false, e.save(args[1])
+	case "quit", "exit":
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %q, type help for commands", args[0])
+	}
+	return false, nil
+}
+
+// listInterfaces prints the open interfaces, marking the one reports are
+// sent to
+func (e *Explorer) listInterfaces() {
+	for i, iface := range e.interfaces {
+		marker := ""
+		if i == e.target {
+			marker = " (sending)"
+		}
+		e.printf("[%d] %s %s, %s%s\n", i+1, iface.Path, iface.Name, iface.Product.BusName(), marker)
+	}
+}
+
+// listReports prints every report group with the offsets of the bytes that
+// have changed so far
+func (e *Explorer) listReports() {
+	keys := make([]groupKey, 0, len(e.groups))
+	for key := range e.groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.iface != b.iface {
+			return a.iface < b.iface
+		}
+		if a.feature != b.feature {
+			return !a.feature
+		}
+		return a.reportID < b.reportID
+	})
+
+	if len(keys) == 0 {
+		e.printf("No reports yet\n")
+	}
+	for _, key := range keys {
+		g := e.groups[key]
+		var changing []string
+		for i, c := range g.changed {
+			if c {
+				changing = append(changing, strconv.Itoa(i))
+			}
+		}
+		summary := "none changing"
+		if len(changing) > 0 {
+			summary = "changing " + strings.Join(changing, " ")
+		}
+
+		kind := "input"
+		if key.feature {
+			kind = "feature"
+		}
+		line := fmt.Sprintf("[%d] %s 0x%02X: %d received, %d bytes, %s", key.iface+1, kind, key.reportID, g.count, len(g.last), summary)
+		if def, ok := e.reports[key.reportID]; ok {
+			line += "  " + def.Name
+		}
+		if e.muted[key.reportID] {
+			line += " (muted)"
+		}
+		e.printf("%s\n", line)
+	}
+}
+
+// annotate adds or replaces a named field of a report
+func (e *Explorer) annotate(args []string) error {
+	if len(args) < 3 || len(args) > 4 {
+		return fmt.Errorf("usage: annotate <report> <offset>[-<end>] <name> [big]")
+	}
+	id, err := parseReportID(args[0])
+	if err != nil {
+		return err
+	}
+	offset, width, err := parseRange(args[1])
+	if err != nil {
+		return err
+	}
+	field := protocol.FieldDefinition{Name: args[2], Offset: offset}
+	if width > 1 {
+		field.Width = width
+	}
+	if len(args) == 4 {
+		field.Endian = args[3]
+	}
+
+	def, ok := e.reports[id]
+	if !ok {
+		def = protocol.ReportDefinition{Name: fmt.Sprintf("Report 0x%02X", id), ReportID: protocol.HexID(id)}
+	}
+	// Copy the fields, which may be shared with the definitions given to New
+	fields := slices.DeleteFunc(slices.Clone(def.Fields), func(f protocol.FieldDefinition) bool {
+		return f.Name == field.Name
+	})
+	def.Fields = append(fields, field)
+
+	check := protocol.DefinitionSet{Reports: []protocol.ReportDefinition{def}}
+	if err := check.Validate(); err != nil {
+		return err
+	}
+	e.reports[id] = def
+	e.annotated[id] = true
+
+	e.printf("Annotated 0x%02X %s at %s\n", id, field.Name, args[1])
+	if g, ok := e.groups[groupKey{iface: e.target, reportID: id}]; ok {
+		e.printf("Last report: %s\n", def.Describe(g.last))
+	}
+	return nil
+}
+
+// unannotate removes a named field of a report
+func (e *Explorer) unannotate(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: unannotate <report> <name>")
+	}
+	id, err := parseReportID(args[0])
+	if err != nil {
+		return err
+	}
+	def, ok := e.reports[id]
+	index := slices.IndexFunc(def.Fields, func(f protocol.FieldDefinition) bool { return f.Name == args[1] })
+	if !ok || index < 0 {
+		return fmt.Errorf("report 0x%02X has no field %q", id, args[1])
+	}
+
+	def.Fields = slices.Delete(slices.Clone(def.Fields), index, index+1)
+	if len(def.Fields) == 0 {
+		delete(e.reports, id)
+	} else {
+		e.reports[id] = def
+	}
+	e.annotated[id] = true
+	return nil
+}
+
+// use chooses the interface that reports are sent to
+func (e *Explorer) use(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: use <interface>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(e.interfaces) {
+		return fmt.Errorf("interface must be between 1 and %d", len(e.interfaces))
+	}
+	e.target = n - 1
+	e.listInterfaces()
+	return nil
+}
+
+// send writes an output or feature report to the chosen interface
+func (e *Explorer) send(feature bool, args []string) error {
+	report, err := parseBytes(args)
+	if err != nil {
+		return err
+	}
+	if len(report) == 0 {
+		return fmt.Errorf("no report given")
+	}
+
+	iface := e.interfaces[e.target]
+	kind := "output"
+	if feature {
+		kind = "feature"
+		err = iface.SendFeatureReport(report)
+	} else {
+		_, err = iface.Write(report)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send %s report: %w", kind, err)
+	}
+	e.printf("[%d] Sent %s report 0x%02X: % x\n", e.target+1, kind, report[0], report)
+	return nil
+}
+
+// getFeature reads a feature report from the chosen interface and shows it
+// like an input report
+func (e *Explorer) getFeature(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: get <report> <length>")
+	}
+	id, err := parseReportID(args[0])
+	if err != nil {
+		return err
+	}
+	length, err := strconv.Atoi(args[1])
+	if err != nil || length < 1 || length > maxReportSize {
+		return fmt.Errorf("length must be between 1 and %d", maxReportSize)
+	}
+
+	report, err := e.interfaces[e.target].GetFeatureReport(id, length)
+	if err != nil {
+		return err
+	}
+	e.record(e.target, true, report)
+	return nil
+}
+
+// save writes the annotated reports, including the fields they already had,
+// as a definition file that goarctis loads from its reports directory
+func (e *Explorer) save(path string) error {
+	ids := make([]byte, 0, len(e.annotated))
+	for id := range e.annotated {
+		if _, ok := e.reports[id]; ok {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no annotated reports to save")
+	}
+	slices.Sort(ids)
+
+	set := protocol.DefinitionSet{Name: "Explored reports"}
+	for _, id := range ids {
+		set.Reports = append(set.Reports, e.reports[id])
+	}
+	// The reports only apply to the explored products
+	for _, iface := range e.interfaces {
+		if !slices.Contains(set.Products, iface.Product) {
+			set.Products = append(set.Products, iface.Product)
+		}
+	}
+	// Commands are not run by a shell, so expand ~ here
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, rest)
+	}
+
+	data, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode definitions: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save definitions: %w", err)
+	}
+	e.printf("Saved %d reports to %s\n", len(ids), path)
+	return nil
+}
+
+// parseReportID parses a report ID written in hex, with or without 0x
+func parseReportID(s string) (byte, error) {
+	n, err := strconv.ParseUint(trimHexPrefix(s), 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid report ID %q", s)
+	}
+	return byte(n), nil
+}
+
+// parseRange parses a byte offset or an inclusive range such as "3-4" into
+// an offset and width
+func parseRange(s string) (int, int, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	start, err := strconv.Atoi(first)
+	end := start
+	if err == nil && isRange {
+		end, err = strconv.Atoi(last)
+	}
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid offset %q", s)
+	}
+	return start, end - start + 1, nil
+}
+
+// parseBytes parses hex bytes, either separate ("b0 1 0x02") or run
+// together ("b00102")
+func parseBytes(args []string) ([]byte, error) {
+	var report []byte
+	for _, arg := range args {
+		digits := trimHexPrefix(arg)
+		if len(digits) == 1 {
+			digits = "0" + digits
+		}
+		b, err := hex.DecodeString(digits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bytes %q", arg)
+		}
+		report = append(report, b...)
+	}
+	return report, nil
+}
+
+func trimHexPrefix(s string) string {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return s[2:]
+	}
+	return s
+}
@@ -0,0 +1,291 @@
+// Package explorer watches the raw reports of hidraw devices to help map
+// unknown report fields. Reports are grouped by report ID with the bytes that
+// changed since the previous report highlighted, fields can be annotated and
+// saved as report definitions, and arbitrary reports can be sent.
+package explorer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jyablonski/goarctis/pkg/hidraw"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+// maxReportSize bounds the buffer used to read input reports
+const maxReportSize = 4096
+
+// Interface is an open hidraw node being explored
+type Interface struct {
+	hidraw.Device
+	Path    string
+	Name    string
+	Product protocol.ProductMatch // HeaderLength bytes are stripped from input reports
+}
+
+// Matcher returns the product an identified hidraw node belongs to
+type Matcher func(info hidraw.Info, name string) (protocol.ProductMatch, bool)
+
+// Open opens the hidraw nodes at paths and keeps those accepted by match
+func Open(paths []string, open hidraw.Opener, match Matcher) ([]Interface, error) {
+	var found []Interface
+	var openErr error
+	for _, path := range paths {
+		dev, err := open(path)
+		if err != nil {
+			openErr = err
+			continue
+		}
+		info, err := dev.Info()
+		if err != nil {
+			dev.Close()
+			continue
+		}
+		name, _ := dev.Name()
+		product, ok := match(info, name)
+		if !ok {
+			dev.Close()
+			continue
+		}
+		found = append(found, Interface{Device: dev, Path: path, Name: name, Product: product})
+	}
+
+	if len(found) == 0 {
+		if openErr != nil {
+			// Nodes without read permission cannot be identified
+			return nil, fmt.Errorf("no matching hidraw interface found: %w", openErr)
+		}
+		return nil, fmt.Errorf("no matching hidraw interface found")
+	}
+	return found, nil
+}
+
+// groupKey identifies the reports compared with each other
+type groupKey struct {
+	iface    int
+	feature  bool
+	reportID byte
+}
+
+// group is the history of one report on one interface
+type group struct {
+	last    []byte
+	count   int
+	changed []bool // Bytes that changed at least once
+}
+
+// Explorer shows reports and runs commands. It is not safe for concurrent
+// use; Run serializes reports and commands.
+type Explorer struct {
+	interfaces []Interface
+	reports    map[byte]protocol.ReportDefinition // Known and annotated reports
+	annotated  map[byte]bool                      // Reports changed by annotate, written by save
+	groups     map[groupKey]*group
+	muted      map[byte]bool
+	target     int // Interface that reports are sent to
+	color      bool
+	out        io.Writer
+}
+
+// New creates an explorer for the given interfaces. Reports described in
+// defs are decoded as they arrive and can be annotated further.
+func New(interfaces []Interface, defs *protocol.DefinitionSet, out io.Writer) *Explorer {
+	reports := make(map[byte]protocol.ReportDefinition, len(defs.Reports))
+	for _, report := range defs.Reports {
+		reports[byte(report.ReportID)] = report
+	}
+	return &Explorer{
+		interfaces: interfaces,
+		reports:    reports,
+		annotated:  make(map[byte]bool),
+		groups:     make(map[groupKey]*group),
+		muted:      make(map[byte]bool),
+		out:        out,
+	}
+}
+
+// SetColor highlights changed bytes with terminal colors instead of brackets
+func (e *Explorer) SetColor(enabled bool) {
+	e.color = enabled
+}
+
+// received is an input report, or the error that ended reading an interface
+type received struct {
+	iface  int
+	report []byte
+	err    error
+}
+
+// Run shows the reports of every interface and executes commands read from
+// commands, one per line, until ctx is cancelled, commands ends or quit is
+// given. The interfaces are closed when Run returns.
+func (e *Explorer) Run(ctx context.Context, commands io.Reader) error {
+	reports := make(chan received)
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		for _, iface := range e.interfaces {
+			iface.Close()
+		}
+	}()
+
+	for i, iface := range e.interfaces {
+		go readReports(i, iface, reports, done)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(commands)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	e.printf("Exploring %d interfaces. Type help for commands.\n", len(e.interfaces))
+	e.listInterfaces()
+
+	open := len(e.interfaces)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case r := <-reports:
+			if r.err != nil {
+				e.printf("[%d] Stopped reading: %v\n", r.iface+1, r.err)
+				if open--; open == 0 {
+					return fmt.Errorf("no interface left to read from")
+				}
+				continue
+			}
+			e.record(r.iface, false, r.report)
+
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+			quit, err := e.execute(line)
+			if err != nil {
+				e.printf("Error: %v\n", err)
+			}
+			if quit {
+				return nil
+			}
+		}
+	}
+}
+
+// readReports reads input reports from an interface until it fails or done
+// is closed
+func readReports(index int, iface Interface, reports chan<- received, done <-chan struct{}) {
+	buf := make([]byte, maxReportSize)
+	for {
+		n, err := iface.Read(buf)
+		r := received{iface: index, err: err}
+		if err == nil {
+			r.report = append([]byte(nil), buf[:n]...)
+		}
+		select {
+		case reports <- r:
+		case <-done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// record adds a report to its group and prints it unless it repeats the
+// previous report of the group or its report ID is muted. Feature reports
+// are always printed, since they are only read on request.
+func (e *Explorer) record(iface int, feature bool, report []byte) {
+	if header := e.interfaces[iface].Product.HeaderLength; !feature && header > 0 {
+		if len(report) <= header {
+			return
+		}
+		report = report[header:]
+	}
+	if len(report) == 0 {
+		return
+	}
+
+	key := groupKey{iface: iface, feature: feature, reportID: report[0]}
+	g, seen := e.groups[key]
+	if !seen {
+		g = &group{}
+		e.groups[key] = g
+	}
+	g.count++
+
+	var changed []bool
+	if seen {
+		changed = diff(g.last, report)
+	}
+	for len(g.changed) < len(report) {
+		g.changed = append(g.changed, false)
+	}
+	for i, c := range changed {
+		g.changed[i] = g.changed[i] || c
+	}
+	repeated := seen && bytes.Equal(g.last, report)
+	g.last = report
+
+	if (repeated && !feature) || e.muted[key.reportID] {
+		return
+	}
+	e.printf("%s\n", e.formatReport(key, g.count, report, changed))
+}
+
+// diff reports which bytes of report differ from prev. Bytes beyond the end
+// of prev count as changed.
+func diff(prev, report []byte) []bool {
+	changed := make([]bool, len(report))
+	for i := range report {
+		changed[i] = i >= len(prev) || prev[i] != report[i]
+	}
+	return changed
+}
+
+// formatReport prints a report as hex with its changed bytes highlighted,
+// followed by the values of its known fields
+func (e *Explorer) formatReport(key groupKey, count int, report []byte, changed []bool) string {
+	var b strings.Builder
+	kind := "input"
+	if key.feature {
+		kind = "feature"
+	}
+	fmt.Fprintf(&b, "[%d] %s 0x%02X #%d:", key.iface+1, kind, key.reportID, count)
+
+	for i, v := range report {
+		switch {
+		case changed == nil || !changed[i]:
+			fmt.Fprintf(&b, " %02x", v)
+		case e.color:
+			fmt.Fprintf(&b, " \x1b[1;33m%02x\x1b[0m", v)
+		default:
+			fmt.Fprintf(&b, " [%02x]", v)
+		}
+	}
+
+	if def, ok := e.reports[key.reportID]; ok {
+		fmt.Fprintf(&b, "  %s", def.Name)
+		if fields := def.Describe(report); fields != "" {
+			fmt.Fprintf(&b, ": %s", fields)
+		}
+	}
+	return b.String()
+}
+
+func (e *Explorer) printf(format string, args ...any) {
+	fmt.Fprintf(e.out, format, args...)
+}
@@ -0,0 +1,272 @@
+package explorer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jyablonski/goarctis/pkg/hidraw"
+	"github.com/jyablonski/goarctis/pkg/protocol"
+)
+
+var (
+	gamebudsInfo    = hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x1038, ProductID: 0x230A}
+	gamebudsProduct = protocol.ProductMatch{VendorID: 0x1038, ProductID: 0x230A}
+)
+
+// newTestExplorer explores one fake GameBuds interface with the built-in
+// definitions, writing to a buffer without colors
+func newTestExplorer() (*Explorer, *hidraw.Fake, *bytes.Buffer) {
+	fake := hidraw.NewFake(gamebudsInfo, "SteelSeries Arctis GameBuds")
+	out := &bytes.Buffer{}
+	interfaces := []Interface{{Device: fake, Path: "/dev/hidraw3", Name: "SteelSeries Arctis GameBuds", Product: gamebudsProduct}}
+	return New(interfaces, protocol.BuiltinDefinitions(), out), fake, out
+}
+
+// run executes commands, failing the test on the first error
+func run(t *testing.T, e *Explorer, commands ...string) {
+	t.Helper()
+	for _, command := range commands {
+		if _, err := e.execute(command); err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fakes := map[string]*hidraw.Fake{
+		"/dev/hidraw0": hidraw.NewFake(hidraw.Info{Bus: hidraw.BusUSB, VendorID: 0x046D}, "Logitech Mouse"),
+		"/dev/hidraw1": hidraw.NewFake(gamebudsInfo, "SteelSeries Arctis GameBuds"),
+	}
+	open := func(path string) (hidraw.Device, error) {
+		if fake, ok := fakes[path]; ok {
+			return fake, nil
+		}
+		return nil, os.ErrPermission
+	}
+	defs := protocol.BuiltinDefinitions()
+	match := func(info hidraw.Info, name string) (protocol.ProductMatch, bool) {
+		return defs.MatchProduct(info.Bus, info.VendorID, info.ProductID, name)
+	}
+
+	interfaces, err := Open([]string{"/dev/hidraw0", "/dev/hidraw1", "/dev/hidraw2"}, open, match)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if len(interfaces) != 1 || interfaces[0].Path != "/dev/hidraw1" {
+		t.Fatalf("Interfaces = %v, want only /dev/hidraw1", interfaces)
+	}
+	if _, err := fakes["/dev/hidraw0"].Write([]byte{0}); err == nil {
+		t.Error("Interfaces that do not match should be closed")
+	}
+
+	// Nodes that cannot be opened explain why nothing matched
+	if _, err := Open([]string{"/dev/hidraw2"}, open, match); err == nil || !strings.Contains(err.Error(), "permission") {
+		t.Errorf("Open = %v, want the permission error", err)
+	}
+}
+
+func TestExplorer_Record(t *testing.T) {
+	e, _, out := newTestExplorer()
+
+	e.record(0, false, []byte{0xB7, 0x57, 0x50})
+	e.record(0, false, []byte{0xB7, 0x57, 0x50}) // Repeats are counted but not shown
+	e.record(0, false, []byte{0xB7, 0x56, 0x50})
+	e.record(0, false, []byte{0x42, 0x01, 0x02, 0x03})
+	e.record(0, false, []byte{0x42, 0x01, 0x02, 0x03, 0x04})
+
+	want := []string{
+		"[1] input 0xB7 #1: b7 57 50  Battery: left=87, right=80",
+		"[1] input 0xB7 #3: b7 [56] 50  Battery: left=86, right=80",
+		"[1] input 0x42 #1: 42 01 02 03",
+		"[1] input 0x42 #2: 42 01 02 03 [04]",
+	}
+	if got := strings.Split(strings.TrimSpace(out.String()), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Output:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	out.Reset()
+	run(t, e, "reports")
+	if !strings.Contains(out.String(), "[1] input 0xB7: 3 received, 3 bytes, changing 1  Battery") ||
+		!strings.Contains(out.String(), "[1] input 0x42: 2 received, 5 bytes, changing 4") {
+		t.Errorf("Reports:\n%s", out.String())
+	}
+
+	// Muted reports are still counted
+	out.Reset()
+	run(t, e, "mute 42")
+	e.record(0, false, []byte{0x42, 0x09})
+	if out.Len() != 0 {
+		t.Errorf("Muted report was shown: %s", out.String())
+	}
+	if g := e.groups[groupKey{reportID: 0x42}]; g.count != 3 {
+		t.Errorf("Count = %d, want 3", g.count)
+	}
+}
+
+func TestExplorer_HeaderLength(t *testing.T) {
+	e, _, out := newTestExplorer()
+	e.interfaces[0].Product.HeaderLength = 2
+
+	e.record(0, false, []byte{0xFF, 0x00, 0xBD, 0x02})
+	e.record(0, false, []byte{0xFF})
+	if got := strings.TrimSpace(out.String()); got != "[1] input 0xBD #1: bd 02  ANC mode: mode=active" {
+		t.Errorf("Output = %q, want the report without its header", got)
+	}
+}
+
+func TestExplorer_Annotate(t *testing.T) {
+	e, _, out := newTestExplorer()
+	e.record(0, false, []byte{0xB7, 0x57, 0x50, 0x21})
+
+	run(t, e, "annotate 0xb7 3 case")
+	if !strings.Contains(out.String(), "Last report: left=87, right=80, case=33") {
+		t.Errorf("Annotating should decode the last report:\n%s", out.String())
+	}
+
+	run(t, e, "annotate C8 1-2 counter big", "annotate c8 3 flags", "unannotate c8 flags")
+	out.Reset()
+	e.record(0, false, []byte{0xC8, 0x01, 0x02, 0x03})
+	if got := strings.TrimSpace(out.String()); got != "[1] input 0xC8 #1: c8 01 02 03  Report 0xC8: counter=258" {
+		t.Errorf("Output = %q", got)
+	}
+
+	// The built-in definitions are not modified
+	if len(protocol.BuiltinDefinitions().Reports[0].Fields) != 2 {
+		t.Error("Annotating changed the built-in definitions")
+	}
+
+	// Saved reports keep their existing fields and can be loaded again
+	path := filepath.Join(t.TempDir(), "explored.json")
+	run(t, e, "save "+path)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	saved, err := protocol.ParseDefinitions(f)
+	if err != nil {
+		t.Fatalf("Saved definitions are invalid: %v", err)
+	}
+	if len(saved.Reports) != 2 || len(saved.Reports[0].Fields) != 3 || saved.Reports[0].Fields[0].Target != protocol.TargetLeftBattery {
+		t.Errorf("Saved reports = %+v, want 0xB7 with its targets and 0xC8", saved.Reports)
+	}
+	if len(saved.Products) != 1 || saved.Products[0] != gamebudsProduct {
+		t.Errorf("Saved products = %+v, want the explored GameBuds", saved.Products)
+	}
+}
+
+func TestExplorer_Send(t *testing.T) {
+	e, fake, out := newTestExplorer()
+	fake.Features[0x06] = []byte{0x06, 0x01, 0x02}
+
+	run(t, e, "output b0 1 0x02", "feature 0510ff", "get 06 8", "get 06 8")
+	if outputs := fake.Outputs(); len(outputs) != 1 || !bytes.Equal(outputs[0], []byte{0xB0, 0x01, 0x02}) {
+		t.Errorf("Outputs = %x, want b00102", outputs)
+	}
+	if !bytes.Equal(fake.Features[0x05], []byte{0x05, 0x10, 0xFF}) {
+		t.Errorf("Feature report = %x, want 0510ff", fake.Features[0x05])
+	}
+	// Feature reports are shown every time they are read
+	if got := strings.Count(out.String(), "[1] feature 0x06"); got != 2 {
+		t.Errorf("Feature report shown %d times, want 2:\n%s", got, out.String())
+	}
+}
+
+func TestExplorer_InvalidCommands(t *testing.T) {
+	tests := []struct {
+		command string
+		wantErr string
+	}{
+		{"dance", "unknown command"},
+		{"annotate zz 1 x", "invalid report ID"},
+		{"annotate b7 0 x", "offset must be at least 1"},
+		{"annotate b7 1-6 x", "width must be between 1 and 4"},
+		{"annotate b7 2-1 x", "invalid offset"},
+		{"annotate b7 1 x sideways", "endian"},
+		{"unannotate b7 case", "has no field"},
+		{"output b0 xyz", "invalid bytes"},
+		{"output", "no report given"},
+		{"use 2", "between 1 and 1"},
+		{"get 06 8", "no such report"},
+		{"save /nonexistent/file.json", "no annotated reports"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			e, _, _ := newTestExplorer()
+			if _, err := e.execute(tt.command); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("execute(%q) = %v, want error containing %q", tt.command, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// syncBuffer is a bytes.Buffer that Run can write to while a test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestExplorer_Run(t *testing.T) {
+	fake := hidraw.NewFake(gamebudsInfo, "SteelSeries Arctis GameBuds")
+	fake.Respond = func(output []byte) [][]byte {
+		return [][]byte{{0xBD, output[1]}}
+	}
+	out := &syncBuffer{}
+	e := New([]Interface{{Device: fake, Path: "/dev/hidraw3"}}, protocol.BuiltinDefinitions(), out)
+
+	commands, input := io.Pipe()
+	result := make(chan error, 1)
+	go func() { result <- e.Run(context.Background(), commands) }()
+
+	waitFor := func(text string) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !strings.Contains(out.String(), text) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %q in:\n%s", text, out.String())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	fake.Inject([]byte{0xB7, 0x57, 0x50})
+	waitFor("left=87, right=80")
+
+	// The reply to an output report shows up as an input report
+	fmt.Fprintln(input, "output bd 01")
+	waitFor("[1] input 0xBD #1: bd 01  ANC mode: mode=transparency")
+
+	fmt.Fprintln(input, "quit")
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Run = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after quit")
+	}
+	if _, err := fake.Write([]byte{0}); err == nil {
+		t.Error("Run should close the interfaces")
+	}
+}
@@ -0,0 +1,338 @@
+package hidraw
+
+import (
+	"fmt"
+)
+
+// Usage is a HID usage: the usage page in the high 16 bits and the usage ID
+// in the low 16 bits
+type Usage uint32
+
+// MakeUsage combines a usage page and usage ID
+func MakeUsage(page, id uint16) Usage {
+	return Usage(uint32(page)<<16 | uint32(id))
+}
+
+// Page returns the usage page
+func (u Usage) Page() uint16 { return uint16(u >> 16) }
+
+// ID returns the usage ID within its page
+func (u Usage) ID() uint16 { return uint16(u) }
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%04X:%04X", u.Page(), u.ID())
+}
+
+// Usages describing batteries, from the Generic Device Controls (0x06) and
+// Battery System (0x85) pages
+var (
+	UsageBatteryStrength       = MakeUsage(0x06, 0x20)
+	UsageCharging              = MakeUsage(0x85, 0x44)
+	UsageDischarging           = MakeUsage(0x85, 0x45)
+	UsageRelativeStateOfCharge = MakeUsage(0x85, 0x64)
+	UsageAbsoluteStateOfCharge = MakeUsage(0x85, 0x65)
+	UsageRemainingCapacity     = MakeUsage(0x85, 0x66)
+	UsageACPresent             = MakeUsage(0x85, 0xD0)
+)
+
+// ReportType is the kind of report a field belongs to
+type ReportType int
+
+const (
+	InputReport ReportType = iota
+	OutputReport
+	FeatureReport
+)
+
+func (t ReportType) String() string {
+	switch t {
+	case InputReport:
+		return "input"
+	case OutputReport:
+		return "output"
+	case FeatureReport:
+		return "feature"
+	default:
+		return "unknown"
+	}
+}
+
+// Main item flag bits
+const (
+	FlagConstant = 1 << 0
+	FlagVariable = 1 << 1
+)
+
+// maxReportBits bounds the size of a single field, guarding against
+// corrupt descriptors
+const maxReportBits = 8 * maxDescriptorSize
+
+// Field is one Input, Output or Feature main item: Count values of Size
+// bits each, starting Offset bits into the report data after the report ID
+type Field struct {
+	Type       ReportType
+	ReportID   byte // 0 when the device does not use report IDs
+	Offset     int
+	Size       int
+	Count      int
+	Usages     []Usage // Usages in declaration order; for variable fields the last one repeats
+	LogicalMin int32
+	LogicalMax int32
+	Flags      uint32
+}
+
+// Variable reports whether each value has its own usage, as opposed to an
+// array of selected usages
+func (f Field) Variable() bool {
+	return f.Flags&FlagVariable != 0
+}
+
+// UsageIndex returns the index of the value reporting usage u, or -1. Only
+// variable fields map usages to values.
+func (f Field) UsageIndex(u Usage) int {
+	if !f.Variable() || f.Flags&FlagConstant != 0 {
+		return -1
+	}
+	for i := 0; i < f.Count; i++ {
+		if f.usageAt(i) == u {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f Field) usageAt(index int) Usage {
+	if len(f.Usages) == 0 {
+		return 0
+	}
+	if index >= len(f.Usages) {
+		return f.Usages[len(f.Usages)-1]
+	}
+	return f.Usages[index]
+}
+
+// Value extracts value index from a report. The report starts with the
+// report ID byte when the field has one. Returns false when the report is
+// for a different report ID or too short.
+func (f Field) Value(report []byte, index int) (int32, bool) {
+	if index < 0 || index >= f.Count || f.Size <= 0 || f.Size > 32 {
+		return 0, false
+	}
+	data := report
+	if f.ReportID != 0 {
+		if len(report) == 0 || report[0] != f.ReportID {
+			return 0, false
+		}
+		data = report[1:]
+	}
+
+	start := f.Offset + index*f.Size
+	if (start+f.Size+7)/8 > len(data) {
+		return 0, false
+	}
+
+	// Values are packed little-endian, least significant bit first
+	var raw uint32
+	for bit := 0; bit < f.Size; bit++ {
+		pos := start + bit
+		if data[pos/8]&(1<<(pos%8)) != 0 {
+			raw |= 1 << bit
+		}
+	}
+
+	value := int32(raw)
+	if f.LogicalMin < 0 && f.Size < 32 && raw&(1<<(f.Size-1)) != 0 {
+		value = int32(raw | ^uint32(0)<<f.Size)
+	}
+	return value, true
+}
+
+// ReportLength returns the length in bytes of a report, including the
+// report ID byte if the report has one, or 0 if no field belongs to it
+func ReportLength(fields []Field, reportType ReportType, reportID byte) int {
+	bits := 0
+	for _, f := range fields {
+		if f.Type == reportType && f.ReportID == reportID {
+			if end := f.Offset + f.Size*f.Count; end > bits {
+				bits = end
+			}
+		}
+	}
+	if bits == 0 {
+		return 0
+	}
+	length := (bits + 7) / 8
+	if reportID != 0 {
+		length++
+	}
+	return length
+}
+
+// globalState holds the global items that apply to following main items
+type globalState struct {
+	usagePage   uint16
+	logicalMin  int32
+	logicalMax  int32
+	reportSize  int
+	reportCount int
+	reportID    byte
+}
+
+// localUsage is a usage as written in the descriptor; short usages take
+// their page from the global state when the main item is reached
+type localUsage struct {
+	value    uint32
+	extended bool
+}
+
+type reportKey struct {
+	reportType ReportType
+	reportID   byte
+}
+
+// ParseDescriptor parses a HID report descriptor into its fields
+func ParseDescriptor(desc []byte) ([]Field, error) {
+	var (
+		fields   []Field
+		global   globalState
+		stack    []globalState
+		usages   []localUsage
+		usageMin *localUsage
+		usageMax *localUsage
+		offsets  = make(map[reportKey]int)
+	)
+
+	for i := 0; i < len(desc); {
+		prefix := desc[i]
+
+		// Long items carry vendor data and are skipped
+		if prefix == 0xFE {
+			if i+1 >= len(desc) {
+				return nil, fmt.Errorf("truncated long item at offset %d", i)
+			}
+			i += 3 + int(desc[i+1])
+			continue
+		}
+
+		size := [4]int{0, 1, 2, 4}[prefix&0x03]
+		itemType := (prefix >> 2) & 0x03
+		tag := prefix >> 4
+		if i+1+size > len(desc) {
+			return nil, fmt.Errorf("truncated item 0x%02X at offset %d", prefix, i)
+		}
+		data := desc[i+1 : i+1+size]
+		i += 1 + size
+
+		switch itemType {
+		case 0: // Main
+			switch tag {
+			case 0x8, 0x9, 0xB: // Input, Output, Feature
+				reportType := map[byte]ReportType{0x8: InputReport, 0x9: OutputReport, 0xB: FeatureReport}[tag]
+				if global.reportSize > maxReportBits || global.reportCount > maxReportBits ||
+					global.reportSize*global.reportCount > maxReportBits {
+					return nil, fmt.Errorf("field of %d x %d bits at offset %d is too large", global.reportCount, global.reportSize, i)
+				}
+
+				field := Field{
+					Type:       reportType,
+					ReportID:   global.reportID,
+					Size:       global.reportSize,
+					Count:      global.reportCount,
+					Usages:     resolveUsages(usages, usageMin, usageMax, global.usagePage, global.reportCount),
+					LogicalMin: global.logicalMin,
+					LogicalMax: global.logicalMax,
+					Flags:      unsignedData(data),
+				}
+				key := reportKey{reportType, global.reportID}
+				field.Offset = offsets[key]
+				offsets[key] += field.Size * field.Count
+				fields = append(fields, field)
+			}
+			// Every main item, including collections, clears the local state
+			usages, usageMin, usageMax = nil, nil, nil
+
+		case 1: // Global
+			switch tag {
+			case 0x0:
+				global.usagePage = uint16(unsignedData(data))
+			case 0x1:
+				global.logicalMin = signedData(data)
+			case 0x2:
+				global.logicalMax = signedData(data)
+				// Maxima such as 255 are often written without a sign byte
+				if global.logicalMin >= 0 && global.logicalMax < 0 {
+					global.logicalMax = int32(unsignedData(data))
+				}
+			case 0x7:
+				global.reportSize = int(unsignedData(data))
+			case 0x8:
+				global.reportID = byte(unsignedData(data))
+			case 0x9:
+				global.reportCount = int(unsignedData(data))
+			case 0xA:
+				stack = append(stack, global)
+			case 0xB:
+				if len(stack) == 0 {
+					return nil, fmt.Errorf("pop without push at offset %d", i)
+				}
+				global = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+
+		case 2: // Local
+			usage := localUsage{value: unsignedData(data), extended: size == 4}
+			switch tag {
+			case 0x0:
+				usages = append(usages, usage)
+			case 0x1:
+				usageMin = &usage
+			case 0x2:
+				usageMax = &usage
+			}
+		}
+	}
+
+	return fields, nil
+}
+
+// resolveUsages applies the usage page to short usages and expands a usage
+// range, bounded by the number of values in the field
+func resolveUsages(usages []localUsage, usageMin, usageMax *localUsage, page uint16, count int) []Usage {
+	resolve := func(u localUsage) Usage {
+		if u.extended {
+			return Usage(u.value)
+		}
+		return MakeUsage(page, uint16(u.value))
+	}
+
+	var resolved []Usage
+	for _, u := range usages {
+		resolved = append(resolved, resolve(u))
+	}
+	if usageMin != nil && usageMax != nil {
+		for u := resolve(*usageMin); u <= resolve(*usageMax) && len(resolved) < count; u++ {
+			resolved = append(resolved, u)
+		}
+	}
+	return resolved
+}
+
+func unsignedData(data []byte) uint32 {
+	var value uint32
+	for i, b := range data {
+		value |= uint32(b) << (8 * i)
+	}
+	return value
+}
+
+func signedData(data []byte) int32 {
+	value := unsignedData(data)
+	switch len(data) {
+	case 1:
+		return int32(int8(value))
+	case 2:
+		return int32(int16(value))
+	default:
+		return int32(value)
+	}
+}
@@ -0,0 +1,184 @@
+package hidraw
+
+import (
+	"testing"
+)
+
+// mouseDescriptor has buttons and a Battery Strength byte in input report 1
+var mouseDescriptor = []byte{
+	0x05, 0x01, // Usage Page (Generic Desktop)
+	0x09, 0x02, // Usage (Mouse)
+	0xA1, 0x01, // Collection (Application)
+	0x85, 0x01, //   Report ID (1)
+	0x05, 0x09, //   Usage Page (Button)
+	0x19, 0x01, //   Usage Minimum (1)
+	0x29, 0x03, //   Usage Maximum (3)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x95, 0x03, //   Report Count (3)
+	0x75, 0x01, //   Report Size (1)
+	0x81, 0x02, //   Input (Data, Variable, Absolute)
+	0x95, 0x01, //   Report Count (1)
+	0x75, 0x05, //   Report Size (5)
+	0x81, 0x01, //   Input (Constant)
+	0x05, 0x06, //   Usage Page (Generic Device Controls)
+	0x09, 0x20, //   Usage (Battery Strength)
+	0x26, 0xFF, 0x00, // Logical Maximum (255)
+	0x75, 0x08, //   Report Size (8)
+	0x81, 0x02, //   Input (Data, Variable, Absolute)
+	0xC0, // End Collection
+}
+
+// upsDescriptor has Battery System values in feature report 12
+var upsDescriptor = []byte{
+	0x05, 0x84, // Usage Page (Power Device)
+	0x09, 0x04, // Usage (UPS)
+	0xA1, 0x01, // Collection (Application)
+	0x85, 0x0C, //   Report ID (12)
+	0x05, 0x85, //   Usage Page (Battery System)
+	0x09, 0x64, //   Usage (Relative State of Charge)
+	0x15, 0x00, //   Logical Minimum (0)
+	0x25, 0x64, //   Logical Maximum (100)
+	0x75, 0x08, //   Report Size (8)
+	0x95, 0x01, //   Report Count (1)
+	0xB1, 0x02, //   Feature (Data, Variable, Absolute)
+	0xA4,       //   Push
+	0x09, 0x44, //   Usage (Charging)
+	0x09, 0xD0, //   Usage (AC Present)
+	0x25, 0x01, //   Logical Maximum (1)
+	0x75, 0x01, //   Report Size (1)
+	0x95, 0x02, //   Report Count (2)
+	0xB1, 0x02, //   Feature (Data, Variable, Absolute)
+	0xB4,       //   Pop
+	0x75, 0x06, //   Report Size (6), after the pop restored size 8
+	0xB1, 0x01, //   Feature (Constant)
+	0xC0, // End Collection
+}
+
+func findUsage(fields []Field, u Usage) (Field, int, bool) {
+	for _, f := range fields {
+		if i := f.UsageIndex(u); i >= 0 {
+			return f, i, true
+		}
+	}
+	return Field{}, -1, false
+}
+
+func TestParseDescriptor_BatteryStrength(t *testing.T) {
+	fields, err := ParseDescriptor(mouseDescriptor)
+	if err != nil {
+		t.Fatalf("ParseDescriptor failed: %v", err)
+	}
+	if len(fields) != 3 {
+		t.Fatalf("Expected 3 fields, got %d", len(fields))
+	}
+
+	field, index, ok := findUsage(fields, UsageBatteryStrength)
+	if !ok {
+		t.Fatal("Battery Strength usage not found")
+	}
+	if field.Type != InputReport || field.ReportID != 1 || field.Offset != 8 || field.Size != 8 {
+		t.Errorf("Battery field = %+v, want input report 1 at bit 8", field)
+	}
+	if field.LogicalMax != 255 {
+		t.Errorf("LogicalMax = %d, want 255 from an unsigned byte", field.LogicalMax)
+	}
+
+	report := []byte{0x01, 0x05, 0x80}
+	if value, ok := field.Value(report, index); !ok || value != 128 {
+		t.Errorf("Battery value = %d (%v), want 128", value, ok)
+	}
+	if _, ok := field.Value([]byte{0x02, 0x05, 0x80}, index); ok {
+		t.Error("Value should reject a report with another report ID")
+	}
+
+	buttons := fields[0]
+	for i, want := range []int32{1, 0, 1} {
+		if value, _ := buttons.Value(report, i); value != want {
+			t.Errorf("Button %d = %d, want %d", i+1, value, want)
+		}
+	}
+	if got := ReportLength(fields, InputReport, 1); got != 3 {
+		t.Errorf("ReportLength = %d, want 3", got)
+	}
+}
+
+func TestParseDescriptor_BatterySystem(t *testing.T) {
+	fields, err := ParseDescriptor(upsDescriptor)
+	if err != nil {
+		t.Fatalf("ParseDescriptor failed: %v", err)
+	}
+
+	report := []byte{0x0C, 87, 0x02}
+	tests := []struct {
+		usage Usage
+		want  int32
+	}{
+		{UsageRelativeStateOfCharge, 87},
+		{UsageCharging, 0},
+		{UsageACPresent, 1},
+	}
+	for _, tt := range tests {
+		field, index, ok := findUsage(fields, tt.usage)
+		if !ok {
+			t.Errorf("Usage %s not found", tt.usage)
+			continue
+		}
+		if field.Type != FeatureReport {
+			t.Errorf("Usage %s in %s report, want feature", tt.usage, field.Type)
+		}
+		if value, ok := field.Value(report, index); !ok || value != tt.want {
+			t.Errorf("Usage %s = %d (%v), want %d", tt.usage, value, ok, tt.want)
+		}
+	}
+
+	padding := fields[len(fields)-1]
+	if padding.Size != 6 || padding.Offset != 10 {
+		t.Errorf("Padding field = %+v, want 6 bits at offset 10", padding)
+	}
+	if got := ReportLength(fields, FeatureReport, 0x0C); got != 3 {
+		t.Errorf("ReportLength = %d, want 3", got)
+	}
+}
+
+func TestParseDescriptor_ExtendedUsageAndSignedValues(t *testing.T) {
+	desc := []byte{
+		0x0B, 0x20, 0x00, 0x06, 0x00, // Usage (Generic Device Controls: Battery Strength)
+		0x15, 0x80, // Logical Minimum (-128)
+		0x25, 0x7F, // Logical Maximum (127)
+		0x75, 0x08, // Report Size (8)
+		0x95, 0x01, // Report Count (1)
+		0x81, 0x02, // Input (Data, Variable, Absolute)
+	}
+	fields, err := ParseDescriptor(desc)
+	if err != nil {
+		t.Fatalf("ParseDescriptor failed: %v", err)
+	}
+	field, index, ok := findUsage(fields, UsageBatteryStrength)
+	if !ok {
+		t.Fatal("Extended usage not resolved")
+	}
+	if value, _ := field.Value([]byte{0xFE}, index); value != -2 {
+		t.Errorf("Signed value = %d, want -2", value)
+	}
+}
+
+func TestParseDescriptor_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		desc []byte
+	}{
+		{"truncated item", []byte{0x26, 0xFF}},
+		{"truncated long item", []byte{0xFE}},
+		{"pop without push", []byte{0xB4}},
+		{"oversized field", []byte{0x77, 0xFF, 0xFF, 0xFF, 0x00, 0x95, 0x01, 0x81, 0x02}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseDescriptor(tt.desc); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package hidraw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrNoReport is returned by Fake for feature reports it does not have
+var ErrNoReport = errors.New("no such report")
+
+// Fake is an in-memory Device for tests. Input reports are queued with
+// Inject and returned by Read, which blocks until one is available or the
+// device is closed.
+type Fake struct {
+	DeviceInfo Info
+	DeviceName string
+	DevicePhys string
+	Descriptor []byte
+
+	// Features holds the feature reports returned by GetFeatureReport,
+	// keyed by report ID and including the report ID byte.
+	// SendFeatureReport stores the report it is given.
+	Features map[byte][]byte
+
+	// Respond, if set, is called with every output report and the input
+	// reports it returns are queued, emulating request/response traffic
+	Respond func(output []byte) [][]byte
+
+	mu      sync.Mutex
+	input   [][]byte
+	outputs [][]byte
+	ready   chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+// NewFake creates a fake device with the given identity
+func NewFake(info Info, name string) *Fake {
+	return &Fake{
+		DeviceInfo: info,
+		DeviceName: name,
+		Features:   make(map[byte][]byte),
+		ready:      make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+	}
+}
+
+// Inject queues an input report for Read
+func (f *Fake) Inject(report []byte) {
+	f.mu.Lock()
+	f.input = append(f.input, append([]byte(nil), report...))
+	f.mu.Unlock()
+
+	select {
+	case f.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Outputs returns the output reports written so far
+func (f *Fake) Outputs() [][]byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]byte(nil), f.outputs...)
+}
+
+// Read returns the next queued input report, truncated to len(p) like a
+// hidraw node. It returns io.EOF once the device is closed and drained.
+func (f *Fake) Read(p []byte) (int, error) {
+	for {
+		f.mu.Lock()
+		if len(f.input) > 0 {
+			report := f.input[0]
+			f.input = f.input[1:]
+			f.mu.Unlock()
+			return copy(p, report), nil
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-f.ready:
+		case <-f.closed:
+			return 0, io.EOF
+		}
+	}
+}
+
+// Write records an output report and queues any responses
+func (f *Fake) Write(p []byte) (int, error) {
+	if f.isClosed() {
+		return 0, os.ErrClosed
+	}
+	report := append([]byte(nil), p...)
+
+	f.mu.Lock()
+	f.outputs = append(f.outputs, report)
+	respond := f.Respond
+	f.mu.Unlock()
+
+	if respond != nil {
+		for _, response := range respond(report) {
+			f.Inject(response)
+		}
+	}
+	return len(p), nil
+}
+
+// Close unblocks pending reads. It is safe to call more than once.
+func (f *Fake) Close() error {
+	f.once.Do(func() { close(f.closed) })
+	return nil
+}
+
+func (f *Fake) isClosed() bool {
+	select {
+	case <-f.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Info returns DeviceInfo
+func (f *Fake) Info() (Info, error) {
+	return f.DeviceInfo, nil
+}
+
+// Name returns DeviceName
+func (f *Fake) Name() (string, error) {
+	return f.DeviceName, nil
+}
+
+// Phys returns DevicePhys
+func (f *Fake) Phys() (string, error) {
+	return f.DevicePhys, nil
+}
+
+// ReportDescriptor returns Descriptor
+func (f *Fake) ReportDescriptor() ([]byte, error) {
+	if f.Descriptor == nil {
+		return nil, ErrNoReport
+	}
+	return append([]byte(nil), f.Descriptor...), nil
+}
+
+// GetFeatureReport returns the stored feature report, truncated to length
+func (f *Fake) GetFeatureReport(reportID byte, length int) ([]byte, error) {
+	f.mu.Lock()
+	report, ok := f.Features[reportID]
+	f.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("feature report 0x%02X: %w", reportID, ErrNoReport)
+	}
+	if len(report) > length {
+		report = report[:length]
+	}
+	return append([]byte(nil), report...), nil
+}
+
+// SendFeatureReport stores the report under its report ID
+func (f *Fake) SendFeatureReport(report []byte) error {
+	if len(report) == 0 {
+		return fmt.Errorf("empty feature report")
+	}
+	if f.isClosed() {
+		return os.ErrClosed
+	}
+
+	f.mu.Lock()
+	f.Features[report[0]] = append([]byte(nil), report...)
+	f.mu.Unlock()
+	return nil
+}
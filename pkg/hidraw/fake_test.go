@@ -0,0 +1,82 @@
+package hidraw
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFake_RequestResponse(t *testing.T) {
+	fake := NewFake(Info{Bus: BusUSB, VendorID: 0x1038, ProductID: 0x230A}, "SteelSeries Arctis GameBuds")
+	fake.Respond = func(output []byte) [][]byte {
+		return [][]byte{{output[0] | 0x80, 0x42}}
+	}
+
+	var dev Device = fake
+	if _, err := dev.Write([]byte{0x06, 0x01}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := dev.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte{0x86, 0x42}) {
+		t.Errorf("Response = % X, want 86 42", buf[:n])
+	}
+	if outputs := fake.Outputs(); len(outputs) != 1 || !bytes.Equal(outputs[0], []byte{0x06, 0x01}) {
+		t.Errorf("Outputs = %v", outputs)
+	}
+}
+
+func TestFake_FeatureReports(t *testing.T) {
+	fake := NewFake(Info{}, "")
+
+	if _, err := fake.GetFeatureReport(0x05, 8); !errors.Is(err, ErrNoReport) {
+		t.Errorf("Expected ErrNoReport, got %v", err)
+	}
+
+	if err := fake.SendFeatureReport([]byte{0x05, 1, 2, 3}); err != nil {
+		t.Fatalf("SendFeatureReport failed: %v", err)
+	}
+	report, err := fake.GetFeatureReport(0x05, 3)
+	if err != nil {
+		t.Fatalf("GetFeatureReport failed: %v", err)
+	}
+	if !bytes.Equal(report, []byte{0x05, 1, 2}) {
+		t.Errorf("Report = % X, want truncated to 3 bytes", report)
+	}
+}
+
+func TestFake_CloseUnblocksRead(t *testing.T) {
+	fake := NewFake(Info{}, "")
+	fake.Inject([]byte{0x01})
+
+	done := make(chan error)
+	go func() {
+		buf := make([]byte, 8)
+		if _, err := fake.Read(buf); err != nil {
+			done <- err
+			return
+		}
+		_, err := fake.Read(buf)
+		done <- err
+	}()
+
+	fake.Close()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("Read after close = %v, want EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after Close")
+	}
+
+	if _, err := fake.Write([]byte{0x01}); err == nil {
+		t.Error("Expected error writing to a closed device")
+	}
+}
@@ -0,0 +1,49 @@
+// Package hidraw talks to Linux hidraw device nodes (/dev/hidraw*): device
+// identification, report descriptors, feature reports and output reports.
+package hidraw
+
+import "io"
+
+// Bus types reported by HIDIOCGRAWINFO
+const (
+	BusUSB       uint16 = 0x0003
+	BusBluetooth uint16 = 0x0005
+)
+
+// maxNameLength bounds the buffers used for HIDIOCGRAWNAME and HIDIOCGRAWPHYS
+const maxNameLength = 256
+
+// maxDescriptorSize is HID_MAX_DESCRIPTOR_SIZE from linux/hid.h
+const maxDescriptorSize = 4096
+
+// Info identifies a hidraw device
+type Info struct {
+	Bus       uint16
+	VendorID  uint16
+	ProductID uint16
+}
+
+// Device is an open hidraw node. Read returns one input report per call.
+// Write sends an output report whose first byte is the report ID, or 0 for
+// devices without numbered reports.
+type Device interface {
+	io.ReadWriteCloser
+
+	// Info returns the bus type and vendor/product IDs (HIDIOCGRAWINFO)
+	Info() (Info, error)
+	// Name returns the device name, e.g. "SteelSeries Arctis GameBuds" (HIDIOCGRAWNAME)
+	Name() (string, error)
+	// Phys returns the physical path, e.g. "usb-0000:00:14.0-2/input3" (HIDIOCGRAWPHYS)
+	Phys() (string, error)
+	// ReportDescriptor returns the raw HID report descriptor (HIDIOCGRDESCSIZE/HIDIOCGRDESC)
+	ReportDescriptor() ([]byte, error)
+	// GetFeatureReport reads feature report reportID into a buffer of length
+	// bytes, including the report ID byte (HIDIOCGFEATURE)
+	GetFeatureReport(reportID byte, length int) ([]byte, error)
+	// SendFeatureReport sends a feature report whose first byte is the report
+	// ID (HIDIOCSFEATURE)
+	SendFeatureReport(report []byte) error
+}
+
+// Opener opens the hidraw node at path
+type Opener func(path string) (Device, error)
@@ -0,0 +1,195 @@
+package hidraw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl request encoding from asm-generic/ioctl.h
+const (
+	iocWrite = 1
+	iocRead  = 2
+
+	iocNRShift   = 0
+	iocTypeShift = 8
+	iocSizeShift = 16
+	iocDirShift  = 30
+
+	hidrawIoctlType = 'H'
+)
+
+func ioc(dir, nr, size uintptr) uintptr {
+	return dir<<iocDirShift | hidrawIoctlType<<iocTypeShift | nr<<iocNRShift | size<<iocSizeShift
+}
+
+// devInfo mirrors struct hidraw_devinfo
+type devInfo struct {
+	BusType uint32
+	Vendor  uint16
+	Product uint16
+}
+
+// reportDescriptor mirrors struct hidraw_report_descriptor
+type reportDescriptor struct {
+	Size  uint32
+	Value [maxDescriptorSize]byte
+}
+
+var (
+	ioctlGetDescriptorSize = ioc(iocRead, 0x01, unsafe.Sizeof(int32(0)))
+	ioctlGetDescriptor     = ioc(iocRead, 0x02, unsafe.Sizeof(reportDescriptor{}))
+	ioctlGetInfo           = ioc(iocRead, 0x03, unsafe.Sizeof(devInfo{}))
+)
+
+func ioctlGetName(length int) uintptr    { return ioc(iocRead, 0x04, uintptr(length)) }
+func ioctlGetPhys(length int) uintptr    { return ioc(iocRead, 0x05, uintptr(length)) }
+func ioctlSetFeature(length int) uintptr { return ioc(iocRead|iocWrite, 0x06, uintptr(length)) }
+func ioctlGetFeature(length int) uintptr { return ioc(iocRead|iocWrite, 0x07, uintptr(length)) }
+
+// File is a hidraw node opened on the local system
+type File struct {
+	file *os.File
+}
+
+// Open opens the hidraw node at path for reading and writing. If write
+// access is denied the node is opened read-only, in which case output and
+// feature reports fail but identification and input reports still work.
+func Open(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if errors.Is(err, fs.ErrPermission) {
+		f, err = os.OpenFile(path, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &File{file: f}, nil
+}
+
+// OpenDevice is an Opener for hidraw nodes on the local system
+func OpenDevice(path string) (Device, error) {
+	f, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Read reads one input report
+func (f *File) Read(p []byte) (int, error) {
+	return f.file.Read(p)
+}
+
+// Write sends an output report
+func (f *File) Write(p []byte) (int, error) {
+	return f.file.Write(p)
+}
+
+// Close closes the node, unblocking any pending Read
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+// Info returns the bus type and vendor/product IDs
+func (f *File) Info() (Info, error) {
+	var info devInfo
+	if _, err := f.ioctl(ioctlGetInfo, unsafe.Pointer(&info)); err != nil {
+		return Info{}, fmt.Errorf("HIDIOCGRAWINFO: %w", err)
+	}
+	return Info{Bus: uint16(info.BusType), VendorID: info.Vendor, ProductID: info.Product}, nil
+}
+
+// Name returns the device name
+func (f *File) Name() (string, error) {
+	buf := make([]byte, maxNameLength)
+	if _, err := f.ioctl(ioctlGetName(len(buf)), unsafe.Pointer(&buf[0])); err != nil {
+		return "", fmt.Errorf("HIDIOCGRAWNAME: %w", err)
+	}
+	return cString(buf), nil
+}
+
+// Phys returns the physical path of the device
+func (f *File) Phys() (string, error) {
+	buf := make([]byte, maxNameLength)
+	if _, err := f.ioctl(ioctlGetPhys(len(buf)), unsafe.Pointer(&buf[0])); err != nil {
+		return "", fmt.Errorf("HIDIOCGRAWPHYS: %w", err)
+	}
+	return cString(buf), nil
+}
+
+// ReportDescriptor returns the raw HID report descriptor
+func (f *File) ReportDescriptor() ([]byte, error) {
+	var size int32
+	if _, err := f.ioctl(ioctlGetDescriptorSize, unsafe.Pointer(&size)); err != nil {
+		return nil, fmt.Errorf("HIDIOCGRDESCSIZE: %w", err)
+	}
+	if size < 0 || size > maxDescriptorSize {
+		return nil, fmt.Errorf("invalid report descriptor size %d", size)
+	}
+
+	desc := &reportDescriptor{Size: uint32(size)}
+	if _, err := f.ioctl(ioctlGetDescriptor, unsafe.Pointer(desc)); err != nil {
+		return nil, fmt.Errorf("HIDIOCGRDESC: %w", err)
+	}
+	return append([]byte(nil), desc.Value[:desc.Size]...), nil
+}
+
+// GetFeatureReport reads a feature report, including the report ID byte
+func (f *File) GetFeatureReport(reportID byte, length int) ([]byte, error) {
+	if length < 1 {
+		return nil, fmt.Errorf("feature report length must be at least 1")
+	}
+	buf := make([]byte, length)
+	buf[0] = reportID
+	n, err := f.ioctl(ioctlGetFeature(length), unsafe.Pointer(&buf[0]))
+	if err != nil {
+		return nil, fmt.Errorf("HIDIOCGFEATURE 0x%02X: %w", reportID, err)
+	}
+	return buf[:n], nil
+}
+
+// SendFeatureReport sends a feature report whose first byte is the report ID
+func (f *File) SendFeatureReport(report []byte) error {
+	if len(report) == 0 {
+		return fmt.Errorf("empty feature report")
+	}
+	buf := append([]byte(nil), report...)
+	if _, err := f.ioctl(ioctlSetFeature(len(buf)), unsafe.Pointer(&buf[0])); err != nil {
+		return fmt.Errorf("HIDIOCSFEATURE 0x%02X: %w", report[0], err)
+	}
+	return nil
+}
+
+// ioctl issues a request on the node without switching it to blocking
+// mode, so Close can still interrupt a pending Read
+func (f *File) ioctl(request uintptr, arg unsafe.Pointer) (int, error) {
+	conn, err := f.file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var result uintptr
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		result, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(result), nil
+}
+
+// cString returns the NUL-terminated string at the start of buf
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
@@ -0,0 +1,56 @@
+package hidraw
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestIoctlRequests(t *testing.T) {
+	// Values computed by the kernel's _IOR/_IOC macros on x86_64 and arm64
+	tests := []struct {
+		name string
+		got  uintptr
+		want uintptr
+	}{
+		{"HIDIOCGRDESCSIZE", ioctlGetDescriptorSize, 0x80044801},
+		{"HIDIOCGRDESC", ioctlGetDescriptor, 0x90044802},
+		{"HIDIOCGRAWINFO", ioctlGetInfo, 0x80084803},
+		{"HIDIOCGRAWNAME(256)", ioctlGetName(256), 0x81004804},
+		{"HIDIOCGRAWPHYS(256)", ioctlGetPhys(256), 0x81004805},
+		{"HIDIOCSFEATURE(9)", ioctlSetFeature(9), 0xC0094806},
+		{"HIDIOCGFEATURE(9)", ioctlGetFeature(9), 0xC0094807},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("%s = 0x%08X, want 0x%08X", tt.name, tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFile_NotHidraw(t *testing.T) {
+	f, err := Open("/dev/null")
+	if err != nil {
+		t.Skipf("Cannot open /dev/null: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Info(); !errors.Is(err, syscall.ENOTTY) {
+		t.Errorf("Info() on /dev/null error = %v, want ENOTTY", err)
+	}
+	if _, err := f.GetFeatureReport(0x01, 0); err == nil {
+		t.Error("Expected error for zero-length feature report")
+	}
+}
+
+func TestCString(t *testing.T) {
+	if got := cString([]byte("GameBuds\x00\x00junk")); got != "GameBuds" {
+		t.Errorf("cString = %q, want GameBuds", got)
+	}
+	if got := cString([]byte("full")); got != "full" {
+		t.Errorf("cString = %q, want full", got)
+	}
+}
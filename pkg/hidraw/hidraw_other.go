@@ -0,0 +1,13 @@
+//go:build !linux
+
+package hidraw
+
+import (
+	"errors"
+	"fmt"
+)
+
+// OpenDevice is an Opener for hidraw nodes, which only exist on Linux
+func OpenDevice(path string) (Device, error) {
+	return nil, fmt.Errorf("open %s: %w", path, errors.ErrUnsupported)
+}
@@ -0,0 +1,125 @@
+// Package instance keeps a single goarctis running per session by owning a
+// well-known name on the session bus, and lets later invocations forward
+// their command line to the running instance.
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	BusName    = "io.github.jyablonski.goarctis"
+	ObjectPath = dbus.ObjectPath("/io/github/jyablonski/goarctis")
+	Interface  = BusName + ".Instance"
+
+	// forwardTimeout bounds how long a forwarded command may take
+	forwardTimeout = 10 * time.Second
+)
+
+var (
+	// ErrAlreadyRunning is returned by Acquire when another instance owns the name
+	ErrAlreadyRunning = errors.New("goarctis is already running")
+	// ErrNotRunning is returned by Forward when no instance owns the name
+	ErrNotRunning = errors.New("goarctis is not running")
+)
+
+// Handler runs a command forwarded from another invocation and returns the
+// text to print there
+type Handler func(args []string) (string, error)
+
+// Instance is the bus name held by the running instance
+type Instance struct {
+	conn    *dbus.Conn
+	handler Handler
+	mu      sync.RWMutex
+}
+
+// exported is the object served on the bus, kept separate so that only
+// Command is exported
+type exported struct {
+	instance *Instance
+}
+
+// Command runs a forwarded command line
+func (e exported) Command(args []string) (string, *dbus.Error) {
+	e.instance.mu.RLock()
+	handler := e.instance.handler
+	e.instance.mu.RUnlock()
+
+	if handler == nil {
+		return "", dbus.MakeFailedError(fmt.Errorf("goarctis is still starting"))
+	}
+	output, err := handler(args)
+	if err != nil {
+		return "", dbus.MakeFailedError(err)
+	}
+	return output, nil
+}
+
+// Acquire claims the instance name on conn. It returns ErrAlreadyRunning if
+// another process holds it. conn should be a private connection, since the
+// name is lost when the connection is closed.
+func Acquire(conn *dbus.Conn) (*Instance, error) {
+	instance := &Instance{conn: conn}
+
+	// Export first so commands are served as soon as the name is ours
+	if err := conn.Export(exported{instance}, ObjectPath, Interface); err != nil {
+		return nil, fmt.Errorf("failed to export instance object: %w", err)
+	}
+
+	reply, err := conn.RequestName(BusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		conn.Export(nil, ObjectPath, Interface)
+		return nil, fmt.Errorf("failed to request %s: %w", BusName, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		conn.Export(nil, ObjectPath, Interface)
+		return nil, ErrAlreadyRunning
+	}
+	return instance, nil
+}
+
+// SetHandler sets how forwarded commands are run. Until it is set they fail
+// with a "still starting" error.
+func (i *Instance) SetHandler(handler Handler) {
+	i.mu.Lock()
+	i.handler = handler
+	i.mu.Unlock()
+}
+
+// Release gives up the name so another instance can start
+func (i *Instance) Release() error {
+	i.conn.Export(nil, ObjectPath, Interface)
+	if _, err := i.conn.ReleaseName(BusName); err != nil {
+		return fmt.Errorf("failed to release %s: %w", BusName, err)
+	}
+	return nil
+}
+
+// Forward sends a command line to the running instance and returns its
+// output, or ErrNotRunning if there is none
+func Forward(ctx context.Context, conn *dbus.Conn, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+
+	var running bool
+	if err := conn.BusObject().CallWithContext(ctx, "org.freedesktop.DBus.NameHasOwner", 0, BusName).Store(&running); err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", BusName, err)
+	}
+	if !running {
+		return "", ErrNotRunning
+	}
+
+	var output string
+	err := conn.Object(BusName, ObjectPath).CallWithContext(ctx, Interface+".Command", 0, args).Store(&output)
+	if err != nil {
+		return "", err
+	}
+	return output, nil
+}
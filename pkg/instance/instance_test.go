@@ -0,0 +1,77 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jyablonski/goarctis/internal/dbustest"
+)
+
+func TestAcquire_SingleInstance(t *testing.T) {
+	address := dbustest.StartBus(t)
+
+	first, err := Acquire(dbustest.Connect(t, address))
+	if err != nil {
+		t.Fatalf("First Acquire failed: %v", err)
+	}
+	if _, err := Acquire(dbustest.Connect(t, address)); !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("Second Acquire = %v, want ErrAlreadyRunning", err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if _, err := Acquire(dbustest.Connect(t, address)); err != nil {
+		t.Errorf("Acquire after Release failed: %v", err)
+	}
+}
+
+func TestAcquire_ClosedConnectionFreesName(t *testing.T) {
+	address := dbustest.StartBus(t)
+
+	conn := dbustest.Connect(t, address)
+	if _, err := Acquire(conn); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	// A crashed instance must not block the next one
+	conn.Close()
+
+	if _, err := Acquire(dbustest.Connect(t, address)); err != nil {
+		t.Errorf("Acquire after the owner exited failed: %v", err)
+	}
+}
+
+func TestForward(t *testing.T) {
+	address := dbustest.StartBus(t)
+	client := dbustest.Connect(t, address)
+
+	if _, err := Forward(context.Background(), client, []string{"status"}); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("Forward without an instance = %v, want ErrNotRunning", err)
+	}
+
+	running, err := Acquire(dbustest.Connect(t, address))
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if _, err := Forward(context.Background(), client, []string{"status"}); err == nil || !strings.Contains(err.Error(), "starting") {
+		t.Errorf("Forward before SetHandler = %v, want a still starting error", err)
+	}
+
+	running.SetHandler(func(args []string) (string, error) {
+		if len(args) == 0 || args[0] != "status" {
+			return "", fmt.Errorf("unknown command %v", args)
+		}
+		return "all good", nil
+	})
+
+	output, err := Forward(context.Background(), client, []string{"status"})
+	if err != nil || output != "all good" {
+		t.Errorf("Forward = %q, %v", output, err)
+	}
+	if _, err := Forward(context.Background(), client, []string{"bogus"}); err == nil || err.Error() != "unknown command [bogus]" {
+		t.Errorf("Forward of a failing command = %v", err)
+	}
+}
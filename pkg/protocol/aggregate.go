@@ -0,0 +1,155 @@
+package protocol
+
+import (
+	"fmt"
+	"math"
+)
+
+// AggregationPolicy selects how the batteries of a multi-battery device are
+// combined into the single level shown in the tray title, tooltip and alerts
+type AggregationPolicy string
+
+const (
+	AggregateMinimum     AggregationPolicy = "minimum"      // Lowest earbud
+	AggregateAverage     AggregationPolicy = "average"      // Mean of the earbuds
+	AggregateWornOnly    AggregationPolicy = "worn_only"    // Lowest earbud being worn
+	AggregateOutOfCase   AggregationPolicy = "out_of_case"  // Lowest earbud outside the case
+	AggregateIncludeCase AggregationPolicy = "include_case" // Lowest of the earbuds and the case
+)
+
+// DefaultAggregationPolicy is used for devices without a configured policy
+const DefaultAggregationPolicy = AggregateOutOfCase
+
+// Validate checks that the policy is known
+func (p AggregationPolicy) Validate() error {
+	switch p {
+	case AggregateMinimum, AggregateAverage, AggregateWornOnly, AggregateOutOfCase, AggregateIncludeCase:
+		return nil
+	default:
+		return fmt.Errorf("unknown aggregation policy %q", p)
+	}
+}
+
+// AggregateBattery combines a device's batteries into one level according to
+// the policy. Single-battery devices always report their battery. Earbud
+// readings of 0 are ignored since the GameBuds send them while switching
+// states. worn_only and out_of_case fall back to the next broader policy when
+// no earbud qualifies, so a level is shown whenever one is known. Returns -1
+// when no battery information is available.
+func AggregateBattery(state DeviceState, policy AggregationPolicy) int {
+	if state.Battery != nil {
+		return *state.Battery
+	}
+
+	var all, out, worn []int
+	for _, bud := range []struct {
+		battery *int
+		status  *EarbudStatus
+	}{
+		{state.LeftBattery, state.LeftStatus},
+		{state.RightBattery, state.RightStatus},
+	} {
+		if bud.battery == nil || *bud.battery <= 0 {
+			continue
+		}
+		all = append(all, *bud.battery)
+		if bud.status != nil && *bud.status != StatusInCase {
+			out = append(out, *bud.battery)
+			if *bud.status == StatusWorn {
+				worn = append(worn, *bud.battery)
+			}
+		}
+	}
+
+	switch policy {
+	case AggregateAverage:
+		return average(all)
+	case AggregateWornOnly:
+		if len(worn) > 0 {
+			return minimum(worn)
+		}
+		return AggregateBattery(state, AggregateOutOfCase)
+	case AggregateMinimum:
+		return minimum(all)
+	case AggregateIncludeCase:
+		if state.DockBattery != nil && *state.DockBattery > 0 {
+			all = append(all, *state.DockBattery)
+		}
+		return minimum(all)
+	default:
+		if len(out) > 0 {
+			return minimum(out)
+		}
+		return minimum(all)
+	}
+}
+
+// AggregationConfig selects an aggregation policy per device
+type AggregationConfig struct {
+	// Default applies to devices without an entry in Devices
+	Default AggregationPolicy `json:"default"`
+	// Devices maps a device ID or device type to its policy. An ID takes
+	// precedence over a type.
+	Devices map[string]AggregationPolicy `json:"devices"`
+}
+
+// DefaultAggregationConfig returns the configuration used when none is set
+func DefaultAggregationConfig() AggregationConfig {
+	return AggregationConfig{Default: DefaultAggregationPolicy}
+}
+
+// Validate checks every configured policy
+func (c AggregationConfig) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return err
+	}
+	for device, policy := range c.Devices {
+		if err := policy.Validate(); err != nil {
+			return fmt.Errorf("device %s: %w", device, err)
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns the policy configured for a device
+func (c AggregationConfig) PolicyFor(state DeviceState) AggregationPolicy {
+	if policy, ok := c.Devices[state.DeviceID]; ok {
+		return policy
+	}
+	if policy, ok := c.Devices[state.DeviceType]; ok {
+		return policy
+	}
+	if c.Default == "" {
+		return DefaultAggregationPolicy
+	}
+	return c.Default
+}
+
+// Battery aggregates a device's batteries using its configured policy
+func (c AggregationConfig) Battery(state DeviceState) int {
+	return AggregateBattery(state, c.PolicyFor(state))
+}
+
+func minimum(levels []int) int {
+	if len(levels) == 0 {
+		return -1
+	}
+	lowest := levels[0]
+	for _, level := range levels[1:] {
+		if level < lowest {
+			lowest = level
+		}
+	}
+	return lowest
+}
+
+func average(levels []int) int {
+	if len(levels) == 0 {
+		return -1
+	}
+	sum := 0
+	for _, level := range levels {
+		sum += level
+	}
+	return int(math.Round(float64(sum) / float64(len(levels))))
+}
@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func earbuds(left, right int, leftStatus, rightStatus EarbudStatus) DeviceState {
+	return DeviceState{
+		DeviceID:     "steelseries_gamebuds",
+		DeviceType:   "steelseries_gamebuds",
+		LeftBattery:  &left,
+		RightBattery: &right,
+		LeftStatus:   &leftStatus,
+		RightStatus:  &rightStatus,
+	}
+}
+
+func TestAggregateBattery(t *testing.T) {
+	mixed := earbuds(90, 40, StatusWorn, StatusInCase)
+	outAndWorn := earbuds(70, 55, StatusWorn, StatusOut)
+	bothInCase := earbuds(80, 60, StatusInCase, StatusInCase)
+	glitch := earbuds(0, 65, StatusWorn, StatusWorn)
+	dock := 20
+	withCase := earbuds(80, 60, StatusWorn, StatusWorn)
+	withCase.DockBattery = &dock
+	mouse := 42
+
+	tests := []struct {
+		name   string
+		state  DeviceState
+		policy AggregationPolicy
+		want   int
+	}{
+		{"minimum", mixed, AggregateMinimum, 40},
+		{"average", mixed, AggregateAverage, 65},
+		{"out of case skips case", mixed, AggregateOutOfCase, 90},
+		{"out of case falls back", bothInCase, AggregateOutOfCase, 60},
+		{"worn only", outAndWorn, AggregateWornOnly, 70},
+		{"worn only falls back", earbuds(70, 55, StatusOut, StatusOut), AggregateWornOnly, 55},
+		{"include case", withCase, AggregateIncludeCase, 20},
+		{"case ignored otherwise", withCase, AggregateMinimum, 60},
+		{"zero reading ignored", glitch, AggregateMinimum, 65},
+		{"single battery", DeviceState{Battery: &mouse}, AggregateWornOnly, 42},
+		{"no data", DeviceState{}, AggregateAverage, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AggregateBattery(tt.state, tt.policy); got != tt.want {
+				t.Errorf("AggregateBattery(%s) = %d, want %d", tt.policy, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregationConfig_PolicyFor(t *testing.T) {
+	cfg := AggregationConfig{
+		Default: AggregateMinimum,
+		Devices: map[string]AggregationPolicy{
+			"steelseries_gamebuds": AggregateWornOnly,
+			"PM1234":               AggregateAverage,
+		},
+	}
+
+	tests := []struct {
+		name  string
+		state DeviceState
+		want  AggregationPolicy
+	}{
+		{"by type", DeviceState{DeviceID: "buds", DeviceType: "steelseries_gamebuds"}, AggregateWornOnly},
+		{"by id", DeviceState{DeviceID: "PM1234", DeviceType: "steelseries_gamebuds"}, AggregateAverage},
+		{"default", DeviceState{DeviceID: "mouse", DeviceType: "razer_deathadder"}, AggregateMinimum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.PolicyFor(tt.state); got != tt.want {
+				t.Errorf("PolicyFor() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := (AggregationConfig{}).PolicyFor(DeviceState{}); got != DefaultAggregationPolicy {
+		t.Errorf("Zero config PolicyFor() = %s, want %s", got, DefaultAggregationPolicy)
+	}
+}
+
+func TestAggregationConfig_Validate(t *testing.T) {
+	var cfg AggregationConfig
+	if err := json.Unmarshal([]byte(`{"default": "minimum", "devices": {"steelseries_gamebuds": "loudest"}}`), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected error for unknown policy")
+	}
+	if err := DefaultAggregationConfig().Validate(); err != nil {
+		t.Errorf("Default config should be valid, got: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package protocol
+
+import "fmt"
+
+// ConnectionState describes the link between a wireless receiver and the
+// device behind it
+type ConnectionState int
+
+const (
+	ConnectionUnknown      ConnectionState = iota
+	ConnectionDisconnected                 // Receiver unplugged or unreadable
+	ConnectionDongle                       // Receiver present, device not heard from yet
+	ConnectionLinked                       // Device is on and reporting
+	ConnectionOff                          // Device was linked but is now off or out of range
+	ConnectionAsleep                       // Device is idle and not answering; the last reading is kept
+)
+
+// connectionStateNames maps enum identifiers used in definition files and
+// JSON to ConnectionState values
+var connectionStateNames = map[string]ConnectionState{
+	"disconnected":     ConnectionDisconnected,
+	"dongle_connected": ConnectionDongle,
+	"buds_linked":      ConnectionLinked,
+	"buds_off":         ConnectionOff,
+	"asleep":           ConnectionAsleep,
+}
+
+func (c ConnectionState) String() string {
+	switch c {
+	case ConnectionDisconnected:
+		return "Disconnected"
+	case ConnectionDongle:
+		return "Dongle connected"
+	case ConnectionLinked:
+		return "Linked"
+	case ConnectionOff:
+		return "Buds off"
+	case ConnectionAsleep:
+		return "Asleep"
+	default:
+		return "Unknown"
+	}
+}
+
+// ID returns the stable identifier for the connection state, e.g. "buds_off"
+func (c ConnectionState) ID() string {
+	for name, state := range connectionStateNames {
+		if state == c {
+			return name
+		}
+	}
+	return enumUnknown
+}
+
+// MarshalText writes the stable identifier
+func (c ConnectionState) MarshalText() ([]byte, error) {
+	return []byte(c.ID()), nil
+}
+
+// UnmarshalText parses a stable identifier
+func (c *ConnectionState) UnmarshalText(text []byte) error {
+	if string(text) == enumUnknown {
+		*c = ConnectionUnknown
+		return nil
+	}
+	state, ok := connectionStateNames[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown connection state %q", text)
+	}
+	*c = state
+	return nil
+}
+
+// ConnectionMode describes how a device is attached to the computer
+type ConnectionMode int
+
+const (
+	ModeUnknown  ConnectionMode = iota
+	ModeWired                   // By cable
+	ModeWireless                // Through a wireless receiver
+	ModeDock                    // Through a charging dock
+)
+
+// connectionModeNames maps enum identifiers used in JSON to ConnectionMode values
+var connectionModeNames = map[string]ConnectionMode{
+	"wired":    ModeWired,
+	"wireless": ModeWireless,
+	"dock":     ModeDock,
+}
+
+func (m ConnectionMode) String() string {
+	switch m {
+	case ModeWired:
+		return "Wired"
+	case ModeWireless:
+		return "Wireless"
+	case ModeDock:
+		return "Dock"
+	default:
+		return "Unknown"
+	}
+}
+
+// ID returns the stable identifier for the connection mode, e.g. "wired"
+func (m ConnectionMode) ID() string {
+	for name, mode := range connectionModeNames {
+		if mode == m {
+			return name
+		}
+	}
+	return enumUnknown
+}
+
+// IsPluggedIn reports whether the mode supplies external power
+func (m ConnectionMode) IsPluggedIn() bool {
+	return m == ModeWired || m == ModeDock
+}
+
+// MarshalText writes the stable identifier
+func (m ConnectionMode) MarshalText() ([]byte, error) {
+	return []byte(m.ID()), nil
+}
+
+// UnmarshalText parses a stable identifier
+func (m *ConnectionMode) UnmarshalText(text []byte) error {
+	if string(text) == enumUnknown {
+		*m = ModeUnknown
+		return nil
+	}
+	mode, ok := connectionModeNames[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown connection mode %q", text)
+	}
+	*m = mode
+	return nil
+}
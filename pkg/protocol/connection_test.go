@@ -0,0 +1,141 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func connectionOf(state DeviceState) ConnectionState {
+	if state.Connection == nil {
+		return ConnectionUnknown
+	}
+	return *state.Connection
+}
+
+func TestHandler_LinkTimeout(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := NewHandler()
+	h.now = func() time.Time { return now }
+
+	var changes []DeviceState
+	h.SetOnChange(func(state DeviceState) {
+		changes = append(changes, state)
+	})
+
+	state := h.GetState()
+	if connectionOf(state) != ConnectionDongle || state.IsConnected {
+		t.Fatalf("Initial state = %v (connected %v), want dongle_connected and not connected", connectionOf(state), state.IsConnected)
+	}
+
+	h.ParseReport([]byte{ReportBattery, 75, 80})
+	state = h.GetState()
+	if connectionOf(state) != ConnectionLinked || !state.IsConnected {
+		t.Fatalf("After report = %v (connected %v), want buds_linked and connected", connectionOf(state), state.IsConnected)
+	}
+
+	if h.CheckLink(now.Add(time.Minute)) {
+		t.Error("CheckLink should not change state within the timeout")
+	}
+
+	if !h.CheckLink(now.Add(4 * time.Minute)) {
+		t.Fatal("CheckLink should mark buds off after the timeout")
+	}
+	last := changes[len(changes)-1]
+	if connectionOf(last) != ConnectionOff || last.IsConnected {
+		t.Errorf("After timeout = %v (connected %v), want buds_off and not connected", connectionOf(last), last.IsConnected)
+	}
+	if last.LeftBattery == nil || *last.LeftBattery != 75 {
+		t.Error("Last battery levels should be kept while buds are off")
+	}
+
+	// Unknown reports prove the dongle is alive but not the buds
+	now = now.Add(5 * time.Minute)
+	h.ParseReport([]byte{0xFF, 0x01})
+	if connectionOf(h.GetState()) != ConnectionOff {
+		t.Error("Unknown report should not relink the buds")
+	}
+
+	h.ParseReport([]byte{ReportANCMode, 0x01})
+	if connectionOf(h.GetState()) != ConnectionLinked {
+		t.Error("State report should relink the buds")
+	}
+}
+
+func TestHandler_ExplicitConnectionReport(t *testing.T) {
+	defs, err := ParseDefinitions(strings.NewReader(`{
+		"name": "status",
+		"reports": [
+			{"name": "Link", "report_id": "0xC0", "fields": [
+				{"name": "link", "target": "connection", "offset": 1, "enum": {"0": "buds_off", "1": "buds_linked"}}
+			]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseDefinitions failed: %v", err)
+	}
+
+	h := NewHandlerWithDefinitions(defs)
+	h.ParseReport([]byte{0xC0, 0x01})
+	if state := h.GetState(); connectionOf(state) != ConnectionLinked || !state.IsConnected {
+		t.Errorf("Link report = %v, want buds_linked", connectionOf(state))
+	}
+
+	h.ParseReport([]byte{0xC0, 0x00})
+	if state := h.GetState(); connectionOf(state) != ConnectionOff || state.IsConnected {
+		t.Errorf("Off report = %v, want buds_off", connectionOf(state))
+	}
+}
+
+func TestHandler_SetConnection(t *testing.T) {
+	h := NewHandler()
+	called := 0
+	h.SetOnChange(func(DeviceState) { called++ })
+
+	h.SetConnection(ConnectionDisconnected)
+	h.SetConnection(ConnectionDisconnected)
+	if called != 1 {
+		t.Errorf("Callback called %d times, want 1", called)
+	}
+	if connectionOf(h.GetState()) != ConnectionDisconnected {
+		t.Errorf("Connection = %v, want disconnected", connectionOf(h.GetState()))
+	}
+}
+
+func TestConnectionStateJSON(t *testing.T) {
+	off := ConnectionOff
+	data, err := json.Marshal(DeviceState{DeviceID: "buds", Connection: &off})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"connection":"buds_off"`) {
+		t.Errorf("Expected connection identifier in %s", data)
+	}
+
+	var decoded DeviceState
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if connectionOf(decoded) != ConnectionOff {
+		t.Errorf("Decoded connection = %v, want buds_off", connectionOf(decoded))
+	}
+}
+
+func TestDefinitions_LinkTimeout(t *testing.T) {
+	if got := BuiltinDefinitions().LinkTimeoutDuration(); got != 3*time.Minute {
+		t.Errorf("Built-in link timeout = %v, want 3m", got)
+	}
+
+	_, err := ParseDefinitions(strings.NewReader(`{"name": "bad", "link_timeout": "soon", "reports": []}`))
+	if err == nil || !strings.Contains(err.Error(), "link_timeout") {
+		t.Errorf("Expected link_timeout error, got %v", err)
+	}
+
+	_, err = ParseDefinitions(strings.NewReader(`{"name": "bad", "reports": [
+		{"name": "Link", "report_id": 1, "fields": [{"name": "link", "target": "connection", "offset": 1}]}
+	]}`))
+	if err == nil || !strings.Contains(err.Error(), "requires an enum") {
+		t.Errorf("Expected enum error, got %v", err)
+	}
+}
@@ -0,0 +1,693 @@
+package protocol
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed definitions/*.json
+var builtinDefinitionFiles embed.FS
+
+// Field targets that a report definition can map values onto
+const (
+	TargetBattery      = "battery"
+	TargetLeftBattery  = "left_battery"
+	TargetRightBattery = "right_battery"
+	TargetDockBattery  = "dock_battery"
+	TargetIsCharging   = "is_charging"
+	TargetLeftStatus   = "left_status"
+	TargetRightStatus  = "right_status"
+	TargetANCMode      = "anc_mode"
+	TargetConnection   = "connection"
+)
+
+// Fields read from devices directly rather than through report definitions,
+// used as keys of DeviceState.Updated
+const (
+	TargetIdleTime            = "idle_time"
+	TargetLowBatteryThreshold = "low_battery_threshold"
+)
+
+// earbudStatusNames maps enum identifiers used in definition files to EarbudStatus values
+var earbudStatusNames = map[string]EarbudStatus{
+	"in_case": StatusInCase,
+	"out":     StatusOut,
+	"worn":    StatusWorn,
+}
+
+// ancModeNames maps enum identifiers used in definition files to ANCMode values
+var ancModeNames = map[string]ANCMode{
+	"off":          ANCOff,
+	"transparency": ANCTransparency,
+	"active":       ANCActive,
+}
+
+// HexID is a numeric identifier that can be written in JSON either as a
+// number or as a hex string such as "0x1038"
+type HexID uint16
+
+// UnmarshalJSON accepts numbers and "0x"-prefixed hex strings
+func (h *HexID) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n uint16
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid id %s: expected number or hex string", data)
+		}
+		*h = HexID(n)
+		return nil
+	}
+
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid id %q: %w", s, err)
+	}
+	*h = HexID(n)
+	return nil
+}
+
+// MarshalJSON writes the id as a hex string
+func (h HexID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("0x%02X", uint16(h)))
+}
+
+// Transports a product can be matched on
+const (
+	BusUSB       = "usb"
+	BusBluetooth = "bluetooth"
+)
+
+// Linux bus type codes, as found in the HID_ID line of a hidraw uevent
+const (
+	busTypeUSB       = 0x0003
+	busTypeBluetooth = 0x0005
+)
+
+// maxHeaderLength bounds ProductMatch.HeaderLength
+const maxHeaderLength = 8
+
+// maxCommandLength bounds the size of a command's output report
+const maxCommandLength = 64
+
+// ProductMatch identifies a USB/Bluetooth HID product by vendor and product ID
+type ProductMatch struct {
+	VendorID  HexID `json:"vendor_id"`
+	ProductID HexID `json:"product_id,omitempty"` // 0 matches any product of the vendor, and requires name
+
+	// Bus is "usb" (default) or "bluetooth". Paired directly over Bluetooth,
+	// a device shows up with bus type 0005 and usually a different product ID.
+	Bus string `json:"bus,omitempty"`
+
+	// Name, if set, must be contained in the device's HID_NAME
+	Name string `json:"name,omitempty"`
+
+	// HeaderLength is the number of transport bytes preceding the report ID
+	// in each report read from this product, which are stripped before parsing
+	HeaderLength int `json:"header_length,omitempty"`
+}
+
+// BusName returns the product's transport, applying the default
+func (p ProductMatch) BusName() string {
+	if p.Bus == "" {
+		return BusUSB
+	}
+	return p.Bus
+}
+
+// busType returns the Linux bus type code for the product's transport
+func (p ProductMatch) busType() uint16 {
+	if p.BusName() == BusBluetooth {
+		return busTypeBluetooth
+	}
+	return busTypeUSB
+}
+
+// Matches reports whether a HID device with the given bus type code, IDs and
+// name is this product
+func (p ProductMatch) Matches(busType, vendorID, productID uint16, name string) bool {
+	if busType != p.busType() || vendorID != uint16(p.VendorID) {
+		return false
+	}
+	if p.ProductID != 0 && productID != uint16(p.ProductID) {
+		return false
+	}
+	return p.Name == "" || strings.Contains(name, p.Name)
+}
+
+func (p ProductMatch) validate() error {
+	if p.Bus != "" && p.Bus != BusUSB && p.Bus != BusBluetooth {
+		return fmt.Errorf("bus must be %q or %q", BusUSB, BusBluetooth)
+	}
+	if p.ProductID == 0 && p.Name == "" {
+		return fmt.Errorf("product_id may only be omitted together with name")
+	}
+	if p.HeaderLength < 0 || p.HeaderLength > maxHeaderLength {
+		return fmt.Errorf("header_length must be between 0 and %d", maxHeaderLength)
+	}
+	return nil
+}
+
+// FieldDefinition describes a single value inside a report
+type FieldDefinition struct {
+	Name   string `json:"name"`             // Label used in logs
+	Target string `json:"target,omitempty"` // DeviceState field to update; empty means log only
+	Offset int    `json:"offset"`           // Byte offset from the start of the report (report ID is byte 0)
+	Width  int    `json:"width,omitempty"`  // Width in bytes (1-4, default 1)
+	Endian string `json:"endian,omitempty"` // "little" (default) or "big" for multi-byte fields
+
+	// Enum maps raw values (as decimal strings) to identifiers such as "in_case"
+	// or "active". Raw values missing from the map are stored as plain numbers
+	// for the wear status and ANC mode targets, and ignored for other targets.
+	Enum map[string]string `json:"enum,omitempty"`
+
+	// ZeroRequiresInCase names a status target ("left_status"/"right_status").
+	// A zero reading is only applied while that earbud is in the case, since the
+	// buds sometimes report 0% while being worn.
+	ZeroRequiresInCase string `json:"zero_requires_in_case,omitempty"`
+}
+
+// ReportDefinition describes how one HID report maps onto DeviceState
+type ReportDefinition struct {
+	Name      string            `json:"name"`
+	ReportID  HexID             `json:"report_id"`
+	MinLength int               `json:"min_length,omitempty"` // Shorter reports are ignored
+	Fields    []FieldDefinition `json:"fields"`
+}
+
+// CommandDefinition describes the output report that sets a value on the
+// device: Report is sent with the byte at ValueOffset replaced by the raw
+// value of the chosen enum entry
+type CommandDefinition struct {
+	Name        string            `json:"name"`
+	Target      string            `json:"target"`       // Setting changed by the command; only anc_mode is supported
+	Report      []HexID           `json:"report"`       // Report bytes, starting with the report ID
+	ValueOffset int               `json:"value_offset"` // Byte replaced by the raw value
+	Enum        map[string]string `json:"enum"`         // Raw values (as decimal strings) to identifiers
+}
+
+// Values returns the enum identifiers ordered by raw value
+func (c CommandDefinition) Values() []string {
+	raws := make([]uint64, 0, len(c.Enum))
+	byRaw := make(map[uint64]string, len(c.Enum))
+	for raw, name := range c.Enum {
+		n, _ := strconv.ParseUint(raw, 0, 8)
+		raws = append(raws, n)
+		byRaw[n] = name
+	}
+	sort.Slice(raws, func(i, j int) bool { return raws[i] < raws[j] })
+
+	values := make([]string, 0, len(raws))
+	for _, raw := range raws {
+		values = append(values, byRaw[raw])
+	}
+	return values
+}
+
+// Encode returns the output report that sets the target to the named value
+func (c CommandDefinition) Encode(value string) ([]byte, error) {
+	for raw, name := range c.Enum {
+		if name != value {
+			continue
+		}
+		n, err := strconv.ParseUint(raw, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid raw value %q: %w", raw, err)
+		}
+		report := make([]byte, len(c.Report))
+		for i, b := range c.Report {
+			report[i] = byte(b)
+		}
+		report[c.ValueOffset] = byte(n)
+		return report, nil
+	}
+	return nil, fmt.Errorf("%s does not support %q", c.Name, value)
+}
+
+func (c CommandDefinition) validate() error {
+	if c.Target != TargetANCMode {
+		return fmt.Errorf("unsupported target %q", c.Target)
+	}
+	if len(c.Report) == 0 || len(c.Report) > maxCommandLength {
+		return fmt.Errorf("report must have 1 to %d bytes", maxCommandLength)
+	}
+	for _, b := range c.Report {
+		if b > 0xFF {
+			return fmt.Errorf("report byte 0x%X does not fit in a byte", uint16(b))
+		}
+	}
+	if c.ValueOffset < 1 || c.ValueOffset >= len(c.Report) {
+		return fmt.Errorf("value_offset must point into the report after the report ID")
+	}
+	if len(c.Enum) == 0 {
+		return fmt.Errorf("enum is required")
+	}
+	for raw, name := range c.Enum {
+		if _, err := strconv.ParseUint(raw, 0, 8); err != nil {
+			return fmt.Errorf("enum key %q is not a byte value", raw)
+		}
+		if _, ok := ancModeNames[name]; !ok {
+			return fmt.Errorf("unknown ANC mode %q", name)
+		}
+	}
+	return nil
+}
+
+// DefinitionSet is the contents of one definition file
+type DefinitionSet struct {
+	Name     string             `json:"name"`
+	Products []ProductMatch     `json:"products,omitempty"`
+	Reports  []ReportDefinition `json:"reports"`
+
+	// Commands change settings on the device. None are built in; they can
+	// be supplied in user definition files.
+	Commands []CommandDefinition `json:"commands,omitempty"`
+
+	// LinkTimeout is how long the device may send no known report before it
+	// is considered switched off, e.g. "3m". Empty disables the check.
+	LinkTimeout string `json:"link_timeout,omitempty"`
+
+	// Scoped holds user definition files that list their own products.
+	// Their reports and commands only apply to those products, see Families.
+	Scoped []*DefinitionSet `json:"-"`
+}
+
+// BuiltinDefinitions returns the definitions shipped with goarctis
+func BuiltinDefinitions() *DefinitionSet {
+	entries, err := builtinDefinitionFiles.ReadDir("definitions")
+	if err != nil {
+		panic(fmt.Sprintf("reading built-in definitions: %v", err))
+	}
+
+	set := &DefinitionSet{Name: "built-in"}
+	for _, entry := range entries {
+		data, err := builtinDefinitionFiles.ReadFile("definitions/" + entry.Name())
+		if err != nil {
+			panic(fmt.Sprintf("reading built-in definition %s: %v", entry.Name(), err))
+		}
+		defs, err := ParseDefinitions(bytes.NewReader(data))
+		if err != nil {
+			panic(fmt.Sprintf("invalid built-in definition %s: %v", entry.Name(), err))
+		}
+		set.Merge(defs)
+	}
+	return set
+}
+
+// ParseDefinitions decodes and validates a JSON definition file
+func ParseDefinitions(r io.Reader) (*DefinitionSet, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var set DefinitionSet
+	if err := dec.Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode definitions: %w", err)
+	}
+	if err := set.Validate(); err != nil {
+		return nil, err
+	}
+	return &set, nil
+}
+
+// LoadDefinitions returns the built-in definitions with every *.json file in
+// dir. Files without products are merged into the built-in definitions;
+// files listing products are kept in Scoped. A missing directory is not an
+// error.
+func LoadDefinitions(dir string) (*DefinitionSet, error) {
+	set := BuiltinDefinitions()
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list definitions in %s: %w", dir, err)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		defs, err := ParseDefinitions(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(defs.Products) > 0 {
+			set.Scoped = append(set.Scoped, defs)
+		} else {
+			set.Merge(defs)
+		}
+	}
+
+	return set, nil
+}
+
+// Merge adds other's products and reports to s. Reports with the same ID as
+// an existing report replace it.
+func (s *DefinitionSet) Merge(other *DefinitionSet) {
+	if other.LinkTimeout != "" {
+		s.LinkTimeout = other.LinkTimeout
+	}
+
+	for _, p := range other.Products {
+		if !s.hasProductMatch(p) {
+			s.Products = append(s.Products, p)
+		}
+	}
+
+	for _, report := range other.Reports {
+		replaced := false
+		for i := range s.Reports {
+			if s.Reports[i].ReportID == report.ReportID {
+				s.Reports[i] = report
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			s.Reports = append(s.Reports, report)
+		}
+	}
+
+	for _, command := range other.Commands {
+		replaced := false
+		for i := range s.Commands {
+			if s.Commands[i].Target == command.Target {
+				s.Commands[i] = command
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			s.Commands = append(s.Commands, command)
+		}
+	}
+}
+
+// Families returns the definitions of each product family: s itself and
+// every scoped set, with sets that share a product merged into one. The
+// reports of a family only apply to its products. Sets without products
+// are left out.
+func (s *DefinitionSet) Families() []*DefinitionSet {
+	var families []*DefinitionSet
+	for _, set := range append([]*DefinitionSet{s}, s.Scoped...) {
+		if len(set.Products) == 0 {
+			continue
+		}
+		shared := slices.IndexFunc(families, func(family *DefinitionSet) bool {
+			return slices.ContainsFunc(set.Products, family.hasProductMatch)
+		})
+		if shared < 0 {
+			family := &DefinitionSet{Name: set.Name}
+			family.Merge(set)
+			families = append(families, family)
+			continue
+		}
+		families[shared].Merge(set)
+	}
+	return families
+}
+
+// Family returns the family of the product matching a HID device, see
+// Families and MatchProduct
+func (s *DefinitionSet) Family(busType, vendorID, productID uint16, name string) (*DefinitionSet, bool) {
+	for _, family := range s.Families() {
+		if _, ok := family.MatchProduct(busType, vendorID, productID, name); ok {
+			return family, true
+		}
+	}
+	return nil, false
+}
+
+// Command returns the command that sets target, if one is defined
+func (s *DefinitionSet) Command(target string) (CommandDefinition, bool) {
+	for _, command := range s.Commands {
+		if command.Target == target {
+			return command, true
+		}
+	}
+	return CommandDefinition{}, false
+}
+
+// LinkTimeoutDuration returns the parsed LinkTimeout, or 0 when unset
+func (s *DefinitionSet) LinkTimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(s.LinkTimeout)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// MatchProduct returns the product matching a HID device with the given bus
+// type code (0003 USB, 0005 Bluetooth), IDs and name, including the products
+// of scoped sets
+func (s *DefinitionSet) MatchProduct(busType, vendorID, productID uint16, name string) (ProductMatch, bool) {
+	for _, p := range s.Products {
+		if p.Matches(busType, vendorID, productID, name) {
+			return p, true
+		}
+	}
+	for _, scoped := range s.Scoped {
+		if p, ok := scoped.MatchProduct(busType, vendorID, productID, name); ok {
+			return p, true
+		}
+	}
+	return ProductMatch{}, false
+}
+
+// hasProductMatch reports whether an identical product entry already exists
+func (s *DefinitionSet) hasProductMatch(match ProductMatch) bool {
+	for _, p := range s.Products {
+		if p == match {
+			return true
+		}
+	}
+	return false
+}
+
+// HasProduct reports whether the set or one of its scoped sets lists the
+// given vendor/product pair
+func (s *DefinitionSet) HasProduct(vendorID, productID uint16) bool {
+	for _, p := range s.Products {
+		if uint16(p.VendorID) == vendorID && uint16(p.ProductID) == productID {
+			return true
+		}
+	}
+	return slices.ContainsFunc(s.Scoped, func(scoped *DefinitionSet) bool {
+		return scoped.HasProduct(vendorID, productID)
+	})
+}
+
+// Validate checks that every report and field is well formed
+func (s *DefinitionSet) Validate() error {
+	if s.LinkTimeout != "" {
+		d, err := time.ParseDuration(s.LinkTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid link_timeout: %w", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("link_timeout must be positive")
+		}
+	}
+
+	for _, p := range s.Products {
+		if err := p.validate(); err != nil {
+			return fmt.Errorf("product %04X:%04X: %w", uint16(p.VendorID), uint16(p.ProductID), err)
+		}
+	}
+
+	seen := make(map[HexID]bool)
+	for _, report := range s.Reports {
+		if report.ReportID > 0xFF {
+			return fmt.Errorf("report %q: report_id 0x%X does not fit in a byte", report.Name, uint16(report.ReportID))
+		}
+		if seen[report.ReportID] {
+			return fmt.Errorf("report 0x%02X defined more than once", uint16(report.ReportID))
+		}
+		seen[report.ReportID] = true
+
+		for _, field := range report.Fields {
+			if err := field.validate(); err != nil {
+				return fmt.Errorf("report 0x%02X field %q: %w", uint16(report.ReportID), field.Name, err)
+			}
+		}
+	}
+
+	targets := make(map[string]bool)
+	for _, command := range s.Commands {
+		if err := command.validate(); err != nil {
+			return fmt.Errorf("command %q: %w", command.Name, err)
+		}
+		if targets[command.Target] {
+			return fmt.Errorf("command for %q defined more than once", command.Target)
+		}
+		targets[command.Target] = true
+	}
+	return nil
+}
+
+func (f FieldDefinition) validate() error {
+	if f.Offset < 1 {
+		return fmt.Errorf("offset must be at least 1 (byte 0 is the report ID)")
+	}
+	if f.Width < 0 || f.Width > 4 {
+		return fmt.Errorf("width must be between 1 and 4 bytes")
+	}
+	if f.Endian != "" && f.Endian != "little" && f.Endian != "big" {
+		return fmt.Errorf("endian must be \"little\" or \"big\"")
+	}
+
+	switch f.Target {
+	case "":
+		// Log-only fields may use an enum purely for readable log output
+	case TargetBattery, TargetLeftBattery, TargetRightBattery, TargetDockBattery:
+		if len(f.Enum) > 0 {
+			return fmt.Errorf("enum is not supported for target %q", f.Target)
+		}
+	case TargetIsCharging:
+		for _, name := range f.Enum {
+			if name != "true" && name != "false" {
+				return fmt.Errorf("is_charging enum values must be \"true\" or \"false\"")
+			}
+		}
+	case TargetLeftStatus, TargetRightStatus:
+		for _, name := range f.Enum {
+			if _, ok := earbudStatusNames[name]; !ok {
+				return fmt.Errorf("unknown earbud status %q", name)
+			}
+		}
+	case TargetANCMode:
+		for _, name := range f.Enum {
+			if _, ok := ancModeNames[name]; !ok {
+				return fmt.Errorf("unknown ANC mode %q", name)
+			}
+		}
+	case TargetConnection:
+		if len(f.Enum) == 0 {
+			return fmt.Errorf("target %q requires an enum", f.Target)
+		}
+		for _, name := range f.Enum {
+			if _, ok := connectionStateNames[name]; !ok {
+				return fmt.Errorf("unknown connection state %q", name)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown target %q", f.Target)
+	}
+
+	for raw := range f.Enum {
+		if _, err := strconv.ParseUint(raw, 0, 32); err != nil {
+			return fmt.Errorf("enum key %q is not a number", raw)
+		}
+	}
+
+	switch f.ZeroRequiresInCase {
+	case "", TargetLeftStatus, TargetRightStatus:
+	default:
+		return fmt.Errorf("zero_requires_in_case must be \"left_status\" or \"right_status\"")
+	}
+	return nil
+}
+
+// hasTargets reports whether any field of the report updates the state
+func (r ReportDefinition) hasTargets() bool {
+	for _, field := range r.Fields {
+		if field.Target != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe formats the fields present in a report as "name=value" pairs,
+// e.g. "left=87, right=80" or "mode=active"
+func (r ReportDefinition) Describe(data []byte) string {
+	parts := make([]string, 0, len(r.Fields))
+	for _, field := range r.Fields {
+		raw, ok := field.read(data)
+		if !ok {
+			continue
+		}
+		name, ok := field.enumName(raw)
+		switch {
+		case !ok:
+			parts = append(parts, fmt.Sprintf("%s=unknown(%d)", field.Name, raw))
+		case name != "":
+			parts = append(parts, fmt.Sprintf("%s=%s", field.Name, name))
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%d", field.Name, raw))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// setsConnection reports whether the report carries an explicit connection state
+func (r ReportDefinition) setsConnection() bool {
+	for _, field := range r.Fields {
+		if field.Target == TargetConnection {
+			return true
+		}
+	}
+	return false
+}
+
+// width returns the field width, applying the default
+func (f FieldDefinition) width() int {
+	if f.Width == 0 {
+		return 1
+	}
+	return f.Width
+}
+
+// read extracts the raw field value, returning false if the report is too short
+func (f FieldDefinition) read(data []byte) (int, bool) {
+	width := f.width()
+	if f.Offset+width > len(data) {
+		return 0, false
+	}
+
+	value := 0
+	for i := 0; i < width; i++ {
+		b := int(data[f.Offset+i])
+		if f.Endian == "big" {
+			value = value<<8 | b
+		} else {
+			value |= b << (8 * i)
+		}
+	}
+	return value, true
+}
+
+// enumName looks up the identifier for a raw value; ok is true if the field
+// has no enum or the value is listed
+func (f FieldDefinition) enumName(raw int) (string, bool) {
+	if len(f.Enum) == 0 {
+		return "", true
+	}
+	for key, name := range f.Enum {
+		if n, err := strconv.ParseUint(key, 0, 32); err == nil && int(n) == raw {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// keepsUnknownValues reports whether raw values missing from the enum are
+// still stored, as plain numbers. Wear status and ANC mode are; a connection
+// or charging value that cannot be mapped is ignored.
+func (f FieldDefinition) keepsUnknownValues() bool {
+	switch f.Target {
+	case TargetLeftStatus, TargetRightStatus, TargetANCMode:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,409 @@
+package protocol
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinDefinitions(t *testing.T) {
+	defs := BuiltinDefinitions()
+
+	if !defs.HasProduct(0x1038, 0x230A) {
+		t.Error("Built-in definitions should include the GameBuds (1038:230A)")
+	}
+
+	for _, id := range []HexID{ReportBattery, ReportWearStatus, ReportANCMode, ReportInEarEvent} {
+		found := false
+		for _, report := range defs.Reports {
+			if report.ReportID == id {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Built-in definitions missing report 0x%02X", uint16(id))
+		}
+	}
+}
+
+func TestParseDefinitions_HexAndNumericIDs(t *testing.T) {
+	input := `{
+		"name": "test",
+		"products": [{"vendor_id": "0x1038", "product_id": 8970}],
+		"reports": [
+			{"name": "Case", "report_id": "0xB8", "fields": [{"name": "case", "target": "dock_battery", "offset": 1}]}
+		]
+	}`
+
+	defs, err := ParseDefinitions(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseDefinitions failed: %v", err)
+	}
+	if !defs.HasProduct(0x1038, 0x230A) {
+		t.Errorf("Expected product 1038:230A, got %+v", defs.Products)
+	}
+	if defs.Reports[0].ReportID != 0xB8 {
+		t.Errorf("ReportID = 0x%02X, want 0xB8", uint16(defs.Reports[0].ReportID))
+	}
+}
+
+func TestParseDefinitions_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "unknown target",
+			input: `{"reports": [{"report_id": 1, "fields": [{"name": "x", "target": "volume", "offset": 1}]}]}`,
+			want:  "unknown target",
+		},
+		{
+			name:  "offset zero",
+			input: `{"reports": [{"report_id": 1, "fields": [{"name": "x", "target": "battery", "offset": 0}]}]}`,
+			want:  "offset must be at least 1",
+		},
+		{
+			name:  "unknown status",
+			input: `{"reports": [{"report_id": 1, "fields": [{"name": "x", "target": "left_status", "offset": 1, "enum": {"1": "floating"}}]}]}`,
+			want:  "unknown earbud status",
+		},
+		{
+			name:  "enum on battery",
+			input: `{"reports": [{"report_id": 1, "fields": [{"name": "x", "target": "battery", "offset": 1, "enum": {"1": "full"}}]}]}`,
+			want:  "enum is not supported",
+		},
+		{
+			name:  "duplicate report",
+			input: `{"reports": [{"report_id": 1, "fields": []}, {"report_id": "0x01", "fields": []}]}`,
+			want:  "defined more than once",
+		},
+		{
+			name:  "report id too large",
+			input: `{"reports": [{"report_id": "0x100", "fields": []}]}`,
+			want:  "does not fit in a byte",
+		},
+		{
+			name:  "unknown key",
+			input: `{"reports": [], "extra": true}`,
+			want:  "unknown field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDefinitions(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDefinitions_UserOverrides(t *testing.T) {
+	dir := t.TempDir()
+	// Without products, a file amends the built-in GameBuds definitions
+	user := `{
+		"name": "GameBuds case battery",
+		"reports": [
+			{"name": "Battery", "report_id": "0xB7", "min_length": 4, "fields": [
+				{"name": "left", "target": "left_battery", "offset": 1},
+				{"name": "right", "target": "right_battery", "offset": 2},
+				{"name": "case", "target": "dock_battery", "offset": 3}
+			]}
+		]
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "case.json"), []byte(user), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defs, err := LoadDefinitions(dir)
+	if err != nil {
+		t.Fatalf("LoadDefinitions failed: %v", err)
+	}
+
+	h := NewHandlerWithDefinitions(defs)
+	h.ParseReport([]byte{ReportBattery, 70, 75, 40})
+
+	state := h.GetState()
+	if state.DockBattery == nil || *state.DockBattery != 40 {
+		t.Errorf("DockBattery = %v, want 40", state.DockBattery)
+	}
+	// Other built-in reports are still available
+	h.ParseReport([]byte{ReportANCMode, 0x02})
+	if state := h.GetState(); state.ANCMode == nil || *state.ANCMode != ANCActive {
+		t.Errorf("ANCMode = %v, want ANCActive", state.ANCMode)
+	}
+}
+
+func TestLoadDefinitions_ScopedProducts(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// Another product reusing report ID 0xB7 for something else
+		"headset.json": `{
+			"name": "Other headset",
+			"products": [{"vendor_id": "0x1038", "product_id": "0x12AB"}],
+			"reports": [
+				{"name": "Battery", "report_id": "0xB7", "min_length": 2, "fields": [
+					{"name": "level", "target": "battery", "offset": 1}
+				]}
+			]
+		}`,
+		// A file listing a built-in product amends that product's family
+		"gamebuds.json": `{
+			"name": "GameBuds extras",
+			"products": [{"vendor_id": "0x1038", "product_id": "0x230A"}],
+			"reports": [
+				{"name": "Counter", "report_id": "0xC8", "min_length": 2, "fields": [
+					{"name": "count", "target": "dock_battery", "offset": 1}
+				]}
+			]
+		}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defs, err := LoadDefinitions(dir)
+	if err != nil {
+		t.Fatalf("LoadDefinitions failed: %v", err)
+	}
+	if !defs.HasProduct(0x1038, 0x230A) || !defs.HasProduct(0x1038, 0x12AB) {
+		t.Errorf("Expected built-in and user products, got %+v", defs.Products)
+	}
+	if families := defs.Families(); len(families) != 2 {
+		t.Fatalf("Families = %d, want GameBuds and the other headset", len(families))
+	}
+
+	gamebuds, ok := defs.Family(busTypeUSB, 0x1038, 0x230A, "")
+	if !ok {
+		t.Fatal("GameBuds have no family")
+	}
+	h := NewHandlerWithDefinitions(gamebuds)
+	h.ParseReport([]byte{ReportBattery, 70, 75})
+	h.ParseReport([]byte{0xC8, 12})
+	state := h.GetState()
+	if state.LeftBattery == nil || *state.LeftBattery != 70 || state.Battery != nil {
+		t.Errorf("GameBuds battery report was replaced by the other product's: %+v", state)
+	}
+	if state.DockBattery == nil || *state.DockBattery != 12 {
+		t.Errorf("DockBattery = %v, want 12 from the GameBuds extras", state.DockBattery)
+	}
+
+	headset, ok := defs.Family(busTypeUSB, 0x1038, 0x12AB, "")
+	if !ok {
+		t.Fatal("Headset has no family")
+	}
+	h = NewHandlerWithDefinitions(headset)
+	h.ParseReport([]byte{ReportBattery, 55})
+	if state := h.GetState(); state.Battery == nil || *state.Battery != 55 {
+		t.Errorf("Battery = %v, want 55", state.Battery)
+	}
+	if _, ok := headset.MatchProduct(busTypeUSB, 0x1038, 0x230A, ""); ok {
+		t.Error("Headset family should not match the GameBuds")
+	}
+}
+
+func TestLoadDefinitions_MissingDir(t *testing.T) {
+	defs, err := LoadDefinitions(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("Missing directory should not be an error, got: %v", err)
+	}
+	if len(defs.Reports) != len(BuiltinDefinitions().Reports) {
+		t.Errorf("Expected only built-in reports, got %d", len(defs.Reports))
+	}
+}
+
+func TestLoadDefinitions_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadDefinitions(dir); err == nil {
+		t.Error("Expected error for invalid definition file")
+	}
+}
+
+func TestFieldDefinition_MultiByte(t *testing.T) {
+	tests := []struct {
+		name  string
+		field FieldDefinition
+		data  []byte
+		want  int
+		ok    bool
+	}{
+		{"single byte", FieldDefinition{Offset: 1}, []byte{0x01, 0x2A}, 42, true},
+		{"little endian", FieldDefinition{Offset: 1, Width: 2}, []byte{0x01, 0x34, 0x12}, 0x1234, true},
+		{"big endian", FieldDefinition{Offset: 1, Width: 2, Endian: "big"}, []byte{0x01, 0x12, 0x34}, 0x1234, true},
+		{"too short", FieldDefinition{Offset: 2, Width: 2}, []byte{0x01, 0x12, 0x34}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.field.read(tt.data)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("read() = (%d, %v), want (%d, %v)", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestReportDefinition_Describe(t *testing.T) {
+	report := ReportDefinition{Fields: []FieldDefinition{
+		{Name: "level", Offset: 1},
+		{Name: "mode", Offset: 2, Enum: map[string]string{"1": "active"}},
+		{Name: "extra", Offset: 4},
+	}}
+
+	if got := report.Describe([]byte{0x01, 0x2A, 0x01}); got != "level=42, mode=active" {
+		t.Errorf("Describe() = %q, want fields beyond the report left out", got)
+	}
+	if got := report.Describe([]byte{0x01, 0x2A, 0x05}); got != "level=42, mode=unknown(5)" {
+		t.Errorf("Describe() = %q, want unmapped enum values marked unknown", got)
+	}
+}
+
+func TestApplyReport_UnmappedEnumKept(t *testing.T) {
+	h := NewHandler()
+	h.ParseReport([]byte{ReportANCMode, 0x09})
+	h.ParseReport([]byte{ReportWearStatus, 0x00, 0x00, 0x07, 0x03})
+
+	state := h.GetState()
+	if state.ANCMode == nil || *state.ANCMode != ANCMode(0x09) {
+		t.Errorf("ANCMode = %v, want the raw value 9", state.ANCMode)
+	}
+	if state.LeftStatus == nil || *state.LeftStatus != EarbudStatus(0x07) {
+		t.Errorf("LeftStatus = %v, want the raw value 7", state.LeftStatus)
+	}
+	if state.RightStatus == nil || *state.RightStatus != StatusWorn {
+		t.Errorf("RightStatus = %v, want StatusWorn", state.RightStatus)
+	}
+}
+
+func TestMatchProduct(t *testing.T) {
+	defs := BuiltinDefinitions()
+
+	tests := []struct {
+		name      string
+		bus       uint16
+		vendor    uint16
+		product   uint16
+		hidName   string
+		wantMatch bool
+		wantBus   string
+	}{
+		{"usb dongle", 0x0003, 0x1038, 0x230A, "SteelSeries Arctis GameBuds", true, BusUSB},
+		{"usb wrong product", 0x0003, 0x1038, 0x1234, "", false, ""},
+		{"bluetooth by name", 0x0005, 0x1038, 0x12AB, "Arctis GameBuds", true, BusBluetooth},
+		{"bluetooth other device", 0x0005, 0x1038, 0x12AB, "Arctis Nova 7", false, ""},
+		{"dongle id over bluetooth bus", 0x0005, 0x1038, 0x230A, "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product, ok := defs.MatchProduct(tt.bus, tt.vendor, tt.product, tt.hidName)
+			if ok != tt.wantMatch {
+				t.Fatalf("MatchProduct() matched = %v, want %v", ok, tt.wantMatch)
+			}
+			if ok && product.BusName() != tt.wantBus {
+				t.Errorf("BusName() = %q, want %q", product.BusName(), tt.wantBus)
+			}
+		})
+	}
+}
+
+func TestValidate_Products(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"unknown bus", `{"name": "x", "products": [{"vendor_id": 1, "product_id": 2, "bus": "serial"}], "reports": []}`, "bus must be"},
+		{"any product without name", `{"name": "x", "products": [{"vendor_id": 1, "bus": "bluetooth"}], "reports": []}`, "omitted together with name"},
+		{"header too long", `{"name": "x", "products": [{"vendor_id": 1, "product_id": 2, "header_length": 20}], "reports": []}`, "header_length"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDefinitions(strings.NewReader(tt.input))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseDefinitions error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+const ancCommand = `{"name": "Set ANC", "target": "anc_mode", "report": ["0x06", "0xBD", "0x00"], "value_offset": 2,
+	"enum": {"0": "off", "1": "transparency", "2": "active"}}`
+
+func TestCommandDefinition(t *testing.T) {
+	defs, err := ParseDefinitions(strings.NewReader(`{"name": "x", "reports": [], "commands": [` + ancCommand + `]}`))
+	if err != nil {
+		t.Fatalf("ParseDefinitions failed: %v", err)
+	}
+	set := BuiltinDefinitions()
+	if _, ok := set.Command(TargetANCMode); ok {
+		t.Fatal("No command should be built in")
+	}
+	set.Merge(defs)
+
+	command, ok := set.Command(TargetANCMode)
+	if !ok {
+		t.Fatal("Merged command not found")
+	}
+	if got := strings.Join(command.Values(), ","); got != "off,transparency,active" {
+		t.Errorf("Values() = %s, want raw value order", got)
+	}
+	report, err := command.Encode("active")
+	if err != nil || !bytes.Equal(report, []byte{0x06, 0xBD, 0x02}) {
+		t.Errorf("Encode(active) = % X, %v", report, err)
+	}
+	if _, err := command.Encode("loud"); err == nil {
+		t.Error("Encode should reject unknown values")
+	}
+}
+
+func TestValidate_Commands(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+	}{
+		{"unsupported target", `{"name": "x", "target": "battery", "report": [1, 0], "value_offset": 1, "enum": {"0": "off"}}`, "unsupported target"},
+		{"offset outside report", `{"name": "x", "target": "anc_mode", "report": [1, 0], "value_offset": 2, "enum": {"0": "off"}}`, "value_offset"},
+		{"missing enum", `{"name": "x", "target": "anc_mode", "report": [1, 0], "value_offset": 1}`, "enum is required"},
+		{"unknown mode", `{"name": "x", "target": "anc_mode", "report": [1, 0], "value_offset": 1, "enum": {"0": "loud"}}`, "unknown ANC mode"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDefinitions(strings.NewReader(`{"name": "x", "reports": [], "commands": [` + tt.command + `]}`))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseDefinitions error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFramedReport(t *testing.T) {
+	h := NewHandler()
+	if err := h.ParseFramedReport([]byte{0xA1, ReportBattery, 40, 45}, 1); err != nil {
+		t.Fatalf("ParseFramedReport failed: %v", err)
+	}
+	state := h.GetState()
+	if state.LeftBattery == nil || *state.LeftBattery != 40 {
+		t.Errorf("Left battery = %v, want 40 after stripping header", state.LeftBattery)
+	}
+
+	if err := h.ParseFramedReport([]byte{0xA1}, 1); err == nil {
+		t.Error("Expected error for report without payload")
+	}
+}
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"testing"
+	"time"
 )
 
 func TestDeviceState_GetPrimaryBattery_AllCases(t *testing.T) {
@@ -61,6 +62,16 @@ func TestDeviceState_GetPrimaryBattery_AllCases(t *testing.T) {
 			},
 			expected: 90,
 		},
+		{
+			name: "Earbud in the case is ignored",
+			state: DeviceState{
+				LeftBattery:  func() *int { b := 40; return &b }(),
+				RightBattery: func() *int { b := 80; return &b }(),
+				LeftStatus:   func() *EarbudStatus { s := StatusInCase; return &s }(),
+				RightStatus:  func() *EarbudStatus { s := StatusWorn; return &s }(),
+			},
+			expected: 80,
+		},
 	}
 
 	for _, tt := range tests {
@@ -118,3 +129,91 @@ func TestDeviceState_String_Default(t *testing.T) {
 		t.Errorf("String() = %q, want %q", result, expected)
 	}
 }
+
+func TestDeviceState_MarkUpdated(t *testing.T) {
+	var state DeviceState
+	t1 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	state.MarkUpdated(TargetLeftBattery, t1)
+
+	snapshot := state
+	t2 := t1.Add(time.Minute)
+	state.MarkUpdated(TargetRightBattery, t2)
+
+	if !state.LastSeen.Equal(t2) {
+		t.Errorf("LastSeen = %v, want %v", state.LastSeen, t2)
+	}
+	if !state.LastUpdated(TargetLeftBattery).Equal(t1) {
+		t.Errorf("LastUpdated(left) = %v, want %v", state.LastUpdated(TargetLeftBattery), t1)
+	}
+	// Earlier copies must not see later updates
+	if !snapshot.LastUpdated(TargetRightBattery).IsZero() {
+		t.Error("MarkUpdated modified a copy of the state")
+	}
+}
+
+func TestDeviceState_IsStale(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		lastSeen  time.Time
+		threshold time.Duration
+		expected  bool
+	}{
+		{"never seen", time.Time{}, time.Hour, false},
+		{"recent", now.Add(-time.Minute), time.Hour, false},
+		{"old", now.Add(-3 * time.Hour), time.Hour, true},
+		{"disabled", now.Add(-3 * time.Hour), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := DeviceState{LastSeen: tt.lastSeen}
+			if got := state.IsStale(tt.threshold, now); got != tt.expected {
+				t.Errorf("IsStale() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDeviceState_EqualIgnoresTimestamps(t *testing.T) {
+	battery := 50
+	s1 := DeviceState{Battery: &battery}
+	s2 := s1
+	s2.MarkUpdated(TargetBattery, time.Now())
+
+	if !s1.Equal(s2) {
+		t.Error("States differing only in timestamps should be equal")
+	}
+
+	s2.Stale = true
+	if s1.Equal(s2) {
+		t.Error("States differing in Stale should not be equal")
+	}
+}
+
+func TestHandler_RecordsTimestamps(t *testing.T) {
+	h := NewHandler()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	h.ParseReport([]byte{ReportBattery, 75, 80})
+
+	state := h.GetState()
+	if !state.LastSeen.Equal(now) {
+		t.Errorf("LastSeen = %v, want %v", state.LastSeen, now)
+	}
+	if !state.LastUpdated(TargetLeftBattery).Equal(now) {
+		t.Errorf("LastUpdated(left_battery) = %v, want %v", state.LastUpdated(TargetLeftBattery), now)
+	}
+	if !state.LastUpdated(TargetANCMode).IsZero() {
+		t.Error("ANC mode should not have an update time")
+	}
+
+	// Unknown reports still count as the device being seen
+	later := now.Add(time.Hour)
+	h.now = func() time.Time { return later }
+	h.ParseReport([]byte{0xFF, 0x01})
+	if !h.GetState().LastSeen.Equal(later) {
+		t.Errorf("LastSeen after unknown report = %v, want %v", h.GetState().LastSeen, later)
+	}
+}
@@ -3,10 +3,12 @@ package protocol
 import (
 	"fmt"
 	"log"
+	"sync"
+	"time"
 )
 
+// Report IDs of the built-in GameBuds definitions (see definitions/gamebuds.json)
 const (
-	// Report IDs
 	ReportBattery    = 0xB7
 	ReportWearStatus = 0xB5
 	ReportANCMode    = 0xBD
@@ -60,42 +62,107 @@ func (s EarbudStatus) String() string {
 // DeviceState represents the current state of a device
 // Fields are optional - only populated for devices that support them
 type DeviceState struct {
-	DeviceID        string
-	DeviceType      string
-	Battery         *int          // Primary battery level (for single-battery devices)
-	LeftBattery     *int          // Left battery (for dual-battery devices like GameBuds)
-	RightBattery    *int          // Right battery (for dual-battery devices like GameBuds)
-	DockBattery     *int          // Dock/case battery (for GameBuds)
-	IsCharging      *bool         // Whether device is charging
-	LeftStatus      *EarbudStatus // Left earbud status (GameBuds only)
-	RightStatus     *EarbudStatus // Right earbud status (GameBuds only)
-	ANCMode         *ANCMode      // ANC mode (GameBuds only)
-	IsConnected     bool
-	FirmwareVersion string
+	DeviceID            string
+	DeviceType          string
+	Battery             *int             // Primary battery level (for single-battery devices)
+	LeftBattery         *int             // Left battery (for dual-battery devices like GameBuds)
+	RightBattery        *int             // Right battery (for dual-battery devices like GameBuds)
+	DockBattery         *int             // Dock/case battery (for GameBuds)
+	IsCharging          *bool            // Deprecated: use Power. Whether the device is charging, nil when unknown
+	Power               *PowerState      // Primary battery power state
+	LeftPower           *PowerState      // Left earbud power state
+	RightPower          *PowerState      // Right earbud power state
+	DockPower           *PowerState      // Dock/case power state
+	Connection          *ConnectionState // Receiver/device link state (wireless receivers only)
+	Mode                *ConnectionMode  // How the device is attached: wired, wireless or dock (Razer only)
+	LeftStatus          *EarbudStatus    // Left earbud status (GameBuds only)
+	RightStatus         *EarbudStatus    // Right earbud status (GameBuds only)
+	ANCMode             *ANCMode         // ANC mode (GameBuds only)
+	IdleTime            *int             // Seconds without input before the device sleeps (Razer only)
+	LowBatteryThreshold *int             // Battery percentage at which the device warns (Razer only)
+	IsConnected         bool
+	FirmwareVersion     string
+
+	LastSeen time.Time            // When the backend last heard from the device
+	Updated  map[string]time.Time // When each field was last reported, keyed by target name (see Target* constants)
+	Stale    bool                 // Set by DeviceManager when LastSeen is older than the staleness threshold
+	Cached   bool                 // Loaded from a previous run; no fresh data has arrived yet
+}
+
+// MarkUpdated records that a field was reported at t, which also counts as
+// the device being seen. The map is copied so earlier copies of the state are
+// not affected.
+func (s *DeviceState) MarkUpdated(field string, t time.Time) {
+	updated := make(map[string]time.Time, len(s.Updated)+1)
+	for k, v := range s.Updated {
+		updated[k] = v
+	}
+	updated[field] = t
+	s.Updated = updated
+	s.LastSeen = t
+}
+
+// LastUpdated returns when a field was last reported, or the zero time if never
+func (s DeviceState) LastUpdated(field string) time.Time {
+	return s.Updated[field]
+}
+
+// Age returns how long ago the device was last seen, or 0 if it never was
+func (s DeviceState) Age(now time.Time) time.Duration {
+	if s.LastSeen.IsZero() {
+		return 0
+	}
+	return now.Sub(s.LastSeen)
+}
+
+// IsStale reports whether the device has not been heard from within threshold.
+// A zero threshold disables staleness tracking.
+func (s DeviceState) IsStale(threshold time.Duration, now time.Time) bool {
+	if threshold <= 0 || s.LastSeen.IsZero() {
+		return false
+	}
+	return now.Sub(s.LastSeen) > threshold
 }
 
 // GetPrimaryBattery returns the primary battery level
-// For dual-battery devices, returns the lower of the two
+// For dual-battery devices, combines them with DefaultAggregationPolicy, as the tray does
 // Returns -1 if no battery information is available
 func (s DeviceState) GetPrimaryBattery() int {
-	if s.Battery != nil {
-		return *s.Battery
-	}
-	if s.LeftBattery != nil && s.RightBattery != nil {
-		left := *s.LeftBattery
-		right := *s.RightBattery
-		if left < right {
-			return left
-		}
-		return right
+	return AggregateBattery(s, DefaultAggregationPolicy)
+}
+
+// Equal compares two states by value, following pointer fields.
+// Timestamps are ignored so repeated identical reports compare equal.
+func (s DeviceState) Equal(other DeviceState) bool {
+	if s.DeviceID != other.DeviceID || s.DeviceType != other.DeviceType || s.IsConnected != other.IsConnected ||
+		s.Stale != other.Stale || s.Cached != other.Cached {
+		return false
 	}
-	if s.LeftBattery != nil {
-		return *s.LeftBattery
+	if !pointerEqual(s.Battery, other.Battery) ||
+		!pointerEqual(s.LeftBattery, other.LeftBattery) ||
+		!pointerEqual(s.RightBattery, other.RightBattery) ||
+		!pointerEqual(s.DockBattery, other.DockBattery) ||
+		!pointerEqual(s.IsCharging, other.IsCharging) {
+		return false
 	}
-	if s.RightBattery != nil {
-		return *s.RightBattery
+	if !pointerEqual(s.Power, other.Power) ||
+		!pointerEqual(s.LeftPower, other.LeftPower) ||
+		!pointerEqual(s.RightPower, other.RightPower) ||
+		!pointerEqual(s.DockPower, other.DockPower) {
+		return false
 	}
-	return -1
+	if !pointerEqual(s.LeftStatus, other.LeftStatus) ||
+		!pointerEqual(s.RightStatus, other.RightStatus) ||
+		!pointerEqual(s.ANCMode, other.ANCMode) ||
+		!pointerEqual(s.Connection, other.Connection) ||
+		!pointerEqual(s.Mode, other.Mode) {
+		return false
+	}
+	if !pointerEqual(s.IdleTime, other.IdleTime) ||
+		!pointerEqual(s.LowBatteryThreshold, other.LowBatteryThreshold) {
+		return false
+	}
+	return true
 }
 
 func (s DeviceState) String() string {
@@ -130,37 +197,68 @@ func (s DeviceState) String() string {
 		if s.Battery != nil {
 			batteryStr = fmt.Sprintf("%d%%", *s.Battery)
 		}
-		if s.IsCharging != nil && *s.IsCharging {
+		if s.Power != nil {
+			if *s.Power != PowerDischarging && *s.Power != PowerUnknown {
+				chargingStr = fmt.Sprintf(" (%s)", s.Power)
+			}
+		} else if s.IsCharging != nil && *s.IsCharging {
 			chargingStr = " (Charging)"
 		}
 		return fmt.Sprintf("Battery: %s%s", batteryStr, chargingStr)
 	default:
 		batteryStr := "--"
+		powerStr := ""
 		if s.Battery != nil {
 			batteryStr = fmt.Sprintf("%d%%", *s.Battery)
 		}
-		return fmt.Sprintf("Battery: %s", batteryStr)
+		if s.Power != nil && *s.Power != PowerDischarging && *s.Power != PowerUnknown {
+			powerStr = fmt.Sprintf(" (%s)", s.Power)
+		}
+		return fmt.Sprintf("Battery: %s%s", batteryStr, powerStr)
 	}
 }
 
 // Handler processes HID reports from the GameBuds
 type Handler struct {
-	state    DeviceState
-	onChange func(DeviceState) // Callback when state changes
+	state       DeviceState
+	reports     map[uint8]ReportDefinition
+	onChange    func(DeviceState) // Callback when state changes
+	now         func() time.Time
+	linkTimeout time.Duration // Silence after which linked buds count as off; 0 disables
+	lastReport  time.Time     // When the last known report arrived
+	mu          sync.Mutex    // Guards state, lastReport and onChange; readers run concurrently
 }
 
+// NewHandler creates a handler using the built-in report definitions
 func NewHandler() *Handler {
+	return NewHandlerWithDefinitions(BuiltinDefinitions())
+}
+
+// NewHandlerWithDefinitions creates a handler that parses reports according to defs
+func NewHandlerWithDefinitions(defs *DefinitionSet) *Handler {
+	reports := make(map[uint8]ReportDefinition, len(defs.Reports))
+	for _, report := range defs.Reports {
+		reports[uint8(report.ReportID)] = report
+	}
+
+	// The receiver is present, but the buds have not said anything yet
+	connection := ConnectionDongle
 	return &Handler{
 		state: DeviceState{
-			DeviceType:  "steelseries_gamebuds",
-			IsConnected: true,
+			DeviceType: "steelseries_gamebuds",
+			Connection: &connection,
 		},
+		reports:     reports,
+		now:         time.Now,
+		linkTimeout: defs.LinkTimeoutDuration(),
 	}
 }
 
 // SetOnChange sets a callback for when device state changes
 func (h *Handler) SetOnChange(callback func(DeviceState)) {
+	h.mu.Lock()
 	h.onChange = callback
+	h.mu.Unlock()
 }
 
 // ParseReport parses incoming HID data
@@ -170,31 +268,97 @@ func (h *Handler) ParseReport(data []byte) error {
 	}
 
 	reportID := data[0]
+
+	h.mu.Lock()
 	oldState := h.copyState()
 
-	switch reportID {
-	case ReportBattery:
-		h.parseBattery(data)
-	case ReportWearStatus:
-		h.parseWearStatus(data)
-	case ReportANCMode:
-		h.parseANCMode(data)
-	case ReportInEarEvent:
-		h.parseInEarEvent(data)
-	default:
+	// Any report, even an unknown one, shows the receiver is alive
+	h.state.LastSeen = h.now()
+
+	report, ok := h.reports[reportID]
+	if !ok {
+		h.mu.Unlock()
 		// Unknown report, log for discovery
 		log.Printf("Unknown report 0x%02X: %x", reportID, data)
 		return nil
 	}
+	h.applyReport(report, data)
 
-	// If state changed, trigger callback
-	if h.onChange != nil && !h.statesEqual(oldState, h.state) {
-		h.onChange(h.state)
+	// A report carrying state comes from the buds, so they are linked unless
+	// the report itself says otherwise. Log-only reports are not counted.
+	if report.hasTargets() {
+		h.lastReport = h.now()
+		if !report.setsConnection() {
+			h.setConnection(ConnectionLinked)
+		}
 	}
+	h.mu.Unlock()
 
+	h.notifyIfChanged(oldState)
 	return nil
 }
 
+// ParseFramedReport parses a report preceded by headerLength transport bytes,
+// as delivered by products whose definition sets header_length
+func (h *Handler) ParseFramedReport(data []byte, headerLength int) error {
+	if headerLength > 0 {
+		if len(data) <= headerLength {
+			return fmt.Errorf("report shorter than its %d byte header", headerLength)
+		}
+		data = data[headerLength:]
+	}
+	return h.ParseReport(data)
+}
+
+// CheckLink marks linked buds as off once no state report has arrived within
+// the definitions' link timeout. It returns true if the state changed.
+func (h *Handler) CheckLink(now time.Time) bool {
+	h.mu.Lock()
+	if h.linkTimeout <= 0 || h.state.Connection == nil || *h.state.Connection != ConnectionLinked ||
+		now.Sub(h.lastReport) <= h.linkTimeout {
+		h.mu.Unlock()
+		return false
+	}
+	oldState := h.copyState()
+	log.Printf("🎧 No reports from the buds for %v, marking them as off", h.linkTimeout)
+	h.setConnection(ConnectionOff)
+	h.mu.Unlock()
+
+	return h.notifyIfChanged(oldState)
+}
+
+// SetConnection records a connection state detected outside the reports,
+// such as the receiver being unplugged
+func (h *Handler) SetConnection(state ConnectionState) {
+	h.mu.Lock()
+	oldState := h.copyState()
+	h.setConnection(state)
+	h.mu.Unlock()
+
+	h.notifyIfChanged(oldState)
+}
+
+// setConnection updates the connection state; only linked buds count as
+// connected. Must be called with h.mu held.
+func (h *Handler) setConnection(state ConnectionState) {
+	h.state.Connection = &state
+	h.state.IsConnected = state == ConnectionLinked
+}
+
+// notifyIfChanged invokes the callback if the state differs from oldState
+func (h *Handler) notifyIfChanged(oldState DeviceState) bool {
+	h.mu.Lock()
+	state := h.state
+	changed := !h.statesEqual(oldState, state)
+	onChange := h.onChange
+	h.mu.Unlock()
+
+	if changed && onChange != nil {
+		onChange(state)
+	}
+	return changed
+}
+
 // copyState creates a deep copy of the state for comparison
 func (h *Handler) copyState() DeviceState {
 	state := h.state
@@ -203,6 +367,10 @@ func (h *Handler) copyState() DeviceState {
 		DeviceType:      state.DeviceType,
 		IsConnected:     state.IsConnected,
 		FirmwareVersion: state.FirmwareVersion,
+		LastSeen:        state.LastSeen,
+		Updated:         state.Updated,
+		Stale:           state.Stale,
+		Cached:          state.Cached,
 	}
 	if state.Battery != nil {
 		b := *state.Battery
@@ -224,6 +392,14 @@ func (h *Handler) copyState() DeviceState {
 		b := *state.IsCharging
 		copy.IsCharging = &b
 	}
+	copy.Power = copyPointer(state.Power)
+	copy.LeftPower = copyPointer(state.LeftPower)
+	copy.RightPower = copyPointer(state.RightPower)
+	copy.DockPower = copyPointer(state.DockPower)
+	copy.Connection = copyPointer(state.Connection)
+	copy.Mode = copyPointer(state.Mode)
+	copy.IdleTime = copyPointer(state.IdleTime)
+	copy.LowBatteryThreshold = copyPointer(state.LowBatteryThreshold)
 	if state.LeftStatus != nil {
 		s := *state.LeftStatus
 		copy.LeftStatus = &s
@@ -241,22 +417,16 @@ func (h *Handler) copyState() DeviceState {
 
 // statesEqual compares two DeviceState structs, handling pointer fields
 func (h *Handler) statesEqual(s1, s2 DeviceState) bool {
-	if s1.DeviceID != s2.DeviceID || s1.DeviceType != s2.DeviceType || s1.IsConnected != s2.IsConnected {
-		return false
-	}
-	if !pointerEqual(s1.Battery, s2.Battery) ||
-		!pointerEqual(s1.LeftBattery, s2.LeftBattery) ||
-		!pointerEqual(s1.RightBattery, s2.RightBattery) ||
-		!pointerEqual(s1.DockBattery, s2.DockBattery) ||
-		!pointerEqual(s1.IsCharging, s2.IsCharging) {
-		return false
-	}
-	if !pointerEqual(s1.LeftStatus, s2.LeftStatus) ||
-		!pointerEqual(s1.RightStatus, s2.RightStatus) ||
-		!pointerEqual(s1.ANCMode, s2.ANCMode) {
-		return false
+	return s1.Equal(s2)
+}
+
+// copyPointer returns a pointer to a copy of *p, or nil
+func copyPointer[T any](p *T) *T {
+	if p == nil {
+		return nil
 	}
-	return true
+	v := *p
+	return &v
 }
 
 // pointerEqual compares two pointers of the same type
@@ -270,73 +440,117 @@ func pointerEqual[T comparable](p1, p2 *T) bool {
 	return *p1 == *p2
 }
 
-func (h *Handler) parseBattery(data []byte) {
-	if len(data) < 3 {
+// applyReport decodes every field of a report and writes it into the state.
+// Must be called with h.mu held.
+func (h *Handler) applyReport(report ReportDefinition, data []byte) {
+	if len(data) < report.MinLength {
 		return
 	}
 
-	leftBattery := int(data[1])
-	rightBattery := int(data[2])
-
-	// Only update battery if it's a valid reading (not 0 unless actually dead)
-	// When an earbud is in the case, the device sometimes reports 0
-	leftStatus := StatusInCase
-	if h.state.LeftStatus != nil {
-		leftStatus = *h.state.LeftStatus
-	}
-	rightStatus := StatusInCase
-	if h.state.RightStatus != nil {
-		rightStatus = *h.state.RightStatus
-	}
-
-	if leftBattery > 0 || leftStatus == StatusInCase {
-		h.state.LeftBattery = &leftBattery
-	}
-	if rightBattery > 0 || rightStatus == StatusInCase {
-		h.state.RightBattery = &rightBattery
+	for _, field := range report.Fields {
+		raw, ok := field.read(data)
+		if !ok {
+			continue
+		}
+		if name, ok := field.enumName(raw); ok || field.keepsUnknownValues() {
+			h.applyField(field, raw, name)
+		}
 	}
+	h.updatePower()
 
-	log.Printf("🔋 Battery: Left %d%%, Right %d%%", leftBattery, rightBattery)
+	log.Printf("📨 %s: %s", report.Name, report.Describe(data))
 }
 
-func (h *Handler) parseWearStatus(data []byte) {
-	if len(data) < 5 {
-		return
+// applyField stores a decoded value in the DeviceState field named by the target
+func (h *Handler) applyField(field FieldDefinition, raw int, name string) {
+	if raw == 0 && field.ZeroRequiresInCase != "" {
+		// When an earbud is in the case, the device sometimes reports 0.
+		// Outside the case a 0 is treated as a glitch and ignored.
+		if status := h.statusFor(field.ZeroRequiresInCase); status != StatusInCase {
+			return
+		}
 	}
 
-	leftStatus := EarbudStatus(data[3])
-	rightStatus := EarbudStatus(data[4])
-	h.state.LeftStatus = &leftStatus
-	h.state.RightStatus = &rightStatus
-
-	log.Printf("👂 Status: Left=%s, Right=%s", leftStatus, rightStatus)
+	if field.Target != "" {
+		h.state.MarkUpdated(field.Target, h.now())
+	}
+
+	value := raw
+	switch field.Target {
+	case TargetBattery:
+		h.state.Battery = &value
+	case TargetLeftBattery:
+		h.state.LeftBattery = &value
+	case TargetRightBattery:
+		h.state.RightBattery = &value
+	case TargetDockBattery:
+		h.state.DockBattery = &value
+	case TargetIsCharging:
+		charging := raw != 0
+		if name != "" {
+			charging = name == "true"
+		}
+		h.state.IsCharging = &charging
+	case TargetLeftStatus, TargetRightStatus:
+		status := EarbudStatus(raw)
+		if name != "" {
+			status = earbudStatusNames[name]
+		}
+		if field.Target == TargetLeftStatus {
+			h.state.LeftStatus = &status
+		} else {
+			h.state.RightStatus = &status
+		}
+	case TargetANCMode:
+		mode := ANCMode(raw)
+		if name != "" {
+			mode = ancModeNames[name]
+		}
+		h.state.ANCMode = &mode
+	case TargetConnection:
+		h.setConnection(connectionStateNames[name])
+	}
 }
 
-func (h *Handler) parseANCMode(data []byte) {
-	if len(data) < 2 {
-		return
+// updatePower derives the power state of each battery from the latest
+// charging flag and earbud positions
+func (h *Handler) updatePower() {
+	if h.state.IsCharging != nil {
+		battery := 0
+		if h.state.Battery != nil {
+			battery = *h.state.Battery
+		}
+		power := ChargerPowerState(*h.state.IsCharging, false, battery)
+		h.state.Power = &power
+	}
+	if h.state.LeftStatus != nil {
+		power := EarbudPowerState(*h.state.LeftStatus, h.state.LeftBattery)
+		h.state.LeftPower = &power
+	}
+	if h.state.RightStatus != nil {
+		power := EarbudPowerState(*h.state.RightStatus, h.state.RightBattery)
+		h.state.RightPower = &power
 	}
-
-	ancMode := ANCMode(data[1])
-	h.state.ANCMode = &ancMode
-	log.Printf("🎧 ANC Mode: %s", ancMode)
 }
 
-func (h *Handler) parseInEarEvent(data []byte) {
-	if len(data) < 2 {
-		return
+// statusFor returns the named earbud status, defaulting to in case when unknown
+func (h *Handler) statusFor(target string) EarbudStatus {
+	var status *EarbudStatus
+	if target == TargetLeftStatus {
+		status = h.state.LeftStatus
+	} else {
+		status = h.state.RightStatus
 	}
-
-	event := data[1]
-	if event == 1 {
-		log.Println("👂 Earbud removed from ear")
-	} else if event == 0 {
-		log.Println("👂 Earbud placed in ear")
+	if status == nil {
+		return StatusInCase
 	}
+	return *status
 }
 
 // GetState returns the current device state
 func (h *Handler) GetState() DeviceState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	return h.state
 }
 
@@ -60,19 +60,22 @@ func TestParseBattery(t *testing.T) {
 			h := NewHandler()
 			h.state = tt.initialState
 
-			h.parseBattery(tt.data)
+			if err := h.ParseReport(tt.data); err != nil {
+				t.Fatalf("ParseReport failed: %v", err)
+			}
+			state := h.GetState()
 
-			if h.state.LeftBattery == nil || *h.state.LeftBattery != tt.expectedLeft {
+			if state.LeftBattery == nil || *state.LeftBattery != tt.expectedLeft {
 				val := 0
-				if h.state.LeftBattery != nil {
-					val = *h.state.LeftBattery
+				if state.LeftBattery != nil {
+					val = *state.LeftBattery
 				}
 				t.Errorf("Left battery = %d, want %d", val, tt.expectedLeft)
 			}
-			if h.state.RightBattery == nil || *h.state.RightBattery != tt.expectedRight {
+			if state.RightBattery == nil || *state.RightBattery != tt.expectedRight {
 				val := 0
-				if h.state.RightBattery != nil {
-					val = *h.state.RightBattery
+				if state.RightBattery != nil {
+					val = *state.RightBattery
 				}
 				t.Errorf("Right battery = %d, want %d", val, tt.expectedRight)
 			}
@@ -122,13 +125,16 @@ func TestParseWearStatus(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := NewHandler()
-			h.parseWearStatus(tt.data)
+			if err := h.ParseReport(tt.data); err != nil {
+				t.Fatalf("ParseReport failed: %v", err)
+			}
+			state := h.GetState()
 
-			if h.state.LeftStatus == nil || *h.state.LeftStatus != tt.expectedLeft {
-				t.Errorf("Left status = %v, want %v", h.state.LeftStatus, tt.expectedLeft)
+			if state.LeftStatus == nil || *state.LeftStatus != tt.expectedLeft {
+				t.Errorf("Left status = %v, want %v", state.LeftStatus, tt.expectedLeft)
 			}
-			if h.state.RightStatus == nil || *h.state.RightStatus != tt.expectedRight {
-				t.Errorf("Right status = %v, want %v", h.state.RightStatus, tt.expectedRight)
+			if state.RightStatus == nil || *state.RightStatus != tt.expectedRight {
+				t.Errorf("Right status = %v, want %v", state.RightStatus, tt.expectedRight)
 			}
 		})
 	}
@@ -160,10 +166,13 @@ func TestParseANCMode(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			h := NewHandler()
-			h.parseANCMode(tt.data)
+			if err := h.ParseReport(tt.data); err != nil {
+				t.Fatalf("ParseReport failed: %v", err)
+			}
+			state := h.GetState()
 
-			if h.state.ANCMode == nil || *h.state.ANCMode != tt.expectedANC {
-				t.Errorf("ANC mode = %v, want %v", h.state.ANCMode, tt.expectedANC)
+			if state.ANCMode == nil || *state.ANCMode != tt.expectedANC {
+				t.Errorf("ANC mode = %v, want %v", state.ANCMode, tt.expectedANC)
 			}
 		})
 	}
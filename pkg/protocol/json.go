@@ -0,0 +1,277 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion is the version of the JSON format produced for DeviceState and
+// Event. It is bumped whenever a field is removed or changes meaning; adding
+// fields does not change the version. See docs/json_schema.md.
+const SchemaVersion = 1
+
+// Event types
+const (
+	EventStateChanged  = "state_changed"
+	EventDeviceAdded   = "device_added"
+	EventDeviceRemoved = "device_removed"
+)
+
+// Event is a notification about a device, in the canonical wire format shared
+// by every output (CLI, sockets, exporters, history)
+type Event struct {
+	Type     string       `json:"type"`
+	DeviceID string       `json:"device_id"`
+	Time     time.Time    `json:"time"`
+	State    *DeviceState `json:"state"`
+}
+
+// NewEvent creates an event stamped with the current time
+func NewEvent(eventType, deviceID string, state *DeviceState) Event {
+	return Event{
+		Type:     eventType,
+		DeviceID: deviceID,
+		Time:     time.Now(),
+		State:    state,
+	}
+}
+
+type eventJSON struct {
+	SchemaVersion int          `json:"schema_version"`
+	Type          string       `json:"type"`
+	DeviceID      string       `json:"device_id"`
+	Time          time.Time    `json:"time"`
+	State         *DeviceState `json:"state"`
+}
+
+// MarshalJSON writes the event with a schema version
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{
+		SchemaVersion: SchemaVersion,
+		Type:          e.Type,
+		DeviceID:      e.DeviceID,
+		Time:          e.Time,
+		State:         e.State,
+	})
+}
+
+// UnmarshalJSON reads an event, rejecting unsupported schema versions
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var raw eventJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(raw.SchemaVersion); err != nil {
+		return err
+	}
+	*e = Event{
+		Type:     raw.Type,
+		DeviceID: raw.DeviceID,
+		Time:     raw.Time,
+		State:    raw.State,
+	}
+	return nil
+}
+
+// stateJSON is the wire representation of DeviceState. Unknown values are
+// written as explicit nulls rather than omitted.
+type stateJSON struct {
+	SchemaVersion       int                  `json:"schema_version"`
+	DeviceID            string               `json:"device_id"`
+	DeviceType          string               `json:"device_type"`
+	IsConnected         bool                 `json:"is_connected"`
+	Battery             *int                 `json:"battery"`
+	LeftBattery         *int                 `json:"left_battery"`
+	RightBattery        *int                 `json:"right_battery"`
+	DockBattery         *int                 `json:"dock_battery"`
+	IsCharging          *bool                `json:"is_charging"`
+	Power               *PowerState          `json:"power"`
+	LeftPower           *PowerState          `json:"left_power"`
+	RightPower          *PowerState          `json:"right_power"`
+	DockPower           *PowerState          `json:"dock_power"`
+	LeftStatus          *EarbudStatus        `json:"left_status"`
+	RightStatus         *EarbudStatus        `json:"right_status"`
+	ANCMode             *ANCMode             `json:"anc_mode"`
+	Connection          *ConnectionState     `json:"connection"`
+	Mode                *ConnectionMode      `json:"connection_mode"`
+	IdleTime            *int                 `json:"idle_time"`
+	LowBatteryThreshold *int                 `json:"low_battery_threshold"`
+	FirmwareVersion     *string              `json:"firmware_version"`
+	LastSeen            *time.Time           `json:"last_seen"`
+	Updated             map[string]time.Time `json:"updated"`
+	Stale               bool                 `json:"stale"`
+	Cached              bool                 `json:"cached"`
+}
+
+// MarshalJSON writes the state in the versioned wire format
+func (s DeviceState) MarshalJSON() ([]byte, error) {
+	out := stateJSON{
+		SchemaVersion:       SchemaVersion,
+		DeviceID:            s.DeviceID,
+		DeviceType:          s.DeviceType,
+		IsConnected:         s.IsConnected,
+		Battery:             s.Battery,
+		LeftBattery:         s.LeftBattery,
+		RightBattery:        s.RightBattery,
+		DockBattery:         s.DockBattery,
+		IsCharging:          s.IsCharging,
+		Power:               s.Power,
+		LeftPower:           s.LeftPower,
+		RightPower:          s.RightPower,
+		DockPower:           s.DockPower,
+		LeftStatus:          s.LeftStatus,
+		RightStatus:         s.RightStatus,
+		ANCMode:             s.ANCMode,
+		Connection:          s.Connection,
+		Mode:                s.Mode,
+		IdleTime:            s.IdleTime,
+		LowBatteryThreshold: s.LowBatteryThreshold,
+		Updated:             s.Updated,
+		Stale:               s.Stale,
+		Cached:              s.Cached,
+	}
+	if s.FirmwareVersion != "" {
+		out.FirmwareVersion = &s.FirmwareVersion
+	}
+	if !s.LastSeen.IsZero() {
+		out.LastSeen = &s.LastSeen
+	}
+	if out.Updated == nil {
+		out.Updated = map[string]time.Time{}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON reads a state, rejecting unsupported schema versions
+func (s *DeviceState) UnmarshalJSON(data []byte) error {
+	var raw stateJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := checkSchemaVersion(raw.SchemaVersion); err != nil {
+		return err
+	}
+
+	*s = DeviceState{
+		DeviceID:            raw.DeviceID,
+		DeviceType:          raw.DeviceType,
+		IsConnected:         raw.IsConnected,
+		Battery:             raw.Battery,
+		LeftBattery:         raw.LeftBattery,
+		RightBattery:        raw.RightBattery,
+		DockBattery:         raw.DockBattery,
+		IsCharging:          raw.IsCharging,
+		Power:               raw.Power,
+		LeftPower:           raw.LeftPower,
+		RightPower:          raw.RightPower,
+		DockPower:           raw.DockPower,
+		LeftStatus:          raw.LeftStatus,
+		RightStatus:         raw.RightStatus,
+		ANCMode:             raw.ANCMode,
+		Connection:          raw.Connection,
+		Mode:                raw.Mode,
+		IdleTime:            raw.IdleTime,
+		LowBatteryThreshold: raw.LowBatteryThreshold,
+		Stale:               raw.Stale,
+		Cached:              raw.Cached,
+	}
+	if raw.FirmwareVersion != nil {
+		s.FirmwareVersion = *raw.FirmwareVersion
+	}
+	if raw.LastSeen != nil {
+		s.LastSeen = *raw.LastSeen
+	}
+	if len(raw.Updated) > 0 {
+		s.Updated = raw.Updated
+	}
+	return nil
+}
+
+func checkSchemaVersion(version int) error {
+	if version == 0 {
+		return fmt.Errorf("missing schema_version")
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("unsupported schema_version %d (this build supports up to %d)", version, SchemaVersion)
+	}
+	return nil
+}
+
+// enumUnknown is the identifier written for enum values without a stable name
+const enumUnknown = "unknown"
+
+// ID returns the stable identifier for the status, e.g. "in_case"
+func (s EarbudStatus) ID() string {
+	for name, status := range earbudStatusNames {
+		if status == s {
+			return name
+		}
+	}
+	return enumUnknown
+}
+
+// MarshalText writes the stable identifier, or the raw value as a decimal
+// string when the status has no name, so it survives a round trip
+func (s EarbudStatus) MarshalText() ([]byte, error) {
+	if id := s.ID(); id != enumUnknown {
+		return []byte(id), nil
+	}
+	return []byte(strconv.Itoa(int(s))), nil
+}
+
+// UnmarshalText parses a stable identifier or a raw value
+func (s *EarbudStatus) UnmarshalText(text []byte) error {
+	if string(text) == enumUnknown {
+		*s = 0
+		return nil
+	}
+	if status, ok := earbudStatusNames[string(text)]; ok {
+		*s = status
+		return nil
+	}
+	raw, err := strconv.Atoi(string(text))
+	if err != nil {
+		return fmt.Errorf("unknown earbud status %q", text)
+	}
+	*s = EarbudStatus(raw)
+	return nil
+}
+
+// ID returns the stable identifier for the mode, e.g. "transparency"
+func (m ANCMode) ID() string {
+	for name, mode := range ancModeNames {
+		if mode == m {
+			return name
+		}
+	}
+	return enumUnknown
+}
+
+// MarshalText writes the stable identifier, or the raw value as a decimal
+// string when the mode has no name, so it survives a round trip
+func (m ANCMode) MarshalText() ([]byte, error) {
+	if id := m.ID(); id != enumUnknown {
+		return []byte(id), nil
+	}
+	return []byte(strconv.Itoa(int(m))), nil
+}
+
+// UnmarshalText parses a stable identifier or a raw value
+func (m *ANCMode) UnmarshalText(text []byte) error {
+	if string(text) == enumUnknown {
+		*m = -1
+		return nil
+	}
+	if mode, ok := ancModeNames[string(text)]; ok {
+		*m = mode
+		return nil
+	}
+	raw, err := strconv.Atoi(string(text))
+	if err != nil {
+		return fmt.Errorf("unknown ANC mode %q", text)
+	}
+	*m = ANCMode(raw)
+	return nil
+}
@@ -0,0 +1,206 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeviceStateJSON_RoundTrip(t *testing.T) {
+	left, right := 75, 80
+	leftStatus, rightStatus := StatusWorn, StatusInCase
+	anc := ANCTransparency
+	charging := false
+	idle, threshold := 300, 10
+	mode := ModeWireless
+	seen := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	state := DeviceState{
+		DeviceID:            "steelseries_gamebuds",
+		DeviceType:          "steelseries_gamebuds",
+		LeftBattery:         &left,
+		RightBattery:        &right,
+		IsCharging:          &charging,
+		LeftStatus:          &leftStatus,
+		RightStatus:         &rightStatus,
+		ANCMode:             &anc,
+		Mode:                &mode,
+		IdleTime:            &idle,
+		LowBatteryThreshold: &threshold,
+		IsConnected:         true,
+		FirmwareVersion:     "1.2.3",
+	}
+	state.MarkUpdated(TargetLeftBattery, seen)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var decoded DeviceState
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if !decoded.Equal(state) {
+		t.Errorf("Round trip changed state:\n got  %s\n want %s", decoded, state)
+	}
+	if decoded.FirmwareVersion != "1.2.3" {
+		t.Errorf("FirmwareVersion = %q, want 1.2.3", decoded.FirmwareVersion)
+	}
+	if !decoded.LastSeen.Equal(seen) || !decoded.LastUpdated(TargetLeftBattery).Equal(seen) {
+		t.Errorf("Timestamps not preserved: LastSeen=%v Updated=%v", decoded.LastSeen, decoded.Updated)
+	}
+}
+
+func TestDeviceStateJSON_Format(t *testing.T) {
+	status := StatusInCase
+	anc := ANCActive
+	state := DeviceState{
+		DeviceID:    "steelseries_gamebuds",
+		DeviceType:  "steelseries_gamebuds",
+		LeftStatus:  &status,
+		ANCMode:     &anc,
+		IsConnected: true,
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Output is not a JSON object: %v", err)
+	}
+
+	if fields["schema_version"] != float64(SchemaVersion) {
+		t.Errorf("schema_version = %v, want %d", fields["schema_version"], SchemaVersion)
+	}
+	if fields["left_status"] != "in_case" {
+		t.Errorf("left_status = %v, want in_case", fields["left_status"])
+	}
+	if fields["anc_mode"] != "active" {
+		t.Errorf("anc_mode = %v, want active", fields["anc_mode"])
+	}
+
+	// Unknown values are explicit nulls, not missing keys
+	for _, key := range []string{"battery", "left_battery", "right_battery", "dock_battery", "is_charging", "right_status", "connection_mode", "idle_time", "low_battery_threshold", "firmware_version", "last_seen"} {
+		value, ok := fields[key]
+		if !ok {
+			t.Errorf("Key %q missing from output", key)
+		} else if value != nil {
+			t.Errorf("Key %q = %v, want null", key, value)
+		}
+	}
+}
+
+func TestDeviceStateJSON_SchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"missing", `{"device_id": "x"}`, "missing schema_version"},
+		{"too new", `{"schema_version": 99, "device_id": "x"}`, "unsupported schema_version 99"},
+		{"bad enum", `{"schema_version": 1, "left_status": "floating"}`, "unknown earbud status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var state DeviceState
+			err := json.Unmarshal([]byte(tt.input), &state)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Unmarshal error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnumIDs(t *testing.T) {
+	if got := StatusOut.ID(); got != "out" {
+		t.Errorf("StatusOut.ID() = %q, want out", got)
+	}
+	if got := EarbudStatus(99).ID(); got != "unknown" {
+		t.Errorf("EarbudStatus(99).ID() = %q, want unknown", got)
+	}
+	if got := ModeDock.ID(); got != "dock" {
+		t.Errorf("ModeDock.ID() = %q, want dock", got)
+	}
+	if got := ANCOff.ID(); got != "off" {
+		t.Errorf("ANCOff.ID() = %q, want off", got)
+	}
+	if got := ANCMode(99).ID(); got != "unknown" {
+		t.Errorf("ANCMode(99).ID() = %q, want unknown", got)
+	}
+}
+
+func TestDeviceStateJSON_UnnamedEnumRoundTrip(t *testing.T) {
+	// Raw values kept from reports without a name in the definitions
+	status, anc := EarbudStatus(9), ANCMode(9)
+	state := DeviceState{DeviceID: "steelseries_gamebuds", LeftStatus: &status, ANCMode: &anc}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"left_status":"9"`) || !strings.Contains(string(data), `"anc_mode":"9"`) {
+		t.Errorf("JSON = %s, want the raw values written as \"9\"", data)
+	}
+
+	var decoded DeviceState
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.LeftStatus == nil || *decoded.LeftStatus != 9 {
+		t.Errorf("LeftStatus = %v, want the raw value 9", decoded.LeftStatus)
+	}
+	if decoded.ANCMode == nil || *decoded.ANCMode != 9 {
+		t.Errorf("ANCMode = %v, want the raw value 9", decoded.ANCMode)
+	}
+
+	// Files written before raw values were kept still load
+	if err := json.Unmarshal([]byte(`{"schema_version": 1, "left_status": "unknown"}`), &decoded); err != nil {
+		t.Errorf("Unmarshal of unknown failed: %v", err)
+	}
+}
+
+func TestEventJSON_RoundTrip(t *testing.T) {
+	battery := 42
+	event := Event{
+		Type:     EventStateChanged,
+		DeviceID: "mouse",
+		Time:     time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		State:    &DeviceState{DeviceID: "mouse", DeviceType: "razer_deathadder", Battery: &battery},
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"schema_version":1`) {
+		t.Errorf("Event JSON missing schema version: %s", data)
+	}
+
+	var decoded Event
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.Type != EventStateChanged || decoded.DeviceID != "mouse" || !decoded.Time.Equal(event.Time) {
+		t.Errorf("Decoded event = %+v, want %+v", decoded, event)
+	}
+	if decoded.State == nil || !decoded.State.Equal(*event.State) {
+		t.Errorf("Decoded state = %v, want %v", decoded.State, event.State)
+	}
+}
+
+func TestEventJSON_NullState(t *testing.T) {
+	data, err := json.Marshal(NewEvent(EventDeviceRemoved, "mouse", nil))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"state":null`) {
+		t.Errorf("Expected explicit null state, got %s", data)
+	}
+}
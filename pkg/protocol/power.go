@@ -0,0 +1,102 @@
+package protocol
+
+import "fmt"
+
+// PowerState describes whether a battery is charging, draining or idle on power
+type PowerState int
+
+const (
+	PowerUnknown     PowerState = iota
+	PowerDischarging            // Running on battery
+	PowerCharging               // Plugged in (or in the case) and charging
+	PowerFull                   // Plugged in and fully charged
+	PowerNotCharging            // Plugged in but not charging, e.g. charge limit or fault
+)
+
+var powerStateNames = map[string]PowerState{
+	"discharging":  PowerDischarging,
+	"charging":     PowerCharging,
+	"full":         PowerFull,
+	"not_charging": PowerNotCharging,
+}
+
+func (p PowerState) String() string {
+	switch p {
+	case PowerDischarging:
+		return "Discharging"
+	case PowerCharging:
+		return "Charging"
+	case PowerFull:
+		return "Full"
+	case PowerNotCharging:
+		return "Not Charging"
+	default:
+		return "Unknown"
+	}
+}
+
+// ID returns the stable identifier for the power state, e.g. "not_charging"
+func (p PowerState) ID() string {
+	for name, state := range powerStateNames {
+		if state == p {
+			return name
+		}
+	}
+	return enumUnknown
+}
+
+// MarshalText writes the stable identifier
+func (p PowerState) MarshalText() ([]byte, error) {
+	return []byte(p.ID()), nil
+}
+
+// UnmarshalText parses a stable identifier
+func (p *PowerState) UnmarshalText(text []byte) error {
+	if string(text) == enumUnknown {
+		*p = PowerUnknown
+		return nil
+	}
+	state, ok := powerStateNames[string(text)]
+	if !ok {
+		return fmt.Errorf("unknown power state %q", text)
+	}
+	*p = state
+	return nil
+}
+
+// IsPluggedIn reports whether the battery is on external power
+func (p PowerState) IsPluggedIn() bool {
+	return p == PowerCharging || p == PowerFull || p == PowerNotCharging
+}
+
+// ChargerPowerState derives the power state of a battery from a charging flag.
+// plugged reports whether the device is known to be on external power even
+// when it is not charging, e.g. a mouse connected by cable.
+func ChargerPowerState(charging, plugged bool, battery int) PowerState {
+	switch {
+	case (charging || plugged) && battery >= 100:
+		return PowerFull
+	case charging:
+		return PowerCharging
+	case plugged:
+		return PowerNotCharging
+	default:
+		return PowerDischarging
+	}
+}
+
+// EarbudPowerState derives the power state of an earbud from where it is.
+// Earbuds charge whenever they are in the case.
+func EarbudPowerState(status EarbudStatus, battery *int) PowerState {
+	switch status {
+	case StatusInCase:
+		if battery != nil && *battery >= 100 {
+			return PowerFull
+		}
+		return PowerCharging
+	case StatusOut, StatusWorn:
+		return PowerDischarging
+	default:
+		return PowerUnknown
+	}
+}
@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestChargerPowerState(t *testing.T) {
+	tests := []struct {
+		name     string
+		charging bool
+		plugged  bool
+		battery  int
+		want     PowerState
+	}{
+		{"on battery", false, false, 60, PowerDischarging},
+		{"charging", true, false, 60, PowerCharging},
+		{"charging full", true, false, 100, PowerFull},
+		{"plugged not charging", false, true, 60, PowerNotCharging},
+		{"plugged full", false, true, 100, PowerFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChargerPowerState(tt.charging, tt.plugged, tt.battery); got != tt.want {
+				t.Errorf("ChargerPowerState(%v, %v, %d) = %v, want %v", tt.charging, tt.plugged, tt.battery, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEarbudPowerState(t *testing.T) {
+	low, full := 40, 100
+
+	tests := []struct {
+		name    string
+		status  EarbudStatus
+		battery *int
+		want    PowerState
+	}{
+		{"in case charging", StatusInCase, &low, PowerCharging},
+		{"in case full", StatusInCase, &full, PowerFull},
+		{"in case unknown battery", StatusInCase, nil, PowerCharging},
+		{"out", StatusOut, &full, PowerDischarging},
+		{"worn", StatusWorn, &low, PowerDischarging},
+		{"unknown status", EarbudStatus(9), &low, PowerUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EarbudPowerState(tt.status, tt.battery); got != tt.want {
+				t.Errorf("EarbudPowerState(%v) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPowerStateJSON(t *testing.T) {
+	power := PowerNotCharging
+	data, err := json.Marshal(DeviceState{DeviceID: "mouse", Power: &power})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Output is not a JSON object: %v", err)
+	}
+	if fields["power"] != "not_charging" {
+		t.Errorf("power = %v, want not_charging", fields["power"])
+	}
+	if value, ok := fields["left_power"]; !ok || value != nil {
+		t.Errorf("left_power = %v, want explicit null", value)
+	}
+
+	var decoded DeviceState
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.Power == nil || *decoded.Power != PowerNotCharging {
+		t.Errorf("Decoded power = %v, want not_charging", decoded.Power)
+	}
+
+	if err := json.Unmarshal([]byte(`{"schema_version": 1, "power": "exploding"}`), &decoded); err == nil {
+		t.Error("Expected error for unknown power state")
+	}
+}
+
+func TestHandler_DerivesEarbudPower(t *testing.T) {
+	h := NewHandler()
+	h.ParseReport([]byte{ReportWearStatus, 0x00, 0x00, 0x01, 0x03})
+	h.ParseReport([]byte{ReportBattery, 100, 55})
+
+	state := h.GetState()
+	if state.LeftPower == nil || *state.LeftPower != PowerFull {
+		t.Errorf("LeftPower = %v, want Full", state.LeftPower)
+	}
+	if state.RightPower == nil || *state.RightPower != PowerDischarging {
+		t.Errorf("RightPower = %v, want Discharging", state.RightPower)
+	}
+	if state.Power != nil {
+		t.Errorf("Power = %v, want nil without a charging report", *state.Power)
+	}
+}
@@ -0,0 +1,161 @@
+package ui
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/getlantern/systray"
+)
+
+// The settings submenus are created up front, since systray can hide menu
+// items but not remove them
+const (
+	maxSettings = 4 // Settings per device section
+	maxChoices  = 6 // Values offered per setting
+)
+
+// SettingMenu is a device setting as shown in the tray. The tray knows
+// nothing about the setting beyond its text; a chosen value is handed back
+// as the string from Choices.
+type SettingMenu struct {
+	ID      string   // Passed back when a value is chosen
+	Name    string   // Label, e.g. "Sleep After"
+	Value   string   // Current value, e.g. "300s"
+	Choices []string // Values offered, e.g. presets of a range setting
+}
+
+// settingsSection is the "Settings" submenu of a device section, or the
+// submenu of a device listed under Other Devices
+type settingsSection struct {
+	parent   *systray.MenuItem     // Shown only while there are settings; nil under Other Devices
+	items    []*systray.MenuItem   // One per setting
+	choices  [][]*systray.MenuItem // Choice items of each setting
+	deviceID string
+	settings []SettingMenu
+}
+
+// addSettingsSection adds a hidden settings submenu and starts listening for
+// clicks on its choices
+func (t *TrayManager) addSettingsSection() *settingsSection {
+	parent := systray.AddMenuItem("  ⚙️ Settings", "Device settings")
+	parent.Hide()
+	section := t.addSettingsItems(parent)
+	section.parent = parent
+	return section
+}
+
+// addSettingsItems adds hidden setting items under menu and starts listening
+// for clicks on their choices
+func (t *TrayManager) addSettingsItems(menu *systray.MenuItem) *settingsSection {
+	section := &settingsSection{}
+	for i := 0; i < maxSettings; i++ {
+		item := menu.AddSubMenuItem("", "")
+		item.Hide()
+		var choices []*systray.MenuItem
+		for j := 0; j < maxChoices; j++ {
+			choice := item.AddSubMenuItemCheckbox("", "", false)
+			choice.Hide()
+			choices = append(choices, choice)
+			go t.watchChoice(section, i, j, choice)
+		}
+		section.items = append(section.items, item)
+		section.choices = append(section.choices, choices)
+	}
+	return section
+}
+
+// SetOnSettingSelected sets the callback for a value chosen from a settings
+// submenu
+func (t *TrayManager) SetOnSettingSelected(callback func(deviceID, settingID, value string)) {
+	t.mu.Lock()
+	t.onSettingSelected = callback
+	t.mu.Unlock()
+}
+
+// SetDeviceSettings shows the settings of a device in its section's
+// submenu, or in the submenu of its entry under Other Devices. An empty list
+// hides them.
+func (t *TrayManager) SetDeviceSettings(deviceID string, settings []SettingMenu) {
+	if len(settings) > maxSettings {
+		log.Printf("Only showing %d of %d settings for %s", maxSettings, len(settings), deviceID)
+		settings = settings[:maxSettings]
+	}
+
+	t.mu.Lock()
+	t.settings[deviceID] = settings
+	section := t.sectionOf(deviceID)
+	t.mu.Unlock()
+
+	switch section {
+	case sectionGameBuds:
+		t.showSettings(t.gameBudsSettings, deviceID, settings)
+	case sectionRazer:
+		t.showSettings(t.razerSettings, deviceID, settings)
+	default:
+		t.updateOthers()
+	}
+}
+
+// showSettings fills a settings submenu with a device's settings
+func (t *TrayManager) showSettings(section *settingsSection, deviceID string, settings []SettingMenu) {
+	t.mu.Lock()
+	section.deviceID = deviceID
+	section.settings = settings
+	t.mu.Unlock()
+
+	if section.parent != nil {
+		if len(settings) == 0 {
+			section.parent.Hide()
+			return
+		}
+		section.parent.Show()
+	}
+
+	for i, item := range section.items {
+		if i >= len(settings) {
+			item.Hide()
+			continue
+		}
+		setting := settings[i]
+		item.SetTitle(settingTitle(setting))
+		item.Show()
+
+		for j, choice := range section.choices[i] {
+			if j >= len(setting.Choices) {
+				choice.Hide()
+				continue
+			}
+			choice.SetTitle(setting.Choices[j])
+			if setting.Choices[j] == setting.Value {
+				choice.Check()
+			} else {
+				choice.Uncheck()
+			}
+			choice.Show()
+		}
+	}
+}
+
+// watchChoice passes clicks on one choice item to the callback
+func (t *TrayManager) watchChoice(section *settingsSection, setting, choice int, item *systray.MenuItem) {
+	for range item.ClickedCh {
+		t.mu.RLock()
+		callback := t.onSettingSelected
+		deviceID := section.deviceID
+		var settingID, value string
+		if setting < len(section.settings) && choice < len(section.settings[setting].Choices) {
+			settingID = section.settings[setting].ID
+			value = section.settings[setting].Choices[choice]
+		}
+		t.mu.RUnlock()
+
+		if callback != nil && settingID != "" {
+			callback(deviceID, settingID, value)
+		}
+	}
+}
+
+// settingTitle shows a setting with its current value, e.g. "Sleep After: 300s"
+func settingTitle(setting SettingMenu) string {
+	return fmt.Sprintf("%s: %s", setting.Name, setting.Value)
+}
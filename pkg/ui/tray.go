@@ -1,39 +1,83 @@
 package ui
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/getlantern/systray"
 	"github.com/jyablonski/goarctis/pkg/protocol"
 	"github.com/jyablonski/goarctis/pkg/version"
 )
 
+// maxOtherDevices is how many generic HID battery and plugin devices the tray
+// has room for
+const maxOtherDevices = 4
+
+// Sections a device can be shown in. The GameBuds and Razer sections show
+// the first device of their type; any further ones are listed under Other
+// Devices.
+const (
+	sectionOther = iota
+	sectionGameBuds
+	sectionRazer
+)
+
 type TrayManager struct {
 	mStatus *systray.MenuItem
 	mQuit   *systray.MenuItem
 
 	// Device-specific menu items
-	gameBudsMenu  *systray.MenuItem
-	gameBudsLeft  *systray.MenuItem
-	gameBudsRight *systray.MenuItem
-	gameBudsANC   *systray.MenuItem
-	razerMenu     *systray.MenuItem
-	razerBattery  *systray.MenuItem
-	razerCharging *systray.MenuItem
+	gameBudsMenu     *systray.MenuItem
+	gameBudsLeft     *systray.MenuItem
+	gameBudsRight    *systray.MenuItem
+	gameBudsANC      *systray.MenuItem
+	gameBudsSettings *settingsSection
+	razerMenu        *systray.MenuItem
+	razerBattery     *systray.MenuItem
+	razerCharging    *systray.MenuItem
+	razerSettings    *settingsSection
+	otherMenu        *systray.MenuItem
+	otherItems       []*systray.MenuItem // Hidden until assigned to a device
+	otherSettings    []*settingsSection  // Settings submenu of each other item
+	mConfirm         *systray.MenuItem   // Hidden until Confirm asks a question
+	confirmMu        sync.Mutex          // One question at a time
 
 	// State tracking
-	devices map[string]protocol.DeviceState
-	mu      sync.RWMutex
+	devices    map[string]protocol.DeviceState
+	names      map[string]string        // Display name per device ID, for generic devices
+	health     map[string]string        // Supervision label per device ID, "" when healthy
+	settings   map[string][]SettingMenu // Settings per device ID
+	gameBudsID string                   // Device shown in the GameBuds section
+	razerID    string                   // Device shown in the Razer section
+	// aggregation combines each device's batteries into the title level
+	aggregation protocol.AggregationConfig
+	// onSettingSelected is called with a value chosen from a settings submenu
+	onSettingSelected func(deviceID, settingID, value string)
+	mu                sync.RWMutex
 }
 
 func NewTrayManager() *TrayManager {
 	return &TrayManager{
-		devices: make(map[string]protocol.DeviceState),
+		devices:     make(map[string]protocol.DeviceState),
+		names:       make(map[string]string),
+		health:      make(map[string]string),
+		settings:    make(map[string][]SettingMenu),
+		aggregation: protocol.DefaultAggregationConfig(),
 	}
 }
 
+// SetAggregation sets how each device's batteries are combined into the
+// level shown in the tray title and tooltip
+func (t *TrayManager) SetAggregation(cfg protocol.AggregationConfig) {
+	t.mu.Lock()
+	t.aggregation = cfg
+	t.mu.Unlock()
+}
+
 func (t *TrayManager) Initialize() {
 	systray.SetTitle("🎧")
 	systray.SetTooltip(fmt.Sprintf("Battery Monitor (v%s)", version.Version))
@@ -53,6 +97,7 @@ func (t *TrayManager) Initialize() {
 	t.gameBudsRight.Disable()
 	t.gameBudsANC = systray.AddMenuItem("  ANC: Unknown", "Noise cancellation mode")
 	t.gameBudsANC.Disable()
+	t.gameBudsSettings = t.addSettingsSection()
 
 	systray.AddSeparator()
 
@@ -63,11 +108,52 @@ func (t *TrayManager) Initialize() {
 	t.razerBattery.Disable()
 	t.razerCharging = systray.AddMenuItem("  Charging: --", "Charging status")
 	t.razerCharging.Disable()
+	t.razerSettings = t.addSettingsSection()
+
+	systray.AddSeparator()
+
+	// Other HID battery and plugin devices, one item each (initially
+	// hidden), with the device's settings as its submenu
+	t.otherMenu = systray.AddMenuItem("🔋 Other Devices", "Other devices reporting a battery")
+	t.otherMenu.Disable()
+	for i := 0; i < maxOtherDevices; i++ {
+		item := systray.AddMenuItem("", "Battery level")
+		item.Disable()
+		item.Hide()
+		t.otherItems = append(t.otherItems, item)
+		t.otherSettings = append(t.otherSettings, t.addSettingsItems(item))
+	}
 
 	systray.AddSeparator()
+	t.mConfirm = systray.AddMenuItem("", "Click to confirm")
+	t.mConfirm.Hide()
 	t.mQuit = systray.AddMenuItem("Quit", "Quit goarctis")
 }
 
+// Confirm shows question as a menu item and reports whether the user clicked
+// it before ctx ended
+func (t *TrayManager) Confirm(ctx context.Context, question string) bool {
+	t.confirmMu.Lock()
+	defer t.confirmMu.Unlock()
+
+	// Drop a click left over from an earlier question
+	select {
+	case <-t.mConfirm.ClickedCh:
+	default:
+	}
+
+	t.mConfirm.SetTitle("❓ " + question)
+	t.mConfirm.Show()
+	defer t.mConfirm.Hide()
+
+	select {
+	case <-t.mConfirm.ClickedCh:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (t *TrayManager) SetStatus(status string) {
 	t.mStatus.SetTitle(status)
 }
@@ -75,36 +161,147 @@ func (t *TrayManager) SetStatus(status string) {
 func (t *TrayManager) UpdateDeviceState(deviceID string, state protocol.DeviceState) {
 	t.mu.Lock()
 	t.devices[deviceID] = state
+	section := t.claimSection(deviceID, state.DeviceType)
 	t.mu.Unlock()
 
 	log.Printf("State updated for %s: %s", deviceID, state)
 
 	// Update device-specific UI
-	switch state.DeviceType {
-	case "steelseries_gamebuds":
-		t.updateGameBuds(state)
-	case "razer_deathadder":
-		t.updateRazer(state)
+	switch section {
+	case sectionGameBuds:
+		t.updateGameBuds(deviceID, state)
+	case sectionRazer:
+		t.updateRazer(deviceID, state)
+	default:
+		t.updateOthers()
 	}
 
 	// Update tray icon and tooltip
 	t.updateTrayIcon()
 }
 
-func (t *TrayManager) updateGameBuds(state protocol.DeviceState) {
+// SetDeviceName sets the name shown for a device in the Other Devices section
+func (t *TrayManager) SetDeviceName(deviceID, name string) {
+	t.mu.Lock()
+	t.names[deviceID] = name
+	t.mu.Unlock()
+}
+
+// claimSection returns the section a device is shown in, giving it the
+// section of its type if no other device has it. Must be called with t.mu held.
+func (t *TrayManager) claimSection(deviceID, deviceType string) int {
+	switch deviceType {
+	case "steelseries_gamebuds":
+		if t.gameBudsID == "" {
+			t.gameBudsID = deviceID
+		}
+	case "razer_deathadder":
+		if t.razerID == "" {
+			t.razerID = deviceID
+		}
+	}
+	return t.sectionOf(deviceID)
+}
+
+// sectionOf returns the section a device is shown in. Must be called with
+// t.mu held.
+func (t *TrayManager) sectionOf(deviceID string) int {
+	switch deviceID {
+	case t.gameBudsID:
+		return sectionGameBuds
+	case t.razerID:
+		return sectionRazer
+	default:
+		return sectionOther
+	}
+}
+
+// UpdateDeviceHealth shows a supervision label such as "reconnecting (attempt 3)"
+// or "failed: ..." next to the device, in its section header or its Other
+// Devices item. An empty label clears it.
+func (t *TrayManager) UpdateDeviceHealth(deviceID, label string) {
+	t.mu.Lock()
+	t.health[deviceID] = label
+	_, known := t.devices[deviceID]
+	section := t.sectionOf(deviceID)
+	t.mu.Unlock()
+
+	if label != "" {
+		log.Printf("Health updated for %s: %s", deviceID, label)
+	}
+	// A device without a state yet has no place in the menu; the label is
+	// shown once it reports
+	if !known {
+		return
+	}
+
+	switch section {
+	case sectionGameBuds:
+		t.gameBudsMenu.SetTitle(t.sectionTitle("🎧 GameBuds", deviceID))
+		t.gameBudsMenu.Enable()
+	case sectionRazer:
+		t.razerMenu.SetTitle(t.sectionTitle("🖱️ Razer Device", deviceID))
+		t.razerMenu.Enable()
+	default:
+		t.updateOthers()
+	}
+}
+
+// sectionTitle builds a section header from the latest state and health of a device
+func (t *TrayManager) sectionTitle(name, deviceID string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	suffix := ""
+	if state, ok := t.devices[deviceID]; ok {
+		suffix = staleSuffix(state) + connectionSuffix(state)
+	}
+	return name + suffix + healthSuffix(t.health[deviceID])
+}
+
+// connectionSuffix returns " (buds off)" style text for devices behind a
+// receiver that are not currently linked, or "" otherwise
+func connectionSuffix(state protocol.DeviceState) string {
+	if state.Connection == nil {
+		return ""
+	}
+	switch *state.Connection {
+	case protocol.ConnectionOff:
+		return " (buds off)"
+	case protocol.ConnectionDongle:
+		return " (waiting for buds)"
+	case protocol.ConnectionDisconnected:
+		return " (dongle unplugged)"
+	case protocol.ConnectionAsleep:
+		return " (asleep)"
+	default:
+		return ""
+	}
+}
+
+// healthSuffix returns " — reconnecting (attempt 3)" style text, or "" when healthy
+func healthSuffix(label string) string {
+	if label == "" {
+		return ""
+	}
+	return " — " + label
+}
+
+func (t *TrayManager) updateGameBuds(deviceID string, state protocol.DeviceState) {
 	// Show GameBuds menu
-	t.gameBudsMenu.SetTitle("🎧 GameBuds")
+	suffix := staleSuffix(state)
+	t.gameBudsMenu.SetTitle(t.sectionTitle("🎧 GameBuds", deviceID))
 	t.gameBudsMenu.Enable()
 
 	// Update Left battery
-	leftText := formatGameBudsBattery(state.LeftBattery, state.LeftStatus, "Left")
-	t.gameBudsLeft.SetTitle("  " + leftText)
-	t.gameBudsLeft.Enable()
+	leftText := formatGameBudsBattery(state.LeftBattery, state.LeftStatus, state.LeftPower, "Left")
+	t.gameBudsLeft.SetTitle("  " + leftText + suffix)
+	setItemEnabled(t.gameBudsLeft, !isOutdated(state))
 
 	// Update Right battery
-	rightText := formatGameBudsBattery(state.RightBattery, state.RightStatus, "Right")
-	t.gameBudsRight.SetTitle("  " + rightText)
-	t.gameBudsRight.Enable()
+	rightText := formatGameBudsBattery(state.RightBattery, state.RightStatus, state.RightPower, "Right")
+	t.gameBudsRight.SetTitle("  " + rightText + suffix)
+	setItemEnabled(t.gameBudsRight, !isOutdated(state))
 
 	// Update ANC mode
 	ancText := "  ANC: Unknown"
@@ -113,12 +310,13 @@ func (t *TrayManager) updateGameBuds(state protocol.DeviceState) {
 		ancText = fmt.Sprintf("  %s ANC: %s", ancIcon, state.ANCMode.String())
 	}
 	t.gameBudsANC.SetTitle(ancText)
-	t.gameBudsANC.Enable()
+	setItemEnabled(t.gameBudsANC, !isOutdated(state))
 }
 
-func (t *TrayManager) updateRazer(state protocol.DeviceState) {
+func (t *TrayManager) updateRazer(deviceID string, state protocol.DeviceState) {
 	// Show Razer menu
-	t.razerMenu.SetTitle("🖱️ Razer Device")
+	suffix := staleSuffix(state)
+	t.razerMenu.SetTitle(t.sectionTitle("🖱️ Razer Device", deviceID))
 	t.razerMenu.Enable()
 
 	// Update battery
@@ -127,20 +325,167 @@ func (t *TrayManager) updateRazer(state protocol.DeviceState) {
 		batteryIcon := getBatteryIcon(*state.Battery)
 		batteryText = fmt.Sprintf("  %s Battery: %d%%", batteryIcon, *state.Battery)
 	}
-	t.razerBattery.SetTitle(batteryText)
-	t.razerBattery.Enable()
+	t.razerBattery.SetTitle(batteryText + suffix)
+	setItemEnabled(t.razerBattery, !isOutdated(state))
 
 	// Update charging/wireless status
-	chargingText := "  Mode: --"
-	if state.IsCharging != nil {
-		if *state.IsCharging {
-			chargingText = "  ⚡ Mode: Charging"
-		} else {
-			chargingText = "  📡 Mode: Wireless"
+	chargingText := formatRazerPower(state.Power, state.IsCharging) + modeSuffix(state.Mode, state.Power)
+	t.razerCharging.SetTitle(chargingText)
+	setItemEnabled(t.razerCharging, !isOutdated(state))
+}
+
+// updateOthers lays out the generic HID battery and plugin devices, and any
+// device whose type's section is taken, sorted by ID so each keeps its
+// position
+func (t *TrayManager) updateOthers() {
+	t.mu.RLock()
+	ids := t.otherDeviceIDs()
+	titles := make([]string, len(ids))
+	outdated := make([]bool, len(ids))
+	settings := make([][]SettingMenu, len(ids))
+	for i, deviceID := range ids {
+		state := t.devices[deviceID]
+		name := t.names[deviceID]
+		if name == "" {
+			name = deviceID
 		}
+		titles[i] = formatOtherDevice(name, state) + staleSuffix(state) + healthSuffix(t.health[deviceID])
+		outdated[i] = isOutdated(state) || !state.IsConnected
+		settings[i] = t.settings[deviceID]
+	}
+	t.mu.RUnlock()
+
+	if len(ids) > len(t.otherItems) {
+		log.Printf("Only showing %d of %d other devices", len(t.otherItems), len(ids))
+	}
+	t.otherMenu.Enable()
+	for i, item := range t.otherItems {
+		if i >= len(ids) {
+			t.showSettings(t.otherSettings[i], "", nil)
+			item.Hide()
+			continue
+		}
+		item.SetTitle(titles[i])
+		// A device with settings stays enabled so its submenu opens
+		setItemEnabled(item, !outdated[i] || len(settings[i]) > 0)
+		t.showSettings(t.otherSettings[i], ids[i], settings[i])
+		item.Show()
+	}
+}
+
+// otherDeviceIDs returns the devices shown in the Other Devices section,
+// sorted by ID. Must be called with t.mu held.
+func (t *TrayManager) otherDeviceIDs() []string {
+	var ids []string
+	for deviceID := range t.devices {
+		if t.sectionOf(deviceID) == sectionOther {
+			ids = append(ids, deviceID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// formatOtherDevice renders a generic device's menu item, e.g.
+// "  🔋 MX Master 3: 80% (Charging)"
+func formatOtherDevice(name string, state protocol.DeviceState) string {
+	if state.Battery == nil {
+		return fmt.Sprintf("  %s: --", name)
+	}
+	text := fmt.Sprintf("  %s %s: %d%%", getBatteryIcon(*state.Battery), name, *state.Battery)
+	if state.Power != nil && *state.Power != protocol.PowerDischarging && *state.Power != protocol.PowerUnknown {
+		text += fmt.Sprintf(" (%s)", state.Power)
+	}
+	return text
+}
+
+// formatLevel renders an aggregated level as a tooltip prefix such as "50% - ",
+// or "" when unknown
+func formatLevel(level int) string {
+	if level < 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d%% - ", level)
+}
+
+// formatRazerPower renders the power state line of the Razer section. States
+// without a power state (e.g. cached by an older version) use the charging flag.
+func formatRazerPower(power *protocol.PowerState, isCharging *bool) string {
+	if power == nil {
+		if isCharging == nil {
+			return "  Mode: --"
+		}
+		if *isCharging {
+			return "  ⚡ Mode: Charging"
+		}
+		return "  📡 Mode: Wireless"
+	}
+
+	switch *power {
+	case protocol.PowerCharging:
+		return "  ⚡ Mode: Charging"
+	case protocol.PowerFull:
+		return "  🔌 Mode: Full, unplug"
+	case protocol.PowerNotCharging:
+		return "  🔌 Mode: Plugged in, not charging"
+	case protocol.PowerDischarging:
+		return "  📡 Mode: Wireless"
+	default:
+		return "  Mode: Unknown"
+	}
+}
+
+// modeSuffix names the cable or dock a plugged in device is on, e.g.
+// " (dock)". A device on battery is already shown as wireless.
+func modeSuffix(mode *protocol.ConnectionMode, power *protocol.PowerState) string {
+	if mode == nil || !mode.IsPluggedIn() || power == nil || !power.IsPluggedIn() {
+		return ""
+	}
+	return " (" + mode.ID() + ")"
+}
+
+// setItemEnabled enables a menu item, or disables it so it renders greyed out
+func setItemEnabled(item *systray.MenuItem, enabled bool) {
+	if enabled {
+		item.Enable()
+	} else {
+		item.Disable()
+	}
+}
+
+// staleSuffix returns " (3h ago)" style text for stale states, " (cached)"
+// for states restored from a previous run, or "" otherwise
+func staleSuffix(state protocol.DeviceState) string {
+	switch {
+	case state.Cached:
+		return " (cached)"
+	case state.Stale:
+		return " " + formatAge(state.Age(time.Now()))
+	default:
+		return ""
+	}
+}
+
+// isOutdated reports whether a state should be rendered greyed out: it is
+// stale, cached, or the device behind a receiver is not linked
+func isOutdated(state protocol.DeviceState) bool {
+	unlinked := state.Connection != nil && *state.Connection != protocol.ConnectionLinked &&
+		*state.Connection != protocol.ConnectionUnknown
+	return state.Stale || state.Cached || unlinked
+}
+
+// formatAge renders how long ago a device was last heard from
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return "(just now)"
+	case age < time.Hour:
+		return fmt.Sprintf("(%dm ago)", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("(%dh ago)", int(age.Hours()))
+	default:
+		return fmt.Sprintf("(%dd ago)", int(age.Hours()/24))
 	}
-	t.razerCharging.SetTitle(chargingText)
-	t.razerCharging.Enable()
 }
 
 func (t *TrayManager) updateTrayIcon() {
@@ -152,63 +497,28 @@ func (t *TrayManager) updateTrayIcon() {
 	var tooltipParts []string
 	hasGameBuds := false
 	hasMouse := false
+	gameBudsStale := false
+	mouseStale := false
 
-	for _, state := range t.devices {
-		switch state.DeviceType {
-		case "steelseries_gamebuds":
+	for deviceID, state := range t.devices {
+		level := t.aggregation.Battery(state)
+		switch t.sectionOf(deviceID) {
+		case sectionGameBuds:
 			hasGameBuds = true
-			// Get the lower of the two earbud batteries
-			// Prefer earbuds that are out/wearing, but fall back to any available battery
-			var validBatteries []int
-			var allBatteries []int
-
-			if state.LeftBattery != nil && *state.LeftBattery > 0 {
-				allBatteries = append(allBatteries, *state.LeftBattery)
-				leftStatus := protocol.StatusInCase
-				if state.LeftStatus != nil {
-					leftStatus = *state.LeftStatus
-				}
-				// Only count if not in case
-				if leftStatus != protocol.StatusInCase {
-					validBatteries = append(validBatteries, *state.LeftBattery)
-				}
-			}
-
-			if state.RightBattery != nil && *state.RightBattery > 0 {
-				allBatteries = append(allBatteries, *state.RightBattery)
-				rightStatus := protocol.StatusInCase
-				if state.RightStatus != nil {
-					rightStatus = *state.RightStatus
-				}
-				// Only count if not in case
-				if rightStatus != protocol.StatusInCase {
-					validBatteries = append(validBatteries, *state.RightBattery)
-				}
-			}
-
-			// Find the lowest battery - prefer valid (out/wearing), fall back to all batteries
-			batteriesToUse := validBatteries
-			if len(batteriesToUse) == 0 {
-				batteriesToUse = allBatteries
-			}
-
-			if len(batteriesToUse) > 0 {
-				lowest := batteriesToUse[0]
-				for _, bat := range batteriesToUse[1:] {
-					if bat < lowest {
-						lowest = bat
-					}
-				}
-				gameBudsBattery = lowest
-			}
-
-			tooltipParts = append(tooltipParts, fmt.Sprintf("GameBuds: %s", state.String()))
-		case "razer_deathadder":
+			gameBudsBattery = level
+			gameBudsStale = isOutdated(state)
+			tooltipParts = append(tooltipParts, fmt.Sprintf("GameBuds: %s%s%s", formatLevel(level), state.String(), staleSuffix(state)))
+		case sectionRazer:
 			hasMouse = true
-			if state.Battery != nil {
-				mouseBattery = *state.Battery
+			mouseBattery = level
+			mouseStale = isOutdated(state)
+			tooltipParts = append(tooltipParts, fmt.Sprintf("Razer: %s%s", state.String(), staleSuffix(state)))
+		default:
+			name := t.names[deviceID]
+			if name == "" {
+				name = deviceID
 			}
-			tooltipParts = append(tooltipParts, fmt.Sprintf("Razer: %s", state.String()))
+			tooltipParts = append(tooltipParts, fmt.Sprintf("%s: %s%s", name, state.String(), staleSuffix(state)))
 		}
 	}
 
@@ -218,7 +528,7 @@ func (t *TrayManager) updateTrayIcon() {
 	// Show GameBuds battery if device exists
 	if hasGameBuds {
 		if gameBudsBattery >= 0 {
-			titleParts = append(titleParts, fmt.Sprintf("🎧 %d%%", gameBudsBattery))
+			titleParts = append(titleParts, fmt.Sprintf("🎧 %s%d%%", stalePrefix(gameBudsStale), gameBudsBattery))
 		} else {
 			titleParts = append(titleParts, "🎧 --")
 		}
@@ -227,18 +537,22 @@ func (t *TrayManager) updateTrayIcon() {
 	// Show Mouse battery if device exists
 	if hasMouse {
 		if mouseBattery >= 0 {
-			titleParts = append(titleParts, fmt.Sprintf("🖱️ %d%%", mouseBattery))
+			titleParts = append(titleParts, fmt.Sprintf("🖱️ %s%d%%", stalePrefix(mouseStale), mouseBattery))
 		} else {
 			titleParts = append(titleParts, "🖱️ --")
 		}
 	}
 
 	// Update tray icon
-	if len(titleParts) == 0 {
+	if len(tooltipParts) == 0 {
 		systray.SetTitle("🎧")
 		systray.SetTooltip(fmt.Sprintf("No devices connected (v%s)", version.Version))
 	} else {
+		// Generic devices are only listed in the tooltip and menu
 		title := ""
+		if len(titleParts) == 0 {
+			title = "🔋"
+		}
 		for i, part := range titleParts {
 			if i > 0 {
 				title += " "
@@ -261,7 +575,15 @@ func (t *TrayManager) updateTrayIcon() {
 	}
 }
 
-func formatGameBudsBattery(battery *int, status *protocol.EarbudStatus, side string) string {
+// stalePrefix marks stale values in the tray title as approximate
+func stalePrefix(stale bool) string {
+	if stale {
+		return "~"
+	}
+	return ""
+}
+
+func formatGameBudsBattery(battery *int, status *protocol.EarbudStatus, power *protocol.PowerState, side string) string {
 	if battery == nil && status == nil {
 		return fmt.Sprintf("🎧 %s: --", side)
 	}
@@ -283,6 +605,12 @@ func formatGameBudsBattery(battery *int, status *protocol.EarbudStatus, side str
 	switch statusVal {
 	case protocol.StatusInCase:
 		if batteryVal > 0 {
+			if power != nil && *power == protocol.PowerFull {
+				return fmt.Sprintf("🔋 %s: %d%% - Full", side, batteryVal)
+			}
+			if power != nil && *power == protocol.PowerNotCharging {
+				return fmt.Sprintf("🔋 %s: %d%% - Not Charging", side, batteryVal)
+			}
 			return fmt.Sprintf("🔋 %s: %d%% - Charging", side, batteryVal)
 		}
 		return fmt.Sprintf("📦 %s: In Case", side)
@@ -102,7 +102,7 @@ func TestFormatGameBudsBattery_EdgeCases(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := formatGameBudsBattery(tt.battery, tt.status, tt.side)
+			result := formatGameBudsBattery(tt.battery, tt.status, nil, tt.side)
 			if result != tt.expected {
 				t.Errorf("formatGameBudsBattery() = %v, want %v", result, tt.expected)
 			}
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"testing"
+	"time"
 
 	"github.com/jyablonski/goarctis/pkg/protocol"
 )
@@ -61,9 +62,18 @@ func TestFormatGameBudsBattery(t *testing.T) {
 		name     string
 		battery  *int
 		status   *protocol.EarbudStatus
+		power    *protocol.PowerState
 		side     string
 		expected string
 	}{
+		{
+			name:     "In case fully charged",
+			battery:  func() *int { b := 100; return &b }(),
+			status:   func() *protocol.EarbudStatus { s := protocol.StatusInCase; return &s }(),
+			power:    func() *protocol.PowerState { p := protocol.PowerFull; return &p }(),
+			side:     "Left",
+			expected: "🔋 Left: 100% - Full",
+		},
 		{
 			name:     "In case with battery",
 			battery:  func() *int { b := 80; return &b }(),
@@ -131,7 +141,7 @@ func TestFormatGameBudsBattery(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := formatGameBudsBattery(tt.battery, tt.status, tt.side)
+			got := formatGameBudsBattery(tt.battery, tt.status, tt.power, tt.side)
 			if got != tt.expected {
 				t.Errorf("formatGameBudsBattery(%v, %v, %s) = %v, want %v",
 					tt.battery, tt.status, tt.side, got, tt.expected)
@@ -258,7 +268,7 @@ func BenchmarkFormatGameBudsBattery(b *testing.B) {
 	battery := 75
 	status := protocol.StatusWorn
 	for i := 0; i < b.N; i++ {
-		formatGameBudsBattery(&battery, &status, "Left")
+		formatGameBudsBattery(&battery, &status, nil, "Left")
 	}
 }
 
@@ -273,3 +283,242 @@ func BenchmarkGetANCIcon(b *testing.B) {
 		getANCIcon(protocol.ANCActive)
 	}
 }
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		age      time.Duration
+		expected string
+	}{
+		{30 * time.Second, "(just now)"},
+		{5 * time.Minute, "(5m ago)"},
+		{3*time.Hour + 20*time.Minute, "(3h ago)"},
+		{50 * time.Hour, "(2d ago)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			if got := formatAge(tt.age); got != tt.expected {
+				t.Errorf("formatAge(%v) = %v, want %v", tt.age, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStaleSuffix(t *testing.T) {
+	state := protocol.DeviceState{LastSeen: time.Now().Add(-3 * time.Hour)}
+	if got := staleSuffix(state); got != "" {
+		t.Errorf("Fresh state should have no suffix, got %q", got)
+	}
+
+	state.Stale = true
+	if got := staleSuffix(state); got != " (3h ago)" {
+		t.Errorf("staleSuffix() = %q, want %q", got, " (3h ago)")
+	}
+}
+
+func TestStaleSuffix_Cached(t *testing.T) {
+	state := protocol.DeviceState{Cached: true, Stale: true}
+	if got := staleSuffix(state); got != " (cached)" {
+		t.Errorf("staleSuffix() = %q, want %q", got, " (cached)")
+	}
+	if !isOutdated(state) {
+		t.Error("Cached state should be rendered as outdated")
+	}
+}
+
+func TestSectionTitle_Health(t *testing.T) {
+	tm := NewTrayManager()
+	tm.devices["mouse"] = protocol.DeviceState{DeviceType: "razer_deathadder", Cached: true}
+
+	if got := tm.sectionTitle("🖱️ Razer Device", "mouse"); got != "🖱️ Razer Device (cached)" {
+		t.Errorf("sectionTitle() = %q, want cached suffix only", got)
+	}
+
+	tm.health["mouse"] = "reconnecting (attempt 3)"
+	want := "🖱️ Razer Device (cached) — reconnecting (attempt 3)"
+	if got := tm.sectionTitle("🖱️ Razer Device", "mouse"); got != want {
+		t.Errorf("sectionTitle() = %q, want %q", got, want)
+	}
+	if got := tm.sectionTitle("🖱️ Razer Device", "other_mouse"); got != "🖱️ Razer Device" {
+		t.Errorf("sectionTitle() = %q, want another device's health left out", got)
+	}
+}
+
+func TestFormatRazerPower(t *testing.T) {
+	power := func(p protocol.PowerState) *protocol.PowerState { return &p }
+	charging := true
+
+	tests := []struct {
+		name       string
+		power      *protocol.PowerState
+		isCharging *bool
+		expected   string
+	}{
+		{"Full", power(protocol.PowerFull), &charging, "  🔌 Mode: Full, unplug"},
+		{"Charging", power(protocol.PowerCharging), &charging, "  ⚡ Mode: Charging"},
+		{"Plugged not charging", power(protocol.PowerNotCharging), nil, "  🔌 Mode: Plugged in, not charging"},
+		{"Wireless", power(protocol.PowerDischarging), nil, "  📡 Mode: Wireless"},
+		{"Unknown", power(protocol.PowerUnknown), nil, "  Mode: Unknown"},
+		{"Legacy charging flag", nil, &charging, "  ⚡ Mode: Charging"},
+		{"No data", nil, nil, "  Mode: --"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatRazerPower(tt.power, tt.isCharging); got != tt.expected {
+				t.Errorf("formatRazerPower() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetAggregation(t *testing.T) {
+	tm := NewTrayManager()
+	left, right := 90, 40
+	worn, inCase := protocol.StatusWorn, protocol.StatusInCase
+	state := protocol.DeviceState{
+		DeviceType:   "steelseries_gamebuds",
+		LeftBattery:  &left,
+		RightBattery: &right,
+		LeftStatus:   &worn,
+		RightStatus:  &inCase,
+	}
+
+	if got := tm.aggregation.Battery(state); got != 90 {
+		t.Errorf("Default aggregation = %d, want 90 (earbud in case skipped)", got)
+	}
+
+	tm.SetAggregation(protocol.AggregationConfig{
+		Default: protocol.AggregateOutOfCase,
+		Devices: map[string]protocol.AggregationPolicy{"steelseries_gamebuds": protocol.AggregateMinimum},
+	})
+	if got := tm.aggregation.Battery(state); got != 40 {
+		t.Errorf("Minimum aggregation = %d, want 40", got)
+	}
+}
+
+func TestFormatLevel(t *testing.T) {
+	if got := formatLevel(55); got != "55% - " {
+		t.Errorf("formatLevel(55) = %q, want %q", got, "55% - ")
+	}
+	if got := formatLevel(-1); got != "" {
+		t.Errorf("formatLevel(-1) = %q, want empty", got)
+	}
+}
+
+func TestConnectionSuffix(t *testing.T) {
+	connection := func(c protocol.ConnectionState) *protocol.ConnectionState { return &c }
+
+	tests := []struct {
+		name       string
+		connection *protocol.ConnectionState
+		expected   string
+		outdated   bool
+	}{
+		{"Linked", connection(protocol.ConnectionLinked), "", false},
+		{"Buds off", connection(protocol.ConnectionOff), " (buds off)", true},
+		{"Waiting", connection(protocol.ConnectionDongle), " (waiting for buds)", true},
+		{"Unplugged", connection(protocol.ConnectionDisconnected), " (dongle unplugged)", true},
+		{"Asleep", connection(protocol.ConnectionAsleep), " (asleep)", true},
+		{"No receiver", nil, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := protocol.DeviceState{DeviceType: "steelseries_gamebuds", Connection: tt.connection}
+			if got := connectionSuffix(state); got != tt.expected {
+				t.Errorf("connectionSuffix() = %q, want %q", got, tt.expected)
+			}
+			if got := isOutdated(state); got != tt.outdated {
+				t.Errorf("isOutdated() = %v, want %v", got, tt.outdated)
+			}
+		})
+	}
+
+	tm := NewTrayManager()
+	tm.devices["buds"] = protocol.DeviceState{DeviceType: "steelseries_gamebuds", Connection: connection(protocol.ConnectionOff)}
+	if got := tm.sectionTitle("🎧 GameBuds", "buds"); got != "🎧 GameBuds (buds off)" {
+		t.Errorf("sectionTitle() = %q, want buds off suffix", got)
+	}
+}
+
+func TestFormatOtherDevice(t *testing.T) {
+	level := 80
+	charging := protocol.PowerCharging
+	discharging := protocol.PowerDischarging
+
+	tests := []struct {
+		name  string
+		state protocol.DeviceState
+		want  string
+	}{
+		{"no reading", protocol.DeviceState{}, "  MX Master: --"},
+		{"on battery", protocol.DeviceState{Battery: &level, Power: &discharging}, "  🔋 MX Master: 80%"},
+		{"charging", protocol.DeviceState{Battery: &level, Power: &charging}, "  🔋 MX Master: 80% (Charging)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatOtherDevice("MX Master", tt.state); got != tt.want {
+				t.Errorf("formatOtherDevice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimSection(t *testing.T) {
+	tm := NewTrayManager()
+	claims := []struct {
+		deviceID, deviceType string
+		want                 int
+	}{
+		{"buds", "steelseries_gamebuds", sectionGameBuds},
+		{"mouse", "razer_deathadder", sectionRazer},
+		{"second_mouse", "razer_deathadder", sectionOther},
+		{"mouse", "razer_deathadder", sectionRazer},
+		{"ups", "ups", sectionOther}, // Declared by a plugin
+	}
+	for _, c := range claims {
+		tm.devices[c.deviceID] = protocol.DeviceState{DeviceType: c.deviceType}
+		if got := tm.claimSection(c.deviceID, c.deviceType); got != c.want {
+			t.Errorf("claimSection(%s) = %d, want %d", c.deviceID, got, c.want)
+		}
+	}
+
+	if got := tm.otherDeviceIDs(); len(got) != 2 || got[0] != "second_mouse" || got[1] != "ups" {
+		t.Errorf("otherDeviceIDs() = %v, want the second mouse and the UPS", got)
+	}
+}
+
+func TestSettingTitle(t *testing.T) {
+	setting := SettingMenu{ID: "idle_time", Name: "Sleep After", Value: "300s", Choices: []string{"60s", "300s"}}
+	if got := settingTitle(setting); got != "Sleep After: 300s" {
+		t.Errorf("settingTitle() = %q, want %q", got, "Sleep After: 300s")
+	}
+}
+
+func TestModeSuffix(t *testing.T) {
+	mode := func(m protocol.ConnectionMode) *protocol.ConnectionMode { return &m }
+	power := func(p protocol.PowerState) *protocol.PowerState { return &p }
+
+	tests := []struct {
+		name     string
+		mode     *protocol.ConnectionMode
+		power    *protocol.PowerState
+		expected string
+	}{
+		{"Dock charging", mode(protocol.ModeDock), power(protocol.PowerCharging), " (dock)"},
+		{"Wired full", mode(protocol.ModeWired), power(protocol.PowerFull), " (wired)"},
+		{"Wireless charging", mode(protocol.ModeWireless), power(protocol.PowerCharging), ""},
+		{"On battery", mode(protocol.ModeWired), power(protocol.PowerDischarging), ""},
+		{"Unknown mode", nil, power(protocol.PowerCharging), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modeSuffix(tt.mode, tt.power); got != tt.expected {
+				t.Errorf("modeSuffix() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}